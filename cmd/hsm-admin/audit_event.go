@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"log"
+	"log/slog"
+
+	"github.com/titaev-lv/hsm-service/internal/audit"
+	"github.com/titaev-lv/hsm-service/internal/config"
+)
+
+// auditEvent records an hsm-admin operation the way the service records its
+// own: into the hash-chained audit log (audit.enabled) and the SIEM outputs
+// (logging.audit_outputs), and on stderr. The running service indexes the
+// record and covers it with its next signed checkpoint.
+func auditEvent(cfg *config.Config, level slog.Level, event string, args ...any) {
+	if cfg.Audit.Enabled {
+		sink, err := audit.OpenSink(&cfg.Audit, nil)
+		if err != nil {
+			log.Printf("⚠ Audit log %s unavailable, %q is only logged here: %v", cfg.Audit.GetFile(), event, err)
+		} else {
+			audit.SetSink(sink)
+			defer func() {
+				audit.SetSink(nil)
+				sink.Close()
+			}()
+		}
+	}
+
+	var outputs []*audit.Output
+	for i := range cfg.Logging.AuditOutputs {
+		out, err := audit.NewOutput(&cfg.Logging.AuditOutputs[i])
+		if err != nil {
+			log.Printf("⚠ %v", err)
+			continue
+		}
+		outputs = append(outputs, out)
+	}
+	audit.SetOutputs(outputs)
+	defer func() {
+		audit.SetOutputs(nil)
+		// Waits for delivery (bounded by the drain timeout)
+		for _, out := range outputs {
+			out.Close()
+		}
+	}()
+
+	args = append([]any{"trigger", "manual", "operator", operatorName()}, args...)
+	audit.Logger().Log(context.Background(), level, event, args...)
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/titaev-lv/hsm-service/internal/config"
+	"github.com/titaev-lv/hsm-service/internal/hsm"
+)
+
+// auditKeysCommand checks PKCS#11 attributes of all secret keys on the token
+// and compares the token inventory with metadata
+func auditKeysCommand(args []string) error {
+	fs := flag.NewFlagSet("audit-keys", flag.ExitOnError)
+	configPath := fs.String("config", getConfigPath(), "Path to config.yaml")
+	format := fs.String("format", "text", "Report format: text or json")
+	output := fs.String("output", "", "Write report to file (default: stdout)")
+	strict := fs.Bool("strict", false, "Treat warnings as failures")
+
+	fs.Parse(args)
+
+	if *format != "text" && *format != "json" {
+		return fmt.Errorf("unknown --format %q (expected text or json)", *format)
+	}
+
+	cfg, err := config.LoadConfig(*configPath)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	store, err := openMetadataStore(cfg)
+	if err != nil {
+		return err
+	}
+	metadata, _, err := store.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load metadata: %w", err)
+	}
+
+	p11ctx, err := openPKCS11(cfg)
+	if err != nil {
+		return err
+	}
+	defer p11ctx.Close()
+
+	keys, err := hsm.ListKeyAttributes(p11ctx)
+	if err != nil {
+		return err
+	}
+
+	expected := hsm.ExpectedKeysFromMetadata(metadata, &cfg.HSM)
+	if cfg.Audit.Enabled {
+		expected[cfg.Audit.GetKeyLabel()] = hsm.ExpectedKey{Role: hsm.KeyRoleAuditMAC}
+	}
+	report := hsm.AuditKeys(cfg.HSM.SlotID, keys, expected)
+	if *strict {
+		report.Strict()
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return fmt.Errorf("failed to create report file: %w", err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	if *format == "json" {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			return fmt.Errorf("failed to encode report: %w", err)
+		}
+	} else {
+		printKeyAuditReport(w, report)
+	}
+
+	if !report.Passed {
+		return fmt.Errorf("key audit failed: %d failure(s)", report.Failures)
+	}
+	return nil
+}
+
+// printKeyAuditReport writes the human-readable report
+func printKeyAuditReport(w io.Writer, report *hsm.KeyAuditReport) {
+	fmt.Fprintln(w, "=== HSM Key Attribute Audit ===")
+	fmt.Fprintf(w, "Token: %s\n", report.Token)
+	fmt.Fprintf(w, "Generated: %s\n", report.GeneratedAt.Format("2006-01-02 15:04:05 MST"))
+	fmt.Fprintf(w, "Keys on token: %d\n\n", len(report.Keys))
+
+	findings := make(map[string][]hsm.KeyAuditFinding)
+	for _, f := range report.Findings {
+		findings[f.Label] = append(findings[f.Label], f)
+	}
+
+	for _, k := range report.Keys {
+		mark := "✓"
+		if !k.Passed {
+			mark = "✗"
+		}
+		role := k.Role
+		if k.Context != "" {
+			role += " " + k.Context
+		}
+		fmt.Fprintf(w, "%s %s (%s, %s-%d, %s)\n", mark, k.Label, role, k.KeyType, k.ValueLen*8, keyFlags(k.KeyAttributes))
+		for _, f := range findings[k.Label] {
+			fmt.Fprintf(w, "    %s %s: %s\n", severityMark(f.Severity), f.Check, f.Message)
+		}
+		delete(findings, k.Label)
+	}
+
+	// Findings for keys not on the token (missing)
+	for _, f := range report.Findings {
+		if _, ok := findings[f.Label]; ok {
+			fmt.Fprintf(w, "✗ %s (not on token)\n", f.Label)
+			fmt.Fprintf(w, "    %s %s: %s\n", severityMark(f.Severity), f.Check, f.Message)
+		}
+	}
+
+	fmt.Fprintln(w)
+	result := "PASS"
+	if !report.Passed {
+		result = "FAIL"
+	}
+	fmt.Fprintf(w, "RESULT: %s (%d failure(s), %d warning(s))\n", result, report.Failures, report.Warnings)
+}
+
+// keyFlags summarises security-relevant attributes
+func keyFlags(k hsm.KeyAttributes) string {
+	flags := []string{}
+	add := func(cond bool, name string) {
+		if cond {
+			flags = append(flags, name)
+		}
+	}
+	add(k.Token, "token")
+	add(k.Sensitive, "sensitive")
+	add(!k.Extractable, "non-extractable")
+	add(k.Extractable, "EXTRACTABLE")
+	add(k.Encrypt || k.Decrypt, "encrypt/decrypt")
+	add(k.Wrap || k.Unwrap, "wrap/unwrap")
+	add(k.Sign || k.Verify, "sign/verify")
+	add(k.Derive, "derive")
+	return strings.Join(flags, " ")
+}
+
+func severityMark(severity string) string {
+	if severity == hsm.SeverityFail {
+		return "✗"
+	}
+	return "⚠"
+}
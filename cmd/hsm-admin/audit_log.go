@@ -0,0 +1,301 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/titaev-lv/hsm-service/internal/audit"
+	"github.com/titaev-lv/hsm-service/internal/config"
+	"github.com/titaev-lv/hsm-service/internal/hsm"
+)
+
+const auditUsage = "usage: hsm-admin audit <init-key|verify|query> [options]"
+
+// auditCommand dispatches the audit log subcommands
+func auditCommand(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf(auditUsage)
+	}
+
+	switch args[0] {
+	case "init-key":
+		return auditInitKeyCommand(args[1:])
+	case "verify":
+		return auditVerifyCommand(args[1:])
+	case "query":
+		return auditQueryCommand(args[1:])
+	default:
+		return fmt.Errorf("unknown audit subcommand: %s\n%s", args[0], auditUsage)
+	}
+}
+
+// auditInitKeyCommand creates the HMAC key that signs audit checkpoints
+func auditInitKeyCommand(args []string) error {
+	fs := flag.NewFlagSet("audit init-key", flag.ExitOnError)
+	configPath := fs.String("config", getConfigPath(), "Path to config.yaml")
+
+	fs.Parse(args)
+
+	cfg, err := config.LoadConfig(*configPath)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	keyLabel := cfg.Audit.GetKeyLabel()
+
+	p11ctx, err := openPKCS11(cfg)
+	if err != nil {
+		return err
+	}
+	defer p11ctx.Close()
+
+	existing, err := p11ctx.FindKey(nil, []byte(keyLabel))
+	if err != nil {
+		return fmt.Errorf("failed to check for existing key: %w", err)
+	}
+	if existing != nil {
+		fmt.Printf("✓ Audit checkpoint key already exists: %s\n", keyLabel)
+		return nil
+	}
+	if _, err := hsm.GenerateAuditKey(p11ctx, keyLabel); err != nil {
+		return err
+	}
+	auditEvent(cfg, slog.LevelInfo, "key created",
+		"purpose", "audit_checkpoint",
+		"label", keyLabel,
+		"outcome", "success")
+	fmt.Printf("✓ Created audit checkpoint key: %s\n", keyLabel)
+	if !cfg.Audit.Enabled {
+		fmt.Println("  Enable the audit log with audit.enabled: true")
+	}
+	return nil
+}
+
+// auditVerifyCommand checks the hash chain and checkpoint signatures of the
+// audit log and all its rotated files
+func auditVerifyCommand(args []string) error {
+	fs := flag.NewFlagSet("audit verify", flag.ExitOnError)
+	configPath := fs.String("config", getConfigPath(), "Path to config.yaml")
+	file := fs.String("file", "", "Audit log (default: audit.file)")
+	noMAC := fs.Bool("no-mac", false, "Check the hash chain only, without HSM access")
+	format := fs.String("format", "text", "Output format: text or json")
+
+	fs.Parse(args)
+
+	cfg, err := config.LoadConfig(*configPath)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	if *file == "" {
+		*file = cfg.Audit.GetFile()
+	}
+
+	var mac audit.MAC
+	if !*noMAC {
+		p11ctx, err := openPKCS11(cfg)
+		if err != nil {
+			return err
+		}
+		defer p11ctx.Close()
+		if mac, err = hsm.NewHMAC(p11ctx, cfg.Audit.GetKeyLabel()); err != nil {
+			return err
+		}
+	}
+
+	result, err := audit.Verify(*file, mac)
+	if err != nil {
+		return err
+	}
+
+	if *format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(result); err != nil {
+			return err
+		}
+	} else {
+		printVerifyResult(result)
+	}
+
+	if !result.Valid() {
+		return fmt.Errorf("%d problem(s) found in %s", len(result.Problems), *file)
+	}
+	return nil
+}
+
+func printVerifyResult(r *audit.VerifyResult) {
+	fmt.Printf("Files: %d\n", len(r.Files))
+	for _, f := range r.Files {
+		fmt.Printf("  %s\n", f)
+	}
+	fmt.Printf("Records: %d (seq %d..%d), checkpoints: %d\n", r.Records, r.FirstSeq, r.LastSeq, r.Checkpoints)
+	if r.StateSeq > 0 {
+		fmt.Printf("Last recorded checkpoint: seq %d\n", r.StateSeq)
+	}
+	if r.FirstSeq > 1 {
+		fmt.Printf("⚠ Chain starts at seq %d: older files were removed (audit.max_backups / max_age_days)\n", r.FirstSeq)
+	}
+	if r.MACChecked {
+		if r.LastCheckpointSeq > 0 {
+			fmt.Printf("✓ Signed up to seq %d\n", r.LastCheckpointSeq)
+		}
+		if r.Unsigned > 0 {
+			fmt.Printf("⚠ %d record(s) after the last valid checkpoint are not signed yet\n", r.Unsigned)
+		}
+	} else {
+		fmt.Println("⚠ Checkpoint signatures not checked (--no-mac)")
+	}
+
+	if r.Valid() {
+		fmt.Println("✓ Audit log chain intact")
+		return
+	}
+	for _, p := range r.Problems {
+		fmt.Printf("✗ %s\n", p)
+	}
+}
+
+// auditQueryCommand searches the audit index (no HSM access needed)
+func auditQueryCommand(args []string) error {
+	fs := flag.NewFlagSet("audit query", flag.ExitOnError)
+	configPath := fs.String("config", getConfigPath(), "Path to config.yaml")
+	from := fs.String("from", "", "Start of the time range (RFC 3339 or YYYY-MM-DD)")
+	to := fs.String("to", "", "End of the time range, inclusive (RFC 3339 or YYYY-MM-DD)")
+	clientCN := fs.String("client-cn", "", "Filter by client certificate CN")
+	clientOU := fs.String("client-ou", "", "Filter by client certificate OU")
+	keyContext := fs.String("context", "", "Filter by key context")
+	operation := fs.String("operation", "", "Filter by operation (encrypt, decrypt, pki.sign_csr, audit.query, request)")
+	keyVersion := fs.Int("key-version", 0, "Filter by key version")
+	outcome := fs.String("outcome", "", "Filter by outcome (success, denied, invalid, error)")
+	limit := fs.Int("limit", audit.DefaultQueryLimit, fmt.Sprintf("Page size (max %d)", audit.MaxQueryLimit))
+	cursor := fs.Uint64("cursor", 0, "Continue after this seq (next cursor of the previous page)")
+	all := fs.Bool("all", false, "Return all matching events (all pages)")
+	format := fs.String("format", "table", "Output format: table, jsonl or csv")
+	output := fs.String("output", "", "Write events to file (default: stdout)")
+	noSync := fs.Bool("no-sync", false, "Do not index new records of the audit log before the query")
+
+	fs.Parse(args)
+
+	if *format != "table" && *format != "jsonl" && *format != "csv" {
+		return fmt.Errorf("unknown --format %q (expected table, jsonl or csv)", *format)
+	}
+
+	cfg, err := config.LoadConfig(*configPath)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	q := audit.Query{
+		ClientCN:   *clientCN,
+		ClientOU:   *clientOU,
+		Context:    *keyContext,
+		Operation:  *operation,
+		Outcome:    *outcome,
+		KeyVersion: *keyVersion,
+		Cursor:     *cursor,
+		Limit:      *limit,
+	}
+	if *from != "" {
+		if q.From, err = audit.ParseTimeBound(*from, false); err != nil {
+			return err
+		}
+	}
+	if *to != "" {
+		if q.To, err = audit.ParseTimeBound(*to, true); err != nil {
+			return err
+		}
+	}
+
+	index := audit.NewIndex(cfg.Audit.GetIndexFile())
+	if !*noSync {
+		// The log is the source of truth: pick up records the service has
+		// not flushed yet (or all of them if the index does not exist)
+		n, err := index.Sync(cfg.Audit.GetFile())
+		if err != nil {
+			return fmt.Errorf("failed to sync audit index: %w", err)
+		}
+		if n > 0 {
+			fmt.Fprintf(os.Stderr, "✓ Indexed %d new record(s) from %s\n", n, cfg.Audit.GetFile())
+		}
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return fmt.Errorf("failed to create output file: %w", err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	var tw *tabwriter.Writer
+	if *format == "table" {
+		tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "SEQ\tTIME\tOPERATION\tOUTCOME\tCLIENT\tCONTEXT\tKEY\tREASON")
+	}
+
+	total := 0
+	for page := 0; ; page++ {
+		res, err := index.Query(q)
+		if err != nil {
+			return fmt.Errorf("audit query failed: %w", err)
+		}
+		switch *format {
+		case "jsonl":
+			err = audit.WriteJSONL(w, res.Events)
+		case "csv":
+			err = audit.WriteCSV(w, res.Events, page == 0)
+		default:
+			for _, rec := range res.Events {
+				fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+					rec.Seq,
+					rec.Time.Format(time.RFC3339),
+					eventName(rec),
+					audit.AttrString(rec.Attrs, "outcome"),
+					audit.AttrString(rec.Attrs, "client_cn"),
+					audit.AttrString(rec.Attrs, "context"),
+					audit.AttrString(rec.Attrs, "key_id"),
+					audit.AttrString(rec.Attrs, "reason"),
+				)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("failed to write events: %w", err)
+		}
+		total += len(res.Events)
+
+		if res.NextCursor == 0 {
+			break
+		}
+		if !*all {
+			fmt.Fprintf(os.Stderr, "More events: --cursor %d (or --all)\n", res.NextCursor)
+			break
+		}
+		q.Cursor = res.NextCursor
+	}
+	if tw != nil {
+		tw.Flush()
+	}
+
+	auditEvent(cfg, slog.LevelInfo, "audit log queried",
+		"events", total,
+		"outcome", "success")
+	if *output != "" {
+		fmt.Fprintf(os.Stderr, "✓ %d event(s) written to %s\n", total, *output)
+	}
+	return nil
+}
+
+// eventName returns the operation of request events, the event name otherwise
+func eventName(rec audit.Record) string {
+	if op := audit.AttrString(rec.Attrs, "operation"); op != "" && rec.Event == audit.EventMessage {
+		return op
+	}
+	return rec.Event
+}
@@ -1,8 +1,6 @@
 package main
 
 import (
-	"crypto/sha256"
-	"encoding/hex"
 	"flag"
 	"fmt"
 	"log"
@@ -10,14 +8,16 @@ import (
 
 	"github.com/ThalesGroup/crypto11"
 	"github.com/titaev-lv/hsm-service/internal/config"
-	"gopkg.in/yaml.v3"
+	"github.com/titaev-lv/hsm-service/internal/hsm"
 )
 
-// updateChecksumsCommand computes SHA-256 checksums for all KEKs and updates metadata.yaml
+// updateChecksumsCommand computes integrity checksums (KCV + attribute digest)
+// for all KEKs and updates metadata.yaml, migrating label-only checksums
 func updateChecksumsCommand(args []string) error {
 	fs := flag.NewFlagSet("update-checksums", flag.ExitOnError)
 	configPath := fs.String("config", getConfigPath(), "Path to config.yaml")
 	dryRun := fs.Bool("dry-run", false, "Show what would be updated without making changes")
+	force := fs.Bool("force", false, "Overwrite checksums that do not match the key in HSM")
 
 	fs.Parse(args)
 
@@ -34,13 +34,17 @@ func updateChecksumsCommand(args []string) error {
 	}
 
 	// Load metadata
-	metadataPath := cfg.HSM.MetadataFile
-	if metadataPath == "" {
-		metadataPath = "metadata.yaml"
-	}
-	metadata, err := config.LoadMetadata(metadataPath)
+	store, err := openMetadataStore(cfg)
 	if err != nil {
-		return fmt.Errorf("failed to load metadata: %w", err)
+		return err
+	}
+	if !*dryRun {
+		// Acquire metadata lock (shared with rotate and the rotation scheduler)
+		unlock, err := store.Lock()
+		if err != nil {
+			return err
+		}
+		defer unlock()
 	}
 
 	// Initialize PKCS#11 context
@@ -54,10 +58,16 @@ func updateChecksumsCommand(args []string) error {
 	}
 	defer p11ctx.Close()
 
+	metadata, revision, mac, err := loadMetadataForUpdate(cfg, p11ctx, store)
+	if err != nil {
+		return err
+	}
+
 	fmt.Println("Computing KEK checksums...")
 	fmt.Println()
 
 	updatedCount := 0
+	mismatches := 0
 	for context, meta := range metadata.Rotation {
 		fmt.Printf("Context: %s\n", context)
 
@@ -74,22 +84,34 @@ func updateChecksumsCommand(args []string) error {
 				continue
 			}
 
-			// Compute checksum (label-based, same as in pkcs11.go)
-			h := sha256.New()
-			h.Write([]byte(version.Label))
-			checksum := hex.EncodeToString(h.Sum(nil))
+			// Compute checksum (KCV + attribute digest, same as the service)
+			checksum, err := hsm.ComputeKeyChecksum(p11ctx, secretKey)
+			if err != nil {
+				log.Printf("  Warning: failed to compute checksum for %s: %v", version.Label, err)
+				continue
+			}
 
 			// Check if update needed
 			if version.Checksum == checksum {
-				fmt.Printf("  ✓ %s (v%d): checksum already up-to-date (%s...)\n",
-					version.Label, version.Version, checksum[:8])
+				fmt.Printf("  ✓ %s (v%d): checksum already up-to-date (%s)\n",
+					version.Label, version.Version, checksum[:9])
 			} else {
-				if version.Checksum == "" {
-					fmt.Printf("  + %s (v%d): NEW checksum %s...\n",
-						version.Label, version.Version, checksum[:8])
-				} else {
-					fmt.Printf("  ⚠ %s (v%d): checksum UPDATE %s... → %s...\n",
-						version.Label, version.Version, version.Checksum[:8], checksum[:8])
+				switch {
+				case version.Checksum == "":
+					fmt.Printf("  + %s (v%d): NEW checksum %s\n",
+						version.Label, version.Version, checksum[:9])
+				case hsm.IsLegacyChecksum(version.Checksum):
+					fmt.Printf("  ↑ %s (v%d): MIGRATE label-only checksum %s... → %s\n",
+						version.Label, version.Version, version.Checksum[:8], checksum[:9])
+				case !*force:
+					// The key in the HSM differs from the one the checksum was recorded for
+					fmt.Printf("  ✗ %s (v%d): checksum MISMATCH %s → %s (possible key substitution, use --force to accept)\n",
+						version.Label, version.Version, version.Checksum[:9], checksum[:9])
+					mismatches++
+					continue
+				default:
+					fmt.Printf("  ⚠ %s (v%d): checksum UPDATE %s → %s (--force)\n",
+						version.Label, version.Version, version.Checksum[:9], checksum[:9])
 				}
 
 				// Update checksum in metadata
@@ -102,7 +124,14 @@ func updateChecksumsCommand(args []string) error {
 		fmt.Println()
 	}
 
+	if mismatches > 0 {
+		fmt.Printf("⚠ %d checksum(s) do not match the keys in HSM - investigate before using --force\n", mismatches)
+	}
+
 	if updatedCount == 0 {
+		if mismatches > 0 {
+			return fmt.Errorf("%d checksum mismatch(es)", mismatches)
+		}
 		fmt.Println("✓ All checksums are up-to-date")
 		return nil
 	}
@@ -114,19 +143,16 @@ func updateChecksumsCommand(args []string) error {
 	}
 
 	// Save updated metadata
-	fmt.Printf("Saving updated metadata to %s...\n", metadataPath)
+	fmt.Printf("Saving updated metadata to %s...\n", store)
 
-	file, err := os.Create(metadataPath)
+	backupPath, err := store.Backup(cfg.HSM.MetadataBackupDirPath())
 	if err != nil {
-		return fmt.Errorf("failed to create metadata file: %w", err)
+		return fmt.Errorf("failed to back up metadata: %w", err)
 	}
-	defer file.Close()
-
-	encoder := yaml.NewEncoder(file)
-	defer encoder.Close()
+	fmt.Printf("✓ Old metadata backed up to: %s\n", backupPath)
 
-	if err := encoder.Encode(metadata); err != nil {
-		return fmt.Errorf("failed to encode metadata: %w", err)
+	if err := saveMetadata(cfg, store, revision, metadata, mac); err != nil {
+		return err
 	}
 
 	fmt.Printf("✓ Updated %d checksum(s) successfully\n", updatedCount)
@@ -135,5 +161,8 @@ func updateChecksumsCommand(args []string) error {
 	fmt.Println("1. Restart HSM service to verify checksums on startup")
 	fmt.Println("2. Commit metadata.yaml to version control")
 
+	if mismatches > 0 {
+		return fmt.Errorf("%d checksum mismatch(es) left unchanged", mismatches)
+	}
 	return nil
 }
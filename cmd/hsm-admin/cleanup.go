@@ -5,18 +5,21 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/ThalesGroup/crypto11"
 	"github.com/titaev-lv/hsm-service/internal/config"
-	"gopkg.in/yaml.v3"
+	"github.com/titaev-lv/hsm-service/internal/hsm"
 )
 
 func cleanupOldVersionsCommand(args []string) error {
 	fs := flag.NewFlagSet("cleanup-old-versions", flag.ExitOnError)
 	configPath := fs.String("config", getConfigPath(), "Path to config.yaml")
 	dryRun := fs.Bool("dry-run", false, "Show what would be deleted without actually deleting")
-	force := fs.Bool("force", false, "Force deletion without confirmation")
+	yes := fs.Bool("yes", false, "Delete without confirmation")
+	ignoreUsage := fs.Bool("ignore-usage", false, "Delete versions used within --min-idle-days too")
+	minIdleDays := fs.Int("min-idle-days", 0, "Refuse to delete versions used within N days (default: hsm.cleanup_min_idle_days or 30)")
 
 	fs.Parse(args)
 
@@ -33,13 +36,47 @@ func cleanupOldVersionsCommand(args []string) error {
 	}
 
 	// Load metadata
-	metadataPath := cfg.HSM.MetadataFile
-	if metadataPath == "" {
-		metadataPath = "metadata.yaml"
-	}
-	metadata, err := config.LoadMetadata(metadataPath)
+	store, err := openMetadataStore(cfg)
 	if err != nil {
-		return fmt.Errorf("failed to load metadata: %w", err)
+		return err
+	}
+	if !*dryRun {
+		// Acquire metadata lock (shared with rotate and the rotation scheduler)
+		unlock, err := store.Lock()
+		if err != nil {
+			return err
+		}
+		defer unlock()
+	}
+
+	// Initialize PKCS#11 context if not dry-run
+	var p11ctx *crypto11.Context
+	if !*dryRun {
+		p11ctx, err = crypto11.Configure(&crypto11.Config{
+			Path:       cfg.HSM.PKCS11Lib,
+			TokenLabel: cfg.HSM.SlotID,
+			Pin:        pin,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to configure PKCS#11: %w", err)
+		}
+		defer p11ctx.Close()
+	}
+
+	// Metadata is verified (when signing is enabled) only if it will be rewritten
+	var metadata *config.Metadata
+	var revision string
+	var mac config.MetadataMAC
+	if *dryRun {
+		metadata, revision, err = store.Load()
+		if err != nil {
+			return fmt.Errorf("failed to load metadata: %w", err)
+		}
+	} else {
+		metadata, revision, mac, err = loadMetadataForUpdate(cfg, p11ctx, store)
+		if err != nil {
+			return err
+		}
 	}
 
 	// Get cleanup parameters
@@ -51,39 +88,52 @@ func cleanupOldVersionsCommand(args []string) error {
 	if cleanupAfterDays == 0 {
 		cleanupAfterDays = 30 // Default
 	}
+	idleDays := *minIdleDays
+	if idleDays == 0 {
+		idleDays = cfg.HSM.CleanupMinIdleDays
+	}
+	if idleDays == 0 {
+		idleDays = 30 // Default
+	}
+
+	// Load usage statistics persisted by the HSM service
+	usagePath := cfg.HSM.UsageFilePath()
+	usage, err := hsm.LoadKeyUsage(usagePath)
+	if err != nil {
+		return fmt.Errorf("failed to load key usage from %s: %w", usagePath, err)
+	}
 
 	fmt.Println("=== PCI DSS Key Cleanup ===")
 	fmt.Printf("Max versions to keep: %d\n", maxVersions)
 	fmt.Printf("Delete versions older than: %d days\n", cleanupAfterDays)
+	fmt.Printf("Keep versions used within: %d days (usage: %s)\n", idleDays, usagePath)
 	if *dryRun {
 		fmt.Println("DRY RUN MODE - No changes will be made")
 	}
 	fmt.Println()
 
-	// Initialize PKCS#11 context if not dry-run
-	var p11ctx *crypto11.Context
-	if !*dryRun {
-		p11ctx, err = crypto11.Configure(&crypto11.Config{
-			Path:       cfg.HSM.PKCS11Lib,
-			TokenLabel: cfg.HSM.SlotID,
-			Pin:        pin,
-		})
-		if err != nil {
-			return fmt.Errorf("failed to configure PKCS#11: %w", err)
-		}
-		defer p11ctx.Close()
-	}
-
 	now := time.Now()
 	cutoffDate := now.AddDate(0, 0, -cleanupAfterDays)
+	idleCutoff := now.AddDate(0, 0, -idleDays)
 
-	totalDeleted := 0
-	modified := false
+	var destroyed []config.KeyVersion
+	contextOf := make(map[string]string) // Label -> context of destroyed versions
 
 	for contextName, keyMeta := range metadata.Rotation {
 		fmt.Printf("Context: %s (current: %s)\n", contextName, keyMeta.Current)
 
-		if len(keyMeta.Versions) <= 1 {
+		// Versions are changed in place; the copy keeps metadata untouched
+		// until deletion is confirmed
+		keyMeta.Versions = append([]config.KeyVersion(nil), keyMeta.Versions...)
+
+		// Destroyed versions stay in metadata as a record and are not counted
+		var versions []config.KeyVersion
+		for _, version := range keyMeta.Versions {
+			if version.EffectiveState(keyMeta.Current) != config.KeyStateDestroyed {
+				versions = append(versions, version)
+			}
+		}
+		if len(versions) <= 1 {
 			fmt.Println("  ✓ Only 1 version, skipping")
 			continue
 		}
@@ -92,7 +142,7 @@ func cleanupOldVersionsCommand(args []string) error {
 		var toDelete []config.KeyVersion
 		var toKeep []config.KeyVersion
 
-		for _, version := range keyMeta.Versions {
+		for _, version := range versions {
 			// Never delete current version
 			if version.Label == keyMeta.Current {
 				toKeep = append(toKeep, version)
@@ -116,6 +166,30 @@ func cleanupOldVersionsCommand(args []string) error {
 				}
 			}
 
+			// Refuse to delete versions that still protect live data
+			if shouldDelete {
+				lastUsed := usage[version.Label].LastUsed()
+				if lastUsed.After(idleCutoff) {
+					if *ignoreUsage {
+						fmt.Printf("  ⚠ %s (v%d) - used %s, deleting anyway (--ignore-usage)\n",
+							version.Label, version.Version, lastUsed.Format("2006-01-02 15:04"))
+					} else {
+						shouldDelete = false
+						fmt.Printf("  ✋ %s (v%d) - used %s (within %d days), keeping (use --ignore-usage to override)\n",
+							version.Label, version.Version, lastUsed.Format("2006-01-02 15:04"), idleDays)
+					}
+				}
+			}
+
+			// Only versions scheduled for destruction whose waiting period and
+			// overlap period have passed are destroyed (no override)
+			if shouldDelete {
+				if err := markDestroyed(cfg, &keyMeta, version.Label, now); err != nil {
+					shouldDelete = false
+					fmt.Printf("  ✋ %s (v%d) - %v, keeping\n", version.Label, version.Version, err)
+				}
+			}
+
 			if shouldDelete {
 				toDelete = append(toDelete, version)
 			} else {
@@ -129,7 +203,7 @@ func cleanupOldVersionsCommand(args []string) error {
 		}
 
 		// Confirm deletion
-		if !*dryRun && !*force {
+		if !*dryRun && !*yes {
 			fmt.Printf("\n  Delete %d versions? (yes/no): ", len(toDelete))
 			var response string
 			fmt.Scanln(&response)
@@ -139,69 +213,62 @@ func cleanupOldVersionsCommand(args []string) error {
 			}
 		}
 
-		// Delete from HSM and metadata
-		for _, version := range toDelete {
-			if !*dryRun {
-				// Delete from HSM
-				key, err := p11ctx.FindKey(nil, []byte(version.Label))
-				if err != nil {
-					log.Printf("  ⚠ Failed to find key %s: %v", version.Label, err)
-					continue
-				}
-				if key != nil {
-					if err := key.Delete(); err != nil {
-						log.Printf("  ✗ Failed to delete key %s: %v", version.Label, err)
-						continue
-					}
-				}
-				fmt.Printf("  ✓ Deleted %s (v%d) from HSM\n", version.Label, version.Version)
-			} else {
+		if *dryRun {
+			for _, version := range toDelete {
 				fmt.Printf("  [DRY-RUN] Would delete %s (v%d)\n", version.Label, version.Version)
 			}
-			totalDeleted++
-		}
-
-		// Update metadata
-		if !*dryRun {
-			keyMeta.Versions = toKeep
+		} else {
+			// Versions are kept in metadata as destroyed
 			metadata.Rotation[contextName] = keyMeta
-			modified = true
+		}
+		destroyed = append(destroyed, toDelete...)
+		for _, version := range toDelete {
+			contextOf[version.Label] = contextName
 		}
 
-		fmt.Printf("  Summary: kept %d, deleted %d\n", len(toKeep), len(toDelete))
+		fmt.Printf("  Summary: kept %d, deleting %d\n", len(toKeep), len(toDelete))
 	}
 
-	// Save updated metadata
-	if modified && !*dryRun {
-		// Backup old metadata (copy instead of rename for bind mounts)
-		backupPath := metadataPath + ".backup." + time.Now().Format("20060102-150405")
-		oldData, err := os.ReadFile(metadataPath)
-		if err != nil {
-			log.Printf("Warning: failed to read metadata for backup: %v", err)
-		} else {
-			if err := os.WriteFile(backupPath, oldData, 0644); err != nil {
-				log.Printf("Warning: failed to write backup: %v", err)
-			} else {
-				fmt.Printf("\n✓ Old metadata backed up to: %s\n", backupPath)
-			}
-		}
+	if *dryRun {
+		fmt.Println()
+		fmt.Printf("DRY RUN COMPLETE - Would delete %d versions\n", len(destroyed))
+		return nil
+	}
+	if len(destroyed) == 0 {
+		fmt.Println()
+		fmt.Println("CLEANUP COMPLETE - Deleted 0 versions")
+		return nil
+	}
+
+	// Record the destruction before deleting key objects: a failed save
+	// leaves the keys untouched
+	backupPath, err := store.Backup(cfg.HSM.MetadataBackupDirPath())
+	if err != nil {
+		return fmt.Errorf("failed to back up metadata: %w", err)
+	}
+	fmt.Printf("\n✓ Old metadata backed up to: %s\n", backupPath)
+	if err := saveMetadata(cfg, store, revision, metadata, mac); err != nil {
+		return err
+	}
+	fmt.Printf("✓ Metadata updated: %s\n", store)
 
-		// Save new metadata
-		data, err := yaml.Marshal(metadata)
+	// Delete from HSM
+	var failed []string
+	for _, version := range destroyed {
+		err := destroyKeyObject(p11ctx, version.Label)
+		auditKeyDeleted(cfg, contextOf[version.Label], version.Label, err)
 		if err != nil {
-			return fmt.Errorf("failed to marshal metadata: %w", err)
-		}
-		if err := os.WriteFile(metadataPath, data, 0644); err != nil {
-			return fmt.Errorf("failed to write metadata: %w", err)
+			log.Printf("  ✗ %v", err)
+			failed = append(failed, version.Label)
+			continue
 		}
-		fmt.Printf("✓ Metadata updated: %s\n", metadataPath)
+		fmt.Printf("  ✓ Deleted %s (v%d) from HSM\n", version.Label, version.Version)
 	}
 
 	fmt.Println()
-	if *dryRun {
-		fmt.Printf("DRY RUN COMPLETE - Would delete %d versions\n", totalDeleted)
-	} else {
-		fmt.Printf("CLEANUP COMPLETE - Deleted %d versions\n", totalDeleted)
+	fmt.Printf("CLEANUP COMPLETE - Deleted %d versions\n", len(destroyed)-len(failed))
+	if len(failed) > 0 {
+		return destroyLeftoverError(strings.Join(failed, ", "), fmt.Errorf("%d key deletion(s) failed", len(failed)))
 	}
 
 	return nil
@@ -6,7 +6,9 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"log/slog"
 	"os"
+	"time"
 
 	"github.com/ThalesGroup/crypto11"
 	"github.com/miekg/pkcs11"
@@ -71,6 +73,42 @@ func main() {
 		if err := updateChecksumsCommand(args[1:]); err != nil {
 			log.Fatalf("Failed to update checksums: %v", err)
 		}
+	case "set-key-state":
+		if err := setKeyStateCommand(args[1:]); err != nil {
+			log.Fatalf("Failed to change key state: %v", err)
+		}
+	case "sign-metadata":
+		if err := signMetadataCommand(args[1:]); err != nil {
+			log.Fatalf("Failed to sign metadata: %v", err)
+		}
+	case "audit-keys":
+		if err := auditKeysCommand(args[1:]); err != nil {
+			log.Fatalf("Key audit: %v", err)
+		}
+	case "metadata":
+		if err := metadataCommand(args[1:]); err != nil {
+			log.Fatalf("Metadata command failed: %v", err)
+		}
+	case "reconcile":
+		if err := reconcileCommand(args[1:]); err != nil {
+			log.Fatalf("Reconcile: %v", err)
+		}
+	case "reencrypt":
+		if err := reencryptCommand(args[1:]); err != nil {
+			log.Fatalf("Re-encryption failed: %v", err)
+		}
+	case "tls-key":
+		if err := tlsKeyCommand(args[1:]); err != nil {
+			log.Fatalf("TLS key: %v", err)
+		}
+	case "audit":
+		if err := auditCommand(args[1:]); err != nil {
+			log.Fatalf("Audit: %v", err)
+		}
+	case "pki":
+		if err := pkiCommand(args[1:]); err != nil {
+			log.Fatalf("PKI: %v", err)
+		}
 	default:
 		fmt.Fprintf(os.Stderr, "Unknown command: %s\n", command)
 		printUsage()
@@ -93,10 +131,19 @@ func printUsage() {
 	fmt.Println("  list-kek          List all KEKs")
 	fmt.Println("  delete-kek        Delete a KEK")
 	fmt.Println("  export-metadata   Export KEK metadata to file")
-	fmt.Println("  rotate            Rotate a KEK to new version")
+	fmt.Println("  rotate            Rotate a KEK to new version (--dry-run, --all-due, rollback <context>)")
 	fmt.Println("  rotation-status   Check rotation status for all keys")
 	fmt.Println("  cleanup-old-versions  Delete old key versions (PCI DSS compliance)")
 	fmt.Println("  update-checksums  Compute and update KEK checksums (integrity verification)")
+	fmt.Println("  set-key-state     Move a key version between lifecycle states")
+	fmt.Println("  sign-metadata     Sign metadata.yaml with the HSM HMAC key (or --verify)")
+	fmt.Println("  audit-keys        Audit PKCS#11 attributes and inventory of all keys (PCI DSS)")
+	fmt.Println("  metadata restore  Restore metadata.yaml from a backup (checked against the token)")
+	fmt.Println("  reconcile         Compare metadata with keys on the token (--fix to repair)")
+	fmt.Println("  reencrypt         Bulk re-encrypt a JSONL dataset to the current key version")
+	fmt.Println("  tls-key           Create the HTTPS server key pair in HSM and write a CSR")
+	fmt.Println("  audit             Audit log checkpoint key, chain verification and search (init-key, verify, query)")
+	fmt.Println("  pki               Mini-CA for client certificates (init-ca, sign-csr, list, revoke)")
 	fmt.Println()
 	fmt.Println("Examples:")
 	fmt.Println("  hsm-admin --config /etc/hsm-service/config.yaml list-kek")
@@ -105,10 +152,27 @@ func printUsage() {
 	fmt.Println("  hsm-admin list-kek")
 	fmt.Println("  hsm-admin delete-kek --label kek-old-v1 --confirm")
 	fmt.Println("  hsm-admin export-metadata --output metadata.json")
-	fmt.Println("  hsm-admin rotate kek-exchange-v1")
+	fmt.Println("  hsm-admin rotate exchange-key --dry-run")
+	fmt.Println("  hsm-admin rotate --all-due")
+	fmt.Println("  hsm-admin rotate rollback exchange-key")
 	fmt.Println("  hsm-admin rotation-status")
 	fmt.Println("  hsm-admin cleanup-old-versions --dry-run")
 	fmt.Println("  hsm-admin update-checksums")
+	fmt.Println("  hsm-admin set-key-state --label kek-exchange-v1 --state disabled")
+	fmt.Println("  hsm-admin set-key-state --label kek-exchange-v1 --state scheduled_for_destruction --wait-days 30")
+	fmt.Println("  hsm-admin sign-metadata --init-key")
+	fmt.Println("  hsm-admin audit-keys --format json --output key-audit.json")
+	fmt.Println("  hsm-admin metadata restore /app/metadata/backups/metadata.yaml.backup-20260101-120000.000 --dry-run")
+	fmt.Println("  hsm-admin reconcile --fix --prune-dangling")
+	fmt.Println("  hsm-admin reencrypt --input data.jsonl --output data.new.jsonl --context exchange --client-ou Trading")
+	fmt.Println("  hsm-admin tls-key --label tls-hsm-service --cn hsm-service.local --dns hsm-service.local,localhost --ip 127.0.0.1")
+	fmt.Println("  hsm-admin audit init-key")
+	fmt.Println("  hsm-admin audit verify --file /var/log/hsm-service/audit.log")
+	fmt.Println("  hsm-admin audit query --from 2026-01-01 --to 2026-01-31 --client-cn trading-service-1 --outcome denied")
+	fmt.Println("  hsm-admin audit query --context exchange-key --key-version 2 --all --format csv --output events.csv")
+	fmt.Println("  hsm-admin pki init-ca --cn \"HSM Service Issuing CA\" --days 1825")
+	fmt.Println("  hsm-admin pki sign-csr --csr trading-service-1.csr --ttl 72h")
+	fmt.Println("  hsm-admin pki revoke --serial 3F2A9C --reason key-compromise")
 	fmt.Println()
 	fmt.Println("Environment Variables:")
 	fmt.Println("  HSM_PIN          HSM token PIN (required)")
@@ -117,15 +181,16 @@ func printUsage() {
 
 func createKEK(args []string) {
 	fs := flag.NewFlagSet("create-kek", flag.ExitOnError)
-	label := fs.String("label", "", "KEK label (required)")
+	label := fs.String("label", "", "KEK label (default: rendered from hsm.keys.<context>.label_template)")
 	context := fs.String("context", "", "Context name (required)")
+	version := fs.Int("version", 1, "Key version used with label_template")
 	keySize := fs.Int("size", 256, "Key size in bits (default: 256)")
 	configPath := fs.String("config", getConfigPath(), "Path to config.yaml")
 
 	fs.Parse(args)
 
-	if *label == "" || *context == "" {
-		fmt.Println("Error: --label and --context are required")
+	if *context == "" {
+		fmt.Println("Error: --context is required")
 		fs.Usage()
 		os.Exit(1)
 	}
@@ -147,6 +212,20 @@ func createKEK(args []string) {
 		log.Fatalf("Failed to load config: %v", err)
 	}
 
+	// Label follows the context's naming scheme when one is configured
+	tmpl := cfg.HSM.ContextLabelTemplate(*context)
+	switch {
+	case *label == "" && tmpl == "":
+		log.Fatalf("--label is required (no hsm.keys.%s.label_template configured)", *context)
+	case *label == "":
+		*label = config.RenderLabel(tmpl, *version)
+	case tmpl != "":
+		if v, ok := config.MatchLabel(tmpl, *label); !ok || v != *version {
+			log.Fatalf("Label %s does not match hsm.keys.%s.label_template for version %d (expected %s)",
+				*label, *context, *version, config.RenderLabel(tmpl, *version))
+		}
+	}
+
 	// Initialize PKCS#11 context
 	p11ctx, err := crypto11.Configure(&crypto11.Config{
 		Path:       cfg.HSM.PKCS11Lib,
@@ -251,11 +330,11 @@ func listKEK(args []string) {
 	}
 
 	// Load metadata
-	metadataPath := cfg.HSM.MetadataFile
-	if metadataPath == "" {
-		metadataPath = "metadata.yaml"
+	store, err := openMetadataStore(cfg)
+	if err != nil {
+		log.Fatal(err)
 	}
-	metadata, err := config.LoadMetadata(metadataPath)
+	metadata, _, err := store.Load()
 	if err != nil {
 		log.Printf("Warning: failed to load metadata: %v", err)
 		metadata = &config.Metadata{Rotation: make(map[string]config.KeyMetadata)}
@@ -275,7 +354,7 @@ func listKEK(args []string) {
 				if v.Label == meta.Current {
 					marker = "*"
 				}
-				fmt.Printf("     %s %s (v%d)\n", marker, v.Label, v.Version)
+				fmt.Printf("     %s %s (v%d, %s)\n", marker, v.Label, v.Version, v.EffectiveState(meta.Current))
 			}
 		} else {
 			fmt.Printf("   Label: (not in metadata)\n")
@@ -345,31 +424,67 @@ func deleteKEK(args []string) {
 	}
 	defer p11ctx.Close()
 
-	fmt.Printf("Searching for KEK: %s\n", *label)
+	// Acquire metadata lock (shared with rotate and the rotation scheduler)
+	store, err := openMetadataStore(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	unlock, err := store.Lock()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer unlock()
 
-	// Find key by label
-	key, err := p11ctx.FindKey(nil, []byte(*label))
+	metadata, revision, mac, err := loadMetadataForUpdate(cfg, p11ctx, store)
 	if err != nil {
-		log.Fatalf("Failed to find KEK: %v", err)
+		log.Fatal(err)
 	}
 
-	if key == nil {
-		log.Fatalf("KEK not found: %s", *label)
+	// Only versions scheduled for destruction whose waiting period has
+	// passed can be deleted (set-key-state --state scheduled_for_destruction)
+	contextName := ""
+	for name, keyMeta := range metadata.Rotation {
+		for _, v := range keyMeta.Versions {
+			if v.Label == *label {
+				contextName = name
+			}
+		}
+	}
+	if contextName == "" {
+		log.Fatalf("Refusing to delete KEK: %s is not in metadata (adopt it with 'hsm-admin reconcile --fix --add-orphans' and schedule its destruction)", *label)
+	}
+	keyMeta := metadata.Rotation[contextName]
+	if err := markDestroyed(cfg, &keyMeta, *label, time.Now()); err != nil {
+		auditEvent(cfg, slog.LevelWarn, "key deletion",
+			"context", contextName,
+			"label", *label,
+			"outcome", "denied",
+			"error", err)
+		log.Fatalf("Refusing to delete KEK: %v", err)
 	}
+	metadata.Rotation[contextName] = keyMeta
 
-	// Delete the key
-	err = key.Delete()
+	// Record the destruction before deleting the key object
+	backupPath, err := store.Backup(cfg.HSM.MetadataBackupDirPath())
 	if err != nil {
-		log.Fatalf("Failed to delete KEK: %v", err)
+		log.Fatalf("Failed to back up metadata: %v", err)
+	}
+	if err := saveMetadata(cfg, store, revision, metadata, mac); err != nil {
+		log.Fatal(err)
 	}
 
+	fmt.Printf("Deleting KEK: %s\n", *label)
+	if err := destroyKeyObject(p11ctx, *label); err != nil {
+		auditKeyDeleted(cfg, contextName, *label, err)
+		log.Fatal(destroyLeftoverError(*label, err))
+	}
+	auditKeyDeleted(cfg, contextName, *label, nil)
 	fmt.Printf("✓ KEK deleted successfully: %s\n", *label)
+	fmt.Printf("  Metadata backup: %s\n", backupPath)
 	fmt.Println()
 	fmt.Println("WARNING: All data encrypted with this KEK is now unrecoverable!")
 	fmt.Println()
-	fmt.Println("Next steps:")
-	fmt.Println("1. Remove KEK from config.yaml")
-	fmt.Println("2. Restart HSM service")
+	fmt.Println("The version stays in metadata as destroyed; the HSM service applies the change via metadata hot reload")
 }
 
 func exportMetadata(args []string) {
@@ -406,11 +521,11 @@ func exportMetadata(args []string) {
 	}
 
 	// Load metadata
-	metadataPath := cfg.HSM.MetadataFile
-	if metadataPath == "" {
-		metadataPath = "metadata.yaml"
+	store, err := openMetadataStore(cfg)
+	if err != nil {
+		log.Fatal(err)
 	}
-	metadataFile, err := config.LoadMetadata(metadataPath)
+	metadataFile, _, err := store.Load()
 	if err != nil {
 		log.Printf("Warning: failed to load metadata: %v", err)
 		metadataFile = &config.Metadata{Rotation: make(map[string]config.KeyMetadata)}
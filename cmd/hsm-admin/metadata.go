@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/ThalesGroup/crypto11"
+	"github.com/titaev-lv/hsm-service/internal/config"
+	"github.com/titaev-lv/hsm-service/internal/hsm"
+)
+
+// openPKCS11 opens a PKCS#11 session with the PIN from HSM_PIN
+func openPKCS11(cfg *config.Config) (*crypto11.Context, error) {
+	pin := os.Getenv("HSM_PIN")
+	if pin == "" {
+		return nil, fmt.Errorf("HSM_PIN environment variable not set")
+	}
+
+	p11ctx, err := crypto11.Configure(&crypto11.Config{
+		Path:       cfg.HSM.PKCS11Lib,
+		TokenLabel: cfg.HSM.SlotID,
+		Pin:        pin,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure PKCS#11: %w", err)
+	}
+	return p11ctx, nil
+}
+
+// openMetadataStore opens the metadata store selected by hsm.metadata_store
+func openMetadataStore(cfg *config.Config) (config.MetadataStore, error) {
+	store, err := config.OpenMetadataStore(&cfg.HSM)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open metadata store: %w", err)
+	}
+	return store, nil
+}
+
+// loadMetadataForUpdate loads metadata before a change. With metadata signing
+// enabled it refuses unsigned, tampered or rolled-back metadata and returns
+// the MAC used to sign the result. The returned revision is passed to
+// saveMetadata. Caller holds the metadata lock.
+func loadMetadataForUpdate(cfg *config.Config, p11ctx *crypto11.Context, store config.MetadataStore) (*config.Metadata, string, config.MetadataMAC, error) {
+	mac, err := hsm.MetadataMACFromConfig(p11ctx, &cfg.HSM)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	minGeneration := uint64(0)
+	if mac != nil {
+		minGeneration, err = config.LoadGeneration(cfg.HSM.MetadataGenerationFilePath())
+		if err != nil {
+			return nil, "", nil, err
+		}
+	}
+
+	metadata, revision, err := config.LoadVerifiedMetadata(store, mac, minGeneration)
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("failed to load metadata: %w", err)
+	}
+	return metadata, revision, mac, nil
+}
+
+// saveMetadata signs (when enabled) and stores metadata if it is still at
+// revision, then records the new generation so older signed copies are refused
+func saveMetadata(cfg *config.Config, store config.MetadataStore, revision string, metadata *config.Metadata, mac config.MetadataMAC) error {
+	if _, err := config.SaveSignedMetadata(store, revision, metadata, mac); err != nil {
+		return fmt.Errorf("failed to save metadata: %w", err)
+	}
+	recordGeneration(cfg, metadata.Generation, mac)
+	return nil
+}
+
+// recordGeneration stores the highest accepted generation (best effort)
+func recordGeneration(cfg *config.Config, generation uint64, mac config.MetadataMAC) {
+	if mac == nil {
+		return
+	}
+	if err := config.SaveGeneration(cfg.HSM.MetadataGenerationFilePath(), generation); err != nil {
+		log.Printf("Warning: failed to record metadata generation: %v", err)
+	}
+}
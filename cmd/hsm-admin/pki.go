@@ -0,0 +1,305 @@
+package main
+
+import (
+	"crypto"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/titaev-lv/hsm-service/internal/config"
+	"github.com/titaev-lv/hsm-service/internal/hsm"
+	"github.com/titaev-lv/hsm-service/internal/pki"
+)
+
+const pkiUsage = "usage: hsm-admin pki <init-ca|sign-csr|list|revoke> [options]"
+
+// pkiCommand dispatches the mini-CA subcommands
+func pkiCommand(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf(pkiUsage)
+	}
+
+	switch args[0] {
+	case "init-ca":
+		return pkiInitCACommand(args[1:])
+	case "sign-csr":
+		return pkiSignCSRCommand(args[1:])
+	case "list":
+		return pkiListCommand(args[1:])
+	case "revoke":
+		return pkiRevokeCommand(args[1:])
+	default:
+		return fmt.Errorf("unknown pki subcommand: %s\n%s", args[0], pkiUsage)
+	}
+}
+
+// pkiInitCACommand creates the CA key pair on the token and a self-signed
+// issuing CA certificate, or a CSR for an offline root to sign
+func pkiInitCACommand(args []string) error {
+	fs := flag.NewFlagSet("pki init-ca", flag.ExitOnError)
+	configPath := fs.String("config", getConfigPath(), "Path to config.yaml")
+	keyType := fs.String("type", hsm.KeyPairTypeECDSA, "Key type for a new key pair: ecdsa (P-256) or rsa (3072)")
+	cn := fs.String("cn", "HSM Service Issuing CA", "CA certificate Common Name")
+	days := fs.Int("days", 1825, "Validity of the self-signed CA certificate in days")
+	csrPath := fs.String("csr", "", "Write a CSR for an external root CA instead of a self-signed certificate")
+	force := fs.Bool("force", false, "Overwrite an existing CA certificate")
+
+	fs.Parse(args)
+
+	cfg, err := config.LoadConfig(*configPath)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	label := cfg.PKI.GetCAKeyLabel()
+	certPath := cfg.PKI.CACertFilePath(&cfg.ACL)
+	if *csrPath == "" && !*force {
+		if _, err := os.Stat(certPath); err == nil {
+			return fmt.Errorf("CA certificate %s already exists (use --force to replace it)", certPath)
+		}
+	}
+
+	p11ctx, err := openPKCS11(cfg)
+	if err != nil {
+		return err
+	}
+	defer p11ctx.Close()
+
+	existing, err := p11ctx.FindKeyPair(nil, []byte(label))
+	if err != nil {
+		return fmt.Errorf("failed to check for existing key pair: %w", err)
+	}
+	var signer crypto.Signer
+	if existing == nil {
+		if signer, err = hsm.GenerateKeyPair(p11ctx, label, *keyType); err != nil {
+			return err
+		}
+		auditEvent(cfg, slog.LevelInfo, "key pair created",
+			"purpose", "pki_ca",
+			"label", label,
+			"type", *keyType,
+			"outcome", "success")
+		fmt.Printf("✓ Created CA key pair in HSM: %s (%s)\n", label, *keyType)
+	} else {
+		if signer, err = hsm.FindSigner(p11ctx, label); err != nil {
+			return err
+		}
+		fmt.Printf("✓ Using existing CA key pair: %s (--type ignored)\n", label)
+	}
+
+	if *csrPath != "" {
+		csrPEM, err := pki.NewCARequest(signer, *cn)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(*csrPath, csrPEM, 0644); err != nil {
+			return fmt.Errorf("failed to write CSR: %w", err)
+		}
+		fmt.Printf("✓ CA CSR written: %s (CN=%s)\n", *csrPath, *cn)
+		fmt.Println()
+		fmt.Println("Next steps:")
+		fmt.Println("  1. Sign the CSR with the root CA as an intermediate (CA:TRUE, pathlen:0, keyCertSign)")
+		fmt.Printf("  2. Save the certificate as %s\n", certPath)
+		fmt.Println("  3. Add the root CA to server.tls.ca_paths")
+		return nil
+	}
+
+	certPEM, err := pki.NewCACertificate(signer, *cn, time.Duration(*days)*24*time.Hour)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(certPath), 0755); err != nil {
+		return fmt.Errorf("failed to create CA directory: %w", err)
+	}
+	if err := config.WriteFileAtomic(certPath, certPEM, 0644); err != nil {
+		return fmt.Errorf("failed to write CA certificate: %w", err)
+	}
+	auditEvent(cfg, slog.LevelInfo, "CA certificate created",
+		"label", label,
+		"subject_cn", *cn,
+		"days", *days,
+		"path", certPath,
+		"outcome", "success")
+	fmt.Printf("✓ CA certificate written: %s (CN=%s, %d days)\n", certPath, *cn, *days)
+	fmt.Println()
+	fmt.Println("Next steps:")
+	fmt.Printf("  1. Add %s to server.tls.ca_paths so issued certificates are accepted\n", certPath)
+	fmt.Println("  2. Set pki.enabled: true to serve /pki/sign-csr, then restart hsm-service")
+	return nil
+}
+
+// pkiSignCSRCommand issues a certificate from a CSR file. Unlike
+// /pki/sign-csr it may issue certificates for the admin OUs.
+func pkiSignCSRCommand(args []string) error {
+	fs := flag.NewFlagSet("pki sign-csr", flag.ExitOnError)
+	configPath := fs.String("config", getConfigPath(), "Path to config.yaml")
+	csrPath := fs.String("csr", "", "CSR file (PEM, required)")
+	ttl := fs.Duration("ttl", 0, "Certificate lifetime, e.g. 24h (default: pki.default_ttl)")
+	outPath := fs.String("out", "", "Output certificate file (default: <cn>.crt)")
+
+	fs.Parse(args)
+
+	if *csrPath == "" {
+		return fmt.Errorf("--csr is required")
+	}
+	csrPEM, err := os.ReadFile(*csrPath)
+	if err != nil {
+		return fmt.Errorf("failed to read CSR: %w", err)
+	}
+	csr, err := pki.ParseCSR(csrPEM)
+	if err != nil {
+		return err
+	}
+
+	cfg, err := config.LoadConfig(*configPath)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	p11ctx, err := openPKCS11(cfg)
+	if err != nil {
+		return err
+	}
+	defer p11ctx.Close()
+
+	signer, err := hsm.FindSigner(p11ctx, cfg.PKI.GetCAKeyLabel())
+	if err != nil {
+		return fmt.Errorf("%w (run 'hsm-admin pki init-ca' first)", err)
+	}
+	registry := pki.NewRegistry(cfg.PKI.IssuedFilePath(&cfg.ACL))
+	mappings := func() map[string][]string { return cfg.ACL.Mappings }
+	revokedCNs, err := pki.RevokedCNs(cfg.ACL.RevokedFile)
+	if err != nil {
+		return err
+	}
+	revoked := func(cn string) bool { return revokedCNs[cn] }
+	ca, err := pki.NewCA(&cfg.PKI, cfg.PKI.CACertFilePath(&cfg.ACL), signer, registry, mappings, revoked, cfg.Server.GetAdminOUs())
+	if err != nil {
+		return err
+	}
+
+	issued, err := ca.Issue(pki.IssueRequest{CSR: csr, TTL: *ttl, IssuedBy: operatorName(), AllowAdminOU: true})
+	if err != nil {
+		return err
+	}
+	rec := issued.Record
+
+	if *outPath == "" {
+		*outPath = rec.CN + ".crt"
+	}
+	if err := os.WriteFile(*outPath, issued.PEM, 0644); err != nil {
+		return fmt.Errorf("certificate %s was issued but could not be written: %w", rec.Serial, err)
+	}
+
+	auditEvent(cfg, slog.LevelInfo, "certificate issued",
+		"serial", rec.Serial,
+		"subject_cn", rec.CN,
+		"subject_ou", rec.OU,
+		"spiffe_id", rec.SPIFFEID,
+		"not_after", rec.NotAfter)
+	fmt.Printf("✓ Certificate written: %s\n", *outPath)
+	fmt.Printf("  Serial:    %s\n", rec.Serial)
+	fmt.Printf("  Subject:   CN=%s, OU=%s\n", rec.CN, rec.OU)
+	fmt.Printf("  SPIFFE ID: %s\n", rec.SPIFFEID)
+	fmt.Printf("  Expires:   %s\n", rec.NotAfter.Format(time.RFC3339))
+	return nil
+}
+
+// pkiListCommand prints the registry of issued certificates
+func pkiListCommand(args []string) error {
+	fs := flag.NewFlagSet("pki list", flag.ExitOnError)
+	configPath := fs.String("config", getConfigPath(), "Path to config.yaml")
+	all := fs.Bool("all", false, "Include expired and revoked certificates")
+
+	fs.Parse(args)
+
+	cfg, err := config.LoadConfig(*configPath)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	registry := pki.NewRegistry(cfg.PKI.IssuedFilePath(&cfg.ACL))
+	records, err := registry.List()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SERIAL\tCN\tOU\tNOT AFTER\tISSUED BY\tSTATUS")
+	shown := 0
+	for _, rec := range records {
+		status := "valid"
+		switch {
+		case rec.RevokedAt != nil:
+			status = "revoked (" + rec.RevocationReason + ")"
+		case now.After(rec.NotAfter):
+			status = "expired"
+		}
+		if status != "valid" && !*all {
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			rec.Serial, rec.CN, rec.OU, rec.NotAfter.Format(time.RFC3339), rec.IssuedBy, status)
+		shown++
+	}
+	w.Flush()
+	fmt.Printf("\n%d of %d certificates (%s)\n", shown, len(records), registry.Path())
+	return nil
+}
+
+// pkiRevokeCommand marks an issued serial as revoked and adds the serial to
+// acl.revoked_file, which the service reloads on change
+func pkiRevokeCommand(args []string) error {
+	fs := flag.NewFlagSet("pki revoke", flag.ExitOnError)
+	configPath := fs.String("config", getConfigPath(), "Path to config.yaml")
+	serial := fs.String("serial", "", "Serial number as printed by 'pki list' (required)")
+	reason := fs.String("reason", "unspecified", "Revocation reason, e.g. key-compromise, superseded")
+
+	fs.Parse(args)
+
+	if *serial == "" {
+		return fmt.Errorf("--serial is required")
+	}
+
+	cfg, err := config.LoadConfig(*configPath)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	registry := pki.NewRegistry(cfg.PKI.IssuedFilePath(&cfg.ACL))
+
+	now := time.Now()
+	rec, err := registry.MarkRevoked(strings.ToUpper(*serial), *reason, now)
+	if err != nil {
+		return err
+	}
+	auditEvent(cfg, slog.LevelInfo, "certificate revoked",
+		"serial", rec.Serial,
+		"subject_cn", rec.CN,
+		"subject_ou", rec.OU,
+		"reason", *reason)
+	fmt.Printf("✓ Marked %s as revoked in %s\n", rec.Serial, registry.Path())
+
+	// Revocation is enforced by serial: a certificate reissued to the CN stays valid
+	added, err := pki.AppendRevoked(cfg.ACL.RevokedFile, rec.CN, rec.Serial, *reason, now)
+	if err != nil {
+		return fmt.Errorf("failed to update %s: %w", cfg.ACL.RevokedFile, err)
+	}
+	if added {
+		fmt.Printf("✓ Added serial %s (CN=%s) to %s (reloaded by hsm-service automatically)\n", rec.Serial, rec.CN, cfg.ACL.RevokedFile)
+	} else {
+		fmt.Printf("⚠ Serial %s is already listed in %s\n", rec.Serial, cfg.ACL.RevokedFile)
+	}
+	return nil
+}
+
+// operatorName identifies the admin running hsm-admin in the registry
+func operatorName() string {
+	if user := os.Getenv("USER"); user != "" {
+		return user
+	}
+	return "hsm-admin"
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/ThalesGroup/crypto11"
+	"github.com/titaev-lv/hsm-service/internal/config"
+	"github.com/titaev-lv/hsm-service/internal/hsm"
+)
+
+// metadataCommand dispatches "metadata <subcommand>"
+func metadataCommand(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: hsm-admin metadata restore <backup> [--dry-run] [--yes] [--force]")
+	}
+
+	switch args[0] {
+	case "restore":
+		return restoreMetadataCommand(args[1:])
+	default:
+		return fmt.Errorf("unknown metadata subcommand: %s", args[0])
+	}
+}
+
+// restoreMetadataCommand replaces metadata.yaml with a backup after checking
+// the backup against the keys actually present on the token
+func restoreMetadataCommand(args []string) error {
+	fs := flag.NewFlagSet("metadata restore", flag.ExitOnError)
+	configPath := fs.String("config", getConfigPath(), "Path to config.yaml")
+	dryRun := fs.Bool("dry-run", false, "Show what would change without restoring")
+	yes := fs.Bool("yes", false, "Restore without confirmation")
+	force := fs.Bool("force", false, "Restore a backup whose signature does not verify")
+
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: hsm-admin metadata restore <backup> [--dry-run] [--yes] [--force]")
+	}
+	backupFile := fs.Arg(0)
+
+	cfg, err := config.LoadConfig(*configPath)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	store, err := openMetadataStore(cfg)
+	if err != nil {
+		return err
+	}
+
+	restored, err := config.LoadMetadata(backupFile)
+	if err != nil {
+		return fmt.Errorf("failed to load backup: %w", err)
+	}
+
+	p11ctx, err := openPKCS11(cfg)
+	if err != nil {
+		return err
+	}
+	defer p11ctx.Close()
+
+	mac, err := hsm.MetadataMACFromConfig(p11ctx, &cfg.HSM)
+	if err != nil {
+		return err
+	}
+
+	// Only the signature is checked: a backup is older than the current
+	// metadata by definition, so the generation check does not apply
+	if mac != nil {
+		if err := config.VerifyMetadata(restored, mac, 0); err != nil {
+			if !*force {
+				return fmt.Errorf("backup %s: %w (use --force after manual review)", backupFile, err)
+			}
+			fmt.Printf("⚠ Backup signature does not verify: %v (--force)\n", err)
+		} else {
+			fmt.Printf("✓ Backup signature valid (generation %d)\n", restored.Generation)
+		}
+	}
+
+	issues, err := reconcileWithToken(cfg, p11ctx, restored)
+	if err != nil {
+		return err
+	}
+	printReconcileIssues(issues)
+	if hsm.HasCurrentMissing(issues) {
+		return fmt.Errorf("refusing to restore %s: current key version missing from the token", backupFile)
+	}
+
+	if !*dryRun {
+		// Acquire metadata lock (shared with rotate and the rotation scheduler)
+		unlock, err := store.Lock()
+		if err != nil {
+			return err
+		}
+		defer unlock()
+	}
+
+	// The current metadata may be the damaged one, so it is read without
+	// verification; an empty store (e.g. a new bolt database) is seeded
+	revision, err := store.Revision()
+	if err != nil {
+		return fmt.Errorf("failed to read metadata store: %w", err)
+	}
+	current, _, err := store.Load()
+	if err != nil && !errors.Is(err, config.ErrMetadataNotFound) {
+		fmt.Printf("⚠ Current metadata unreadable: %v\n", err)
+	}
+	if current == nil {
+		current = &config.Metadata{}
+	}
+
+	fmt.Printf("\nChanges to %s:\n", store)
+	diff := hsm.DiffMetadata(current, restored)
+	if len(diff) == 0 {
+		fmt.Println("  (no changes to key versions)")
+	}
+	for _, line := range diff {
+		fmt.Printf("  %s\n", line)
+	}
+
+	if *dryRun {
+		fmt.Println("\n(DRY RUN - metadata not restored)")
+		return nil
+	}
+
+	if !*yes {
+		fmt.Printf("\nRestore %s from %s? (yes/no): ", store, backupFile)
+		var response string
+		fmt.Scanln(&response)
+		if response != "yes" {
+			fmt.Println("Restore cancelled")
+			return nil
+		}
+	}
+
+	var backupPath string
+	if revision != "" {
+		backupPath, err = store.Backup(cfg.HSM.MetadataBackupDirPath())
+		if err != nil {
+			return fmt.Errorf("failed to back up metadata: %w", err)
+		}
+	}
+
+	// Re-sign above every generation seen, so the restored copy is not
+	// rejected as a rollback and older copies stay rejected
+	if mac != nil {
+		minGeneration, err := config.LoadGeneration(cfg.HSM.MetadataGenerationFilePath())
+		if err != nil {
+			return err
+		}
+		restored.Generation = max(restored.Generation, current.Generation, minGeneration)
+	}
+	if err := saveMetadata(cfg, store, revision, restored, mac); err != nil {
+		return err
+	}
+
+	auditEvent(cfg, slog.LevelInfo, "metadata restore",
+		"backup", backupFile,
+		"generation", restored.Generation,
+		"changes", len(diff),
+		"outcome", "success")
+	fmt.Printf("✓ Restored %s from %s\n", store, backupFile)
+	if backupPath != "" {
+		fmt.Printf("  Previous metadata backup: %s\n", backupPath)
+	}
+	fmt.Println("  The HSM service picks up the change via metadata hot reload")
+
+	return nil
+}
+
+// reconcileCommand compares metadata with the keys on the token and
+// optionally fixes orphan and dangling versions
+func reconcileCommand(args []string) error {
+	fs := flag.NewFlagSet("reconcile", flag.ExitOnError)
+	configPath := fs.String("config", getConfigPath(), "Path to config.yaml")
+	fix := fs.Bool("fix", false, "Apply fixes selected by --add-orphans/--prune-dangling/--delete-destroyed")
+	addOrphans := fs.Bool("add-orphans", false, "Add token keys missing from metadata as decrypt_only versions")
+	pruneDangling := fs.Bool("prune-dangling", false, "Remove non-current versions missing from the token")
+	deleteDestroyed := fs.Bool("delete-destroyed", false, "Delete keys recorded as destroyed that are still on the token")
+	yes := fs.Bool("yes", false, "Apply fixes without confirmation")
+
+	fs.Parse(args)
+
+	opts := hsm.ReconcileOptions{AddOrphans: *addOrphans, PruneDangling: *pruneDangling}
+	if *fix && !opts.AddOrphans && !opts.PruneDangling && !*deleteDestroyed {
+		return fmt.Errorf("--fix requires --add-orphans, --prune-dangling and/or --delete-destroyed")
+	}
+
+	cfg, err := config.LoadConfig(*configPath)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	store, err := openMetadataStore(cfg)
+	if err != nil {
+		return err
+	}
+
+	if *fix {
+		// Acquire metadata lock (shared with rotate and the rotation scheduler)
+		unlock, err := store.Lock()
+		if err != nil {
+			return err
+		}
+		defer unlock()
+	}
+
+	p11ctx, err := openPKCS11(cfg)
+	if err != nil {
+		return err
+	}
+	defer p11ctx.Close()
+
+	// Metadata is verified (when signing is enabled) only if it will be rewritten
+	var metadata *config.Metadata
+	var revision string
+	var mac config.MetadataMAC
+	if *fix {
+		metadata, revision, mac, err = loadMetadataForUpdate(cfg, p11ctx, store)
+		if err != nil {
+			return err
+		}
+	} else {
+		metadata, revision, err = store.Load()
+		if err != nil {
+			return fmt.Errorf("failed to load metadata: %w", err)
+		}
+	}
+
+	issues, err := reconcileWithToken(cfg, p11ctx, metadata)
+	if err != nil {
+		return err
+	}
+	printReconcileIssues(issues)
+
+	// Without a selection the report previews every available fix
+	applyOpts := opts
+	if !*fix {
+		applyOpts = hsm.ReconcileOptions{AddOrphans: true, PruneDangling: true}
+	}
+	fixed, changes := hsm.ApplyReconcile(metadata, issues, applyOpts, time.Now().UTC())
+
+	// Leftovers of an interrupted destruction: metadata already records them
+	var leftovers []hsm.ReconcileIssue
+	for _, issue := range issues {
+		if issue.Kind == hsm.IssueDestroyedPresent && (*deleteDestroyed || !*fix) {
+			leftovers = append(leftovers, issue)
+			changes = append(changes, fmt.Sprintf("%s: delete %s from the token (recorded as destroyed)", issue.Context, issue.Label))
+		}
+	}
+
+	if len(changes) > 0 {
+		if *fix {
+			fmt.Println("\nChanges:")
+		} else {
+			fmt.Println("\nreconcile --fix would change:")
+		}
+		for _, change := range changes {
+			fmt.Printf("  %s\n", change)
+		}
+	}
+
+	if !*fix {
+		if hsm.HasCurrentMissing(issues) {
+			return fmt.Errorf("current key version missing from the token")
+		}
+		if len(issues) > 0 {
+			return fmt.Errorf("%d difference(s) between metadata and the token", len(issues))
+		}
+		return nil
+	}
+
+	if len(changes) == 0 {
+		fmt.Println("\nNothing to fix")
+		return nil
+	}
+
+	if !*yes {
+		fmt.Printf("\nApply %d change(s) to %s? (yes/no): ", len(changes), store)
+		var response string
+		fmt.Scanln(&response)
+		if response != "yes" {
+			fmt.Println("Reconcile cancelled")
+			return nil
+		}
+	}
+
+	// Adopted keys get a checksum so the service verifies them on load
+	for _, issue := range issues {
+		if issue.Kind != hsm.IssueOrphan || issue.Context == "" || !opts.AddOrphans {
+			continue
+		}
+		if err := setAdoptedChecksum(p11ctx, fixed, issue.Context, issue.Label); err != nil {
+			return err
+		}
+	}
+
+	// Token-only changes (leftover deletions) leave metadata as it is
+	var backupPath string
+	if metadataChanges := changes[:len(changes)-len(leftovers)]; len(metadataChanges) > 0 {
+		backupPath, err = store.Backup(cfg.HSM.MetadataBackupDirPath())
+		if err != nil {
+			return fmt.Errorf("failed to back up metadata: %w", err)
+		}
+		if err := saveMetadata(cfg, store, revision, fixed, mac); err != nil {
+			return err
+		}
+		for _, change := range metadataChanges {
+			auditEvent(cfg, slog.LevelInfo, "metadata reconcile",
+				"change", change,
+				"outcome", "success")
+		}
+	}
+
+	for _, issue := range leftovers {
+		err := destroyKeyObject(p11ctx, issue.Label)
+		auditKeyDeleted(cfg, issue.Context, issue.Label, err)
+		if err != nil {
+			return err
+		}
+	}
+	fmt.Printf("✓ Applied %d change(s) to %s\n", len(changes), store)
+	if backupPath != "" {
+		fmt.Printf("  Metadata backup: %s\n", backupPath)
+		fmt.Println("  The HSM service picks up the change via metadata hot reload")
+	}
+
+	if hsm.HasCurrentMissing(issues) {
+		return fmt.Errorf("current key version missing from the token")
+	}
+	return nil
+}
+
+// reconcileWithToken lists keys on the token and compares them with metadata
+func reconcileWithToken(cfg *config.Config, p11ctx *crypto11.Context, metadata *config.Metadata) ([]hsm.ReconcileIssue, error) {
+	keys, err := hsm.ListKeyAttributes(p11ctx)
+	if err != nil {
+		return nil, err
+	}
+	return hsm.ReconcileMetadata(metadata, keys, &cfg.HSM), nil
+}
+
+// printReconcileIssues prints differences between metadata and the token
+func printReconcileIssues(issues []hsm.ReconcileIssue) {
+	if len(issues) == 0 {
+		fmt.Println("✓ Metadata matches keys on the token")
+		return
+	}
+	for _, issue := range issues {
+		mark := "⚠"
+		if issue.Kind == hsm.IssueCurrentMissing || issue.Kind == hsm.IssueDestroyedPresent {
+			mark = "✗"
+		}
+		fmt.Printf("%s %s\n", mark, issue)
+	}
+}
+
+// setAdoptedChecksum computes the checksum of an adopted orphan key
+func setAdoptedChecksum(p11ctx *crypto11.Context, metadata *config.Metadata, context, label string) error {
+	key, err := p11ctx.FindKey(nil, []byte(label))
+	if err != nil || key == nil {
+		return fmt.Errorf("failed to find key %s: %v", label, err)
+	}
+	checksum, err := hsm.ComputeKeyChecksum(p11ctx, key)
+	if err != nil {
+		return fmt.Errorf("failed to compute checksum for %s: %w", label, err)
+	}
+
+	keyMeta := metadata.Rotation[context]
+	for i := range keyMeta.Versions {
+		if keyMeta.Versions[i].Label == label {
+			keyMeta.Versions[i].Checksum = checksum
+		}
+	}
+	metadata.Rotation[context] = keyMeta
+	return nil
+}
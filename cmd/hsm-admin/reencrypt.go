@@ -0,0 +1,710 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/titaev-lv/hsm-service/internal/config"
+	"github.com/titaev-lv/hsm-service/internal/hsm"
+)
+
+// reencryptCheckpoint is persisted periodically so an interrupted run can resume
+type reencryptCheckpoint struct {
+	InputLines  int64                      `json:"input_lines"`  // Input lines fully written to output
+	OutputBytes int64                      `json:"output_bytes"` // Output size at that point
+	ErrorsBytes int64                      `json:"errors_bytes"` // --errors file size at that point
+	Summary     map[string]*reencryptCount `json:"summary"`      // Counts per source key version
+	UpdatedAt   time.Time                  `json:"updated_at"`
+}
+
+// reencryptCount holds per-source-version counters
+type reencryptCount struct {
+	Rewrapped      int64 `json:"rewrapped"`
+	AlreadyCurrent int64 `json:"already_current"`
+	Failed         int64 `json:"failed"`
+}
+
+// reencryptJob is a single input line
+type reencryptJob struct {
+	seq  int64
+	line []byte
+}
+
+// reencryptResult is the processed line
+type reencryptResult struct {
+	seq       int64
+	line      []byte // Output line (rewrapped record, or original on failure)
+	sourceKey string
+	status    string // rewrapped, already_current, failed, skipped
+	err       error
+}
+
+// rewrapper rewraps a ciphertext to the current key of a context
+type rewrapper interface {
+	currentLabel(keyContext string) (string, error)
+	rewrap(ciphertext []byte, keyContext, ou, cn, keyID string) ([]byte, string, error)
+}
+
+// reencryptCommand streams JSONL records and rewraps each ciphertext to the
+// current key of its context
+func reencryptCommand(args []string) error {
+	fs := flag.NewFlagSet("reencrypt", flag.ExitOnError)
+	configPath := fs.String("config", getConfigPath(), "Path to config.yaml")
+	input := fs.String("input", "-", "Input JSONL file ('-' for stdin)")
+	output := fs.String("output", "", "Output JSONL file (required)")
+	contextName := fs.String("context", "", "Key context (default: per-record 'context' field)")
+	clientOU := fs.String("client-ou", "", "Client OU used as AAD for shared-mode keys (in-process mode)")
+	clientCN := fs.String("client-cn", "", "Client CN used as AAD for private-mode keys (in-process mode)")
+	mode := fs.String("mode", "inproc", "Rewrap mode: inproc (KeyManager) or api (service /decrypt + /encrypt)")
+	url := fs.String("url", "https://localhost:8443", "Service URL (api mode)")
+	certPath := fs.String("cert", "", "Client certificate (api mode)")
+	keyPath := fs.String("key", "", "Client private key (api mode)")
+	caPath := fs.String("ca", "", "CA certificate (api mode)")
+	workers := fs.Int("workers", 4, "Number of parallel workers")
+	checkpointPath := fs.String("checkpoint", "", "Checkpoint file (default: <output>.checkpoint)")
+	errorsPath := fs.String("errors", "", "Write failed records with error messages to this JSONL file")
+	resume := fs.Bool("resume", false, "Resume from checkpoint")
+	summaryPath := fs.String("summary", "", "Write JSON summary to this file")
+
+	fs.Parse(args)
+
+	if *output == "" {
+		fs.Usage()
+		return fmt.Errorf("--output is required")
+	}
+	if *workers < 1 {
+		return fmt.Errorf("--workers must be at least 1")
+	}
+	if *checkpointPath == "" {
+		*checkpointPath = *output + ".checkpoint"
+	}
+
+	// 1. Build rewrapper
+	var rw rewrapper
+	switch *mode {
+	case "inproc":
+		cfg, err := config.LoadConfig(*configPath)
+		if err != nil {
+			return fmt.Errorf("failed to load config: %w", err)
+		}
+		km, closeFn, err := openKeyManager(cfg)
+		if err != nil {
+			return err
+		}
+		defer closeFn()
+		rw = &kmRewrapper{km: km}
+	case "api":
+		// The service derives AAD from the client certificate only
+		if *clientOU != "" || *clientCN != "" {
+			return fmt.Errorf("--client-ou and --client-cn apply to inproc mode only; in api mode the identity is the --cert certificate")
+		}
+		client, leaf, err := newAPIClient(*certPath, *keyPath, *caPath)
+		if err != nil {
+			return err
+		}
+		api, err := newAPIRewrapper(client, *url)
+		if err != nil {
+			return err
+		}
+		api.certCN = leaf.Subject.CommonName
+		if len(leaf.Subject.OrganizationalUnit) > 0 {
+			api.certOU = leaf.Subject.OrganizationalUnit[0]
+		}
+		rw = api
+	default:
+		return fmt.Errorf("unknown --mode %q (expected inproc or api)", *mode)
+	}
+
+	// 2. Load checkpoint
+	checkpoint := &reencryptCheckpoint{Summary: make(map[string]*reencryptCount)}
+	if *resume {
+		data, err := os.ReadFile(*checkpointPath)
+		if err != nil {
+			return fmt.Errorf("failed to read checkpoint: %w", err)
+		}
+		if err := json.Unmarshal(data, checkpoint); err != nil {
+			return fmt.Errorf("failed to parse checkpoint: %w", err)
+		}
+		if checkpoint.Summary == nil {
+			checkpoint.Summary = make(map[string]*reencryptCount)
+		}
+		log.Printf("Resuming after %d input lines (%d output bytes, %d errors file bytes)",
+			checkpoint.InputLines, checkpoint.OutputBytes, checkpoint.ErrorsBytes)
+	}
+
+	// 3. Open input/output
+	var in io.Reader = os.Stdin
+	if *input != "-" {
+		f, err := os.Open(*input)
+		if err != nil {
+			return fmt.Errorf("failed to open input: %w", err)
+		}
+		defer f.Close()
+		in = f
+	}
+
+	outFlags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if *resume {
+		outFlags = os.O_CREATE | os.O_WRONLY
+	}
+	out, err := os.OpenFile(*output, outFlags, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open output: %w", err)
+	}
+	defer out.Close()
+	if *resume {
+		// Drop anything written after the last checkpoint
+		if err := out.Truncate(checkpoint.OutputBytes); err != nil {
+			return fmt.Errorf("failed to truncate output: %w", err)
+		}
+		if _, err := out.Seek(checkpoint.OutputBytes, io.SeekStart); err != nil {
+			return fmt.Errorf("failed to seek output: %w", err)
+		}
+	}
+
+	var errOut *os.File
+	if *errorsPath != "" {
+		errOut, err = os.OpenFile(*errorsPath, outFlags, 0600)
+		if err != nil {
+			return fmt.Errorf("failed to open errors file: %w", err)
+		}
+		defer errOut.Close()
+		if *resume {
+			// Errors of records after the checkpoint are reported again
+			if err := errOut.Truncate(checkpoint.ErrorsBytes); err != nil {
+				return fmt.Errorf("failed to truncate errors file: %w", err)
+			}
+			if _, err := errOut.Seek(checkpoint.ErrorsBytes, io.SeekStart); err != nil {
+				return fmt.Errorf("failed to seek errors file: %w", err)
+			}
+		}
+	}
+
+	// 4. Run pipeline: reader -> workers -> ordered writer
+	jobs := make(chan reencryptJob, *workers*2)
+	results := make(chan reencryptResult, *workers*2)
+
+	readErr := make(chan error, 1)
+	go func() {
+		defer close(jobs)
+		readErr <- readJobs(in, checkpoint.InputLines, jobs)
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < *workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				results <- processRecord(rw, job, *contextName, *clientOU, *clientCN)
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	writer := bufio.NewWriter(out)
+	pending := make(map[int64]reencryptResult)
+	next := checkpoint.InputLines
+	written := checkpoint.OutputBytes
+	errWritten := checkpoint.ErrorsBytes
+	start := time.Now()
+	lastProgress := start
+	processed := int64(0)
+
+	for res := range results {
+		pending[res.seq] = res
+		for {
+			r, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+
+			n, err := writer.Write(r.line)
+			if err != nil {
+				return fmt.Errorf("failed to write output: %w", err)
+			}
+			written += int64(n)
+			next++
+			processed++
+
+			if r.status != "skipped" {
+				count := checkpoint.Summary[r.sourceKey]
+				if count == nil {
+					count = &reencryptCount{}
+					checkpoint.Summary[r.sourceKey] = count
+				}
+				switch r.status {
+				case "rewrapped":
+					count.Rewrapped++
+				case "already_current":
+					count.AlreadyCurrent++
+				case "failed":
+					count.Failed++
+					n, err := writeReencryptError(errOut, r)
+					if err != nil {
+						return fmt.Errorf("failed to write errors file: %w", err)
+					}
+					errWritten += n
+				}
+			}
+
+			// Persist progress periodically
+			if next%1000 == 0 {
+				if err := writer.Flush(); err != nil {
+					return fmt.Errorf("failed to flush output: %w", err)
+				}
+				checkpoint.InputLines = next
+				checkpoint.OutputBytes = written
+				checkpoint.ErrorsBytes = errWritten
+				if err := saveCheckpoint(*checkpointPath, out, errOut, checkpoint); err != nil {
+					return err
+				}
+			}
+		}
+
+		if time.Since(lastProgress) >= 5*time.Second {
+			lastProgress = time.Now()
+			rate := float64(processed) / time.Since(start).Seconds()
+			log.Printf("Progress: %d records (%.0f/s), %s", next, rate, formatSummary(checkpoint.Summary))
+		}
+	}
+
+	if err := <-readErr; err != nil {
+		return fmt.Errorf("failed to read input: %w", err)
+	}
+
+	// 5. Final checkpoint and summary
+	if err := writer.Flush(); err != nil {
+		return fmt.Errorf("failed to flush output: %w", err)
+	}
+	checkpoint.InputLines = next
+	checkpoint.OutputBytes = written
+	checkpoint.ErrorsBytes = errWritten
+	if err := saveCheckpoint(*checkpointPath, out, errOut, checkpoint); err != nil {
+		return err
+	}
+
+	log.Printf("✓ Re-encryption finished: %d records in %s", next, time.Since(start).Round(time.Second))
+	printReencryptSummary(checkpoint.Summary)
+
+	if *summaryPath != "" {
+		data, err := json.MarshalIndent(checkpoint.Summary, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal summary: %w", err)
+		}
+		if err := config.WriteFileAtomic(*summaryPath, data, 0644); err != nil {
+			return fmt.Errorf("failed to write summary: %w", err)
+		}
+	}
+
+	for _, c := range checkpoint.Summary {
+		if c.Failed > 0 {
+			return fmt.Errorf("some records failed to re-encrypt (see summary)")
+		}
+	}
+
+	return nil
+}
+
+// readJobs reads input lines, skipping the first skip lines (resume)
+func readJobs(in io.Reader, skip int64, jobs chan<- reencryptJob) error {
+	reader := bufio.NewReaderSize(in, 1<<20)
+	var seq int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			if line[len(line)-1] != '\n' {
+				line = append(line, '\n')
+			}
+			if seq >= skip {
+				jobs <- reencryptJob{seq: seq, line: line}
+			}
+			seq++
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// processRecord rewraps a single JSONL record
+func processRecord(rw rewrapper, job reencryptJob, defaultContext, defaultOU, defaultCN string) reencryptResult {
+	res := reencryptResult{seq: job.seq, line: job.line}
+
+	trimmed := bytes.TrimSpace(job.line)
+	if len(trimmed) == 0 {
+		res.status = "skipped"
+		return res
+	}
+
+	fail := func(err error) reencryptResult {
+		res.status = "failed"
+		res.err = err
+		return res
+	}
+
+	var record map[string]json.RawMessage
+	if err := json.Unmarshal(trimmed, &record); err != nil {
+		res.sourceKey = "(invalid)"
+		return fail(fmt.Errorf("invalid JSON: %w", err))
+	}
+
+	field := func(name, fallback string) string {
+		var v string
+		if raw, ok := record[name]; ok {
+			json.Unmarshal(raw, &v)
+		}
+		if v == "" {
+			return fallback
+		}
+		return v
+	}
+
+	keyID := field("key_id", "")
+	res.sourceKey = keyID
+	if keyID == "" {
+		res.sourceKey = "(missing)"
+		return fail(fmt.Errorf("record has no key_id"))
+	}
+	keyContext := field("context", defaultContext)
+	if keyContext == "" {
+		return fail(fmt.Errorf("record has no context and --context not set"))
+	}
+
+	current, err := rw.currentLabel(keyContext)
+	if err != nil {
+		return fail(err)
+	}
+	if keyID == current {
+		res.status = "already_current"
+		return res
+	}
+
+	ciphertext, err := base64.StdEncoding.DecodeString(field("ciphertext", ""))
+	if err != nil || len(ciphertext) == 0 {
+		return fail(fmt.Errorf("invalid base64 ciphertext"))
+	}
+
+	newCiphertext, newKeyID, err := rw.rewrap(ciphertext, keyContext,
+		field("client_ou", defaultOU), field("client_cn", defaultCN), keyID)
+	if err != nil {
+		return fail(err)
+	}
+
+	record["ciphertext"], _ = json.Marshal(base64.StdEncoding.EncodeToString(newCiphertext))
+	record["key_id"], _ = json.Marshal(newKeyID)
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		return fail(fmt.Errorf("marshal record: %w", err))
+	}
+	res.line = append(line, '\n')
+	res.status = "rewrapped"
+	return res
+}
+
+// saveCheckpoint syncs output and the errors file (if any) and atomically
+// writes the checkpoint file
+func saveCheckpoint(path string, out, errOut *os.File, checkpoint *reencryptCheckpoint) error {
+	if err := out.Sync(); err != nil {
+		return fmt.Errorf("failed to sync output: %w", err)
+	}
+	if errOut != nil {
+		if err := errOut.Sync(); err != nil {
+			return fmt.Errorf("failed to sync errors file: %w", err)
+		}
+	}
+
+	checkpoint.UpdatedAt = time.Now().UTC()
+	data, err := json.MarshalIndent(checkpoint, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal checkpoint: %w", err)
+	}
+
+	if err := config.WriteFileAtomic(path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	return nil
+}
+
+// writeReencryptError appends a failed record to the errors file and
+// returns the number of bytes written
+func writeReencryptError(errOut *os.File, r reencryptResult) (int64, error) {
+	log.Printf("  ✗ line %d (key_id %s): %v", r.seq+1, r.sourceKey, r.err)
+	if errOut == nil {
+		return 0, nil
+	}
+	entry, _ := json.Marshal(map[string]any{
+		"line":   r.seq + 1,
+		"key_id": r.sourceKey,
+		"error":  r.err.Error(),
+	})
+	n, err := errOut.Write(append(entry, '\n'))
+	return int64(n), err
+}
+
+// formatSummary renders totals for progress lines
+func formatSummary(summary map[string]*reencryptCount) string {
+	var total reencryptCount
+	for _, c := range summary {
+		total.Rewrapped += c.Rewrapped
+		total.AlreadyCurrent += c.AlreadyCurrent
+		total.Failed += c.Failed
+	}
+	return fmt.Sprintf("rewrapped=%d already_current=%d failed=%d",
+		total.Rewrapped, total.AlreadyCurrent, total.Failed)
+}
+
+// printReencryptSummary prints counts per source key version
+func printReencryptSummary(summary map[string]*reencryptCount) {
+	labels := make([]string, 0, len(summary))
+	for label := range summary {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+
+	fmt.Println()
+	fmt.Println("Source version              Rewrapped  Already current  Failed")
+	for _, label := range labels {
+		c := summary[label]
+		fmt.Printf("%-26s  %9d  %15d  %6d\n", label, c.Rewrapped, c.AlreadyCurrent, c.Failed)
+	}
+	fmt.Printf("Total: %s\n", formatSummary(summary))
+}
+
+// openKeyManager opens the HSM and creates a KeyManager (in-process mode)
+func openKeyManager(cfg *config.Config) (*hsm.KeyManager, func(), error) {
+	pin := os.Getenv("HSM_PIN")
+	if pin == "" {
+		return nil, nil, fmt.Errorf("HSM_PIN environment variable not set")
+	}
+
+	store, err := openMetadataStore(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	metadata, _, err := store.Load()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load metadata: %w", err)
+	}
+
+	hsmCtx, err := hsm.InitHSM(&cfg.HSM, metadata, pin)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to initialize HSM: %w", err)
+	}
+
+	km, err := hsm.NewKeyManager(hsmCtx.GetContext(), cfg, store)
+	if err != nil {
+		hsmCtx.Close()
+		return nil, nil, fmt.Errorf("failed to create key manager: %w", err)
+	}
+
+	return km, func() { km.Close() }, nil
+}
+
+// kmRewrapper rewraps in-process through KeyManager
+type kmRewrapper struct {
+	km *hsm.KeyManager
+}
+
+func (k *kmRewrapper) currentLabel(keyContext string) (string, error) {
+	return k.km.GetKeyLabelByContext(keyContext)
+}
+
+func (k *kmRewrapper) rewrap(ciphertext []byte, keyContext, ou, cn, keyID string) ([]byte, string, error) {
+	plaintext, err := k.km.Decrypt(context.Background(), ciphertext, keyContext, ou, cn, keyID)
+	if err != nil {
+		return nil, "", err
+	}
+	// Zero plaintext memory after use
+	defer func() {
+		for i := range plaintext {
+			plaintext[i] = 0
+		}
+	}()
+
+	return k.km.Encrypt(context.Background(), plaintext, keyContext, ou, cn)
+}
+
+// apiRewrapper rewraps through the running service (/decrypt + /encrypt)
+// AAD is derived by the service from the client certificate used here
+type apiRewrapper struct {
+	client  *http.Client
+	baseURL string
+	certOU  string // Identity the service sees (client certificate)
+	certCN  string
+
+	mu       sync.Mutex
+	current  map[string]string // context -> current label (from /keys, updated by /encrypt)
+	mismatch sync.Once         // Warns once about records of another client
+}
+
+// newAPIRewrapper loads the current key of every context from /keys, so
+// records already on it are recognized before the first /encrypt
+func newAPIRewrapper(client *http.Client, baseURL string) (*apiRewrapper, error) {
+	a := &apiRewrapper{client: client, baseURL: baseURL, current: make(map[string]string)}
+
+	var keys struct {
+		Keys []struct {
+			Label   string `json:"label"`
+			Context string `json:"context"`
+			Current bool   `json:"current"`
+		} `json:"keys"`
+	}
+	if err := a.do(http.MethodGet, "/keys", nil, &keys); err != nil {
+		return nil, fmt.Errorf("failed to load current keys: %w", err)
+	}
+	for _, k := range keys.Keys {
+		if k.Current {
+			a.current[k.Context] = k.Label
+		}
+	}
+	return a, nil
+}
+
+func (a *apiRewrapper) currentLabel(keyContext string) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	label, ok := a.current[keyContext]
+	if !ok {
+		return "", fmt.Errorf("context %s has no current key in the service (/keys)", keyContext)
+	}
+	return label, nil
+}
+
+func (a *apiRewrapper) rewrap(ciphertext []byte, keyContext, ou, cn, keyID string) ([]byte, string, error) {
+	if ou != "" && ou != a.certOU || cn != "" && cn != a.certCN {
+		a.mismatch.Do(func() {
+			log.Printf("⚠ Records carry client_ou/client_cn (e.g. OU=%s CN=%s) other than the --cert certificate (OU=%s CN=%s): "+
+				"in api mode the service uses the certificate, records of other clients fail to decrypt",
+				ou, cn, a.certOU, a.certCN)
+		})
+	}
+
+	var dec struct {
+		Plaintext string `json:"plaintext"`
+	}
+	if err := a.post("/decrypt", map[string]string{
+		"context":    keyContext,
+		"ciphertext": base64.StdEncoding.EncodeToString(ciphertext),
+		"key_id":     keyID,
+	}, &dec); err != nil {
+		return nil, "", err
+	}
+
+	var enc struct {
+		Ciphertext string `json:"ciphertext"`
+		KeyID      string `json:"key_id"`
+	}
+	if err := a.post("/encrypt", map[string]string{
+		"context":   keyContext,
+		"plaintext": dec.Plaintext,
+	}, &enc); err != nil {
+		return nil, "", err
+	}
+
+	// The service may have rotated since /keys was read
+	a.mu.Lock()
+	a.current[keyContext] = enc.KeyID
+	a.mu.Unlock()
+
+	newCiphertext, err := base64.StdEncoding.DecodeString(enc.Ciphertext)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid ciphertext from service: %w", err)
+	}
+	return newCiphertext, enc.KeyID, nil
+}
+
+// post sends a JSON request and decodes the JSON response
+func (a *apiRewrapper) post(path string, body any, out any) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	return a.do(http.MethodPost, path, data, out)
+}
+
+// do sends a request (JSON body if any) and decodes the JSON response
+func (a *apiRewrapper) do(method, path string, body []byte, out any) error {
+	req, err := http.NewRequest(method, a.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&e)
+		return fmt.Errorf("%s: HTTP %d: %s", path, resp.StatusCode, e.Error)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// newAPIClient creates an mTLS HTTP client for the service API and returns
+// it with the parsed client certificate
+func newAPIClient(certPath, keyPath, caPath string) (*http.Client, *x509.Certificate, error) {
+	if certPath == "" || keyPath == "" || caPath == "" {
+		return nil, nil, fmt.Errorf("--cert, --key and --ca are required in api mode")
+	}
+
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load client certificate: %w", err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse client certificate: %w", err)
+	}
+	caData, err := os.ReadFile(caPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read CA certificate: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caData) {
+		return nil, nil, fmt.Errorf("failed to parse CA certificate")
+	}
+
+	return &http.Client{
+		Timeout: 30 * time.Second,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				Certificates: []tls.Certificate{cert},
+				RootCAs:      pool,
+				MinVersion:   tls.VersionTLS13,
+			},
+			MaxIdleConnsPerHost: 64,
+		},
+	}, leaf, nil
+}
@@ -1,26 +1,36 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
 	"log"
+	"log/slog"
 	"os"
-	"os/exec"
+	"slices"
+	"sort"
 	"strings"
-	"syscall"
 	"time"
 
+	"github.com/ThalesGroup/crypto11"
 	"github.com/titaev-lv/hsm-service/internal/config"
-	"gopkg.in/yaml.v3"
+	"github.com/titaev-lv/hsm-service/internal/hsm"
 )
 
 // rotateKeyCommand rotates a KEK by creating a new version
 func rotateKeyCommand(args []string) error {
-	if len(args) < 1 {
-		return fmt.Errorf("usage: hsm-admin rotate <context>")
+	if len(args) > 0 && args[0] == "rollback" {
+		return rollbackKeyCommand(args[1:])
 	}
 
-	contextName := args[0]
-	log.Printf("Starting rotation for context: %s", contextName)
+	fs := flag.NewFlagSet("rotate", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "Show the planned version and metadata changes without rotating")
+	allDue := fs.Bool("all-due", false, "Rotate every context whose rotation interval has passed")
+
+	positional := parseInterleaved(fs, args)
+	if *allDue == (len(positional) == 1) || len(positional) > 1 {
+		return fmt.Errorf("usage: hsm-admin rotate [--dry-run] <context> | --all-due [--dry-run] | rollback <context>")
+	}
 
 	// 1. Load config to get metadata path
 	cfg, err := config.LoadConfig(getConfigPath())
@@ -29,226 +39,269 @@ func rotateKeyCommand(args []string) error {
 	}
 	log.Printf("Config loaded successfully")
 
-	// 2. Get metadata path
-	metadataPath := cfg.HSM.MetadataFile
-	if metadataPath == "" {
-		metadataPath = "metadata.yaml"
+	// 2. Open metadata store
+	store, err := openMetadataStore(cfg)
+	if err != nil {
+		return err
 	}
 
-	// 3. Acquire exclusive lock on metadata file to prevent concurrent rotations
-	lockFile, err := os.OpenFile(metadataPath+".lock", os.O_CREATE|os.O_RDWR, 0644)
-	if err != nil {
-		return fmt.Errorf("failed to create lock file: %w", err)
+	contexts := positional
+	if *allDue {
+		metadata, _, err := store.Load()
+		if err != nil {
+			return fmt.Errorf("failed to load metadata: %w", err)
+		}
+		contexts = hsm.DueContexts(metadata)
+		log.Printf("Contexts due for rotation: %d %v", len(contexts), contexts)
+		if len(contexts) == 0 {
+			return nil
+		}
 	}
-	defer lockFile.Close()
-	defer os.Remove(metadataPath + ".lock")
 
-	// Acquire exclusive lock (blocks if another rotation is in progress)
-	if err := syscall.Flock(int(lockFile.Fd()), syscall.LOCK_EX); err != nil {
-		return fmt.Errorf("failed to acquire lock: %w", err)
+	if *dryRun {
+		return previewRotation(cfg, store, contexts)
 	}
-	defer syscall.Flock(int(lockFile.Fd()), syscall.LOCK_UN)
 
-	// 4. Load metadata (after acquiring lock)
-	log.Printf("Loading metadata from: %s", metadataPath)
-	metadata, err := config.LoadMetadata(metadataPath)
-	if err != nil {
-		return fmt.Errorf("failed to load metadata: %w", err)
+	// 3. Get HSM PIN
+	hsmPIN := os.Getenv("HSM_PIN")
+	if hsmPIN == "" {
+		return fmt.Errorf("HSM_PIN environment variable not set")
 	}
-	log.Printf("Loaded metadata with %d contexts", len(metadata.Rotation))
 
-	// 5. Find the key in metadata by context
-	keyMeta, found := metadata.Rotation[contextName]
-	if !found {
-		log.Printf("Available contexts: %v", getKeys(metadata.Rotation))
-		return fmt.Errorf("key %s not found in metadata", contextName)
+	// 4. Open PKCS#11 session (PIN stays in-process, never passed via argv)
+	p11ctx, err := crypto11.Configure(&crypto11.Config{
+		Path:       cfg.HSM.PKCS11Lib,
+		TokenLabel: cfg.HSM.SlotID,
+		Pin:        hsmPIN,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to configure PKCS#11: %w", err)
 	}
+	defer p11ctx.Close()
 
-	// 6. Get current version info
-	if len(keyMeta.Versions) == 0 {
-		return fmt.Errorf("no versions found for context: %s", contextName)
+	mac, err := hsm.MetadataMACFromConfig(p11ctx, &cfg.HSM)
+	if err != nil {
+		return err
 	}
 
-	// Find current version by label
-	var currentVersion *config.KeyVersion
-	for i := range keyMeta.Versions {
-		if keyMeta.Versions[i].Label == keyMeta.Current {
-			currentVersion = &keyMeta.Versions[i]
-			break
+	failed := 0
+	for _, contextName := range contexts {
+		if err := rotateContext(cfg, p11ctx, mac, store, contextName, *allDue); err != nil {
+			auditEvent(cfg, slog.LevelError, "key rotation",
+				"context", contextName,
+				"outcome", "failure",
+				"error", err)
+			if !*allDue {
+				return err
+			}
+			log.Printf("✗ %s: %v", contextName, err)
+			failed++
 		}
 	}
-	if currentVersion == nil {
-		return fmt.Errorf("current version %s not found in versions list", keyMeta.Current)
+	if failed > 0 {
+		return fmt.Errorf("%d of %d due context(s) failed to rotate", failed, len(contexts))
 	}
 
-	// Find the highest version number among all versions
-	highestVersion := 0
-	for _, v := range keyMeta.Versions {
-		if v.Version > highestVersion {
-			highestVersion = v.Version
-		}
-	}
+	return nil
+}
 
-	// Generate new version (increment from highest, not current)
-	newVersion := highestVersion + 1
+// rotateContext rotates one context: lock, generate, verify, write metadata
+// atomically, unlock (same transaction as the in-service rotation scheduler)
+func rotateContext(cfg *config.Config, p11ctx *crypto11.Context, mac config.MetadataMAC, store config.MetadataStore, contextName string, onlyIfDue bool) error {
+	log.Printf("Starting rotation for context: %s", contextName)
 
-	// Parse label to extract base name and version
-	// Example: kek-exchange-v1 -> kek-exchange-v2
-	currentLabel := currentVersion.Label
-	parts := strings.Split(currentLabel, "-v")
-	if len(parts) != 2 {
-		return fmt.Errorf("invalid key label format: %s (expected format: name-v1)", currentLabel)
+	minGeneration, err := config.LoadGeneration(cfg.HSM.MetadataGenerationFilePath())
+	if err != nil {
+		return err
+	}
+	result, err := hsm.RotateContext(p11ctx, store, contextName, hsm.RotateOptions{
+		OnlyIfDue:     onlyIfDue,
+		BackupDir:     cfg.HSM.MetadataBackupDirPath(),
+		MAC:           mac,
+		MinGeneration: minGeneration,
+		LabelTemplate: cfg.HSM.ContextLabelTemplate(contextName),
+	})
+	if errors.Is(err, hsm.ErrRotationNotDue) {
+		log.Printf("Context %s already rotated, skipping", contextName)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	recordGeneration(cfg, result.Generation, mac)
+	auditEvent(cfg, slog.LevelInfo, "key rotation",
+		"context", contextName,
+		"outcome", "success",
+		"old_label", result.OldLabel,
+		"old_version", result.OldVersion,
+		"new_label", result.NewLabel,
+		"new_version", result.NewVersion)
+	log.Printf("✓ New KEK verified (GCM round trip)")
+	if result.BackupPath != "" {
+		log.Printf("Created metadata backup: %s", result.BackupPath)
 	}
 
-	baseName := parts[0]
-	newLabel := fmt.Sprintf("%s-v%d", baseName, newVersion)
-
-	// Check if this version already exists
-	for _, v := range keyMeta.Versions {
-		if v.Label == newLabel {
-			return fmt.Errorf("version %s already exists, cannot create duplicate", newLabel)
-		}
+	log.Printf("✓ Key rotation completed:")
+	log.Printf("  Context: %s", contextName)
+	log.Printf("  Old key: %s (version %d)", result.OldLabel, result.OldVersion)
+	log.Printf("  New key: %s (version %d)", result.NewLabel, result.NewVersion)
+	log.Printf("")
+	log.Printf("⚠️  IMPORTANT:")
+	log.Printf("  1. The HSM service picks up the new key via metadata hot reload")
+	log.Printf("  2. Re-encrypt all data encrypted with the old key")
+	overlapDays := cfg.HSM.RetentionPolicy(config.KeyMetadata{}).OverlapDays
+	if metadata, _, err := store.Load(); err == nil {
+		overlapDays = cfg.HSM.RetentionPolicy(metadata.Rotation[contextName]).OverlapDays
 	}
+	log.Printf("  3. After the %d day overlap period, delete the old key:", overlapDays)
+	log.Printf("     hsm-admin delete-kek --label %s --confirm", result.OldLabel)
+	log.Printf("  To undo: hsm-admin rotate rollback %s", contextName)
 
-	// 7. Get HSM PIN
-	hsmPIN := os.Getenv("HSM_PIN")
-	if hsmPIN == "" {
-		return fmt.Errorf("HSM_PIN environment variable not set")
+	return nil
+}
+
+// previewRotation prints what rotating the contexts would change
+func previewRotation(cfg *config.Config, store config.MetadataStore, contexts []string) error {
+	metadata, _, err := store.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load metadata: %w", err)
 	}
 
-	// 8. Create new KEK using create-kek utility
-	// Determine the path to create-kek based on the environment
-	createKekPath := os.Getenv("CREATE_KEK_PATH")
-	if createKekPath == "" {
-		// Try common paths
-		possiblePaths := []string{
-			"/opt/hsm-service/bin/create-kek", // Production (Debian)
-			"/app/create-kek",                 // Docker
-			"./create-kek",                    // Current directory
+	for _, contextName := range contexts {
+		plan, preview, err := hsm.PreviewRotation(metadata, contextName, cfg.HSM.ContextLabelTemplate(contextName), time.Now())
+		if err != nil {
+			return err
 		}
 
-		for _, path := range possiblePaths {
-			if _, err := os.Stat(path); err == nil {
-				createKekPath = path
-				break
-			}
+		fmt.Printf("\nContext: %s\n", contextName)
+		fmt.Printf("  Current key: %s (version %d)\n", plan.OldLabel, plan.OldVersion)
+		fmt.Printf("  New key:     %s (version %d)\n", plan.NewLabel, plan.NewVersion)
+		fmt.Println("  Metadata changes:")
+		for _, line := range hsm.DiffMetadata(metadata, preview) {
+			fmt.Printf("    %s\n", line)
 		}
-
-		if createKekPath == "" {
-			return fmt.Errorf("create-kek binary not found. Set CREATE_KEK_PATH environment variable or ensure it's in one of: /opt/hsm-service/bin/create-kek, /app/create-kek, ./create-kek")
+		ous := aclOUsForContext(cfg, contextName)
+		if len(ous) == 0 {
+			fmt.Println("  Affected ACL OUs: none")
+		} else {
+			fmt.Printf("  Affected ACL OUs: %s\n", strings.Join(ous, ", "))
 		}
-	}
 
-	cmd := fmt.Sprintf("%s %s %s %d", createKekPath, newLabel, hsmPIN, newVersion)
-
-	log.Printf("Creating new KEK: %s", newLabel)
-	if err := runCommand(cmd); err != nil {
-		return fmt.Errorf("failed to create new KEK: %w", err)
+		auditEvent(cfg, slog.LevelInfo, "key rotation dry-run",
+			"context", contextName,
+			"current_label", plan.OldLabel,
+			"planned_label", plan.NewLabel)
 	}
 
-	// 9. Add new version to metadata
-	now := time.Now()
-	newKeyVersion := config.KeyVersion{
-		Label:     newLabel,
-		Version:   newVersion,
-		CreatedAt: &now,
-	}
+	fmt.Println("\n(DRY RUN - no keys created, metadata unchanged)")
+	return nil
+}
 
-	// Append new version and update current
-	keyMeta.Versions = append(keyMeta.Versions, newKeyVersion)
-	keyMeta.Current = newLabel
+// aclOUsForContext returns the OUs allowed to use a context
+func aclOUsForContext(cfg *config.Config, contextName string) []string {
+	var ous []string
+	for ou, contexts := range cfg.ACL.Mappings {
+		if slices.Contains(contexts, contextName) {
+			ous = append(ous, ou)
+		}
+	}
+	sort.Strings(ous)
+	return ous
+}
 
-	metadata.Rotation[contextName] = keyMeta
+// rollbackKeyCommand points current back at the previous version of a
+// context; the rolled-back version stays decrypt_only
+func rollbackKeyCommand(args []string) error {
+	fs := flag.NewFlagSet("rotate rollback", flag.ExitOnError)
+	yes := fs.Bool("yes", false, "Roll back without confirmation")
 
-	// 10. Backup old metadata
-	backupPath := fmt.Sprintf("metadata.yaml.backup-%s", time.Now().Format("20060102-150405"))
-	if err := copyFile(metadataPath, backupPath); err != nil {
-		log.Printf("Warning: failed to create backup: %v", err)
-	} else {
-		log.Printf("Created metadata backup: %s", backupPath)
+	positional := parseInterleaved(fs, args)
+	if len(positional) != 1 {
+		return fmt.Errorf("usage: hsm-admin rotate rollback <context> [--yes]")
 	}
+	contextName := positional[0]
 
-	// 11. Write updated metadata with explicit sync
-	data, err := yaml.Marshal(metadata)
+	cfg, err := config.LoadConfig(getConfigPath())
 	if err != nil {
-		return fmt.Errorf("failed to marshal metadata: %w", err)
+		return fmt.Errorf("failed to load config: %w", err)
 	}
 
-	// Open file for writing
-	f, err := os.OpenFile(metadataPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	store, err := openMetadataStore(cfg)
 	if err != nil {
-		return fmt.Errorf("failed to open metadata for writing: %w", err)
+		return err
 	}
-	defer f.Close()
 
-	// Write data
-	if _, err := f.Write(data); err != nil {
-		return fmt.Errorf("failed to write metadata: %w", err)
+	if !*yes {
+		fmt.Printf("Roll back %s to its previous key version? New encryptions will use the older key (yes/no): ", contextName)
+		var response string
+		fmt.Scanln(&response)
+		if response != "yes" {
+			fmt.Println("Rollback cancelled")
+			return nil
+		}
 	}
 
-	// Force sync to disk before closing
-	if err := f.Sync(); err != nil {
-		log.Printf("Warning: failed to sync metadata to disk: %v", err)
+	p11ctx, err := openPKCS11(cfg)
+	if err != nil {
+		return err
 	}
+	defer p11ctx.Close()
 
-	log.Printf("✓ Key rotation completed:")
-	log.Printf("  Context: %s", contextName)
-	log.Printf("  Old key: %s (version %d)", currentLabel, currentVersion.Version)
-	log.Printf("  New key: %s (version %d)", newLabel, newVersion)
-	log.Printf("")
-	log.Printf("⚠️  IMPORTANT:")
-	log.Printf("  1. Restart the HSM service to load the new key")
-	log.Printf("  2. Re-encrypt all data encrypted with the old key")
-	log.Printf("  3. After 7 days overlap period, delete the old key:")
-	log.Printf("     hsm-admin delete-kek --label %s --confirm", currentLabel)
-
-	return nil
-}
-
-// runCommand executes a shell command
-func runCommand(cmd string) error {
-	parts := strings.Fields(cmd)
-	if len(parts) == 0 {
-		return fmt.Errorf("empty command")
-	}
-
-	// Log command with PIN masked for security
-	logCmd := cmd
-	// Mask HSM_PIN in logs (replace PIN with ***)
-	for i, part := range parts {
-		// Skip first part (binary path) and check if next part looks like a PIN
-		if i > 0 && len(part) > 4 && !strings.Contains(part, "/") && !strings.HasPrefix(part, "-") {
-			// This might be a PIN, mask it
-			if len(parts) > i+1 {
-				// Check if this is the PIN parameter (usually between label and version)
-				parts[i] = strings.Repeat("*", len(part))
-				logCmd = strings.Join(parts, " ")
-			}
-		}
+	mac, err := hsm.MetadataMACFromConfig(p11ctx, &cfg.HSM)
+	if err != nil {
+		return err
+	}
+	minGeneration, err := config.LoadGeneration(cfg.HSM.MetadataGenerationFilePath())
+	if err != nil {
+		return err
 	}
 
-	log.Printf("Executing: %s", logCmd)
-
-	// Execute the command using exec.Command (use original cmd for execution)
-	origParts := strings.Fields(cmd)
-	command := exec.Command(origParts[0], origParts[1:]...)
-	command.Stdout = os.Stdout
-	command.Stderr = os.Stderr
-
-	if err := command.Run(); err != nil {
-		return fmt.Errorf("command failed: %w", err)
+	result, err := hsm.RollbackContext(p11ctx, store, contextName, hsm.RotateOptions{
+		BackupDir:     cfg.HSM.MetadataBackupDirPath(),
+		MAC:           mac,
+		MinGeneration: minGeneration,
+	})
+	if err != nil {
+		auditEvent(cfg, slog.LevelError, "key rotation rollback",
+			"context", contextName,
+			"outcome", "failure",
+			"error", err)
+		return err
+	}
+	recordGeneration(cfg, result.Generation, mac)
+
+	auditEvent(cfg, slog.LevelInfo, "key rotation rollback",
+		"context", contextName,
+		"outcome", "success",
+		"from_label", result.FromLabel,
+		"from_version", result.FromVersion,
+		"to_label", result.ToLabel,
+		"to_version", result.ToVersion)
+	log.Printf("✓ Rollback completed:")
+	log.Printf("  Context: %s", contextName)
+	log.Printf("  Current key: %s (version %d)", result.ToLabel, result.ToVersion)
+	log.Printf("  Rolled back: %s (version %d), now decrypt_only", result.FromLabel, result.FromVersion)
+	if result.BackupPath != "" {
+		log.Printf("  Metadata backup: %s", result.BackupPath)
 	}
+	log.Printf("  The HSM service picks up the change via metadata hot reload")
 
 	return nil
 }
 
-// copyFile copies a file from src to dst
-func copyFile(src, dst string) error {
-	data, err := os.ReadFile(src)
-	if err != nil {
-		return err
+// parseInterleaved parses flags that may appear before or after positional
+// arguments (flag.Parse stops at the first positional one)
+func parseInterleaved(fs *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		fs.Parse(args)
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
 	}
-	return os.WriteFile(dst, data, 0644)
 }
 
 // checkRotationStatus checks rotation status for all keys
@@ -259,11 +312,11 @@ func checkRotationStatusCommand() error {
 	}
 
 	// Load metadata
-	metadataPath := cfg.HSM.MetadataFile
-	if metadataPath == "" {
-		metadataPath = "metadata.yaml"
+	store, err := openMetadataStore(cfg)
+	if err != nil {
+		return err
 	}
-	metadata, err := config.LoadMetadata(metadataPath)
+	metadata, _, err := store.Load()
 	if err != nil {
 		return fmt.Errorf("failed to load metadata: %w", err)
 	}
@@ -327,12 +380,3 @@ func checkRotationStatusCommand() error {
 
 	return nil
 }
-
-// getKeys returns the keys of a map as a slice
-func getKeys(m map[string]config.KeyMetadata) []string {
-	keys := make([]string, 0, len(m))
-	for k := range m {
-		keys = append(keys, k)
-	}
-	return keys
-}
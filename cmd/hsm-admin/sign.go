@@ -0,0 +1,127 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"sort"
+
+	"github.com/titaev-lv/hsm-service/internal/config"
+	"github.com/titaev-lv/hsm-service/internal/hsm"
+)
+
+// signMetadataCommand signs metadata.yaml with the HSM-resident HMAC key
+// (initial signing, or re-signing after a reviewed manual edit), or verifies it
+func signMetadataCommand(args []string) error {
+	fs := flag.NewFlagSet("sign-metadata", flag.ExitOnError)
+	configPath := fs.String("config", getConfigPath(), "Path to config.yaml")
+	initKey := fs.Bool("init-key", false, "Create the metadata HMAC key in HSM if it does not exist")
+	verifyOnly := fs.Bool("verify", false, "Only verify the current signature")
+
+	fs.Parse(args)
+
+	cfg, err := config.LoadConfig(*configPath)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	store, err := openMetadataStore(cfg)
+	if err != nil {
+		return err
+	}
+	keyLabel := cfg.HSM.MetadataSigning.GetKeyLabel()
+
+	p11ctx, err := openPKCS11(cfg)
+	if err != nil {
+		return err
+	}
+	defer p11ctx.Close()
+
+	if *initKey {
+		existing, err := p11ctx.FindKey(nil, []byte(keyLabel))
+		if err != nil {
+			return fmt.Errorf("failed to check for existing key: %w", err)
+		}
+		if existing == nil {
+			if _, err := hsm.GenerateMetadataKey(p11ctx, keyLabel); err != nil {
+				return err
+			}
+			fmt.Printf("✓ Created metadata HMAC key: %s\n", keyLabel)
+		} else {
+			fmt.Printf("✓ Metadata HMAC key already exists: %s\n", keyLabel)
+		}
+	}
+
+	mac, err := hsm.NewMetadataMAC(p11ctx, keyLabel)
+	if err != nil {
+		return err
+	}
+
+	minGeneration, err := config.LoadGeneration(cfg.HSM.MetadataGenerationFilePath())
+	if err != nil {
+		return err
+	}
+
+	if *verifyOnly {
+		metadata, _, err := store.Load()
+		if err != nil {
+			return fmt.Errorf("failed to load metadata: %w", err)
+		}
+		if err := config.VerifyMetadata(metadata, mac, minGeneration); err != nil {
+			return fmt.Errorf("%s: %w", store, err)
+		}
+		fmt.Printf("✓ %s: signature valid (generation %d, accepted %d)\n", store, metadata.Generation, minGeneration)
+		return nil
+	}
+
+	// Acquire metadata lock (shared with rotate and the rotation scheduler)
+	unlock, err := store.Lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	metadata, revision, err := store.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load metadata: %w", err)
+	}
+
+	// Show what is being signed: after this, the service trusts it
+	if err := config.VerifyMetadata(metadata, mac, minGeneration); err != nil {
+		fmt.Printf("⚠ Current metadata does not verify: %v\n", err)
+	}
+	contexts := make([]string, 0, len(metadata.Rotation))
+	for name := range metadata.Rotation {
+		contexts = append(contexts, name)
+	}
+	sort.Strings(contexts)
+	for _, name := range contexts {
+		keyMeta := metadata.Rotation[name]
+		fmt.Printf("  %s: current=%s (%d versions)\n", name, keyMeta.Current, len(keyMeta.Versions))
+	}
+
+	// Continue above any generation already accepted on this host
+	if metadata.Generation < minGeneration {
+		metadata.Generation = minGeneration
+	}
+
+	backupPath, err := store.Backup(cfg.HSM.MetadataBackupDirPath())
+	if err != nil {
+		return fmt.Errorf("failed to back up metadata: %w", err)
+	}
+	if err := saveMetadata(cfg, store, revision, metadata, mac); err != nil {
+		return err
+	}
+
+	auditEvent(cfg, slog.LevelInfo, "metadata signed",
+		"generation", metadata.Generation,
+		"key_label", keyLabel,
+		"outcome", "success")
+	fmt.Printf("✓ Signed %s (generation %d)\n", store, metadata.Generation)
+	fmt.Printf("  Metadata backup: %s\n", backupPath)
+	if !cfg.HSM.MetadataSigning.Enabled {
+		fmt.Println("  Enable verification with hsm.metadata_signing.enabled: true")
+	}
+
+	return nil
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"time"
+
+	"github.com/ThalesGroup/crypto11"
+	"github.com/titaev-lv/hsm-service/internal/config"
+)
+
+// setKeyStateCommand moves a key version to a new lifecycle state
+// active -> decrypt_only -> disabled -> scheduled_for_destruction -> destroyed
+func setKeyStateCommand(args []string) error {
+	fs := flag.NewFlagSet("set-key-state", flag.ExitOnError)
+	configPath := fs.String("config", getConfigPath(), "Path to config.yaml")
+	label := fs.String("label", "", "Key version label (required)")
+	state := fs.String("state", "", "Target state: decrypt_only, disabled, scheduled_for_destruction, destroyed (required)")
+	waitDays := fs.Int("wait-days", 7, "Waiting period before destruction (for scheduled_for_destruction)")
+	confirm := fs.Bool("confirm", false, "Confirm destruction (required for destroyed)")
+
+	fs.Parse(args)
+
+	if *label == "" || *state == "" {
+		fs.Usage()
+		return fmt.Errorf("--label and --state are required")
+	}
+
+	target := config.KeyState(*state)
+	if !target.Valid() {
+		return fmt.Errorf("unknown state: %s", *state)
+	}
+	if *waitDays < 1 {
+		return fmt.Errorf("--wait-days must be at least 1")
+	}
+	if target == config.KeyStateDestroyed && !*confirm {
+		return fmt.Errorf("--confirm flag is required to destroy a key version (irreversible)")
+	}
+
+	// Load configuration
+	cfg, err := config.LoadConfig(*configPath)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	store, err := openMetadataStore(cfg)
+	if err != nil {
+		return err
+	}
+
+	// Acquire metadata lock (shared with rotate and the rotation scheduler)
+	unlock, err := store.Lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// PKCS#11 session for metadata signing and key destruction
+	var p11ctx *crypto11.Context
+	if cfg.HSM.MetadataSigning.Enabled || target == config.KeyStateDestroyed {
+		p11ctx, err = openPKCS11(cfg)
+		if err != nil {
+			return err
+		}
+		defer p11ctx.Close()
+	}
+
+	metadata, revision, mac, err := loadMetadataForUpdate(cfg, p11ctx, store)
+	if err != nil {
+		return err
+	}
+
+	// Find context owning the label
+	contextName := ""
+	for name, keyMeta := range metadata.Rotation {
+		for _, v := range keyMeta.Versions {
+			if v.Label == *label {
+				contextName = name
+			}
+		}
+	}
+	if contextName == "" {
+		return fmt.Errorf("key version %s not found in metadata", *label)
+	}
+
+	keyMeta := metadata.Rotation[contextName]
+	var from config.KeyState
+	for _, v := range keyMeta.Versions {
+		if v.Label == *label {
+			from = v.EffectiveState(keyMeta.Current)
+		}
+	}
+
+	now := time.Now()
+	if target == config.KeyStateDestroyed {
+		err = markDestroyed(cfg, &keyMeta, *label, now)
+	} else {
+		err = keyMeta.TransitionVersion(*label, target, now, time.Duration(*waitDays)*24*time.Hour)
+	}
+	if err != nil {
+		return err
+	}
+	metadata.Rotation[contextName] = keyMeta
+
+	backupPath, err := store.Backup(cfg.HSM.MetadataBackupDirPath())
+	if err != nil {
+		return fmt.Errorf("failed to back up metadata: %w", err)
+	}
+
+	if err := saveMetadata(cfg, store, revision, metadata, mac); err != nil {
+		return err
+	}
+	auditEvent(cfg, slog.LevelInfo, "key state transition",
+		"context", contextName,
+		"label", *label,
+		"from", from,
+		"to", target,
+		"outcome", "success")
+
+	// The key object is deleted only once metadata records the destruction
+	if target == config.KeyStateDestroyed {
+		if err := destroyKeyObject(p11ctx, *label); err != nil {
+			auditKeyDeleted(cfg, contextName, *label, err)
+			return destroyLeftoverError(*label, err)
+		}
+		auditKeyDeleted(cfg, contextName, *label, nil)
+	}
+
+	fmt.Printf("✓ %s (%s): %s -> %s\n", *label, contextName, from, target)
+	if target == config.KeyStateScheduledForDestruction {
+		fmt.Printf("  Destruction allowed after: %s\n", now.AddDate(0, 0, *waitDays).Format(time.RFC3339))
+		fmt.Printf("  Cancel with: hsm-admin set-key-state --label %s --state disabled\n", *label)
+	}
+	fmt.Printf("  Metadata backup: %s\n", backupPath)
+	fmt.Println("  The HSM service applies the change via metadata hot reload")
+
+	return nil
+}
+
+// markDestroyed moves a version to destroyed in keyMeta. Only versions in
+// scheduled_for_destruction whose waiting period and overlap period have
+// passed can be destroyed.
+func markDestroyed(cfg *config.Config, keyMeta *config.KeyMetadata, label string, now time.Time) error {
+	for _, v := range keyMeta.Versions {
+		if state := v.EffectiveState(keyMeta.Current); v.Label == label && state != config.KeyStateScheduledForDestruction {
+			return fmt.Errorf("%s is %s, schedule it first with 'hsm-admin set-key-state --label %s --state %s'",
+				label, state, label, config.KeyStateScheduledForDestruction)
+		}
+	}
+	if err := cfg.HSM.RetentionPolicy(*keyMeta).CheckDestroy(keyMeta, label, now); err != nil {
+		return err
+	}
+	return keyMeta.TransitionVersion(label, config.KeyStateDestroyed, now, 0)
+}
+
+// destroyLeftoverError reports a key recorded as destroyed in metadata but
+// still on the token
+func destroyLeftoverError(label string, err error) error {
+	return fmt.Errorf("%s is recorded as destroyed but is still in the HSM: %w (finish with 'hsm-admin reconcile --fix --delete-destroyed')", label, err)
+}
+
+// auditKeyDeleted records the deletion of a key object from the HSM
+func auditKeyDeleted(cfg *config.Config, contextName, label string, err error) {
+	if err != nil {
+		auditEvent(cfg, slog.LevelError, "key deletion",
+			"context", contextName,
+			"label", label,
+			"outcome", "failure",
+			"error", err)
+		return
+	}
+	auditEvent(cfg, slog.LevelInfo, "key deletion",
+		"context", contextName,
+		"label", label,
+		"outcome", "success")
+}
+
+// destroyKeyObject deletes the key object with the given label from the HSM
+func destroyKeyObject(p11ctx *crypto11.Context, label string) error {
+	key, err := p11ctx.FindKey(nil, []byte(label))
+	if err != nil {
+		return fmt.Errorf("failed to find key %s: %w", label, err)
+	}
+	if key == nil {
+		log.Printf("Warning: key %s not found in HSM, marking as destroyed", label)
+		return nil
+	}
+	if err := key.Delete(); err != nil {
+		return fmt.Errorf("failed to delete key %s: %w", label, err)
+	}
+	return nil
+}
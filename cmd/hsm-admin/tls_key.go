@@ -0,0 +1,122 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/titaev-lv/hsm-service/internal/config"
+	"github.com/titaev-lv/hsm-service/internal/hsm"
+)
+
+// tlsKeyCommand creates the HTTPS server key pair on the token (or reuses
+// it) and writes a CSR for it; the CSR is signed with the HSM key, the
+// private key never leaves the token
+func tlsKeyCommand(args []string) error {
+	fs := flag.NewFlagSet("tls-key", flag.ExitOnError)
+	configPath := fs.String("config", getConfigPath(), "Path to config.yaml")
+	label := fs.String("label", "", "Key pair label (default: server.tls.key_label)")
+	keyType := fs.String("type", hsm.KeyPairTypeECDSA, "Key type for a new key pair: ecdsa (P-256) or rsa (3072)")
+	cn := fs.String("cn", "", "Certificate Common Name, e.g. hsm-service.local (required)")
+	dnsNames := fs.String("dns", "", "DNS SANs, comma-separated (default: --cn)")
+	ipAddrs := fs.String("ip", "", "IP SANs, comma-separated")
+	csrPath := fs.String("csr", "", "Output CSR file (default: <label>.csr)")
+
+	fs.Parse(args)
+
+	cfg, err := config.LoadConfig(*configPath)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	if *label == "" {
+		*label = cfg.Server.TLS.KeyLabel
+	}
+	if *label == "" {
+		return fmt.Errorf("--label is required (or set server.tls.key_label)")
+	}
+	if *cn == "" {
+		return fmt.Errorf("--cn is required")
+	}
+	if *csrPath == "" {
+		*csrPath = *label + ".csr"
+	}
+
+	template := &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: *cn},
+		DNSNames: splitList(*dnsNames),
+	}
+	if len(template.DNSNames) == 0 {
+		template.DNSNames = []string{*cn}
+	}
+	for _, s := range splitList(*ipAddrs) {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return fmt.Errorf("invalid IP address: %s", s)
+		}
+		template.IPAddresses = append(template.IPAddresses, ip)
+	}
+
+	p11ctx, err := openPKCS11(cfg)
+	if err != nil {
+		return err
+	}
+	defer p11ctx.Close()
+
+	existing, err := p11ctx.FindKeyPair(nil, []byte(*label))
+	if err != nil {
+		return fmt.Errorf("failed to check for existing key pair: %w", err)
+	}
+	var signer crypto.Signer
+	if existing == nil {
+		if signer, err = hsm.GenerateKeyPair(p11ctx, *label, *keyType); err != nil {
+			return err
+		}
+		auditEvent(cfg, slog.LevelInfo, "key pair created",
+			"purpose", "tls",
+			"label", *label,
+			"type", *keyType,
+			"outcome", "success")
+		fmt.Printf("✓ Created TLS key pair in HSM: %s (%s)\n", *label, *keyType)
+	} else {
+		if signer, err = hsm.FindSigner(p11ctx, *label); err != nil {
+			return err
+		}
+		fmt.Printf("✓ Using existing TLS key pair: %s (--type ignored)\n", *label)
+	}
+
+	csrDER, err := x509.CreateCertificateRequest(rand.Reader, template, signer)
+	if err != nil {
+		return fmt.Errorf("failed to create CSR: %w", err)
+	}
+	csrPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csrDER})
+	if err := os.WriteFile(*csrPath, csrPEM, 0644); err != nil {
+		return fmt.Errorf("failed to write CSR: %w", err)
+	}
+
+	fmt.Printf("✓ CSR written: %s (CN=%s, DNS=%s)\n", *csrPath, *cn, strings.Join(template.DNSNames, ","))
+	fmt.Println()
+	fmt.Println("Next steps:")
+	fmt.Println("  1. Sign the CSR with the CA, e.g.:")
+	fmt.Printf("     openssl x509 -req -in %s -CA pki/ca/ca.crt -CAkey pki/ca/ca.key -CAcreateserial -days 365 -copy_extensions copy -out server.crt\n", *csrPath)
+	fmt.Println("  2. Set server.tls.cert_path to the certificate and server.tls.key_label to", *label, "(remove key_path)")
+	return nil
+}
+
+// splitList splits a comma-separated flag value, dropping empty entries
+func splitList(s string) []string {
+	var result []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
@@ -0,0 +1,358 @@
+package audit
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/titaev-lv/hsm-service/internal/config"
+)
+
+// testMAC stands in for the HSM HMAC key
+func testMAC(key string) MAC {
+	return func(data []byte) ([]byte, error) {
+		h := hmac.New(sha256.New, []byte(key))
+		h.Write(data)
+		return h.Sum(nil), nil
+	}
+}
+
+// writeLog appends n records to the audit log at path and closes it
+func writeLog(t *testing.T, path string, n int) {
+	t.Helper()
+	sink, err := OpenSink(&config.AuditConfig{File: path}, testMAC("k1"))
+	if err != nil {
+		t.Fatalf("OpenSink failed: %v", err)
+	}
+	if err := sink.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		if err := sink.Append(slog.LevelInfo, "request", map[string]any{"n": i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// rotate renames the log like lumberjack does on rotation
+func rotate(t *testing.T, path, stamp string) {
+	t.Helper()
+	if err := os.Rename(path, filepath.Join(filepath.Dir(path), "audit-"+stamp+".log")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readLines(t *testing.T, path string) []string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+}
+
+func writeLines(t *testing.T, path string, lines []string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSink_VerifyAcrossRotatedFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	writeLog(t, path, 3)
+	rotate(t, path, "2026-01-01T00-00-00.000")
+	writeLog(t, path, 2) // Resumes the chain from the rotated file
+
+	result, err := Verify(path, testMAC("k1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Valid() {
+		t.Fatalf("unexpected problems: %v", result.Problems)
+	}
+	if len(result.Files) != 2 || result.FirstSeq != 1 || result.LastSeq != 8 {
+		t.Errorf("files=%d seq=%d..%d, want 2 files, seq 1..8", len(result.Files), result.FirstSeq, result.LastSeq)
+	}
+	// New chain, end of the first run, end of the second run
+	if result.Checkpoints != 3 || result.LastCheckpointSeq != 8 || result.Unsigned != 0 {
+		t.Errorf("checkpoints=%d last=%d unsigned=%d", result.Checkpoints, result.LastCheckpointSeq, result.Unsigned)
+	}
+}
+
+// Records appended by hsm-admin while the service holds the log open
+// continue one chain and are indexed and signed by the service
+func TestSink_AppendRecordFromAnotherProcess(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "audit.log")
+	cfg := &config.AuditConfig{File: path}
+	idx := NewIndex(filepath.Join(dir, "audit-index.db"))
+
+	sink, err := OpenSink(cfg, testMAC("k1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink.SetIndex(idx)
+	if err := sink.Append(slog.LevelInfo, EventMessage, map[string]any{"operation": OpEncrypt}); err != nil {
+		t.Fatal(err)
+	}
+	admin, err := OpenSink(cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := admin.Append(slog.LevelInfo, "key rotation", map[string]any{"trigger": "manual", "context": "exchange-key"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := admin.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// No service record since: the checkpoint still covers the hsm-admin record
+	if err := sink.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Append(slog.LevelInfo, EventMessage, map[string]any{"operation": OpDecrypt}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := Verify(path, testMAC("k1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Valid() {
+		t.Fatalf("unexpected problems: %v", result.Problems)
+	}
+	if result.Records != 5 || result.Checkpoints != 2 || result.Unsigned != 0 {
+		t.Errorf("records=%d checkpoints=%d unsigned=%d, want 5, 2, 0", result.Records, result.Checkpoints, result.Unsigned)
+	}
+
+	if err := idx.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	res, err := idx.Query(Query{Context: "exchange-key"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Events) != 1 || res.Events[0].Seq != 2 || res.Events[0].Event != "key rotation" {
+		t.Errorf("hsm-admin record not indexed: %+v", res.Events)
+	}
+}
+
+func TestVerify_DetectsTampering(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(t *testing.T, dir, path string)
+		want   string
+	}{
+		{"modified record", func(t *testing.T, dir, path string) {
+			lines := readLines(t, path)
+			lines[0] = strings.Replace(lines[0], `"n":0`, `"n":7`, 1)
+			writeLines(t, path, lines)
+		}, "hash mismatch"},
+		{"removed record", func(t *testing.T, dir, path string) {
+			lines := readLines(t, path)
+			writeLines(t, path, append(lines[:1:1], lines[2:]...))
+		}, "gap"},
+		{"reordered records", func(t *testing.T, dir, path string) {
+			lines := readLines(t, path)
+			lines[0], lines[1] = lines[1], lines[0]
+			writeLines(t, path, lines)
+		}, "out of order"},
+		{"removed rotated file", func(t *testing.T, dir, path string) {
+			os.Remove(filepath.Join(dir, "audit-2026-01-02T00-00-00.000.log"))
+		}, "gap"},
+		{"truncated after the last checkpoint", func(t *testing.T, dir, path string) {
+			lines := readLines(t, path)
+			writeLines(t, path, lines[:len(lines)-2])
+		}, "before the last checkpoint"},
+		{"removed newest file", func(t *testing.T, dir, path string) {
+			os.Remove(path)
+		}, "before the last checkpoint"},
+		{"removed checkpoint state", func(t *testing.T, dir, path string) {
+			os.Remove(CheckpointStatePath(path))
+		}, "checkpoint state missing"},
+		{"replaced log", func(t *testing.T, dir, path string) {
+			// A new chain written in place of the current file
+			sink := newSink(mustCreate(t, path), testMAC("k1"), 0, "")
+			sink.Append(slog.LevelInfo, "request", nil)
+			sink.Close()
+		}, "chain restarted"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "audit.log")
+			writeLog(t, path, 2)
+			rotate(t, path, "2026-01-01T00-00-00.000")
+			writeLog(t, path, 2)
+			rotate(t, path, "2026-01-02T00-00-00.000")
+			writeLog(t, path, 2)
+
+			tt.tamper(t, dir, path)
+
+			result, err := Verify(path, testMAC("k1"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Valid() {
+				t.Fatal("tampering not detected")
+			}
+			found := false
+			for _, p := range result.Problems {
+				found = found || strings.Contains(p.Message, tt.want)
+			}
+			if !found {
+				t.Errorf("problems = %v, want %q", result.Problems, tt.want)
+			}
+		})
+	}
+}
+
+// A log written before the checkpoint state existed gets it with the next checkpoint
+func TestSink_RecordsMissingCheckpointState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	writeLog(t, path, 2)
+	if err := os.Remove(CheckpointStatePath(path)); err != nil {
+		t.Fatal(err)
+	}
+
+	sink, err := OpenSink(&config.AuditConfig{File: path}, testMAC("k1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+	sink.Close()
+
+	state, err := ReadCheckpointState(path)
+	if err != nil || state == nil {
+		t.Fatalf("ReadCheckpointState = %v, %v", state, err)
+	}
+	result, err := Verify(path, testMAC("k1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Valid() || result.StateSeq != result.LastSeq {
+		t.Errorf("state_seq=%d last_seq=%d problems=%v", result.StateSeq, result.LastSeq, result.Problems)
+	}
+}
+
+func TestVerify_RewrittenChain(t *testing.T) {
+	// Recomputing all hashes after an edit keeps the chain intact, but the
+	// checkpoints cannot be re-signed without the HSM key
+	path := filepath.Join(t.TempDir(), "audit.log")
+	writeLog(t, path, 2)
+
+	result, err := Verify(path, testMAC("other-key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Valid() || !strings.Contains(result.Problems[0].Message, "signature mismatch") {
+		t.Errorf("forged checkpoint not detected: %v", result.Problems)
+	}
+
+	// Without the key only the chain is checked
+	result, err = Verify(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Valid() || result.MACChecked {
+		t.Errorf("chain-only verification: valid=%t mac_checked=%t", result.Valid(), result.MACChecked)
+	}
+}
+
+func TestLogger_TeesIntoSink(t *testing.T) {
+	var buf bytes.Buffer
+	sink := newSink(nopCloser{&buf}, testMAC("k1"), 0, "")
+	SetSink(sink)
+	defer SetSink(nil)
+
+	Logger().With("client_cn", "trading-service-1").
+		Warn("request", "path", "/encrypt", "error", errors.New("access denied"))
+
+	var rec Record
+	if err := json.Unmarshal(bytes.TrimSpace(buf.Bytes()), &rec); err != nil {
+		t.Fatalf("invalid record %q: %v", buf.String(), err)
+	}
+	if rec.Seq != 1 || rec.Event != "request" || rec.Level != "WARN" {
+		t.Errorf("unexpected record: %+v", rec)
+	}
+	want := map[string]any{"client_cn": "trading-service-1", "path": "/encrypt", "error": "access denied"}
+	for k, v := range want {
+		if rec.Attrs[k] != v {
+			t.Errorf("attrs[%s] = %v, want %v", k, rec.Attrs[k], v)
+		}
+	}
+}
+
+type nopCloser struct{ *bytes.Buffer }
+
+func (nopCloser) Close() error { return nil }
+
+func mustCreate(t *testing.T, path string) *os.File {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestNewRequestEvent_RequestID(t *testing.T) {
+	tests := []struct {
+		header string
+		keep   bool
+	}{
+		{"req-42", true},
+		{"3f1c9a2e-7b5d-4c8e-9a1f-2d6b8e4c7a90", true},
+		{"", false},
+		{"bad id\nforged=1", false},
+		{strings.Repeat("a", 65), false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("POST", "/encrypt", nil)
+		r.Header.Set(RequestIDHeader, tt.header)
+		got := NewRequestEvent(r).RequestID
+		if tt.keep && got != tt.header {
+			t.Errorf("request ID %q replaced with %q", tt.header, got)
+		}
+		if !tt.keep && (got == tt.header || len(got) != 36) {
+			t.Errorf("request ID %q kept as %q, want a generated ID", tt.header, got)
+		}
+	}
+}
+
+func TestEvent_EmitOnce(t *testing.T) {
+	var buf bytes.Buffer
+	sink := newSink(nopCloser{&buf}, testMAC("k1"), 0, "")
+	SetSink(sink)
+	defer SetSink(nil)
+
+	ev := NewRequestEvent(httptest.NewRequest("POST", "/decrypt", nil))
+	ev.Operation = OpDecrypt
+	ev.Reason = "decryption failed"
+	ev.Emit(400)
+	ev.Emit(200) // Fallback emission by the middleware is a no-op
+
+	var rec Record
+	if err := json.Unmarshal(bytes.TrimSpace(buf.Bytes()), &rec); err != nil {
+		t.Fatalf("expected one record, got %q: %v", buf.String(), err)
+	}
+	if rec.Level != "WARN" || rec.Attrs["outcome"] != OutcomeInvalid || rec.Attrs["status"] != float64(400) {
+		t.Errorf("unexpected record: %+v", rec)
+	}
+}
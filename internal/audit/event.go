@@ -0,0 +1,213 @@
+package audit
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Operations recorded in audit events
+const (
+	OpEncrypt  = "encrypt"
+	OpDecrypt  = "decrypt"
+	OpSignCSR  = "pki.sign_csr"
+	OpQuery    = "audit.query"
+	OpLogLevel = "admin.log_level"
+	OpRequest  = "request" // Endpoints without a dedicated operation (/health, /keys, ...)
+)
+
+// Outcomes of an audited request
+const (
+	OutcomeSuccess = "success"
+	OutcomeDenied  = "denied"  // Authentication, ACL, revocation or rate limit
+	OutcomeInvalid = "invalid" // Malformed request
+	OutcomeError   = "error"   // HSM or internal failure
+)
+
+// RequestIDHeader carries the caller's request ID, echoed in the response
+const RequestIDHeader = "X-Request-ID"
+
+// EventMessage is the log message of every request audit event
+const EventMessage = "request"
+
+// Event is the audit record of one API request. The schema has no field for
+// request or response bodies, so plaintext cannot end up in the audit log;
+// Reason holds the fixed message returned to the client, never HSM error details.
+type Event struct {
+	EventID           string `json:"event_id"`
+	RequestID         string `json:"request_id"`
+	TraceID           string `json:"trace_id,omitempty"` // W3C trace ID (traceparent or the service's own trace)
+	Operation         string `json:"operation"`
+	Method            string `json:"method"`
+	Path              string `json:"path"`
+	Status            int    `json:"status,omitempty"` // HTTP status
+	Outcome           string `json:"outcome"`
+	Reason            string `json:"reason,omitempty"` // Denial or failure reason
+	Context           string `json:"context,omitempty"`
+	KeyID             string `json:"key_id,omitempty"`
+	KeyVersion        int    `json:"key_version,omitempty"`
+	PayloadBytes      int    `json:"payload_bytes,omitempty"` // Size of the decoded plaintext/ciphertext
+	ClientCN          string `json:"client_cn,omitempty"`
+	ClientOU          string `json:"client_ou,omitempty"`
+	ClientSerial      string `json:"client_serial,omitempty"`      // Hex, like 'openssl x509 -serial'
+	ClientFingerprint string `json:"client_fingerprint,omitempty"` // SHA-256 of the DER certificate, hex
+	RemoteAddr        string `json:"remote_addr,omitempty"`
+	DurationMs        int64  `json:"duration_ms"`
+
+	started time.Time
+	emitted atomic.Bool
+}
+
+type eventKey struct{}
+
+// NewRequestEvent starts the audit event of r: IDs, client certificate and
+// remote address are filled in, the handler adds the operation details
+func NewRequestEvent(r *http.Request) *Event {
+	e := &Event{
+		EventID:    NewEventID(),
+		RequestID:  requestID(r),
+		Operation:  OpRequest,
+		Method:     r.Method,
+		Path:       r.URL.Path,
+		RemoteAddr: r.RemoteAddr,
+		started:    time.Now(),
+	}
+	if sc := trace.SpanContextFromContext(r.Context()); sc.IsValid() {
+		e.TraceID = sc.TraceID().String()
+	}
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		cert := r.TLS.PeerCertificates[0]
+		e.ClientCN = cert.Subject.CommonName
+		if len(cert.Subject.OrganizationalUnit) > 0 {
+			e.ClientOU = cert.Subject.OrganizationalUnit[0]
+		}
+		e.ClientSerial = fmt.Sprintf("%X", cert.SerialNumber)
+		sum := sha256.Sum256(cert.Raw)
+		e.ClientFingerprint = hex.EncodeToString(sum[:])
+	}
+	return e
+}
+
+// WithEvent stores the request's audit event in ctx
+func WithEvent(ctx context.Context, e *Event) context.Context {
+	return context.WithValue(ctx, eventKey{}, e)
+}
+
+// EventFrom returns the audit event of the request, starting one if the
+// request did not pass through the audit middleware
+func EventFrom(r *http.Request) *Event {
+	if e, ok := r.Context().Value(eventKey{}).(*Event); ok {
+		return e
+	}
+	return NewRequestEvent(r)
+}
+
+// Emitted reports whether the event was already logged
+func (e *Event) Emitted() bool {
+	return e.emitted.Load()
+}
+
+// Emit logs the event once: status 0 means unknown (outcome from Reason)
+func (e *Event) Emit(status int) {
+	if e.emitted.Swap(true) {
+		return
+	}
+	if status != 0 {
+		e.Status = status
+	}
+	if e.Outcome == "" {
+		e.Outcome = outcomeForStatus(e.Status, e.Reason)
+	}
+	if !e.started.IsZero() {
+		e.DurationMs = time.Since(e.started).Milliseconds()
+	}
+
+	level := slog.LevelInfo
+	if e.Outcome != OutcomeSuccess {
+		level = slog.LevelWarn
+	}
+	Logger().LogAttrs(context.Background(), level, EventMessage, e.attrs()...)
+}
+
+// attrs returns the non-empty fields as log attributes (keys as in JSON)
+func (e *Event) attrs() []slog.Attr {
+	attrs := []slog.Attr{
+		slog.String("event_id", e.EventID),
+		slog.String("request_id", e.RequestID),
+		slog.String("operation", e.Operation),
+		slog.String("method", e.Method),
+		slog.String("path", e.Path),
+		slog.String("outcome", e.Outcome),
+	}
+	if e.Status != 0 {
+		attrs = append(attrs, slog.Int("status", e.Status))
+	}
+	for _, f := range []struct{ key, value string }{
+		{"trace_id", e.TraceID},
+		{"reason", e.Reason},
+		{"context", e.Context},
+		{"key_id", e.KeyID},
+		{"client_cn", e.ClientCN},
+		{"client_ou", e.ClientOU},
+		{"client_serial", e.ClientSerial},
+		{"client_fingerprint", e.ClientFingerprint},
+		{"remote_addr", e.RemoteAddr},
+	} {
+		if f.value != "" {
+			attrs = append(attrs, slog.String(f.key, f.value))
+		}
+	}
+	if e.KeyVersion != 0 {
+		attrs = append(attrs, slog.Int("key_version", e.KeyVersion))
+	}
+	if e.PayloadBytes != 0 {
+		attrs = append(attrs, slog.Int("payload_bytes", e.PayloadBytes))
+	}
+	return append(attrs, slog.Int64("duration_ms", e.DurationMs))
+}
+
+// outcomeForStatus maps an HTTP status to an outcome
+func outcomeForStatus(status int, reason string) string {
+	switch {
+	case status == 0 && reason == "", status >= 200 && status < 400:
+		return OutcomeSuccess
+	case status == http.StatusUnauthorized, status == http.StatusForbidden, status == http.StatusTooManyRequests:
+		return OutcomeDenied
+	case status >= 400 && status < 500:
+		return OutcomeInvalid
+	default:
+		return OutcomeError
+	}
+}
+
+// NewEventID returns a random UUID (version 4)
+func NewEventID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// requestID returns the caller's X-Request-ID if it is safe to log,
+// otherwise a new ID
+func requestID(r *http.Request) string {
+	id := r.Header.Get(RequestIDHeader)
+	if id == "" || len(id) > 64 {
+		return NewEventID()
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.' || c == ':') {
+			return NewEventID()
+		}
+	}
+	return id
+}
@@ -0,0 +1,90 @@
+package audit
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// CSVColumns are the columns of a CSV export: record fields followed by
+// the request event attributes (other attributes are JSONL only)
+var CSVColumns = []string{
+	"seq", "time", "level", "event",
+	"event_id", "request_id", "trace_id", "operation", "outcome", "status", "reason",
+	"context", "key_id", "key_version", "payload_bytes",
+	"client_cn", "client_ou", "client_serial", "client_fingerprint",
+	"remote_addr", "duration_ms",
+}
+
+// WriteJSONL writes records as JSON lines (the audit log record format)
+func WriteJSONL(w io.Writer, recs []Record) error {
+	enc := json.NewEncoder(w)
+	for i := range recs {
+		if err := enc.Encode(&recs[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteCSV writes records as CSV rows, preceded by the header if header is set
+// (pages of one export share a single header)
+func WriteCSV(w io.Writer, recs []Record, header bool) error {
+	cw := csv.NewWriter(w)
+	if header {
+		if err := cw.Write(CSVColumns); err != nil {
+			return err
+		}
+	}
+	row := make([]string, len(CSVColumns))
+	for _, rec := range recs {
+		row[0] = strconv.FormatUint(rec.Seq, 10)
+		row[1] = rec.Time.UTC().Format(time.RFC3339Nano)
+		row[2] = rec.Level
+		row[3] = rec.Event
+		for i, col := range CSVColumns[4:] {
+			row[4+i] = AttrString(rec.Attrs, col)
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// AttrString formats an attribute for filtering and export ("" if missing).
+// Numbers decoded from JSON are float64 and print without exponent.
+func AttrString(attrs map[string]any, key string) string {
+	switch v := attrs[key].(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case fmt.Stringer:
+		return v.String()
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// ParseTimeBound parses a query time range bound: RFC 3339 or a date
+// (2006-01-02, UTC). A date as upper bound covers the whole day.
+func ParseTimeBound(s string, upper bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.DateOnly, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q (want RFC 3339 or YYYY-MM-DD)", s)
+	}
+	if upper {
+		t = t.Add(24*time.Hour - time.Nanosecond)
+	}
+	return t, nil
+}
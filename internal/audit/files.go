@@ -0,0 +1,67 @@
+package audit
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// backupTimeFormat is the timestamp lumberjack puts into rotated file names
+// (audit-2026-01-02T15-04-05.000.log)
+const backupTimeFormat = "2006-01-02T15-04-05.000"
+
+// LogFiles returns the rotated files of the log at path, oldest first,
+// followed by path itself if it exists
+func LogFiles(path string) ([]string, error) {
+	dir := filepath.Dir(path)
+	base := filepath.Base(path)
+	ext := filepath.Ext(base)
+	prefix := strings.TrimSuffix(base, ext) + "-"
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	type backup struct {
+		path string
+		at   time.Time
+	}
+	var backups []backup
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		stamp := strings.TrimPrefix(name, prefix)
+		stamp = strings.TrimSuffix(stamp, ".gz")
+		if !strings.HasSuffix(stamp, ext) {
+			continue
+		}
+		at, err := time.Parse(backupTimeFormat, strings.TrimSuffix(stamp, ext))
+		if err != nil {
+			continue
+		}
+		backups = append(backups, backup{path: filepath.Join(dir, name), at: at})
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].at.Before(backups[j].at) })
+
+	files := make([]string, 0, len(backups)+1)
+	for _, b := range backups {
+		files = append(files, b.path)
+	}
+	if _, err := os.Stat(path); err == nil {
+		files = append(files, path)
+	}
+	return files, nil
+}
+
+// isCompressed reports whether a rotated file was gzipped by lumberjack
+func isCompressed(path string) bool {
+	return strings.HasSuffix(path, ".gz")
+}
@@ -0,0 +1,183 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+// current is the installed sink (nil = audit events go to the service log only)
+var current atomic.Pointer[Sink]
+
+// outputs are the installed SIEM outputs (independent of the audit log)
+var outputs atomic.Pointer[[]*Output]
+
+// base is the logger audit events are written to besides the audit log
+// (nil = the service log)
+var base atomic.Pointer[slog.Logger]
+
+// SetSink installs the sink receiving audit events (nil uninstalls it)
+func SetSink(s *Sink) {
+	current.Store(s)
+}
+
+// SetLogger sends audit events to l instead of the service log (logging.audit);
+// nil restores the service log
+func SetLogger(l *slog.Logger) {
+	base.Store(l)
+}
+
+// SetOutputs installs the SIEM outputs receiving audit events (nil uninstalls them)
+func SetOutputs(outs []*Output) {
+	if len(outs) == 0 {
+		outputs.Store(nil)
+		return
+	}
+	outputs.Store(&outs)
+}
+
+// Logger returns the logger for audit events: records go to the service
+// log (or the logging.audit destination) and, when installed, into the
+// hash-chained audit log and the SIEM outputs
+func Logger() *slog.Logger {
+	logger := base.Load()
+	if logger == nil {
+		// The service log level can be raised at runtime (/admin/log-level);
+		// audit events must not be filtered by it
+		logger = slog.New(allLevels{slog.Default().Handler()})
+	}
+	s := current.Load()
+	var outs []*Output
+	if p := outputs.Load(); p != nil {
+		outs = *p
+	}
+	if s != nil || len(outs) > 0 {
+		logger = slog.New(&handler{sink: s, outputs: outs, next: logger.Handler()})
+	}
+	return logger.With("component", "audit")
+}
+
+// allLevels passes records of every level to the wrapped handler
+type allLevels struct {
+	slog.Handler
+}
+
+func (h allLevels) Enabled(context.Context, slog.Level) bool {
+	return true
+}
+
+func (h allLevels) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return allLevels{h.Handler.WithAttrs(attrs)}
+}
+
+func (h allLevels) WithGroup(name string) slog.Handler {
+	return allLevels{h.Handler.WithGroup(name)}
+}
+
+// handler tees slog records into the sink and the SIEM outputs
+type handler struct {
+	sink    *Sink
+	outputs []*Output
+	next    slog.Handler
+	attrs   []slog.Attr
+	groups  []string
+}
+
+func (h *handler) Enabled(ctx context.Context, level slog.Level) bool {
+	// Audit records are kept regardless of the service log level
+	return true
+}
+
+func (h *handler) Handle(ctx context.Context, r slog.Record) error {
+	attrs := make(map[string]any, len(h.attrs)+r.NumAttrs())
+	for _, a := range h.attrs {
+		addAttr(attrs, "", a)
+	}
+	prefix := groupPrefix(h.groups)
+	r.Attrs(func(a slog.Attr) bool {
+		addAttr(attrs, prefix, a)
+		return true
+	})
+	delete(attrs, "component")
+
+	if h.sink != nil {
+		if err := h.sink.Append(r.Level, r.Message, attrs); err != nil {
+			// Reported through the service log; the event itself still goes there
+			slog.Error("audit log write failed", "event", r.Message, "error", err)
+		}
+	}
+	if len(h.outputs) > 0 {
+		rec := &Record{Time: r.Time.UTC(), Level: r.Level.String(), Event: r.Message, Attrs: attrs}
+		for _, o := range h.outputs {
+			o.Send(rec)
+		}
+	}
+
+	if h.next.Enabled(ctx, r.Level) {
+		return h.next.Handle(ctx, r)
+	}
+	return nil
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	prefix := groupPrefix(h.groups)
+	merged := append([]slog.Attr{}, h.attrs...)
+	for _, a := range attrs {
+		merged = append(merged, slog.Attr{Key: prefix + a.Key, Value: a.Value})
+	}
+	return &handler{sink: h.sink, outputs: h.outputs, next: h.next.WithAttrs(attrs), attrs: merged, groups: h.groups}
+}
+
+func (h *handler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	groups := append(append([]string{}, h.groups...), name)
+	return &handler{sink: h.sink, outputs: h.outputs, next: h.next.WithGroup(name), attrs: h.attrs, groups: groups}
+}
+
+// addAttr flattens groups into dotted keys and converts values to JSON-friendly types
+func addAttr(dst map[string]any, prefix string, a slog.Attr) {
+	v := a.Value.Resolve()
+	if v.Kind() == slog.KindGroup {
+		p := prefix
+		if a.Key != "" {
+			p += a.Key + "."
+		}
+		for _, ga := range v.Group() {
+			addAttr(dst, p, ga)
+		}
+		return
+	}
+	if a.Key == "" {
+		return
+	}
+
+	switch v.Kind() {
+	case slog.KindTime:
+		dst[prefix+a.Key] = v.Time().UTC().Format(time.RFC3339Nano)
+	case slog.KindDuration:
+		dst[prefix+a.Key] = v.Duration().String()
+	case slog.KindAny:
+		switch x := v.Any().(type) {
+		case error:
+			dst[prefix+a.Key] = x.Error()
+		case fmt.Stringer:
+			dst[prefix+a.Key] = x.String()
+		default:
+			dst[prefix+a.Key] = x
+		}
+	default:
+		dst[prefix+a.Key] = v.Any()
+	}
+}
+
+func groupPrefix(groups []string) string {
+	prefix := ""
+	for _, g := range groups {
+		prefix += g + "."
+	}
+	return prefix
+}
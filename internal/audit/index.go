@@ -0,0 +1,445 @@
+package audit
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Query limits (page size)
+const (
+	DefaultQueryLimit = 100
+	MaxQueryLimit     = 1000
+)
+
+// indexOpenTimeout bounds the wait for another process holding the database
+const indexOpenTimeout = 5 * time.Second
+
+// maxPendingRecords bounds the records buffered between flushes; records
+// beyond it are picked up by the next Sync
+const maxPendingRecords = 100000
+
+var (
+	indexEventsBucket = []byte("events")   // seq -> record JSON (checkpoints are not indexed)
+	indexTimeBucket   = []byte("time")     // unix nanos + seq -> empty
+	indexMetaBucket   = []byte("meta")     // last_seq
+	indexLastSeqKey   = []byte("last_seq") // Highest indexed seq, checkpoints included
+)
+
+// Index is a bbolt database of audit records for queries by time range,
+// client, context, key version and outcome. The audit log stays the source
+// of truth: the index is fed by the sink and can always be rebuilt from the
+// log with Sync. Like the bolt metadata store, the database is opened per
+// operation so the service and hsm-admin can both use it.
+type Index struct {
+	path string
+
+	mu      sync.Mutex
+	pending []Record
+	dropped bool // pending overflowed, Sync needed
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewIndex returns the index backed by the bbolt database at path
+func NewIndex(path string) *Index {
+	return &Index{path: path, stop: make(chan struct{})}
+}
+
+// Path returns the database path
+func (x *Index) Path() string {
+	return x.path
+}
+
+// Add buffers a record written by the sink until the next Flush
+func (x *Index) Add(rec Record) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if len(x.pending) >= maxPendingRecords {
+		x.dropped = true
+		return
+	}
+	x.pending = append(x.pending, rec)
+}
+
+// Flush writes the buffered records in one transaction. On failure they
+// stay buffered for the next attempt.
+func (x *Index) Flush() error {
+	x.mu.Lock()
+	recs := x.pending
+	x.pending = nil
+	dropped := x.dropped
+	x.dropped = false
+	x.mu.Unlock()
+
+	if len(recs) == 0 {
+		return nil
+	}
+	err := x.update(func(tx *bolt.Tx) error {
+		for i := range recs {
+			if err := putRecord(tx, &recs[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		x.mu.Lock()
+		x.pending = append(recs, x.pending...)
+		x.dropped = x.dropped || dropped
+		x.mu.Unlock()
+		return err
+	}
+	if dropped {
+		slog.Warn("audit index buffer overflowed, records are indexed on the next sync",
+			"index", x.path,
+		)
+	}
+	return nil
+}
+
+// Start flushes buffered records every interval until Close
+func (x *Index) Start(interval time.Duration) {
+	x.wg.Add(1)
+	go func() {
+		defer x.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if err := x.Flush(); err != nil {
+					slog.Error("audit index flush failed", "index", x.path, "error", err)
+				}
+			case <-x.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Close stops the flush loop and writes the remaining records
+func (x *Index) Close() error {
+	select {
+	case <-x.stop:
+	default:
+		close(x.stop)
+	}
+	x.wg.Wait()
+	return x.Flush()
+}
+
+// LastSeq returns the highest indexed sequence number (0 = empty index)
+func (x *Index) LastSeq() (uint64, error) {
+	var last uint64
+	err := x.view(func(tx *bolt.Tx) error {
+		last = lastSeq(tx)
+		return nil
+	})
+	return last, err
+}
+
+// Sync indexes the records of the log at logPath (and its rotated files)
+// that are not in the index yet, e.g. after a restart or a failed flush.
+// A log that ends before the index does (chain restarted) rebuilds the index.
+// Returns the number of records added.
+func (x *Index) Sync(logPath string) (int, error) {
+	last, err := x.LastSeq()
+	if err != nil {
+		return 0, err
+	}
+	tail, _, err := chainTail(logPath)
+	if err != nil {
+		return 0, err
+	}
+	if tail == last {
+		return 0, nil
+	}
+	if tail < last {
+		if err := x.update(resetIndex); err != nil {
+			return 0, fmt.Errorf("failed to reset audit index: %w", err)
+		}
+		last = 0
+	}
+
+	files, err := LogFiles(logPath)
+	if err != nil {
+		return 0, err
+	}
+	added := 0
+	for _, file := range files {
+		// Skip files that are indexed completely
+		if line, err := lastLine(file); err == nil && line != nil {
+			var rec Record
+			if json.Unmarshal(line, &rec) == nil && rec.Seq <= last {
+				continue
+			}
+		}
+
+		n, err := x.syncFile(file, last)
+		added += n
+		if err != nil {
+			return added, err
+		}
+	}
+	return added, nil
+}
+
+// syncFile indexes the records of one log file after seq in one transaction
+func (x *Index) syncFile(path string, after uint64) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if isCompressed(path) {
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			return 0, fmt.Errorf("read %s: %w", path, err)
+		}
+		defer zr.Close()
+		r = zr
+	}
+
+	added := 0
+	err = x.update(func(tx *bolt.Tx) error {
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64*1024), 2*maxRecordSize)
+		for scanner.Scan() {
+			var rec Record
+			// Unparsable lines are reported by 'hsm-admin audit verify'
+			if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil || rec.Seq <= after {
+				continue
+			}
+			if err := putRecord(tx, &rec); err != nil {
+				return err
+			}
+			added++
+		}
+		if err := scanner.Err(); err != nil {
+			return fmt.Errorf("read %s: %w", path, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return added, nil
+}
+
+// Query filters indexed audit records
+type Query struct {
+	From       time.Time // Inclusive (zero = no lower bound)
+	To         time.Time // Inclusive (zero = no upper bound)
+	ClientCN   string
+	ClientOU   string
+	Context    string
+	Operation  string
+	Outcome    string
+	KeyVersion int
+	Cursor     uint64 // Return records after this seq (NextCursor of the previous page)
+	Limit      int    // 0 = DefaultQueryLimit, capped at MaxQueryLimit
+}
+
+// QueryResult is one page of matching records in log order
+type QueryResult struct {
+	Events     []Record `json:"events"`
+	NextCursor uint64   `json:"next_cursor,omitempty"` // 0 = last page
+}
+
+// Query returns the records matching q (an index that does not exist yet is empty)
+func (x *Index) Query(q Query) (*QueryResult, error) {
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DefaultQueryLimit
+	}
+	if limit > MaxQueryLimit {
+		limit = MaxQueryLimit
+	}
+
+	res := &QueryResult{Events: []Record{}}
+	err := x.view(func(tx *bolt.Tx) error {
+		events := tx.Bucket(indexEventsBucket)
+		times := tx.Bucket(indexTimeBucket)
+		if events == nil || times == nil {
+			return nil
+		}
+
+		// The time index narrows the seq range, records are still
+		// checked one by one in case the clock went backwards
+		start, end := q.Cursor+1, uint64(math.MaxUint64)
+		if !q.From.IsZero() {
+			k, _ := times.Cursor().Seek(timeKey(q.From, 0))
+			if k == nil {
+				return nil
+			}
+			start = max(start, binary.BigEndian.Uint64(k[8:]))
+		}
+		if !q.To.IsZero() {
+			c := times.Cursor()
+			k, _ := c.Seek(timeKey(q.To, math.MaxUint64))
+			if k == nil {
+				k, _ = c.Last()
+			} else {
+				k, _ = c.Prev()
+			}
+			if k == nil {
+				return nil
+			}
+			end = binary.BigEndian.Uint64(k[8:])
+		}
+
+		c := events.Cursor()
+		for k, v := c.Seek(seqKey(start)); k != nil && binary.BigEndian.Uint64(k) <= end; k, v = c.Next() {
+			var rec Record
+			if err := json.Unmarshal(v, &rec); err != nil {
+				return fmt.Errorf("corrupt index record %d: %w", binary.BigEndian.Uint64(k), err)
+			}
+			if !q.match(&rec) {
+				continue
+			}
+			if len(res.Events) == limit {
+				res.NextCursor = res.Events[limit-1].Seq
+				return nil
+			}
+			res.Events = append(res.Events, rec)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// match reports whether rec passes the filters
+func (q *Query) match(rec *Record) bool {
+	if !q.From.IsZero() && rec.Time.Before(q.From) || !q.To.IsZero() && rec.Time.After(q.To) {
+		return false
+	}
+	for _, f := range []struct{ key, want string }{
+		{"client_cn", q.ClientCN},
+		{"client_ou", q.ClientOU},
+		{"context", q.Context},
+		{"operation", q.Operation},
+		{"outcome", q.Outcome},
+	} {
+		if f.want != "" && AttrString(rec.Attrs, f.key) != f.want {
+			return false
+		}
+	}
+	if q.KeyVersion != 0 && AttrString(rec.Attrs, "key_version") != fmt.Sprint(q.KeyVersion) {
+		return false
+	}
+	return true
+}
+
+// putRecord indexes rec and advances last_seq
+func putRecord(tx *bolt.Tx, rec *Record) error {
+	if rec.Event != CheckpointEvent {
+		data, err := json.Marshal(rec)
+		if err != nil {
+			return fmt.Errorf("failed to marshal audit record: %w", err)
+		}
+		if err := tx.Bucket(indexEventsBucket).Put(seqKey(rec.Seq), data); err != nil {
+			return err
+		}
+		if err := tx.Bucket(indexTimeBucket).Put(timeKey(rec.Time, rec.Seq), []byte{}); err != nil {
+			return err
+		}
+	}
+	if rec.Seq > lastSeq(tx) {
+		return tx.Bucket(indexMetaBucket).Put(indexLastSeqKey, seqKey(rec.Seq))
+	}
+	return nil
+}
+
+func lastSeq(tx *bolt.Tx) uint64 {
+	meta := tx.Bucket(indexMetaBucket)
+	if meta == nil {
+		return 0
+	}
+	if v := meta.Get(indexLastSeqKey); len(v) == 8 {
+		return binary.BigEndian.Uint64(v)
+	}
+	return 0
+}
+
+// resetIndex drops all indexed records
+func resetIndex(tx *bolt.Tx) error {
+	for _, name := range [][]byte{indexEventsBucket, indexTimeBucket, indexMetaBucket} {
+		if tx.Bucket(name) == nil {
+			continue
+		}
+		if err := tx.DeleteBucket(name); err != nil {
+			return err
+		}
+	}
+	return createBuckets(tx)
+}
+
+func createBuckets(tx *bolt.Tx) error {
+	for _, name := range [][]byte{indexEventsBucket, indexTimeBucket, indexMetaBucket} {
+		if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func seqKey(seq uint64) []byte {
+	return binary.BigEndian.AppendUint64(nil, seq)
+}
+
+func timeKey(t time.Time, seq uint64) []byte {
+	// Sortable as bytes for times after 1970
+	return binary.BigEndian.AppendUint64(binary.BigEndian.AppendUint64(nil, uint64(t.UnixNano())), seq)
+}
+
+// update runs fn in a write transaction with all buckets created
+func (x *Index) update(fn func(tx *bolt.Tx) error) error {
+	db, err := bolt.Open(x.path, 0640, &bolt.Options{Timeout: indexOpenTimeout})
+	if err != nil {
+		return fmt.Errorf("open audit index: %w", err)
+	}
+	defer db.Close()
+
+	return db.Update(func(tx *bolt.Tx) error {
+		if err := createBuckets(tx); err != nil {
+			return err
+		}
+		return fn(tx)
+	})
+}
+
+// view runs fn in a read transaction; fn is not called if the index does
+// not exist yet (buckets may still be nil in a database from another version)
+func (x *Index) view(fn func(tx *bolt.Tx) error) error {
+	if _, err := os.Stat(x.path); os.IsNotExist(err) {
+		return nil
+	}
+
+	db, err := bolt.Open(x.path, 0640, &bolt.Options{Timeout: indexOpenTimeout, ReadOnly: true})
+	if err != nil {
+		return fmt.Errorf("open audit index: %w", err)
+	}
+	defer db.Close()
+
+	return db.View(fn)
+}
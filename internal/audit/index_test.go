@@ -0,0 +1,209 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/csv"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/titaev-lv/hsm-service/internal/config"
+)
+
+// writeEvents appends encrypt events for the given clients, one minute apart
+// from 2026-01-01 10:01 UTC, feeding idx (if set) through the sink
+func writeEvents(t *testing.T, path string, idx *Index, clients ...string) {
+	t.Helper()
+	sink, err := OpenSink(&config.AuditConfig{File: path}, testMAC("k1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idx != nil {
+		sink.SetIndex(idx)
+	}
+	n := 0
+	sink.now = func() time.Time {
+		n++
+		return time.Date(2026, 1, 1, 10, n, 0, 0, time.UTC)
+	}
+	for i, cn := range clients {
+		outcome := OutcomeSuccess
+		if cn == "intruder" {
+			outcome = OutcomeDenied
+		}
+		attrs := map[string]any{
+			"operation":   OpEncrypt,
+			"client_cn":   cn,
+			"client_ou":   "Trading",
+			"context":     "exchange-key",
+			"key_version": 1 + i%2,
+			"outcome":     outcome,
+		}
+		if err := sink.Append(slog.LevelInfo, EventMessage, attrs); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func querySeqs(t *testing.T, idx *Index, q Query) []uint64 {
+	t.Helper()
+	res, err := idx.Query(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seqs := []uint64{}
+	for _, rec := range res.Events {
+		seqs = append(seqs, rec.Seq)
+	}
+	return seqs
+}
+
+func TestIndex_Query(t *testing.T) {
+	dir := t.TempDir()
+	idx := NewIndex(filepath.Join(dir, "audit-index.db"))
+	// Events seq 1..4 (10:01..10:04), seq 5 is the closing checkpoint (not indexed)
+	writeEvents(t, filepath.Join(dir, "audit.log"), idx, "svc-1", "svc-2", "intruder", "svc-1")
+	if err := idx.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		query Query
+		want  []uint64
+	}{
+		{"all", Query{}, []uint64{1, 2, 3, 4}},
+		{"client", Query{ClientCN: "svc-1"}, []uint64{1, 4}},
+		{"outcome", Query{Outcome: OutcomeDenied}, []uint64{3}},
+		{"key version", Query{KeyVersion: 2}, []uint64{2, 4}},
+		{"ou and context", Query{ClientOU: "Trading", Context: "exchange-key", Operation: OpEncrypt}, []uint64{1, 2, 3, 4}},
+		{"other context", Query{Context: "2fa"}, []uint64{}},
+		{"time range", Query{
+			From: time.Date(2026, 1, 1, 10, 2, 0, 0, time.UTC),
+			To:   time.Date(2026, 1, 1, 10, 3, 0, 0, time.UTC),
+		}, []uint64{2, 3}},
+		{"after the last event", Query{From: time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)}, []uint64{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := querySeqs(t, idx, tt.query); !slices.Equal(got, tt.want) {
+				t.Errorf("seqs = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIndex_Pagination(t *testing.T) {
+	dir := t.TempDir()
+	idx := NewIndex(filepath.Join(dir, "audit-index.db"))
+	writeEvents(t, filepath.Join(dir, "audit.log"), idx, "a", "b", "c", "d", "e")
+	idx.Close()
+
+	var pages [][]uint64
+	q := Query{Limit: 2}
+	for {
+		res, err := idx.Query(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		page := []uint64{}
+		for _, rec := range res.Events {
+			page = append(page, rec.Seq)
+		}
+		pages = append(pages, page)
+		if res.NextCursor == 0 {
+			break
+		}
+		q.Cursor = res.NextCursor
+	}
+	want := [][]uint64{{1, 2}, {3, 4}, {5}}
+	if !slices.EqualFunc(pages, want, slices.Equal) {
+		t.Errorf("pages = %v, want %v", pages, want)
+	}
+}
+
+func TestIndex_Sync(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "audit.log")
+	idx := NewIndex(filepath.Join(dir, "audit-index.db"))
+
+	// Empty index is empty, not an error
+	if got := querySeqs(t, idx, Query{}); len(got) != 0 {
+		t.Fatalf("new index returned %v", got)
+	}
+
+	// Written without the index (e.g. the service crashed before a flush)
+	writeEvents(t, logPath, nil, "svc-1", "svc-2")
+	rotate(t, logPath, "2026-01-01T00-00-00.000")
+	writeEvents(t, logPath, nil, "svc-3")
+
+	n, err := idx.Sync(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Checkpoints advance last_seq but are not returned
+	if want := []uint64{1, 2, 4}; !slices.Equal(querySeqs(t, idx, Query{}), want) || n != 5 {
+		t.Errorf("after sync: %d records, seqs %v, want 5 records, seqs %v", n, querySeqs(t, idx, Query{}), want)
+	}
+	if n, err := idx.Sync(logPath); err != nil || n != 0 {
+		t.Errorf("second sync added %d records (err %v), want 0", n, err)
+	}
+
+	// A replaced log (new chain shorter than the index) rebuilds the index
+	for _, f := range []string{logPath, filepath.Join(dir, "audit-2026-01-01T00-00-00.000.log")} {
+		os.Remove(f)
+	}
+	writeEvents(t, logPath, nil, "svc-9")
+	if _, err := idx.Sync(logPath); err != nil {
+		t.Fatal(err)
+	}
+	res, _ := idx.Query(Query{})
+	if len(res.Events) != 1 || AttrString(res.Events[0].Attrs, "client_cn") != "svc-9" {
+		t.Errorf("index not rebuilt: %+v", res.Events)
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	recs := []Record{{
+		Seq:   42,
+		Time:  time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC),
+		Level: "WARN",
+		Event: EventMessage,
+		Attrs: map[string]any{
+			"client_cn":     "trading-service-1",
+			"reason":        "access denied: insufficient permissions",
+			"payload_bytes": float64(1048576),
+		},
+	}}
+	var buf bytes.Buffer
+	if err := WriteCSV(&buf, recs, true); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil || len(rows) != 2 {
+		t.Fatalf("invalid CSV %q: %v", buf.String(), err)
+	}
+	row := map[string]string{}
+	for i, col := range rows[0] {
+		row[col] = rows[1][i]
+	}
+	want := map[string]string{
+		"seq":           "42",
+		"time":          "2026-01-01T10:00:00Z",
+		"client_cn":     "trading-service-1",
+		"reason":        "access denied: insufficient permissions",
+		"payload_bytes": "1048576",
+		"context":       "",
+	}
+	for k, v := range want {
+		if row[k] != v {
+			t.Errorf("%s = %q, want %q", k, row[k], v)
+		}
+	}
+}
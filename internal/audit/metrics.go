@@ -0,0 +1,45 @@
+package audit
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Prometheus metrics for SIEM outputs
+var (
+	// Events delivered to the collector per output
+	OutputSentTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "hsm_audit_output_sent_total",
+			Help: "Total number of audit events sent by SIEM output",
+		},
+		[]string{"output"},
+	)
+
+	// Events discarded per output (buffer full or undeliverable at shutdown)
+	OutputDroppedTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "hsm_audit_output_dropped_total",
+			Help: "Total number of audit events dropped by SIEM output",
+		},
+		[]string{"output"},
+	)
+
+	// Events waiting in the output buffer
+	OutputQueueLength = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "hsm_audit_output_queue_length",
+			Help: "Number of audit events buffered by SIEM output",
+		},
+		[]string{"output"},
+	)
+
+	// Collector connection state (1 = connected)
+	OutputConnected = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "hsm_audit_output_connected",
+			Help: "Whether the SIEM output is connected to its collector (1 = connected)",
+		},
+		[]string{"output"},
+	)
+)
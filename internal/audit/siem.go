@@ -0,0 +1,264 @@
+package audit
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/titaev-lv/hsm-service/internal/config"
+)
+
+// Output timings
+const (
+	outputDialTimeout  = 5 * time.Second
+	outputWriteTimeout = 5 * time.Second
+	outputMinBackoff   = 500 * time.Millisecond
+	outputMaxBackoff   = 30 * time.Second
+	outputDrainTimeout = 5 * time.Second // Close: longest wait to flush the buffer
+)
+
+// Output forwards audit events to a SIEM collector over syslog. Events are
+// formatted on the caller's goroutine and queued in a bounded buffer; a
+// background goroutine delivers them and reconnects with backoff while the
+// collector is down. A full buffer drops events or blocks the caller for
+// block_timeout, as configured.
+type Output struct {
+	name         string
+	network      string
+	address      string
+	tlsConfig    *tls.Config
+	formatter    *formatter
+	onFull       string
+	blockTimeout time.Duration
+
+	queue chan []byte
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+// NewOutput validates the TLS material and starts the delivery goroutine.
+// The collector is dialed lazily: an unreachable collector at startup only
+// fills the buffer.
+func NewOutput(cfg *config.AuditOutputConfig) (*Output, error) {
+	o := &Output{
+		name:         cfg.GetName(),
+		network:      cfg.GetNetwork(),
+		address:      cfg.Address,
+		formatter:    newFormatter(cfg),
+		onFull:       cfg.GetOnFull(),
+		blockTimeout: cfg.GetBlockTimeout(),
+		queue:        make(chan []byte, cfg.GetBufferSize()),
+		stop:         make(chan struct{}),
+		done:         make(chan struct{}),
+	}
+
+	if o.network == "tls" {
+		tlsConfig, err := outputTLSConfig(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("audit output %s: %w", o.name, err)
+		}
+		o.tlsConfig = tlsConfig
+	}
+
+	OutputConnected.WithLabelValues(o.name).Set(0)
+	OutputQueueLength.WithLabelValues(o.name).Set(0)
+	go o.run()
+	return o, nil
+}
+
+// outputTLSConfig builds the client TLS configuration of a tls output
+func outputTLSConfig(cfg *config.AuditOutputConfig) (*tls.Config, error) {
+	serverName := cfg.ServerName
+	if serverName == "" {
+		serverName, _, _ = net.SplitHostPort(cfg.Address)
+	}
+	tlsConfig := &tls.Config{ServerName: serverName, MinVersion: tls.VersionTLS12}
+
+	if cfg.CAPath != "" {
+		pem, err := os.ReadFile(cfg.CAPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", cfg.CAPath)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if cfg.CertPath != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertPath, cfg.KeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// Name returns the output label
+func (o *Output) Name() string {
+	return o.name
+}
+
+// Send formats rec and queues it for delivery, applying the full-buffer policy
+func (o *Output) Send(rec *Record) {
+	msg := o.formatter.Format(rec)
+
+	select {
+	case o.queue <- msg:
+		OutputQueueLength.WithLabelValues(o.name).Set(float64(len(o.queue)))
+		return
+	default:
+	}
+
+	if o.onFull == config.AuditOutputBlock {
+		timer := time.NewTimer(o.blockTimeout)
+		defer timer.Stop()
+		select {
+		case o.queue <- msg:
+			OutputQueueLength.WithLabelValues(o.name).Set(float64(len(o.queue)))
+			return
+		case <-timer.C:
+		}
+	}
+	OutputDroppedTotal.WithLabelValues(o.name).Inc()
+}
+
+// Close stops accepting deliveries and flushes the buffer for up to
+// outputDrainTimeout; undelivered events are counted as dropped
+func (o *Output) Close() {
+	select {
+	case <-o.stop:
+	default:
+		close(o.stop)
+	}
+	<-o.done
+}
+
+// run delivers queued messages, retrying the current one until it is
+// written or the output is closed
+func (o *Output) run() {
+	defer close(o.done)
+
+	var (
+		conn     net.Conn
+		pending  []byte
+		backoff  = outputMinBackoff
+		deadline time.Time // Set once Close is called
+		down     bool      // Collector failure already logged
+	)
+	defer func() {
+		if conn != nil {
+			conn.Close()
+		}
+		OutputConnected.WithLabelValues(o.name).Set(0)
+	}()
+
+	for {
+		if pending == nil {
+			if deadline.IsZero() {
+				select {
+				case pending = <-o.queue:
+				case <-o.stop:
+					deadline = time.Now().Add(outputDrainTimeout)
+					continue
+				}
+			} else {
+				select {
+				case pending = <-o.queue:
+				default:
+					return // Drained
+				}
+			}
+			OutputQueueLength.WithLabelValues(o.name).Set(float64(len(o.queue)))
+		}
+
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			o.dropQueued(pending)
+			return
+		}
+
+		if conn == nil {
+			c, err := o.dial()
+			if err != nil {
+				if !down {
+					slog.Warn("audit output: collector unreachable, buffering events",
+						"output", o.name, "address", o.address, "error", err)
+					down = true
+				}
+				if !deadline.IsZero() {
+					o.dropQueued(pending)
+					return
+				}
+				select {
+				case <-time.After(backoff):
+				case <-o.stop:
+					deadline = time.Now().Add(outputDrainTimeout)
+				}
+				backoff = min(2*backoff, outputMaxBackoff)
+				continue
+			}
+			conn = c
+			backoff = outputMinBackoff
+			if down {
+				slog.Info("audit output: collector reconnected", "output", o.name, "address", o.address)
+				down = false
+			}
+			OutputConnected.WithLabelValues(o.name).Set(1)
+		}
+
+		conn.SetWriteDeadline(time.Now().Add(outputWriteTimeout))
+		if _, err := conn.Write(o.frame(pending)); err != nil {
+			if !down {
+				slog.Warn("audit output: write failed, reconnecting",
+					"output", o.name, "address", o.address, "error", err)
+				down = true
+			}
+			conn.Close()
+			conn = nil
+			OutputConnected.WithLabelValues(o.name).Set(0)
+			continue
+		}
+		OutputSentTotal.WithLabelValues(o.name).Inc()
+		pending = nil
+	}
+}
+
+// dial connects to the collector
+func (o *Output) dial() (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: outputDialTimeout}
+	if o.tlsConfig != nil {
+		return tls.DialWithDialer(dialer, "tcp", o.address, o.tlsConfig)
+	}
+	return dialer.Dial(o.network, o.address)
+}
+
+// frame adds the transport framing: one datagram per message over UDP,
+// octet counting over TCP and TLS (RFC 6587, RFC 5425)
+func (o *Output) frame(msg []byte) []byte {
+	if o.network == "udp" {
+		return msg
+	}
+	return append([]byte(strconv.Itoa(len(msg))+" "), msg...)
+}
+
+// dropQueued counts the pending message and everything still buffered as dropped
+func (o *Output) dropQueued(pending []byte) {
+	n := len(o.queue)
+	for range n {
+		<-o.queue
+	}
+	if pending != nil {
+		n++
+	}
+	OutputDroppedTotal.WithLabelValues(o.name).Add(float64(n))
+	OutputQueueLength.WithLabelValues(o.name).Set(0)
+	if n > 0 {
+		slog.Warn("audit output: events dropped at shutdown", "output", o.name, "count", n)
+	}
+}
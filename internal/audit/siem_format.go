@@ -0,0 +1,353 @@
+package audit
+
+import (
+	"maps"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/titaev-lv/hsm-service/internal/config"
+)
+
+// Header fields of CEF and LEEF events
+const (
+	siemVendor  = "HSM Service"
+	siemProduct = "hsm-service"
+	siemVersion = "1.0"
+)
+
+// sdID is the RFC 5424 structured data ID of audit attributes
+// (32473 is the private enterprise number reserved for documentation)
+const sdID = "audit@32473"
+
+// Derived attributes available to field mappings besides the event attributes
+const (
+	attrRemoteIP = "remote_ip" // Host part of remote_addr
+	attrTimeMs   = "time_ms"   // Record time, milliseconds since the epoch
+	attrTime     = "time"      // Record time, RFC 3339
+	attrEvent    = "event"     // Event name (log message)
+	attrSeverity = "severity"  // CEF/LEEF severity 0-10
+)
+
+// defaultCEFFields maps CEF extension keys to attributes. Values starting
+// with "=" are literals (custom field labels).
+var defaultCEFFields = map[string]string{
+	"rt":            attrTimeMs,
+	"suser":         "client_cn",
+	"src":           attrRemoteIP,
+	"requestMethod": "method",
+	"request":       "path",
+	"outcome":       "outcome",
+	"reason":        "reason",
+	"externalId":    "event_id",
+	"cs1Label":      "=context",
+	"cs1":           "context",
+	"cs2Label":      "=keyId",
+	"cs2":           "key_id",
+	"cs3Label":      "=requestId",
+	"cs3":           "request_id",
+	"cs4Label":      "=clientOU",
+	"cs4":           "client_ou",
+	"cs5Label":      "=clientSerial",
+	"cs5":           "client_serial",
+	"cs6Label":      "=clientFingerprint",
+	"cs6":           "client_fingerprint",
+	"cn1Label":      "=keyVersion",
+	"cn1":           "key_version",
+	"cn2Label":      "=payloadBytes",
+	"cn2":           "payload_bytes",
+	"cn3Label":      "=httpStatus",
+	"cn3":           "status",
+}
+
+// defaultLEEFFields maps LEEF attribute keys to attributes
+var defaultLEEFFields = map[string]string{
+	"devTime":           attrTime,
+	"devTimeFormat":     "=yyyy-MM-dd'T'HH:mm:ss.SSSXXX",
+	"cat":               "operation",
+	"sev":               attrSeverity,
+	"usrName":           "client_cn",
+	"src":               attrRemoteIP,
+	"outcome":           "outcome",
+	"reason":            "reason",
+	"eventId":           "event_id",
+	"requestId":         "request_id",
+	"traceId":           "trace_id",
+	"context":           "context",
+	"keyId":             "key_id",
+	"keyVersion":        "key_version",
+	"payloadBytes":      "payload_bytes",
+	"clientOU":          "client_ou",
+	"clientSerial":      "client_serial",
+	"clientFingerprint": "client_fingerprint",
+	"method":            "method",
+	"url":               "path",
+	"status":            "status",
+	"durationMs":        "duration_ms",
+}
+
+// formatter renders a record as one syslog message (without transport framing)
+type formatter struct {
+	format   string
+	facility int
+	hostname string
+	appName  string
+	procID   string
+	fields   map[string]string // Output key -> attribute (syslog: renames only)
+	keys     []string          // Sorted output keys, labels before their field (stable messages)
+}
+
+func newFormatter(cfg *config.AuditOutputConfig) *formatter {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+
+	fields := make(map[string]string)
+	switch cfg.Format {
+	case config.AuditOutputCEF:
+		maps.Copy(fields, defaultCEFFields)
+	case config.AuditOutputLEEF:
+		maps.Copy(fields, defaultLEEFFields)
+	}
+	for k, v := range cfg.Fields {
+		if v == "" {
+			delete(fields, k)
+		} else {
+			fields[k] = v
+		}
+	}
+
+	return &formatter{
+		format:   cfg.Format,
+		facility: cfg.GetFacility(),
+		hostname: hostname,
+		appName:  cfg.GetAppName(),
+		procID:   strconv.Itoa(os.Getpid()),
+		fields:   fields,
+		keys: slices.SortedFunc(maps.Keys(fields), func(a, b string) int {
+			return strings.Compare(keyOrder(a), keyOrder(b))
+		}),
+	}
+}
+
+// keyOrder sorts a CEF custom field label ("cs1Label") right before its field
+func keyOrder(k string) string {
+	if base, ok := strings.CutSuffix(k, "Label"); ok {
+		return base + "\x00"
+	}
+	return k + "\x01"
+}
+
+// Format returns the RFC 5424 message of rec; CEF and LEEF are carried as
+// the MSG part
+func (f *formatter) Format(rec *Record) []byte {
+	level := parseLevel(rec.Level)
+	msgID := AttrString(rec.Attrs, "operation")
+	if msgID == "" {
+		msgID = rec.Event
+	}
+
+	var b strings.Builder
+	b.WriteString("<" + strconv.Itoa(f.facility*8+syslogSeverity(level)) + ">1 ")
+	b.WriteString(rec.Time.UTC().Format("2006-01-02T15:04:05.000000Z07:00") + " ")
+	b.WriteString(headerField(f.hostname, 255) + " ")
+	b.WriteString(headerField(f.appName, 48) + " ")
+	b.WriteString(f.procID + " ")
+	b.WriteString(headerField(msgID, 32) + " ")
+
+	switch f.format {
+	case config.AuditOutputCEF:
+		b.WriteString("- ")
+		f.writeCEF(&b, rec, level)
+	case config.AuditOutputLEEF:
+		b.WriteString("- ")
+		f.writeLEEF(&b, rec, level)
+	default:
+		f.writeStructuredData(&b, rec)
+		b.WriteString(" " + rec.Event)
+	}
+	return []byte(b.String())
+}
+
+// writeStructuredData writes all attributes as one SD element; fields
+// renames attributes (output key -> attribute)
+func (f *formatter) writeStructuredData(b *strings.Builder, rec *Record) {
+	renamed := make(map[string]string, len(f.fields))
+	for _, k := range f.keys {
+		renamed[f.fields[k]] = k
+	}
+
+	b.WriteString("[" + sdID)
+	for _, attr := range slices.Sorted(maps.Keys(rec.Attrs)) {
+		name := attr
+		if k, ok := renamed[attr]; ok {
+			name = k
+		}
+		name = sdName(name)
+		if name == "" {
+			continue
+		}
+		b.WriteString(" " + name + `="` + sdEscaper.Replace(AttrString(rec.Attrs, attr)) + `"`)
+	}
+	b.WriteString("]")
+}
+
+func (f *formatter) writeCEF(b *strings.Builder, rec *Record, level slogLevel) {
+	b.WriteString("CEF:0|")
+	for _, h := range []string{siemVendor, siemProduct, siemVersion, signatureID(rec), rec.Event} {
+		b.WriteString(cefHeaderEscaper.Replace(h) + "|")
+	}
+	b.WriteString(strconv.Itoa(siemSeverity(rec, level)) + "|")
+
+	sep := ""
+	for _, k := range f.keys {
+		v := f.value(rec, level, f.fields[k])
+		if base, ok := strings.CutSuffix(k, "Label"); ok {
+			if attr, mapped := f.fields[base]; mapped && f.value(rec, level, attr) == "" {
+				continue // No label without its field
+			}
+		}
+		if v == "" {
+			continue
+		}
+		b.WriteString(sep + k + "=" + cefValueEscaper.Replace(v))
+		sep = " "
+	}
+}
+
+func (f *formatter) writeLEEF(b *strings.Builder, rec *Record, level slogLevel) {
+	b.WriteString("LEEF:2.0|")
+	for _, h := range []string{siemVendor, siemProduct, siemVersion, signatureID(rec)} {
+		b.WriteString(leefHeaderEscaper.Replace(h) + "|")
+	}
+	b.WriteString("x09|")
+
+	sep := ""
+	for _, k := range f.keys {
+		v := f.value(rec, level, f.fields[k])
+		if v == "" {
+			continue
+		}
+		b.WriteString(sep + k + "=" + leefValueEscaper.Replace(v))
+		sep = "\t"
+	}
+}
+
+// value resolves a field mapping: a literal, a derived attribute or an event attribute
+func (f *formatter) value(rec *Record, level slogLevel, attr string) string {
+	if lit, ok := strings.CutPrefix(attr, "="); ok {
+		return lit
+	}
+	switch attr {
+	case attrTimeMs:
+		return strconv.FormatInt(rec.Time.UnixMilli(), 10)
+	case attrTime:
+		return rec.Time.UTC().Format("2006-01-02T15:04:05.000Z07:00")
+	case attrEvent:
+		return rec.Event
+	case attrSeverity:
+		return strconv.Itoa(siemSeverity(rec, level))
+	case attrRemoteIP:
+		addr := AttrString(rec.Attrs, "remote_addr")
+		if host, _, err := net.SplitHostPort(addr); err == nil {
+			return host
+		}
+		return addr
+	}
+	return AttrString(rec.Attrs, attr)
+}
+
+// signatureID identifies the event class: the operation of request events,
+// the event name otherwise
+func signatureID(rec *Record) string {
+	if op := AttrString(rec.Attrs, "operation"); op != "" {
+		return op
+	}
+	return rec.Event
+}
+
+// slogLevel is a record level as ordered by slog (DEBUG -4 ... ERROR 8)
+type slogLevel int
+
+func parseLevel(s string) slogLevel {
+	switch {
+	case strings.HasPrefix(s, "ERROR"):
+		return 8
+	case strings.HasPrefix(s, "WARN"):
+		return 4
+	case strings.HasPrefix(s, "DEBUG"):
+		return -4
+	default:
+		return 0
+	}
+}
+
+// syslogSeverity maps a level to the RFC 5424 severity
+func syslogSeverity(level slogLevel) int {
+	switch {
+	case level >= 8:
+		return 3 // Error
+	case level >= 4:
+		return 4 // Warning
+	case level >= 0:
+		return 6 // Informational
+	default:
+		return 7 // Debug
+	}
+}
+
+// siemSeverity maps a record to the CEF/LEEF severity (0-10): denials rank
+// above other warnings
+func siemSeverity(rec *Record, level slogLevel) int {
+	switch {
+	case level >= 8:
+		return 8
+	case AttrString(rec.Attrs, "outcome") == OutcomeDenied:
+		return 7
+	case level >= 4:
+		return 5
+	default:
+		return 3
+	}
+}
+
+var (
+	// RFC 5424 PARAM-VALUE
+	sdEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+	// CEF header fields and extension values
+	cefHeaderEscaper = strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\r", " ", "\n", " ")
+	cefValueEscaper  = strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\r", `\r`, "\n", `\n`)
+	// LEEF: pipes end header fields, tabs separate attributes
+	leefHeaderEscaper = strings.NewReplacer(`|`, " ", "\t", " ", "\r", " ", "\n", " ")
+	leefValueEscaper  = strings.NewReplacer("\t", " ", "\r", " ", "\n", " ")
+)
+
+// headerField returns s as an RFC 5424 header field: printable ASCII
+// without spaces, at most max characters, "-" if empty
+func headerField(s string, max int) string {
+	b := make([]byte, 0, len(s))
+	for i := 0; i < len(s) && len(b) < max; i++ {
+		if c := s[i]; c > ' ' && c < 0x7f {
+			b = append(b, c)
+		}
+	}
+	if len(b) == 0 {
+		return "-"
+	}
+	return string(b)
+}
+
+// sdName returns s as an SD-NAME (printable ASCII except = ] " and space,
+// at most 32 characters)
+func sdName(s string) string {
+	b := make([]byte, 0, len(s))
+	for i := 0; i < len(s) && len(b) < 32; i++ {
+		if c := s[i]; c > ' ' && c < 0x7f && c != '=' && c != ']' && c != '"' {
+			b = append(b, c)
+		}
+	}
+	return string(b)
+}
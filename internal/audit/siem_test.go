@@ -0,0 +1,225 @@
+package audit
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/titaev-lv/hsm-service/internal/config"
+)
+
+func testRecord() *Record {
+	return &Record{
+		Time:  time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+		Level: "WARN",
+		Event: EventMessage,
+		Attrs: map[string]any{
+			"operation":   OpDecrypt,
+			"outcome":     OutcomeDenied,
+			"reason":      `access "denied"] a=b|c\d`,
+			"client_cn":   "trading-service-1",
+			"remote_addr": "10.0.0.5:51234",
+			"key_version": float64(2),
+		},
+	}
+}
+
+func TestFormatter_Syslog(t *testing.T) {
+	f := newFormatter(&config.AuditOutputConfig{Format: config.AuditOutputSyslog, Fields: map[string]string{"suser": "client_cn"}})
+	msg := string(f.Format(testRecord()))
+
+	// facility 13 * 8 + warning (4)
+	if !strings.HasPrefix(msg, "<108>1 2026-01-02T03:04:05.000000Z ") {
+		t.Errorf("unexpected header: %s", msg)
+	}
+	for _, want := range []string{
+		" hsm-service " + f.procID + " decrypt [audit@32473 ",
+		`reason="access \"denied\"\] a=b|c\\d"`,
+		`suser="trading-service-1"`,
+		`key_version="2"`,
+		"] request",
+	} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("missing %q in %s", want, msg)
+		}
+	}
+	if strings.Contains(msg, "client_cn=") {
+		t.Errorf("renamed attribute kept its name: %s", msg)
+	}
+}
+
+func TestFormatter_CEF(t *testing.T) {
+	f := newFormatter(&config.AuditOutputConfig{
+		Format: config.AuditOutputCEF,
+		Fields: map[string]string{"suser": "", "duser": "client_cn"},
+	})
+	msg := string(f.Format(testRecord()))
+
+	_, cef, ok := strings.Cut(msg, " - ")
+	if !ok {
+		t.Fatalf("no MSG part: %s", msg)
+	}
+	if !strings.HasPrefix(cef, "CEF:0|HSM Service|hsm-service|1.0|decrypt|request|7|") {
+		t.Errorf("unexpected CEF header: %s", cef)
+	}
+	for _, want := range []string{
+		`reason=access "denied"] a\=b|c\\d`,
+		"duser=trading-service-1",
+		"src=10.0.0.5",
+		"cn1Label=keyVersion cn1=2",
+		"rt=" + strconv.FormatInt(testRecord().Time.UnixMilli(), 10),
+	} {
+		if !strings.Contains(cef, want) {
+			t.Errorf("missing %q in %s", want, cef)
+		}
+	}
+	if strings.Contains(cef, "suser=") || strings.Contains(cef, "cs2") {
+		t.Errorf("removed or empty field present: %s", cef)
+	}
+}
+
+func TestFormatter_LEEF(t *testing.T) {
+	f := newFormatter(&config.AuditOutputConfig{Format: config.AuditOutputLEEF})
+	rec := testRecord()
+	rec.Attrs["context"] = "exchange\tkey"
+	msg := string(f.Format(rec))
+
+	_, leef, _ := strings.Cut(msg, " - ")
+	header, attrs, ok := strings.Cut(leef, "|x09|")
+	if !ok || header != "LEEF:2.0|HSM Service|hsm-service|1.0|decrypt" {
+		t.Fatalf("unexpected LEEF header: %s", leef)
+	}
+	got := make(map[string]string)
+	for _, kv := range strings.Split(attrs, "\t") {
+		k, v, _ := strings.Cut(kv, "=")
+		got[k] = v
+	}
+	for k, want := range map[string]string{
+		"usrName": "trading-service-1",
+		"src":     "10.0.0.5",
+		"sev":     "7",
+		"context": "exchange key",
+		"devTime": "2026-01-02T03:04:05.000Z",
+	} {
+		if got[k] != want {
+			t.Errorf("%s = %q, want %q", k, got[k], want)
+		}
+	}
+}
+
+func TestOutput_UDP(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+
+	out, err := NewOutput(&config.AuditOutputConfig{Name: "udp-test", Format: config.AuditOutputSyslog, Address: pc.LocalAddr().String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	out.Send(testRecord())
+
+	buf := make([]byte, 4096)
+	pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("no datagram: %v", err)
+	}
+	if !strings.HasPrefix(string(buf[:n]), "<108>1 ") {
+		t.Errorf("unexpected datagram: %s", buf[:n])
+	}
+}
+
+// TestOutput_TCPBuffersWhileDown starts the collector after the events
+// were sent: they must be delivered once it comes up
+func TestOutput_TCPBuffersWhileDown(t *testing.T) {
+	addr := freeAddr(t)
+	out, err := NewOutput(&config.AuditOutputConfig{Name: "tcp-test", Format: config.AuditOutputCEF, Network: "tcp", Address: addr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	out.Send(testRecord())
+	out.Send(testRecord())
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	// Octet counting: "<length> <message>"
+	r := bufio.NewReader(conn)
+	for i := 0; i < 2; i++ {
+		size, err := r.ReadString(' ')
+		if err != nil {
+			t.Fatalf("frame %d: %v", i, err)
+		}
+		n, err := strconv.Atoi(strings.TrimSuffix(size, " "))
+		if err != nil {
+			t.Fatalf("frame %d: bad length %q", i, size)
+		}
+		msg := make([]byte, n)
+		if _, err := io.ReadFull(r, msg); err != nil {
+			t.Fatalf("frame %d: %v", i, err)
+		}
+		if !strings.Contains(string(msg), "CEF:0|") {
+			t.Errorf("frame %d: unexpected message %s", i, msg)
+		}
+	}
+}
+
+func TestOutput_DropWhenFull(t *testing.T) {
+	out, err := NewOutput(&config.AuditOutputConfig{
+		Name: "drop-test", Format: config.AuditOutputSyslog, Network: "tcp", Address: freeAddr(t), BufferSize: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// At most one message in flight and one buffered: Send never waits
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		out.Send(testRecord())
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("Send blocked with on_full: drop")
+	}
+	if dropped := testutil.ToFloat64(OutputDroppedTotal.WithLabelValues("drop-test")); dropped < 3 {
+		t.Errorf("dropped = %v, want at least 3", dropped)
+	}
+
+	// Collector still down at shutdown: the rest is dropped too
+	out.Close()
+	if dropped := testutil.ToFloat64(OutputDroppedTotal.WithLabelValues("drop-test")); dropped != 5 {
+		t.Errorf("dropped after Close = %v, want 5", dropped)
+	}
+	if sent := testutil.ToFloat64(OutputSentTotal.WithLabelValues("drop-test")); sent != 0 {
+		t.Errorf("sent = %v, want 0", sent)
+	}
+}
+
+// freeAddr returns a local TCP address with nothing listening on it
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	return addr
+}
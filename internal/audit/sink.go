@@ -0,0 +1,499 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/titaev-lv/hsm-service/internal/config"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// CheckpointEvent is the event name of signed checkpoint records
+const CheckpointEvent = "audit.checkpoint"
+
+// checkpointMACDomain separates checkpoint MACs from any other use of the key
+const checkpointMACDomain = "hsm-service/audit-checkpoint/v1\n"
+
+// maxRecordSize bounds the tail read when resuming the chain
+const maxRecordSize = 1 << 20
+
+// MAC computes a MAC over data (HMAC-SHA256 with an HSM-resident key)
+type MAC func(data []byte) ([]byte, error)
+
+// Record is one line of the audit log. Prev is the SHA-256 of the previous
+// line, so removing, reordering or editing a line breaks the chain; MAC is
+// set on checkpoint records only.
+type Record struct {
+	Seq   uint64         `json:"seq"`
+	Time  time.Time      `json:"time"`
+	Level string         `json:"level"`
+	Event string         `json:"event"`
+	Attrs map[string]any `json:"attrs,omitempty"`
+	Prev  string         `json:"prev"`
+	MAC   string         `json:"mac,omitempty"`
+}
+
+// Sink appends hash-chained records to the audit log and periodically
+// writes checkpoints signed in the HSM. A valid checkpoint MAC authenticates
+// every record before it: rewriting the chain requires the HSM key.
+// hsm-admin appends to the same log: writers take a lock on <file>.lock and
+// a sink continues the chain from records appended by other processes.
+type Sink struct {
+	mu              sync.Mutex
+	w               io.WriteCloser
+	mac             MAC
+	seq             uint64
+	prev            string // Hash of the last written line ("" = new chain)
+	sinceCheckpoint int
+	now             func() time.Time
+	index           *Index // Query index fed with every written record (optional)
+
+	path string      // Audit log shared with other processes ("" = not shared)
+	lock *os.File    // <path>.lock
+	info os.FileInfo // Audit log after the last write (nil = not created yet)
+
+	noState bool // <path>.checkpoint is missing: the next Checkpoint records it
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// OpenSink opens the audit log (rotated by size like the service log) and
+// continues the chain from its last record. Without mac no checkpoints are
+// written: the service signs the records with its next checkpoint.
+func OpenSink(cfg *config.AuditConfig, mac MAC) (*Sink, error) {
+	path := cfg.GetFile()
+	lock, err := openLock(path)
+	if err != nil {
+		return nil, err
+	}
+	unlock, err := lockFile(lock)
+	if err != nil {
+		lock.Close()
+		return nil, err
+	}
+	defer unlock()
+
+	seq, prev, err := chainTail(path)
+	if err != nil {
+		lock.Close()
+		return nil, fmt.Errorf("failed to resume audit chain: %w", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil && !os.IsNotExist(err) {
+		lock.Close()
+		return nil, fmt.Errorf("failed to stat audit log: %w", err)
+	}
+
+	w := &lumberjack.Logger{
+		Filename:   path,
+		MaxSize:    cfg.GetMaxSizeMB(),
+		MaxBackups: cfg.MaxBackups,
+		MaxAge:     cfg.MaxAgeDays,
+		Compress:   cfg.Compress,
+	}
+	s := newSink(w, mac, seq, prev)
+	s.path, s.lock, s.info = path, lock, info
+	if _, err := os.Stat(CheckpointStatePath(path)); os.IsNotExist(err) {
+		s.noState = true
+	}
+	return s, nil
+}
+
+func newSink(w io.WriteCloser, mac MAC, seq uint64, prev string) *Sink {
+	return &Sink{w: w, mac: mac, seq: seq, prev: prev, now: time.Now, stop: make(chan struct{})}
+}
+
+// SetIndex feeds every record written from now on into idx
+func (s *Sink) SetIndex(idx *Index) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.index = idx
+}
+
+// Append writes one audit record
+func (s *Sink) Append(level slog.Level, event string, attrs map[string]any) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	unlock, err := s.lockShared()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := s.write(&Record{Level: level.String(), Event: event, Attrs: attrs}); err != nil {
+		return err
+	}
+	s.sinceCheckpoint++
+	return nil
+}
+
+// Checkpoint writes a record signed with the HSM key if anything was
+// appended since the last checkpoint
+func (s *Sink) Checkpoint() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.mac == nil {
+		return nil
+	}
+
+	unlock, err := s.lockShared()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if s.sinceCheckpoint == 0 && s.seq > 0 && !s.noState {
+		return nil
+	}
+	return s.checkpoint()
+}
+
+func (s *Sink) checkpoint() error {
+	rec := &Record{
+		Level: slog.LevelInfo.String(),
+		Event: CheckpointEvent,
+		Attrs: map[string]any{"records": s.sinceCheckpoint},
+		Seq:   s.seq + 1,
+		Time:  s.now().UTC(),
+		Prev:  s.prev,
+	}
+	sum, err := s.mac(checkpointData(rec))
+	if err != nil {
+		return fmt.Errorf("failed to sign audit checkpoint: %w", err)
+	}
+	rec.MAC = hex.EncodeToString(sum)
+
+	if err := s.write(rec); err != nil {
+		return err
+	}
+	s.sinceCheckpoint = 0
+
+	if s.path != "" {
+		if err := writeCheckpointState(s.path, &CheckpointState{Seq: rec.Seq, Hash: s.prev, Time: rec.Time}); err != nil {
+			return err
+		}
+		s.noState = false
+	}
+	return nil
+}
+
+// write chains rec to the previous record and appends it as one line
+// (checkpoints arrive with Seq, Time and Prev already set for the MAC)
+func (s *Sink) write(rec *Record) error {
+	if rec.Seq == 0 {
+		rec.Seq = s.seq + 1
+		rec.Time = s.now().UTC()
+		rec.Prev = s.prev
+	}
+
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("failed to marshal audit record: %w", err)
+	}
+	// One Write per line: lumberjack rotates between writes, so a record
+	// never spans two files
+	if _, err := s.w.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write audit record: %w", err)
+	}
+	s.seq = rec.Seq
+	s.prev = lineHash(line)
+	if s.index != nil {
+		s.index.Add(*rec)
+	}
+	if s.path != "" {
+		if info, err := os.Stat(s.path); err == nil {
+			s.info = info
+		}
+	}
+	return nil
+}
+
+// lockShared takes the lock of a shared audit log and picks up records
+// other processes appended since the last write. The returned function
+// releases the lock.
+func (s *Sink) lockShared() (func(), error) {
+	if s.path == "" {
+		return func() {}, nil
+	}
+	unlock, err := lockFile(s.lock)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.sync(); err != nil {
+		unlock()
+		return nil, err
+	}
+	return unlock, nil
+}
+
+// sync continues the chain from records appended by another process: they
+// are indexed and covered by the next checkpoint
+func (s *Sink) sync() error {
+	info, err := os.Stat(s.path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to stat audit log: %w", err)
+	}
+	if info == nil && s.info == nil ||
+		info != nil && s.info != nil && os.SameFile(info, s.info) && info.Size() == s.info.Size() {
+		return nil
+	}
+
+	seq, prev, err := chainTail(s.path)
+	if err != nil {
+		return fmt.Errorf("failed to resume audit chain: %w", err)
+	}
+	if seq > s.seq {
+		if s.index != nil && info != nil {
+			recs, err := readRecords(s.path, s.seq)
+			if err != nil {
+				slog.Error("audit index: failed to read appended records", "error", err)
+			}
+			for _, rec := range recs {
+				s.index.Add(rec)
+			}
+		}
+		s.sinceCheckpoint += int(seq - s.seq)
+	}
+	s.seq, s.prev, s.info = seq, prev, info
+
+	// The writer's file may have been replaced or its offset be stale:
+	// lumberjack reopens the log in append mode on the next write
+	return s.w.Close()
+}
+
+// StartCheckpoints writes a signed checkpoint now and every interval
+func (s *Sink) StartCheckpoints(interval time.Duration) error {
+	if err := s.Checkpoint(); err != nil {
+		return err
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if err := s.Checkpoint(); err != nil {
+					slog.Error("audit checkpoint failed", "error", err)
+				}
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// Close writes a final checkpoint and closes the log
+func (s *Sink) Close() error {
+	select {
+	case <-s.stop:
+	default:
+		close(s.stop)
+	}
+	s.wg.Wait()
+
+	err := s.Checkpoint()
+	if cerr := s.w.Close(); err == nil {
+		err = cerr
+	}
+	if s.lock != nil {
+		s.lock.Close()
+	}
+	return err
+}
+
+// openLock opens <path>.lock, creating the log directory if needed
+func openLock(path string) (*os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create audit log directory: %w", err)
+	}
+	lock, err := os.OpenFile(path+".lock", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log lock: %w", err)
+	}
+	return lock, nil
+}
+
+// lockFile takes an exclusive flock on f; the returned function releases it
+func lockFile(f *os.File) (func(), error) {
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		return nil, fmt.Errorf("failed to lock audit log: %w", err)
+	}
+	return func() { syscall.Flock(int(f.Fd()), syscall.LOCK_UN) }, nil
+}
+
+// readRecords returns the records of one (uncompressed) log file after seq
+func readRecords(path string, after uint64) ([]Record, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var recs []Record
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 2*maxRecordSize)
+	for scanner.Scan() {
+		var rec Record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil || rec.Seq <= after {
+			continue
+		}
+		recs = append(recs, rec)
+	}
+	if err := scanner.Err(); err != nil {
+		return recs, fmt.Errorf("read %s: %w", path, err)
+	}
+	return recs, nil
+}
+
+// CheckpointState is the last checkpoint written to the log. It is kept
+// outside the log, so Verify detects records or files removed after it.
+type CheckpointState struct {
+	Seq  uint64    `json:"seq"`
+	Hash string    `json:"hash"` // Chain hash of the checkpoint line
+	Time time.Time `json:"time"`
+}
+
+// CheckpointStatePath returns the file recording the last checkpoint of the
+// log at path
+func CheckpointStatePath(path string) string {
+	return path + ".checkpoint"
+}
+
+// ReadCheckpointState returns the last checkpoint recorded for the log at
+// path (nil if none was recorded)
+func ReadCheckpointState(path string) (*CheckpointState, error) {
+	data, err := os.ReadFile(CheckpointStatePath(path))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read audit checkpoint state: %w", err)
+	}
+	var state CheckpointState
+	if err := json.Unmarshal(data, &state); err != nil || state.Seq == 0 {
+		return nil, fmt.Errorf("audit checkpoint state %s is corrupt", CheckpointStatePath(path))
+	}
+	return &state, nil
+}
+
+func writeCheckpointState(path string, state *CheckpointState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("failed to marshal audit checkpoint state: %w", err)
+	}
+	if err := config.WriteFileAtomic(CheckpointStatePath(path), append(data, '\n'), 0600); err != nil {
+		return fmt.Errorf("failed to record audit checkpoint: %w", err)
+	}
+	return nil
+}
+
+// checkpointData is the byte string covered by a checkpoint MAC: position,
+// time and the hash of the record before it
+func checkpointData(rec *Record) []byte {
+	return []byte(checkpointMACDomain +
+		strconv.FormatUint(rec.Seq, 10) + "\n" +
+		rec.Time.UTC().Format(time.RFC3339Nano) + "\n" +
+		rec.Prev)
+}
+
+// lineHash returns the chain hash of a log line (without the newline)
+func lineHash(line []byte) string {
+	sum := sha256.Sum256(line)
+	return hex.EncodeToString(sum[:])
+}
+
+// chainTail returns the sequence number and hash of the last record in the
+// newest non-empty file of the log (0, "" for a new log)
+func chainTail(path string) (uint64, string, error) {
+	files, err := LogFiles(path)
+	if err != nil {
+		return 0, "", err
+	}
+	for i := len(files) - 1; i >= 0; i-- {
+		last, err := lastLine(files[i])
+		if err != nil {
+			return 0, "", err
+		}
+		if last == nil {
+			continue
+		}
+		var rec Record
+		if err := json.Unmarshal(last, &rec); err != nil || rec.Seq == 0 {
+			return 0, "", fmt.Errorf("last record of %s is corrupt (run 'hsm-admin audit verify')", files[i])
+		}
+		return rec.Seq, lineHash(last), nil
+	}
+	return 0, "", nil
+}
+
+// lastLine returns the last complete line of a log file (nil if empty)
+func lastLine(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if isCompressed(path) {
+		// Compressed rotated files are read through
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", path, err)
+		}
+		defer zr.Close()
+		r = zr
+	} else if info, err := f.Stat(); err == nil && info.Size() > maxRecordSize {
+		if _, err := f.Seek(info.Size()-maxRecordSize, io.SeekStart); err != nil {
+			return nil, fmt.Errorf("read %s: %w", path, err)
+		}
+	}
+
+	var tail []byte
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := r.Read(buf)
+		tail = append(tail, buf[:n]...)
+		if len(tail) > 2*maxRecordSize {
+			tail = tail[len(tail)-maxRecordSize:]
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", path, err)
+		}
+	}
+
+	tail = bytes.TrimRight(tail, "\n")
+	if len(tail) == 0 {
+		return nil, nil
+	}
+	if i := bytes.LastIndexByte(tail, '\n'); i >= 0 {
+		tail = tail[i+1:]
+	}
+	return tail, nil
+}
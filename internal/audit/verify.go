@@ -0,0 +1,202 @@
+package audit
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/hmac"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Problem is a chain violation found by Verify
+type Problem struct {
+	File    string `json:"file"`
+	Line    int    `json:"line"`
+	Seq     uint64 `json:"seq,omitempty"`
+	Message string `json:"message"`
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("%s:%d: %s", p.File, p.Line, p.Message)
+}
+
+// VerifyResult summarizes the verification of an audit log and its rotated files
+type VerifyResult struct {
+	Files             []string  `json:"files"`
+	Records           int       `json:"records"`
+	FirstSeq          uint64    `json:"first_seq"`
+	LastSeq           uint64    `json:"last_seq"`
+	Checkpoints       int       `json:"checkpoints"`
+	LastCheckpointSeq uint64    `json:"last_checkpoint_seq"`
+	Unsigned          int       `json:"unsigned"`            // Records after the last valid checkpoint
+	StateSeq          uint64    `json:"state_seq,omitempty"` // Last checkpoint recorded in <file>.checkpoint
+	MACChecked        bool      `json:"mac_checked"`
+	Problems          []Problem `json:"problems,omitempty"`
+}
+
+// Valid reports whether no problems were found
+func (r *VerifyResult) Valid() bool {
+	return len(r.Problems) == 0
+}
+
+// Verify walks the log at path and its rotated files in order and checks
+// that sequence numbers are contiguous, every record carries the hash of
+// its predecessor and (with mac) every checkpoint signature is valid.
+// Detects removed records or files, reordering and modified records; the
+// checkpoint recorded in <path>.checkpoint detects a log cut off after it.
+func Verify(path string, mac MAC) (*VerifyResult, error) {
+	files, err := LogFiles(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no audit log found at %s", path)
+	}
+	state, err := ReadCheckpointState(path)
+	if err != nil {
+		return nil, err
+	}
+
+	statePath := CheckpointStatePath(path)
+	v := &verifier{result: &VerifyResult{Files: files, MACChecked: mac != nil}, mac: mac, state: state, statePath: statePath}
+	for _, file := range files {
+		if err := v.file(file); err != nil {
+			return nil, err
+		}
+	}
+
+	res := v.result
+	switch {
+	case state == nil:
+		if res.Checkpoints > 0 {
+			res.Problems = append(res.Problems, Problem{File: statePath,
+				Message: "checkpoint state missing: records removed after the last checkpoint cannot be detected"})
+		}
+	case res.LastSeq < state.Seq:
+		res.StateSeq = state.Seq
+		res.Problems = append(res.Problems, Problem{File: files[len(files)-1], Seq: state.Seq,
+			Message: fmt.Sprintf("log ends at seq %d before the last checkpoint seq %d (%s): records or files removed", res.LastSeq, state.Seq, statePath)})
+	default:
+		res.StateSeq = state.Seq
+	}
+	return res, nil
+}
+
+type verifier struct {
+	result    *VerifyResult
+	mac       MAC
+	state     *CheckpointState // Last checkpoint recorded outside the log (nil = none)
+	statePath string
+	started   bool
+	seq       uint64
+	prev      string
+}
+
+func (v *verifier) file(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if isCompressed(path) {
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			return fmt.Errorf("read %s: %w", path, err)
+		}
+		defer zr.Close()
+		r = zr
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 2*maxRecordSize)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		v.record(path, lineNo, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("read %s: %w", path, err)
+	}
+	return nil
+}
+
+func (v *verifier) record(path string, lineNo int, line []byte) {
+	res := v.result
+	problem := func(seq uint64, format string, args ...any) {
+		res.Problems = append(res.Problems, Problem{File: path, Line: lineNo, Seq: seq, Message: fmt.Sprintf(format, args...)})
+	}
+
+	var rec Record
+	if err := json.Unmarshal(line, &rec); err != nil || rec.Seq == 0 {
+		problem(0, "unparsable record")
+		// Continue the chain from this line so one bad line is reported once
+		v.prev = lineHash(line)
+		v.seq++
+		res.Unsigned++
+		return
+	}
+
+	switch {
+	case !v.started:
+		// Older files may have been removed by retention (max_backups, max_age_days);
+		// the first record present starts the verified range
+		res.FirstSeq = rec.Seq
+		if rec.Seq == 1 && rec.Prev != "" {
+			problem(rec.Seq, "first record of the chain references a predecessor")
+		}
+	case rec.Seq == 1 && rec.Prev == "":
+		problem(rec.Seq, "chain restarted after seq %d (audit log removed or replaced)", v.seq)
+	case rec.Seq != v.seq+1:
+		if rec.Seq > v.seq+1 {
+			problem(rec.Seq, "gap: seq %d follows %d (%d records missing)", rec.Seq, v.seq, rec.Seq-v.seq-1)
+		} else {
+			problem(rec.Seq, "out of order: seq %d follows %d", rec.Seq, v.seq)
+		}
+	case rec.Prev != v.prev:
+		problem(rec.Seq, "hash mismatch: previous record was modified, removed or reordered")
+	}
+
+	v.started = true
+	v.seq = rec.Seq
+	v.prev = lineHash(line)
+	res.Records++
+	res.LastSeq = rec.Seq
+	res.Unsigned++
+
+	if v.state != nil && rec.Seq == v.state.Seq && v.prev != v.state.Hash {
+		problem(rec.Seq, "record differs from the checkpoint recorded in %s (audit log replaced)", v.statePath)
+	}
+
+	if rec.Event != CheckpointEvent {
+		return
+	}
+	res.Checkpoints++
+	if v.mac == nil {
+		return
+	}
+	want, err := hex.DecodeString(rec.MAC)
+	if err != nil || rec.MAC == "" {
+		problem(rec.Seq, "checkpoint without a valid signature")
+		return
+	}
+	got, err := v.mac(checkpointData(&rec))
+	if err != nil {
+		problem(rec.Seq, "checkpoint signature check failed: %v", err)
+		return
+	}
+	if !hmac.Equal(got, want) {
+		problem(rec.Seq, "checkpoint signature mismatch: records up to seq %d were rewritten", rec.Seq)
+		return
+	}
+	res.LastCheckpointSeq = rec.Seq
+	res.Unsigned = 0
+}
@@ -0,0 +1,107 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// WriteFileAtomic replaces path with data so that readers see either the old
+// or the new content, never a partial file, and the change survives a crash:
+// temp file in the same directory -> fsync -> rename -> fsync directory
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("create temp file: %w", err)
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath) // no-op after successful rename
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write temp file: %w", err)
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return fmt.Errorf("chmod temp file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("sync temp file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("close temp file: %w", err)
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		// A file bind-mounted on its own (docker -v ./metadata.yaml:/app/metadata.yaml)
+		// cannot be replaced; mount the directory instead to keep writes atomic
+		if errors.Is(err, syscall.EBUSY) {
+			return writeFileInPlace(path, data)
+		}
+		return fmt.Errorf("rename temp file: %w", err)
+	}
+
+	return syncDir(dir)
+}
+
+// writeFileInPlace rewrites a file that cannot be renamed over (fallback only)
+func writeFileInPlace(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		return fmt.Errorf("open file: %w", err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("write file: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("sync file: %w", err)
+	}
+	return f.Close()
+}
+
+// syncDir fsyncs a directory so that a rename in it is durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("open directory: %w", err)
+	}
+	defer d.Close()
+
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("sync directory: %w", err)
+	}
+	return nil
+}
+
+// BackupMetadata copies the current metadata file into backupDir as
+// <name>.backup-<timestamp> and returns the backup path
+func BackupMetadata(metadataPath, backupDir string) (string, error) {
+	data, err := os.ReadFile(metadataPath)
+	if err != nil {
+		return "", fmt.Errorf("read metadata file: %w", err)
+	}
+	return writeMetadataBackup(filepath.Base(metadataPath), data, backupDir)
+}
+
+// writeMetadataBackup writes data into backupDir as <name>.backup-<timestamp>
+func writeMetadataBackup(name string, data []byte, backupDir string) (string, error) {
+	if err := os.MkdirAll(backupDir, 0750); err != nil {
+		return "", fmt.Errorf("create backup directory: %w", err)
+	}
+
+	backupPath := filepath.Join(backupDir,
+		fmt.Sprintf("%s.backup-%s", name, time.Now().UTC().Format("20060102-150405.000")))
+	if err := WriteFileAtomic(backupPath, data, 0640); err != nil {
+		return "", fmt.Errorf("write metadata backup: %w", err)
+	}
+
+	return backupPath, nil
+}
@@ -0,0 +1,100 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteFileAtomic_ReplacesContent(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "metadata.yaml")
+
+	if err := os.WriteFile(path, []byte("old"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFileAtomic(path, []byte("new"), 0644); err != nil {
+		t.Fatalf("WriteFileAtomic() error = %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "new" {
+		t.Errorf("content = %q, want %q", data, "new")
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0644 {
+		t.Errorf("mode = %v, want 0644", info.Mode().Perm())
+	}
+
+	// No temp files left behind
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("directory has %d entries, want 1", len(entries))
+	}
+}
+
+func TestSaveMetadata_RoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metadata.yaml")
+	meta := &Metadata{Rotation: map[string]KeyMetadata{
+		"exchange": {Current: "kek-exchange-v1", Versions: []KeyVersion{{Label: "kek-exchange-v1", Version: 1}}},
+	}}
+
+	if err := SaveMetadata(path, meta); err != nil {
+		t.Fatalf("SaveMetadata() error = %v", err)
+	}
+	loaded, err := LoadMetadata(path)
+	if err != nil {
+		t.Fatalf("LoadMetadata() error = %v", err)
+	}
+	if loaded.Rotation["exchange"].Current != "kek-exchange-v1" {
+		t.Errorf("current = %q, want kek-exchange-v1", loaded.Rotation["exchange"].Current)
+	}
+}
+
+func TestBackupMetadata(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "metadata.yaml")
+	if err := os.WriteFile(path, []byte("rotation: {}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	backupDir := filepath.Join(dir, "backups")
+	backupPath, err := BackupMetadata(path, backupDir)
+	if err != nil {
+		t.Fatalf("BackupMetadata() error = %v", err)
+	}
+
+	if filepath.Dir(backupPath) != backupDir {
+		t.Errorf("backup dir = %s, want %s", filepath.Dir(backupPath), backupDir)
+	}
+	if !strings.HasPrefix(filepath.Base(backupPath), "metadata.yaml.backup-") {
+		t.Errorf("backup name = %s", filepath.Base(backupPath))
+	}
+	data, err := os.ReadFile(backupPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "rotation: {}\n" {
+		t.Errorf("backup content = %q", data)
+	}
+}
+
+func TestMetadataBackupDirPath(t *testing.T) {
+	cfg := HSMConfig{MetadataFile: "/app/metadata.yaml"}
+	if got := cfg.MetadataBackupDirPath(); got != "/app/backups" {
+		t.Errorf("default = %s, want /app/backups", got)
+	}
+
+	cfg.MetadataBackupDir = "/var/backups/hsm"
+	if got := cfg.MetadataBackupDirPath(); got != "/var/backups/hsm" {
+		t.Errorf("configured = %s, want /var/backups/hsm", got)
+	}
+}
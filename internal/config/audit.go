@@ -0,0 +1,82 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+)
+
+// DefaultAuditKeyLabel is the HSM label of the audit checkpoint HMAC key
+const DefaultAuditKeyLabel = "audit-hmac"
+
+// AuditConfig defines the tamper-evident audit log: every record carries the
+// hash of the previous one, checkpoints are signed with an HSM key
+type AuditConfig struct {
+	Enabled            bool     `yaml:"enabled"`
+	File               string   `yaml:"file"`                // Audit log (default: /var/log/hsm-service/audit.log)
+	MaxSizeMB          int      `yaml:"max_size_mb"`         // Rotate after N MB (default: 100)
+	MaxBackups         int      `yaml:"max_backups"`         // Rotated files to keep (default: 0 = all)
+	MaxAgeDays         int      `yaml:"max_age_days"`        // Delete rotated files older than N days (default: 0 = never)
+	Compress           bool     `yaml:"compress"`            // gzip rotated files
+	CheckpointInterval string   `yaml:"checkpoint_interval"` // Signed checkpoint interval, e.g. "5m" (default: 5m)
+	KeyLabel           string   `yaml:"key_label"`           // Checkpoint HMAC key label in HSM (default: audit-hmac)
+	IndexFile          string   `yaml:"index_file"`          // Query index database (default: audit-index.db next to file)
+	AdminOUs           []string `yaml:"admin_ous"`           // Replaced by server.admin_ous (rejected when set)
+}
+
+// GetFile returns the audit log path
+func (c *AuditConfig) GetFile() string {
+	if c.File == "" {
+		return "/var/log/hsm-service/audit.log"
+	}
+	return c.File
+}
+
+// GetMaxSizeMB returns the rotation size
+func (c *AuditConfig) GetMaxSizeMB() int {
+	if c.MaxSizeMB <= 0 {
+		return 100
+	}
+	return c.MaxSizeMB
+}
+
+// GetCheckpointInterval returns the interval between signed checkpoints
+func (c *AuditConfig) GetCheckpointInterval() time.Duration {
+	return parseDurationDefault(c.CheckpointInterval, 5*time.Minute)
+}
+
+// GetKeyLabel returns the checkpoint HMAC key label
+func (c *AuditConfig) GetKeyLabel() string {
+	if c.KeyLabel == "" {
+		return DefaultAuditKeyLabel
+	}
+	return c.KeyLabel
+}
+
+// GetIndexFile returns the path of the bbolt query index
+func (c *AuditConfig) GetIndexFile() string {
+	if c.IndexFile == "" {
+		return filepath.Join(filepath.Dir(c.GetFile()), "audit-index.db")
+	}
+	return c.IndexFile
+}
+
+// Validate checks the checkpoint interval and retention settings
+func (c *AuditConfig) Validate() error {
+	if len(c.AdminOUs) > 0 {
+		return fmt.Errorf("admin_ous was replaced by server.admin_ous")
+	}
+	if c.CheckpointInterval != "" {
+		d, err := time.ParseDuration(c.CheckpointInterval)
+		if err != nil {
+			return fmt.Errorf("checkpoint_interval: %w", err)
+		}
+		if d < time.Second {
+			return fmt.Errorf("checkpoint_interval must be at least 1s, got %s", c.CheckpointInterval)
+		}
+	}
+	if c.MaxSizeMB < 0 || c.MaxBackups < 0 || c.MaxAgeDays < 0 {
+		return fmt.Errorf("max_size_mb, max_backups and max_age_days must not be negative")
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAuditConfig_Validate(t *testing.T) {
+	c := &AuditConfig{}
+	if err := c.Validate(); err != nil {
+		t.Errorf("Validate() with defaults: %v", err)
+	}
+	if got := c.GetCheckpointInterval(); got != 5*time.Minute {
+		t.Errorf("GetCheckpointInterval() = %s, want 5m", got)
+	}
+
+	for _, bad := range []AuditConfig{
+		{CheckpointInterval: "often"},
+		{CheckpointInterval: "100ms"},
+		{MaxBackups: -1},
+	} {
+		if err := bad.Validate(); err == nil {
+			t.Errorf("Validate(%+v) should fail", bad)
+		}
+	}
+}
+
+func TestAuditConfig_GetIndexFile(t *testing.T) {
+	c := &AuditConfig{File: "/data/audit/audit.log"}
+	if got := c.GetIndexFile(); got != "/data/audit/audit-index.db" {
+		t.Errorf("GetIndexFile() = %s, want /data/audit/audit-index.db", got)
+	}
+	c.IndexFile = "/var/lib/hsm-service/audit.db"
+	if got := c.GetIndexFile(); got != c.IndexFile {
+		t.Errorf("GetIndexFile() = %s, want %s", got, c.IndexFile)
+	}
+}
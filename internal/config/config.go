@@ -3,6 +3,7 @@ package config
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 
 	"gopkg.in/yaml.v3"
 )
@@ -46,20 +47,47 @@ func LoadMetadata(path string) (*Metadata, error) {
 	return &meta, nil
 }
 
-// SaveMetadata saves key rotation metadata to metadata.yaml
+// SaveMetadata atomically replaces metadata.yaml (see WriteFileAtomic)
+// Callers modifying metadata must hold LockMetadata around load+save
 func SaveMetadata(path string, meta *Metadata) error {
 	data, err := yaml.Marshal(meta)
 	if err != nil {
 		return fmt.Errorf("marshal metadata to YAML: %w", err)
 	}
 
-	if err := os.WriteFile(path, data, 0644); err != nil {
+	if err := WriteFileAtomic(path, data, 0644); err != nil {
 		return fmt.Errorf("write metadata file: %w", err)
 	}
 
 	return nil
 }
 
+// MetadataBackupDirPath returns the directory for metadata backups
+// Defaults to backups/ in the same directory as metadata_file
+func (c *HSMConfig) MetadataBackupDirPath() string {
+	if c.MetadataBackupDir != "" {
+		return c.MetadataBackupDir
+	}
+	metadataPath := c.MetadataFile
+	if metadataPath == "" {
+		metadataPath = "metadata.yaml"
+	}
+	return filepath.Join(filepath.Dir(metadataPath), "backups")
+}
+
+// UsageFilePath returns the path of the key usage statistics file
+// Defaults to key-usage.json in the same directory as metadata_file
+func (c *HSMConfig) UsageFilePath() string {
+	if c.UsageFile != "" {
+		return c.UsageFile
+	}
+	metadataPath := c.MetadataFile
+	if metadataPath == "" {
+		metadataPath = "metadata.yaml"
+	}
+	return filepath.Join(filepath.Dir(metadataPath), "key-usage.json")
+}
+
 // applyEnvOverrides applies environment variable overrides to configuration
 func applyEnvOverrides(cfg *Config) {
 	// Server overrides
@@ -102,14 +130,11 @@ func validateConfig(cfg *Config) error {
 	if cfg.Server.Port == "" {
 		return fmt.Errorf("server.port is required")
 	}
-	if cfg.Server.TLS.CertPath == "" {
-		return fmt.Errorf("server.tls.cert_path is required")
-	}
-	if cfg.Server.TLS.KeyPath == "" {
-		return fmt.Errorf("server.tls.key_path is required")
+	if err := validateTLS(&cfg.Server.TLS); err != nil {
+		return err
 	}
-	if cfg.Server.TLS.CAPath == "" {
-		return fmt.Errorf("server.tls.ca_path is required")
+	if err := validateAdminOUs(cfg.Server.AdminOUs); err != nil {
+		return err
 	}
 
 	// Validate HSM config
@@ -134,20 +159,48 @@ func validateConfig(cfg *Config) error {
 		}
 		// Validate mode (default: private if not set)
 		if key.Mode == "" {
-			cfg.HSM.Keys[name] = KeyConfig{
-				Type: key.Type,
-				Mode: "private", // default
-			}
+			key.Mode = "private" // default
+			cfg.HSM.Keys[name] = key
 		} else if key.Mode != "shared" && key.Mode != "private" {
 			return fmt.Errorf("hsm.keys.%s.mode must be 'shared' or 'private', got '%s'", name, key.Mode)
 		}
 	}
 
+	// Validate metadata store backend
+	if err := cfg.HSM.MetadataStore.Validate(); err != nil {
+		return fmt.Errorf("hsm.metadata_store: %w", err)
+	}
+
+	// Validate key label templates
+	if err := validateLabelTemplates(&cfg.HSM); err != nil {
+		return err
+	}
+
+	// Validate overlap/retention policy
+	if err := validateRetention(&cfg.HSM); err != nil {
+		return err
+	}
+
+	// Validate auto-rotation scheduler config
+	if err := cfg.HSM.AutoRotation.Validate(); err != nil {
+		return fmt.Errorf("hsm.auto_rotation: %w", err)
+	}
+
 	// Validate ACL config
 	if len(cfg.ACL.Mappings) == 0 {
 		return fmt.Errorf("acl.mappings cannot be empty")
 	}
 
+	// Validate mini-CA config
+	if err := cfg.PKI.Validate(); err != nil {
+		return fmt.Errorf("pki: %w", err)
+	}
+
+	// Validate audit log config
+	if err := cfg.Audit.Validate(); err != nil {
+		return fmt.Errorf("audit: %w", err)
+	}
+
 	// Validate logging config
 	if cfg.Logging.Level == "" {
 		cfg.Logging.Level = "info" // default
@@ -155,6 +208,12 @@ func validateConfig(cfg *Config) error {
 	if cfg.Logging.Format == "" {
 		cfg.Logging.Format = "json" // default
 	}
+	if err := cfg.Logging.Validate(); err != nil {
+		return fmt.Errorf("logging: %w", err)
+	}
+	if err := cfg.Tracing.Validate(); err != nil {
+		return fmt.Errorf("tracing: %w", err)
+	}
 
 	return nil
 }
@@ -0,0 +1,106 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Label template placeholders
+const (
+	LabelPlaceholderContext = "{context}"
+	LabelPlaceholderEnv     = "{env}"
+	LabelPlaceholderVersion = "{version}"
+)
+
+var (
+	labelPlaceholderPattern = regexp.MustCompile(`\{[^{}]*\}`)
+	labelCharsPattern       = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+)
+
+// ValidateLabelTemplate checks a key label template such as
+// "kek-{context}-{env}-v{version}": known placeholders only, exactly one
+// {version}, {env} only with hsm.environment set, and labels limited to
+// letters, digits, '.', '_' and '-'
+func ValidateLabelTemplate(tmpl, context, env string) error {
+	for _, p := range labelPlaceholderPattern.FindAllString(tmpl, -1) {
+		switch p {
+		case LabelPlaceholderContext, LabelPlaceholderVersion:
+		case LabelPlaceholderEnv:
+			if env == "" {
+				return fmt.Errorf("template %q uses {env} but hsm.environment is not set", tmpl)
+			}
+		default:
+			return fmt.Errorf("template %q: unknown placeholder %s", tmpl, p)
+		}
+	}
+	if n := strings.Count(tmpl, LabelPlaceholderVersion); n != 1 {
+		return fmt.Errorf("template %q must contain {version} exactly once", tmpl)
+	}
+
+	label := RenderLabel(expandLabelTemplate(tmpl, context, env), 1)
+	if !labelCharsPattern.MatchString(label) {
+		return fmt.Errorf("template %q renders invalid label %q (allowed: letters, digits, '.', '_', '-')", tmpl, label)
+	}
+	return nil
+}
+
+// ContextLabelTemplate returns the label template of a context with {context}
+// and {env} expanded, leaving {version} ("" if label_template is not set)
+func (c *HSMConfig) ContextLabelTemplate(context string) string {
+	tmpl := c.Keys[context].LabelTemplate
+	if tmpl == "" {
+		return ""
+	}
+	return expandLabelTemplate(tmpl, context, c.Environment)
+}
+
+// RenderLabel replaces {version} in an expanded label template
+func RenderLabel(tmpl string, version int) string {
+	return strings.Replace(tmpl, LabelPlaceholderVersion, strconv.Itoa(version), 1)
+}
+
+// MatchLabel returns the version encoded in label if it was rendered from
+// the expanded template
+func MatchLabel(tmpl, label string) (int, bool) {
+	prefix, suffix, found := strings.Cut(tmpl, LabelPlaceholderVersion)
+	if !found || len(label) <= len(prefix)+len(suffix) ||
+		!strings.HasPrefix(label, prefix) || !strings.HasSuffix(label, suffix) {
+		return 0, false
+	}
+	digits := label[len(prefix) : len(label)-len(suffix)]
+	if strings.HasPrefix(digits, "0") || strings.ContainsAny(digits, "+-") {
+		return 0, false
+	}
+	version, err := strconv.Atoi(digits)
+	if err != nil || version < 1 {
+		return 0, false
+	}
+	return version, true
+}
+
+// expandLabelTemplate substitutes {context} and {env}
+func expandLabelTemplate(tmpl, context, env string) string {
+	return strings.NewReplacer(LabelPlaceholderContext, context, LabelPlaceholderEnv, env).Replace(tmpl)
+}
+
+// validateLabelTemplates validates all templates and rejects templates of
+// different contexts that render the same labels
+func validateLabelTemplates(hsm *HSMConfig) error {
+	owners := make(map[string]string)
+	for name, key := range hsm.Keys {
+		if key.LabelTemplate == "" {
+			continue
+		}
+		if err := ValidateLabelTemplate(key.LabelTemplate, name, hsm.Environment); err != nil {
+			return fmt.Errorf("hsm.keys.%s.label_template: %w", name, err)
+		}
+		label := RenderLabel(hsm.ContextLabelTemplate(name), 1)
+		if other, ok := owners[label]; ok {
+			return fmt.Errorf("hsm.keys.%s.label_template: renders the same labels as hsm.keys.%s (%s)", name, other, label)
+		}
+		owners[label] = name
+	}
+	return nil
+}
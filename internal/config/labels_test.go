@@ -0,0 +1,82 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateLabelTemplate(t *testing.T) {
+	tests := []struct {
+		name    string
+		tmpl    string
+		env     string
+		wantErr string
+	}{
+		{"context and version", "kek-{context}-v{version}", "", ""},
+		{"with env", "kek-{context}-{env}-v{version}", "prod", ""},
+		{"env not set", "kek-{context}-{env}-v{version}", "", "hsm.environment"},
+		{"no version", "kek-{context}", "", "exactly once"},
+		{"two versions", "kek-v{version}-{version}", "", "exactly once"},
+		{"unknown placeholder", "kek-{ctx}-v{version}", "", "unknown placeholder"},
+		{"invalid characters", "kek {context} v{version}", "", "invalid label"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateLabelTemplate(tt.tmpl, "exchange", tt.env)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestContextLabelTemplate(t *testing.T) {
+	hsm := &HSMConfig{
+		Environment: "dev",
+		Keys: map[string]KeyConfig{
+			"vault":    {LabelTemplate: "kek-{env}-{context}-v{version}"},
+			"exchange": {},
+		},
+	}
+
+	tmpl := hsm.ContextLabelTemplate("vault")
+	if tmpl != "kek-dev-vault-v{version}" {
+		t.Fatalf("template = %q", tmpl)
+	}
+	if label := RenderLabel(tmpl, 3); label != "kek-dev-vault-v3" {
+		t.Errorf("label = %q, want kek-dev-vault-v3", label)
+	}
+	if hsm.ContextLabelTemplate("exchange") != "" {
+		t.Error("template for context without label_template")
+	}
+}
+
+func TestMatchLabel(t *testing.T) {
+	tmpl := "kek-dev-vault-v{version}"
+
+	if v, ok := MatchLabel(tmpl, "kek-dev-vault-v12"); !ok || v != 12 {
+		t.Errorf("MatchLabel = %d, %v, want 12, true", v, ok)
+	}
+	for _, label := range []string{"kek-dev-vault-v", "kek-dev-vault-v0", "kek-dev-vault-v01", "kek-dev-vault-vx", "kek-dev-other-v1", "kek-dev-vault-v+1"} {
+		if _, ok := MatchLabel(tmpl, label); ok {
+			t.Errorf("MatchLabel(%q) matched", label)
+		}
+	}
+}
+
+func TestValidateLabelTemplates_Collision(t *testing.T) {
+	hsm := &HSMConfig{Keys: map[string]KeyConfig{
+		"a": {LabelTemplate: "kek-shared-v{version}"},
+		"b": {LabelTemplate: "kek-shared-v{version}"},
+	}}
+	if err := validateLabelTemplates(hsm); err == nil || !strings.Contains(err.Error(), "same labels") {
+		t.Errorf("error = %v, want collision", err)
+	}
+}
@@ -0,0 +1,120 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// KeyState is the lifecycle state of a key version
+type KeyState string
+
+const (
+	// KeyStateActive - current version, used for encryption and decryption
+	KeyStateActive KeyState = "active"
+	// KeyStateDecryptOnly - previous version, decryption only
+	KeyStateDecryptOnly KeyState = "decrypt_only"
+	// KeyStateDisabled - refuses all operations, can be re-enabled
+	KeyStateDisabled KeyState = "disabled"
+	// KeyStateScheduledForDestruction - disabled, destroyed after the waiting period
+	KeyStateScheduledForDestruction KeyState = "scheduled_for_destruction"
+	// KeyStateDestroyed - removed from the HSM, kept in metadata as a record
+	KeyStateDestroyed KeyState = "destroyed"
+)
+
+// keyStateTransitions lists allowed transitions (from -> to)
+var keyStateTransitions = map[KeyState][]KeyState{
+	KeyStateActive:                  {KeyStateDecryptOnly},
+	KeyStateDecryptOnly:             {KeyStateActive, KeyStateDisabled},
+	KeyStateDisabled:                {KeyStateDecryptOnly, KeyStateScheduledForDestruction},
+	KeyStateScheduledForDestruction: {KeyStateDisabled, KeyStateDestroyed},
+	KeyStateDestroyed:               {},
+}
+
+// Valid reports whether s is a known state
+func (s KeyState) Valid() bool {
+	_, ok := keyStateTransitions[s]
+	return ok
+}
+
+// CanDecrypt reports whether a version in this state may decrypt
+func (s KeyState) CanDecrypt() bool {
+	return s == KeyStateActive || s == KeyStateDecryptOnly
+}
+
+// CheckKeyStateTransition returns an error if from -> to is not allowed
+func CheckKeyStateTransition(from, to KeyState) error {
+	if !to.Valid() {
+		return fmt.Errorf("unknown key state: %s", to)
+	}
+	for _, allowed := range keyStateTransitions[from] {
+		if allowed == to {
+			return nil
+		}
+	}
+	return fmt.Errorf("transition %s -> %s is not allowed", from, to)
+}
+
+// EffectiveState returns the version state, treating metadata written before
+// lifecycle states existed as active (current) or decrypt_only (older versions)
+func (v *KeyVersion) EffectiveState(current string) KeyState {
+	if v.State != "" {
+		return v.State
+	}
+	if v.Label == current {
+		return KeyStateActive
+	}
+	return KeyStateDecryptOnly
+}
+
+// TransitionVersion moves a version to a new state, enforcing lifecycle rules:
+//   - the current version must stay active (rotate or roll back first)
+//   - only the current version can be active
+//   - destruction requires the waiting period to have passed
+func (m *KeyMetadata) TransitionVersion(label string, to KeyState, now time.Time, waitingPeriod time.Duration) error {
+	var v *KeyVersion
+	for i := range m.Versions {
+		if m.Versions[i].Label == label {
+			v = &m.Versions[i]
+			break
+		}
+	}
+	if v == nil {
+		return fmt.Errorf("version %s not found", label)
+	}
+
+	from := v.EffectiveState(m.Current)
+	if err := CheckKeyStateTransition(from, to); err != nil {
+		return fmt.Errorf("%s: %w", label, err)
+	}
+
+	if label == m.Current && to != KeyStateActive {
+		return fmt.Errorf("%s is the current version and must stay active", label)
+	}
+	if to == KeyStateActive && label != m.Current {
+		return fmt.Errorf("%s is not the current version, only the current version can be active", label)
+	}
+
+	switch to {
+	case KeyStateScheduledForDestruction:
+		destroyAfter := now.Add(waitingPeriod)
+		v.DestroyAfter = &destroyAfter
+	case KeyStateDestroyed:
+		if v.DestroyAfter == nil || now.Before(*v.DestroyAfter) {
+			return fmt.Errorf("%s: waiting period has not passed (destroy after %s)", label, formatTimePtr(v.DestroyAfter))
+		}
+	default:
+		v.DestroyAfter = nil
+	}
+
+	v.State = to
+	v.StateChangedAt = &now
+	return nil
+}
+
+// formatTimePtr formats an optional timestamp for messages
+func formatTimePtr(t *time.Time) string {
+	if t == nil {
+		return "unset"
+	}
+	return t.Format(time.RFC3339)
+}
@@ -0,0 +1,114 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func newLifecycleMetadata() KeyMetadata {
+	return KeyMetadata{
+		Current: "kek-test-v2",
+		Versions: []KeyVersion{
+			{Label: "kek-test-v1", Version: 1},
+			{Label: "kek-test-v2", Version: 2},
+		},
+	}
+}
+
+func TestEffectiveState_Legacy(t *testing.T) {
+	meta := newLifecycleMetadata()
+	if got := meta.Versions[0].EffectiveState(meta.Current); got != KeyStateDecryptOnly {
+		t.Errorf("old version state = %s, want %s", got, KeyStateDecryptOnly)
+	}
+	if got := meta.Versions[1].EffectiveState(meta.Current); got != KeyStateActive {
+		t.Errorf("current version state = %s, want %s", got, KeyStateActive)
+	}
+}
+
+func TestCheckKeyStateTransition(t *testing.T) {
+	tests := []struct {
+		from, to KeyState
+		wantErr  bool
+	}{
+		{KeyStateActive, KeyStateDecryptOnly, false},
+		{KeyStateDecryptOnly, KeyStateDisabled, false},
+		{KeyStateDisabled, KeyStateDecryptOnly, false},
+		{KeyStateDisabled, KeyStateScheduledForDestruction, false},
+		{KeyStateScheduledForDestruction, KeyStateDisabled, false},
+		{KeyStateScheduledForDestruction, KeyStateDestroyed, false},
+		{KeyStateActive, KeyStateDestroyed, true},
+		{KeyStateDecryptOnly, KeyStateDestroyed, true},
+		{KeyStateDestroyed, KeyStateDisabled, true},
+		{KeyStateDisabled, "bogus", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.from)+"->"+string(tt.to), func(t *testing.T) {
+			err := CheckKeyStateTransition(tt.from, tt.to)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CheckKeyStateTransition() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTransitionVersion_FullLifecycle(t *testing.T) {
+	meta := newLifecycleMetadata()
+	now := time.Now()
+	wait := 7 * 24 * time.Hour
+
+	steps := []KeyState{KeyStateDisabled, KeyStateScheduledForDestruction}
+	for _, to := range steps {
+		if err := meta.TransitionVersion("kek-test-v1", to, now, wait); err != nil {
+			t.Fatalf("transition to %s: %v", to, err)
+		}
+	}
+
+	v := meta.Versions[0]
+	if v.DestroyAfter == nil || !v.DestroyAfter.Equal(now.Add(wait)) {
+		t.Fatalf("DestroyAfter = %v, want %v", v.DestroyAfter, now.Add(wait))
+	}
+
+	// Destruction before the waiting period ends must fail
+	err := meta.TransitionVersion("kek-test-v1", KeyStateDestroyed, now.Add(time.Hour), wait)
+	if err == nil || !strings.Contains(err.Error(), "waiting period") {
+		t.Fatalf("early destroy error = %v, want waiting period error", err)
+	}
+
+	if err := meta.TransitionVersion("kek-test-v1", KeyStateDestroyed, now.Add(wait+time.Second), wait); err != nil {
+		t.Fatalf("destroy after waiting period: %v", err)
+	}
+	if meta.Versions[0].State != KeyStateDestroyed {
+		t.Errorf("state = %s, want %s", meta.Versions[0].State, KeyStateDestroyed)
+	}
+}
+
+func TestTransitionVersion_CancelDestruction(t *testing.T) {
+	meta := newLifecycleMetadata()
+	now := time.Now()
+
+	for _, to := range []KeyState{KeyStateDisabled, KeyStateScheduledForDestruction, KeyStateDisabled, KeyStateDecryptOnly} {
+		if err := meta.TransitionVersion("kek-test-v1", to, now, time.Hour); err != nil {
+			t.Fatalf("transition to %s: %v", to, err)
+		}
+	}
+	if meta.Versions[0].DestroyAfter != nil {
+		t.Error("DestroyAfter should be cleared after cancelling destruction")
+	}
+}
+
+func TestTransitionVersion_CurrentVersionRules(t *testing.T) {
+	meta := newLifecycleMetadata()
+	now := time.Now()
+
+	if err := meta.TransitionVersion("kek-test-v2", KeyStateDecryptOnly, now, 0); err == nil {
+		t.Error("current version must not leave active state")
+	}
+	if err := meta.TransitionVersion("kek-test-v1", KeyStateActive, now, 0); err == nil {
+		t.Error("non-current version must not become active")
+	}
+	if err := meta.TransitionVersion("kek-missing", KeyStateDisabled, now, 0); err == nil {
+		t.Error("unknown version should fail")
+	}
+}
@@ -0,0 +1,32 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// LockMetadata acquires an exclusive advisory lock (flock) on <metadataPath>.lock.
+// All metadata writers (hsm-admin commands and the in-service rotation
+// scheduler) take this lock, so only one of them modifies metadata at a time.
+// Blocks until the lock is available. The returned function releases it.
+func LockMetadata(metadataPath string) (func(), error) {
+	lockPath := metadataPath + ".lock"
+
+	lockFile, err := os.OpenFile(lockPath, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create lock file: %w", err)
+	}
+
+	if err := syscall.Flock(int(lockFile.Fd()), syscall.LOCK_EX); err != nil {
+		lockFile.Close()
+		return nil, fmt.Errorf("failed to acquire lock: %w", err)
+	}
+
+	// The lock file is intentionally left on disk: removing it while another
+	// process waits on the same inode would let two writers hold "the" lock
+	return func() {
+		syscall.Flock(int(lockFile.Fd()), syscall.LOCK_UN)
+		lockFile.Close()
+	}, nil
+}
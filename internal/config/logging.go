@@ -0,0 +1,273 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Log destinations
+const (
+	LogOutputStdout = "stdout"
+	LogOutputStderr = "stderr"
+	LogOutputFile   = "file" // Rotated by size (lumberjack)
+)
+
+// DefaultLogFile is the service log file
+const DefaultLogFile = "/var/log/hsm-service/hsm-service.log"
+
+// LogOutputConfig defines where a log stream is written and how its file is rotated
+type LogOutputConfig struct {
+	Outputs    []string `yaml:"outputs"`      // stdout, stderr, file (default: [stdout, file])
+	File       string   `yaml:"file"`         // Log file (service log default: /var/log/hsm-service/hsm-service.log)
+	MaxSizeMB  int      `yaml:"max_size_mb"`  // Rotate at this size (default: 100)
+	MaxBackups int      `yaml:"max_backups"`  // Rotated files kept (default: 10)
+	MaxAgeDays int      `yaml:"max_age_days"` // Days rotated files are kept (default: 30)
+	Compress   *bool    `yaml:"compress"`     // Gzip rotated files (default: true)
+}
+
+// GetOutputs returns the destinations of the stream
+func (c *LogOutputConfig) GetOutputs() []string {
+	if len(c.Outputs) == 0 {
+		return []string{LogOutputStdout, LogOutputFile}
+	}
+	return c.Outputs
+}
+
+// HasFile reports whether the stream is written to a file
+func (c *LogOutputConfig) HasFile() bool {
+	return slices.Contains(c.GetOutputs(), LogOutputFile)
+}
+
+// GetMaxSizeMB returns the rotation size
+func (c *LogOutputConfig) GetMaxSizeMB() int {
+	if c.MaxSizeMB <= 0 {
+		return 100
+	}
+	return c.MaxSizeMB
+}
+
+// GetMaxBackups returns the number of rotated files kept
+func (c *LogOutputConfig) GetMaxBackups() int {
+	if c.MaxBackups <= 0 {
+		return 10
+	}
+	return c.MaxBackups
+}
+
+// GetMaxAgeDays returns the retention of rotated files in days
+func (c *LogOutputConfig) GetMaxAgeDays() int {
+	if c.MaxAgeDays <= 0 {
+		return 30
+	}
+	return c.MaxAgeDays
+}
+
+// GetCompress reports whether rotated files are compressed
+func (c *LogOutputConfig) GetCompress() bool {
+	return c.Compress == nil || *c.Compress
+}
+
+// Validate checks the destinations and rotation settings
+func (c *LogOutputConfig) Validate() error {
+	for _, out := range c.Outputs {
+		switch out {
+		case LogOutputStdout, LogOutputStderr, LogOutputFile:
+		default:
+			return fmt.Errorf("outputs: unknown output %q (stdout, stderr or file)", out)
+		}
+	}
+	if c.MaxSizeMB < 0 || c.MaxBackups < 0 || c.MaxAgeDays < 0 {
+		return fmt.Errorf("max_size_mb, max_backups and max_age_days must not be negative")
+	}
+	return nil
+}
+
+// GetLevel returns the service log level
+func (c *LoggingConfig) GetLevel() string {
+	if c.Level == "" {
+		return "info"
+	}
+	return strings.ToLower(c.Level)
+}
+
+// GetFormat returns the log format
+func (c *LoggingConfig) GetFormat() string {
+	if c.Format == "" {
+		return "json"
+	}
+	return strings.ToLower(c.Format)
+}
+
+// GetFile returns the service log file
+func (c *LoggingConfig) GetFile() string {
+	if c.File == "" {
+		return DefaultLogFile
+	}
+	return c.File
+}
+
+// Audit output formats
+const (
+	AuditOutputSyslog = "syslog" // RFC 5424 with the event attributes as structured data
+	AuditOutputCEF    = "cef"    // ArcSight Common Event Format over syslog
+	AuditOutputLEEF   = "leef"   // QRadar Log Event Extended Format 2.0 over syslog
+)
+
+// Policies when an output buffer is full (collector down or slow)
+const (
+	AuditOutputDrop  = "drop"  // Discard the event (default: the API never waits for the SIEM)
+	AuditOutputBlock = "block" // Wait up to block_timeout for free space, then discard
+)
+
+// AuditOutputConfig defines one SIEM destination for audit events
+type AuditOutputConfig struct {
+	Name         string            `yaml:"name"`          // Label in logs and metrics (default: <format>-<address>)
+	Format       string            `yaml:"format"`        // syslog, cef or leef
+	Network      string            `yaml:"network"`       // udp, tcp or tls (default: udp)
+	Address      string            `yaml:"address"`       // Collector host:port
+	CAPath       string            `yaml:"ca_path"`       // tls: CA bundle of the collector (default: system roots)
+	CertPath     string            `yaml:"cert_path"`     // tls: client certificate (optional)
+	KeyPath      string            `yaml:"key_path"`      // tls: client key (optional)
+	ServerName   string            `yaml:"server_name"`   // tls: expected collector name (default: host of address)
+	Facility     *int              `yaml:"facility"`      // Syslog facility 0-23 (default: 13 = log audit)
+	AppName      string            `yaml:"app_name"`      // Syslog APP-NAME (default: hsm-service)
+	Fields       map[string]string `yaml:"fields"`        // Output key -> event attribute, merged over the format defaults ("" removes a key)
+	BufferSize   int               `yaml:"buffer_size"`   // Events queued while the collector is unreachable (default: 10000)
+	OnFull       string            `yaml:"on_full"`       // drop or block (default: drop)
+	BlockTimeout string            `yaml:"block_timeout"` // block: longest wait for buffer space (default: 1s)
+}
+
+// GetName returns the output label
+func (c *AuditOutputConfig) GetName() string {
+	if c.Name == "" {
+		return c.Format + "-" + c.Address
+	}
+	return c.Name
+}
+
+// GetNetwork returns the transport
+func (c *AuditOutputConfig) GetNetwork() string {
+	if c.Network == "" {
+		return "udp"
+	}
+	return c.Network
+}
+
+// GetFacility returns the syslog facility
+func (c *AuditOutputConfig) GetFacility() int {
+	if c.Facility == nil {
+		return 13 // log audit (RFC 5424)
+	}
+	return *c.Facility
+}
+
+// GetAppName returns the syslog APP-NAME
+func (c *AuditOutputConfig) GetAppName() string {
+	if c.AppName == "" {
+		return "hsm-service"
+	}
+	return c.AppName
+}
+
+// GetBufferSize returns the queue capacity in events
+func (c *AuditOutputConfig) GetBufferSize() int {
+	if c.BufferSize <= 0 {
+		return 10000
+	}
+	return c.BufferSize
+}
+
+// GetOnFull returns the full-buffer policy
+func (c *AuditOutputConfig) GetOnFull() string {
+	if c.OnFull == "" {
+		return AuditOutputDrop
+	}
+	return c.OnFull
+}
+
+// GetBlockTimeout returns the longest wait for buffer space with on_full: block
+func (c *AuditOutputConfig) GetBlockTimeout() time.Duration {
+	return parseDurationDefault(c.BlockTimeout, time.Second)
+}
+
+// Validate checks the format, transport and buffer policy
+func (c *AuditOutputConfig) Validate() error {
+	switch c.Format {
+	case AuditOutputSyslog, AuditOutputCEF, AuditOutputLEEF:
+	default:
+		return fmt.Errorf("format must be syslog, cef or leef, got %q", c.Format)
+	}
+	switch c.GetNetwork() {
+	case "udp", "tcp", "tls":
+	default:
+		return fmt.Errorf("network must be udp, tcp or tls, got %q", c.Network)
+	}
+	if _, _, err := net.SplitHostPort(c.Address); err != nil {
+		return fmt.Errorf("address must be host:port: %w", err)
+	}
+	if (c.CertPath == "") != (c.KeyPath == "") {
+		return fmt.Errorf("cert_path and key_path must be set together")
+	}
+	if f := c.GetFacility(); f < 0 || f > 23 {
+		return fmt.Errorf("facility must be 0-23, got %d", f)
+	}
+	if c.BufferSize < 0 {
+		return fmt.Errorf("buffer_size must not be negative")
+	}
+	switch c.GetOnFull() {
+	case AuditOutputDrop, AuditOutputBlock:
+	default:
+		return fmt.Errorf("on_full must be drop or block, got %q", c.OnFull)
+	}
+	if c.BlockTimeout != "" {
+		if d, err := time.ParseDuration(c.BlockTimeout); err != nil || d <= 0 {
+			return fmt.Errorf("block_timeout must be a positive duration, got %q", c.BlockTimeout)
+		}
+	}
+	return nil
+}
+
+// Validate checks the level, format, destinations and audit outputs
+// (output names must be unique: they label metrics)
+func (c *LoggingConfig) Validate() error {
+	switch c.GetLevel() {
+	case "debug", "info", "warn", "error":
+	default:
+		return fmt.Errorf("level must be debug, info, warn or error, got %q", c.Level)
+	}
+	switch c.GetFormat() {
+	case "json", "text":
+	default:
+		return fmt.Errorf("format must be json or text, got %q", c.Format)
+	}
+	if err := c.LogOutputConfig.Validate(); err != nil {
+		return err
+	}
+	if c.Audit != nil {
+		if err := c.Audit.Validate(); err != nil {
+			return fmt.Errorf("audit: %w", err)
+		}
+		if c.Audit.HasFile() && c.Audit.File == "" {
+			return fmt.Errorf("audit: file is required with the file output")
+		}
+		if c.Audit.HasFile() && c.Audit.File == c.GetFile() && c.HasFile() {
+			return fmt.Errorf("audit: file must differ from the service log file")
+		}
+	}
+
+	names := make(map[string]bool)
+	for i := range c.AuditOutputs {
+		out := &c.AuditOutputs[i]
+		if err := out.Validate(); err != nil {
+			return fmt.Errorf("audit_outputs[%d]: %w", i, err)
+		}
+		if names[out.GetName()] {
+			return fmt.Errorf("audit_outputs[%d]: duplicate name %q", i, out.GetName())
+		}
+		names[out.GetName()] = true
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package config
+
+import (
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestLoggingConfig_Validate(t *testing.T) {
+	ok := AuditOutputConfig{Format: AuditOutputCEF, Address: "siem.example.com:514"}
+	c := &LoggingConfig{AuditOutputs: []AuditOutputConfig{ok}}
+	if err := c.Validate(); err != nil {
+		t.Errorf("Validate() with defaults: %v", err)
+	}
+	if got := ok.GetName(); got != "cef-siem.example.com:514" {
+		t.Errorf("GetName() = %s, want cef-siem.example.com:514", got)
+	}
+	if ok.GetNetwork() != "udp" || ok.GetFacility() != 13 || ok.GetOnFull() != AuditOutputDrop {
+		t.Errorf("unexpected defaults: %s %d %s", ok.GetNetwork(), ok.GetFacility(), ok.GetOnFull())
+	}
+
+	badFacility := 24
+	for _, bad := range []AuditOutputConfig{
+		{Format: "json", Address: "siem:514"},
+		{Format: AuditOutputSyslog, Network: "http", Address: "siem:514"},
+		{Format: AuditOutputSyslog, Address: "siem"},
+		{Format: AuditOutputSyslog, Network: "tls", Address: "siem:6514", CertPath: "client.crt"},
+		{Format: AuditOutputSyslog, Address: "siem:514", Facility: &badFacility},
+		{Format: AuditOutputSyslog, Address: "siem:514", OnFull: "retry"},
+		{Format: AuditOutputSyslog, Address: "siem:514", OnFull: AuditOutputBlock, BlockTimeout: "soon"},
+	} {
+		if err := (&LoggingConfig{AuditOutputs: []AuditOutputConfig{bad}}).Validate(); err == nil {
+			t.Errorf("Validate(%+v) should fail", bad)
+		}
+	}
+
+	dup := &LoggingConfig{AuditOutputs: []AuditOutputConfig{ok, ok}}
+	if err := dup.Validate(); err == nil {
+		t.Error("Validate() should reject duplicate output names")
+	}
+}
+
+func TestLoggingConfig_Destinations(t *testing.T) {
+	c := &LoggingConfig{}
+	if err := c.Validate(); err != nil {
+		t.Errorf("Validate() with defaults: %v", err)
+	}
+	if c.GetLevel() != "info" || c.GetFormat() != "json" || c.GetFile() != DefaultLogFile || !c.HasFile() {
+		t.Errorf("unexpected defaults: %s %s %s %v", c.GetLevel(), c.GetFormat(), c.GetFile(), c.GetOutputs())
+	}
+	if c.GetMaxSizeMB() != 100 || c.GetMaxBackups() != 10 || c.GetMaxAgeDays() != 30 || !c.GetCompress() {
+		t.Errorf("unexpected rotation defaults")
+	}
+
+	for _, bad := range []LoggingConfig{
+		{Level: "trace"},
+		{Format: "xml"},
+		{LogOutputConfig: LogOutputConfig{Outputs: []string{"syslog"}}},
+		{LogOutputConfig: LogOutputConfig{MaxBackups: -1}},
+		{Audit: &LogOutputConfig{Outputs: []string{LogOutputFile}}},
+		{Audit: &LogOutputConfig{Outputs: []string{LogOutputFile}, File: DefaultLogFile}},
+	} {
+		if err := bad.Validate(); err == nil {
+			t.Errorf("Validate(%+v) should fail", bad)
+		}
+	}
+
+	ok := &LoggingConfig{Level: "DEBUG", Audit: &LogOutputConfig{Outputs: []string{LogOutputStderr}}}
+	if err := ok.Validate(); err != nil {
+		t.Errorf("Validate() with audit on stderr: %v", err)
+	}
+}
+
+func TestLoggingConfig_YAML(t *testing.T) {
+	var c LoggingConfig
+	err := yaml.Unmarshal([]byte(`
+level: warn
+outputs: [file]
+file: /tmp/hsm.log
+max_size_mb: 50
+compress: false
+audit:
+  outputs: [stdout]
+`), &c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.GetFile() != "/tmp/hsm.log" || c.GetMaxSizeMB() != 50 || c.GetCompress() || c.Audit == nil || c.Audit.HasFile() {
+		t.Errorf("unexpected config: %+v", c)
+	}
+}
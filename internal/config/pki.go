@@ -0,0 +1,122 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+)
+
+// PKIConfig defines the built-in mini-CA issuing client certificates with a
+// CA key held on the token ('hsm-admin pki', /pki/sign-csr)
+type PKIConfig struct {
+	Enabled     bool     `yaml:"enabled"`      // Serve /pki/sign-csr (hsm-admin pki works regardless)
+	CAKeyLabel  string   `yaml:"ca_key_label"` // CA key pair on the token (default: pki-ca)
+	CACertPath  string   `yaml:"ca_cert_path"` // Issuing CA certificate (default: ca/issuing-ca.crt next to revoked_file)
+	TrustDomain string   `yaml:"trust_domain"` // SPIFFE trust domain of issued certificates (default: hsm-service.local)
+	DefaultTTL  string   `yaml:"default_ttl"`  // Lifetime when the request names none, e.g. "24h" (default: 24h)
+	MaxTTL      string   `yaml:"max_ttl"`      // Upper bound for requested lifetimes (default: 168h)
+	AdminOUs    []string `yaml:"admin_ous"`    // Replaced by server.admin_ous (rejected when set)
+	IssuedFile  string   `yaml:"issued_file"`  // Registry of issued serials (default: issued.yaml next to revoked_file)
+}
+
+// GetCAKeyLabel returns the CA key pair label
+func (c *PKIConfig) GetCAKeyLabel() string {
+	if c.CAKeyLabel == "" {
+		return "pki-ca"
+	}
+	return c.CAKeyLabel
+}
+
+// GetTrustDomain returns the SPIFFE trust domain
+func (c *PKIConfig) GetTrustDomain() string {
+	if c.TrustDomain == "" {
+		return "hsm-service.local"
+	}
+	return c.TrustDomain
+}
+
+// GetDefaultTTL returns the default certificate lifetime (default: 24h)
+func (c *PKIConfig) GetDefaultTTL() time.Duration {
+	return parseDurationDefault(c.DefaultTTL, 24*time.Hour)
+}
+
+// GetMaxTTL returns the maximum certificate lifetime (default: 168h)
+func (c *PKIConfig) GetMaxTTL() time.Duration {
+	return parseDurationDefault(c.MaxTTL, 7*24*time.Hour)
+}
+
+// CACertFilePath returns the issuing CA certificate path
+func (c *PKIConfig) CACertFilePath(acl *ACLConfig) string {
+	if c.CACertPath != "" {
+		return c.CACertPath
+	}
+	return filepath.Join(pkiDir(acl), "ca", "issuing-ca.crt")
+}
+
+// IssuedFilePath returns the registry of issued certificates
+func (c *PKIConfig) IssuedFilePath(acl *ACLConfig) string {
+	if c.IssuedFile != "" {
+		return c.IssuedFile
+	}
+	return filepath.Join(pkiDir(acl), "issued.yaml")
+}
+
+// Validate checks lifetimes and the trust domain
+func (c *PKIConfig) Validate() error {
+	if len(c.AdminOUs) > 0 {
+		return fmt.Errorf("admin_ous was replaced by server.admin_ous")
+	}
+	for name, value := range map[string]string{"default_ttl": c.DefaultTTL, "max_ttl": c.MaxTTL} {
+		if value == "" {
+			continue
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		if d <= 0 {
+			return fmt.Errorf("%s must be positive, got %s", name, value)
+		}
+	}
+	if c.GetDefaultTTL() > c.GetMaxTTL() {
+		return fmt.Errorf("default_ttl (%s) exceeds max_ttl (%s)", c.GetDefaultTTL(), c.GetMaxTTL())
+	}
+	if err := ValidateTrustDomain(c.GetTrustDomain()); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ValidateTrustDomain checks a SPIFFE trust domain name
+// (lowercase letters, digits, dots, dashes and underscores)
+func ValidateTrustDomain(td string) error {
+	if td == "" || len(td) > 255 {
+		return fmt.Errorf("invalid trust_domain %q", td)
+	}
+	for _, r := range td {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '.' || r == '-' || r == '_') {
+			return fmt.Errorf("invalid trust_domain %q: only lowercase letters, digits, '.', '-' and '_' are allowed", td)
+		}
+	}
+	return nil
+}
+
+// pkiDir is the directory of revoked.yaml (pki/)
+func pkiDir(acl *ACLConfig) string {
+	if acl.RevokedFile == "" {
+		return "pki"
+	}
+	return filepath.Dir(acl.RevokedFile)
+}
+
+// parseDurationDefault parses s, falling back to def when empty or invalid
+func parseDurationDefault(s string, def time.Duration) time.Duration {
+	if s == "" {
+		return def
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return def
+	}
+	return d
+}
@@ -0,0 +1,47 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPKIConfig_Defaults(t *testing.T) {
+	c := &PKIConfig{}
+	acl := &ACLConfig{RevokedFile: "/app/pki/revoked.yaml"}
+
+	if got := c.GetDefaultTTL(); got != 24*time.Hour {
+		t.Errorf("GetDefaultTTL() = %s, want 24h", got)
+	}
+	if got := c.GetMaxTTL(); got != 168*time.Hour {
+		t.Errorf("GetMaxTTL() = %s, want 168h", got)
+	}
+	if got := c.CACertFilePath(acl); got != "/app/pki/ca/issuing-ca.crt" {
+		t.Errorf("CACertFilePath() = %s", got)
+	}
+	if got := c.IssuedFilePath(acl); got != "/app/pki/issued.yaml" {
+		t.Errorf("IssuedFilePath() = %s", got)
+	}
+	if err := c.Validate(); err != nil {
+		t.Errorf("Validate() with defaults: %v", err)
+	}
+}
+
+func TestPKIConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  PKIConfig
+	}{
+		{"invalid duration", PKIConfig{MaxTTL: "a week"}},
+		{"negative duration", PKIConfig{DefaultTTL: "-1h"}},
+		{"default above max", PKIConfig{DefaultTTL: "48h", MaxTTL: "24h"}},
+		{"uppercase trust domain", PKIConfig{TrustDomain: "Example.org"}},
+		{"trust domain with path", PKIConfig{TrustDomain: "example.org/ns"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.cfg.Validate(); err == nil {
+				t.Error("Validate() should fail")
+			}
+		})
+	}
+}
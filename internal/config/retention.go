@@ -0,0 +1,136 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// DefaultOverlapDays is the overlap period when none is configured
+const DefaultOverlapDays = 7
+
+// ErrOverlapActive is returned when a version is destroyed during its overlap period
+var ErrOverlapActive = errors.New("overlap period has not ended")
+
+// RetentionPolicy is the effective overlap/retention policy of a context
+//   - OverlapDays: after a version is superseded by rotation it must not be
+//     destroyed for this many days
+//   - RetentionDays: after a version is superseded, the service moves it from
+//     decrypt_only to disabled once this many days have passed (0 = never)
+type RetentionPolicy struct {
+	OverlapDays   int
+	RetentionDays int
+}
+
+// RetentionPolicy returns the policy of a context: values in metadata
+// override hsm.overlap_days / hsm.retention_days. Retention never ends
+// before the overlap period.
+func (c *HSMConfig) RetentionPolicy(keyMeta KeyMetadata) RetentionPolicy {
+	policy := RetentionPolicy{OverlapDays: c.OverlapDays, RetentionDays: c.RetentionDays}
+	if keyMeta.OverlapDays > 0 {
+		policy.OverlapDays = keyMeta.OverlapDays
+	}
+	if keyMeta.RetentionDays > 0 {
+		policy.RetentionDays = keyMeta.RetentionDays
+	}
+	if policy.OverlapDays == 0 {
+		policy.OverlapDays = DefaultOverlapDays
+	}
+	if policy.RetentionDays > 0 && policy.RetentionDays < policy.OverlapDays {
+		policy.RetentionDays = policy.OverlapDays
+	}
+	return policy
+}
+
+// SupersededAt returns when a version stopped being current: the creation
+// time of the next newer version (nil for the current version or if unknown)
+func (m *KeyMetadata) SupersededAt(label string) *time.Time {
+	if label == m.Current {
+		return nil
+	}
+	var version *KeyVersion
+	for i := range m.Versions {
+		if m.Versions[i].Label == label {
+			version = &m.Versions[i]
+		}
+	}
+	if version == nil {
+		return nil
+	}
+
+	var next *KeyVersion
+	for i := range m.Versions {
+		v := &m.Versions[i]
+		if v.Version > version.Version && (next == nil || v.Version < next.Version) {
+			next = v
+		}
+	}
+	if next != nil && next.CreatedAt != nil {
+		return next.CreatedAt
+	}
+	// Fall back to the rotation that moved it out of active
+	return version.StateChangedAt
+}
+
+// OverlapEnd returns when the overlap period of a version ends
+// (nil: the version is current, or its supersession time is unknown)
+func (p RetentionPolicy) OverlapEnd(keyMeta *KeyMetadata, label string) *time.Time {
+	superseded := keyMeta.SupersededAt(label)
+	if superseded == nil {
+		return nil
+	}
+	end := superseded.AddDate(0, 0, p.OverlapDays)
+	return &end
+}
+
+// CheckDestroy returns ErrOverlapActive if a version may not be destroyed yet
+// Versions with unknown supersession time are refused as well.
+func (p RetentionPolicy) CheckDestroy(keyMeta *KeyMetadata, label string, now time.Time) error {
+	if label == keyMeta.Current {
+		return fmt.Errorf("%s is the current version", label)
+	}
+	end := p.OverlapEnd(keyMeta, label)
+	if end == nil {
+		return fmt.Errorf("%s: %w (supersession time unknown)", label, ErrOverlapActive)
+	}
+	if now.Before(*end) {
+		return fmt.Errorf("%s: %w (%d days, ends %s)", label, ErrOverlapActive, p.OverlapDays, end.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// RetentionExpired returns decrypt_only versions whose retention has passed
+func (p RetentionPolicy) RetentionExpired(keyMeta *KeyMetadata, now time.Time) []string {
+	if p.RetentionDays == 0 {
+		return nil
+	}
+	var expired []string
+	for _, v := range keyMeta.Versions {
+		if v.EffectiveState(keyMeta.Current) != KeyStateDecryptOnly {
+			continue
+		}
+		superseded := keyMeta.SupersededAt(v.Label)
+		if superseded != nil && !now.Before(superseded.AddDate(0, 0, p.RetentionDays)) {
+			expired = append(expired, v.Label)
+		}
+	}
+	return expired
+}
+
+// validateRetention checks hsm.overlap_days / hsm.retention_days
+func validateRetention(hsm *HSMConfig) error {
+	if hsm.OverlapDays < 0 {
+		return fmt.Errorf("hsm.overlap_days must not be negative")
+	}
+	if hsm.RetentionDays < 0 {
+		return fmt.Errorf("hsm.retention_days must not be negative")
+	}
+	overlap := hsm.OverlapDays
+	if overlap == 0 {
+		overlap = DefaultOverlapDays
+	}
+	if hsm.RetentionDays > 0 && hsm.RetentionDays < overlap {
+		return fmt.Errorf("hsm.retention_days (%d) must not be shorter than overlap_days (%d)", hsm.RetentionDays, overlap)
+	}
+	return nil
+}
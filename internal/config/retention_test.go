@@ -0,0 +1,83 @@
+package config
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func retentionKeyMetadata(rotatedAt time.Time) *KeyMetadata {
+	created := rotatedAt.AddDate(0, -3, 0)
+	return &KeyMetadata{
+		Current: "kek-exchange-v2",
+		Versions: []KeyVersion{
+			{Label: "kek-exchange-v1", Version: 1, CreatedAt: &created, State: KeyStateDecryptOnly},
+			{Label: "kek-exchange-v2", Version: 2, CreatedAt: &rotatedAt, State: KeyStateActive},
+		},
+	}
+}
+
+func TestRetentionPolicy_Defaults(t *testing.T) {
+	hsm := &HSMConfig{RetentionDays: 3}
+
+	policy := hsm.RetentionPolicy(KeyMetadata{})
+	if policy.OverlapDays != DefaultOverlapDays || policy.RetentionDays != DefaultOverlapDays {
+		t.Errorf("policy = %+v, want overlap %d and retention raised to overlap", policy, DefaultOverlapDays)
+	}
+
+	policy = hsm.RetentionPolicy(KeyMetadata{OverlapDays: 14, RetentionDays: 30})
+	if policy.OverlapDays != 14 || policy.RetentionDays != 30 {
+		t.Errorf("per-context policy = %+v, want 14/30", policy)
+	}
+}
+
+func TestRetentionPolicy_CheckDestroy(t *testing.T) {
+	now := time.Now()
+	policy := RetentionPolicy{OverlapDays: 7}
+
+	keyMeta := retentionKeyMetadata(now.AddDate(0, 0, -2))
+	if err := policy.CheckDestroy(keyMeta, "kek-exchange-v1", now); !errors.Is(err, ErrOverlapActive) {
+		t.Errorf("err = %v, want ErrOverlapActive", err)
+	}
+	if err := policy.CheckDestroy(keyMeta, "kek-exchange-v2", now); err == nil {
+		t.Error("current version may be destroyed")
+	}
+
+	keyMeta = retentionKeyMetadata(now.AddDate(0, 0, -8))
+	if err := policy.CheckDestroy(keyMeta, "kek-exchange-v1", now); err != nil {
+		t.Errorf("after overlap: %v", err)
+	}
+
+	// Unknown supersession time is refused
+	keyMeta.Versions[1].CreatedAt = nil
+	if err := policy.CheckDestroy(keyMeta, "kek-exchange-v1", now); !errors.Is(err, ErrOverlapActive) {
+		t.Errorf("unknown supersession: err = %v, want ErrOverlapActive", err)
+	}
+}
+
+func TestRetentionPolicy_RetentionExpired(t *testing.T) {
+	now := time.Now()
+	keyMeta := retentionKeyMetadata(now.AddDate(0, 0, -31))
+
+	if expired := (RetentionPolicy{OverlapDays: 7}).RetentionExpired(keyMeta, now); len(expired) != 0 {
+		t.Errorf("retention disabled: expired = %v", expired)
+	}
+	if expired := (RetentionPolicy{OverlapDays: 7, RetentionDays: 30}).RetentionExpired(keyMeta, now); len(expired) != 1 || expired[0] != "kek-exchange-v1" {
+		t.Errorf("expired = %v, want [kek-exchange-v1]", expired)
+	}
+	if expired := (RetentionPolicy{OverlapDays: 7, RetentionDays: 60}).RetentionExpired(keyMeta, now); len(expired) != 0 {
+		t.Errorf("within retention: expired = %v", expired)
+	}
+}
+
+func TestValidateRetention(t *testing.T) {
+	if err := validateRetention(&HSMConfig{OverlapDays: 14, RetentionDays: 7}); err == nil {
+		t.Error("retention shorter than overlap accepted")
+	}
+	if err := validateRetention(&HSMConfig{RetentionDays: -1}); err == nil {
+		t.Error("negative retention accepted")
+	}
+	if err := validateRetention(&HSMConfig{OverlapDays: 7, RetentionDays: 90}); err != nil {
+		t.Errorf("valid policy rejected: %v", err)
+	}
+}
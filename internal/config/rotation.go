@@ -0,0 +1,150 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// AutoRotationConfig defines the in-service key rotation scheduler (opt-in)
+type AutoRotationConfig struct {
+	Enabled            bool                `yaml:"enabled"`             // Disabled by default
+	CheckInterval      string              `yaml:"check_interval"`      // e.g., "1h" (default: 1h)
+	MaintenanceWindows []MaintenanceWindow `yaml:"maintenance_windows"` // Empty = rotate at any time
+}
+
+// MaintenanceWindow defines a recurring time window when rotation is allowed
+// Times are "HH:MM" in UTC. A window with start > end spans midnight.
+type MaintenanceWindow struct {
+	Days  []string `yaml:"days"`  // mon, tue, wed, thu, fri, sat, sun (empty = every day)
+	Start string   `yaml:"start"` // e.g., "02:00"
+	End   string   `yaml:"end"`   // e.g., "05:00"
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// GetCheckInterval returns the scheduler check interval (default: 1h)
+func (c *AutoRotationConfig) GetCheckInterval() time.Duration {
+	if c.CheckInterval == "" {
+		return time.Hour
+	}
+	d, err := time.ParseDuration(c.CheckInterval)
+	if err != nil {
+		return time.Hour
+	}
+	return d
+}
+
+// InMaintenanceWindow reports whether t falls into any configured window
+// No windows configured means rotation is always allowed
+func (c *AutoRotationConfig) InMaintenanceWindow(t time.Time) bool {
+	if len(c.MaintenanceWindows) == 0 {
+		return true
+	}
+	for _, w := range c.MaintenanceWindows {
+		if w.Contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks the auto-rotation configuration
+func (c *AutoRotationConfig) Validate() error {
+	if c.CheckInterval != "" {
+		d, err := time.ParseDuration(c.CheckInterval)
+		if err != nil {
+			return fmt.Errorf("invalid check_interval: %w", err)
+		}
+		if d < time.Minute {
+			return fmt.Errorf("check_interval must be at least 1m, got %s", d)
+		}
+	}
+	for i, w := range c.MaintenanceWindows {
+		if err := w.Validate(); err != nil {
+			return fmt.Errorf("maintenance_windows[%d]: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// Validate checks the window days and times
+func (w *MaintenanceWindow) Validate() error {
+	for _, d := range w.Days {
+		if _, ok := weekdays[strings.ToLower(d)]; !ok {
+			return fmt.Errorf("invalid day %q (expected mon..sun)", d)
+		}
+	}
+	start, err := parseClock(w.Start)
+	if err != nil {
+		return fmt.Errorf("invalid start: %w", err)
+	}
+	end, err := parseClock(w.End)
+	if err != nil {
+		return fmt.Errorf("invalid end: %w", err)
+	}
+	if start == end {
+		return fmt.Errorf("start and end must differ")
+	}
+	return nil
+}
+
+// Contains reports whether t (converted to UTC) is inside the window
+// For windows spanning midnight, the day refers to the day the window starts
+func (w *MaintenanceWindow) Contains(t time.Time) bool {
+	start, err := parseClock(w.Start)
+	if err != nil {
+		return false
+	}
+	end, err := parseClock(w.End)
+	if err != nil {
+		return false
+	}
+
+	t = t.UTC()
+	now := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	day := t.Weekday()
+
+	if start < end {
+		return now >= start && now < end && w.allowsDay(day)
+	}
+
+	// Window spans midnight: [start, 24:00) on day D, [00:00, end) on day D+1
+	if now >= start {
+		return w.allowsDay(day)
+	}
+	if now < end {
+		return w.allowsDay((day + 6) % 7)
+	}
+	return false
+}
+
+// allowsDay reports whether the window is active on the given weekday
+func (w *MaintenanceWindow) allowsDay(day time.Weekday) bool {
+	if len(w.Days) == 0 {
+		return true
+	}
+	for _, d := range w.Days {
+		if weekdays[strings.ToLower(d)] == day {
+			return true
+		}
+	}
+	return false
+}
+
+// parseClock parses "HH:MM" into an offset from midnight
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("expected HH:MM, got %q", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
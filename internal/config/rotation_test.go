@@ -0,0 +1,74 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMaintenanceWindowContains(t *testing.T) {
+	// 2026-01-10 is a Saturday
+	at := func(day int, hh, mm int) time.Time {
+		return time.Date(2026, 1, day, hh, mm, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name   string
+		window MaintenanceWindow
+		t      time.Time
+		want   bool
+	}{
+		{"inside daily window", MaintenanceWindow{Start: "02:00", End: "05:00"}, at(12, 3, 0), true},
+		{"at start", MaintenanceWindow{Start: "02:00", End: "05:00"}, at(12, 2, 0), true},
+		{"at end (exclusive)", MaintenanceWindow{Start: "02:00", End: "05:00"}, at(12, 5, 0), false},
+		{"before window", MaintenanceWindow{Start: "02:00", End: "05:00"}, at(12, 1, 59), false},
+		{"weekend day match", MaintenanceWindow{Days: []string{"sat", "sun"}, Start: "02:00", End: "05:00"}, at(10, 3, 0), true},
+		{"weekend day mismatch", MaintenanceWindow{Days: []string{"sat", "sun"}, Start: "02:00", End: "05:00"}, at(12, 3, 0), false},
+		{"midnight span before midnight", MaintenanceWindow{Days: []string{"fri"}, Start: "23:00", End: "02:00"}, at(9, 23, 30), true},
+		{"midnight span after midnight", MaintenanceWindow{Days: []string{"fri"}, Start: "23:00", End: "02:00"}, at(10, 1, 0), true},
+		{"midnight span wrong day", MaintenanceWindow{Days: []string{"fri"}, Start: "23:00", End: "02:00"}, at(11, 1, 0), false},
+		{"midnight span outside", MaintenanceWindow{Start: "23:00", End: "02:00"}, at(10, 12, 0), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.window.Contains(tt.t); got != tt.want {
+				t.Errorf("Contains(%v) = %v, want %v", tt.t, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAutoRotationConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     AutoRotationConfig
+		wantErr bool
+	}{
+		{"empty", AutoRotationConfig{}, false},
+		{"valid", AutoRotationConfig{Enabled: true, CheckInterval: "30m", MaintenanceWindows: []MaintenanceWindow{{Days: []string{"Sun"}, Start: "01:00", End: "03:00"}}}, false},
+		{"bad interval", AutoRotationConfig{CheckInterval: "soon"}, true},
+		{"interval too short", AutoRotationConfig{CheckInterval: "10s"}, true},
+		{"bad day", AutoRotationConfig{MaintenanceWindows: []MaintenanceWindow{{Days: []string{"funday"}, Start: "01:00", End: "03:00"}}}, true},
+		{"bad time", AutoRotationConfig{MaintenanceWindows: []MaintenanceWindow{{Start: "25:00", End: "03:00"}}}, true},
+		{"empty window", AutoRotationConfig{MaintenanceWindows: []MaintenanceWindow{{Start: "03:00", End: "03:00"}}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.cfg.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestInMaintenanceWindow_NoWindows(t *testing.T) {
+	cfg := AutoRotationConfig{}
+	if !cfg.InMaintenanceWindow(time.Now()) {
+		t.Error("no windows configured should allow rotation at any time")
+	}
+	if cfg.GetCheckInterval() != time.Hour {
+		t.Errorf("default check interval = %v, want 1h", cfg.GetCheckInterval())
+	}
+}
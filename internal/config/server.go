@@ -0,0 +1,23 @@
+package config
+
+import "fmt"
+
+// GetAdminOUs returns the client OUs allowed to use the admin API
+// (/admin/*, /audit/events, /pki/sign-csr)
+func (c *ServerConfig) GetAdminOUs() []string {
+	if len(c.AdminOUs) == 0 {
+		return []string{"Admin"}
+	}
+	return c.AdminOUs
+}
+
+// validateAdminOUs rejects empty entries: a certificate without an OU must
+// never match an admin OU
+func validateAdminOUs(ous []string) error {
+	for _, ou := range ous {
+		if ou == "" {
+			return fmt.Errorf("server.admin_ous must not contain empty entries")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestServerConfig_AdminOUs(t *testing.T) {
+	if got := (&ServerConfig{}).GetAdminOUs(); !reflect.DeepEqual(got, []string{"Admin"}) {
+		t.Errorf("GetAdminOUs() default = %v, want [Admin]", got)
+	}
+	c := ServerConfig{AdminOUs: []string{"SecOps"}}
+	if got := c.GetAdminOUs(); !reflect.DeepEqual(got, []string{"SecOps"}) {
+		t.Errorf("GetAdminOUs() = %v, want [SecOps]", got)
+	}
+	if err := validateAdminOUs([]string{"SecOps", ""}); err == nil {
+		t.Error("validateAdminOUs should reject an empty OU")
+	}
+}
+
+// The old per-section settings are rejected instead of silently ignored
+func TestAdminOUs_OldSettingsRejected(t *testing.T) {
+	if err := (&AuditConfig{AdminOUs: []string{"Admin"}}).Validate(); err == nil {
+		t.Error("audit.admin_ous should be rejected")
+	}
+	if err := (&PKIConfig{AdminOUs: []string{"Admin"}}).Validate(); err == nil {
+		t.Error("pki.admin_ous should be rejected")
+	}
+}
@@ -0,0 +1,161 @@
+package config
+
+import (
+	"crypto/hmac"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// DefaultMetadataKeyLabel is the HSM label of the metadata HMAC key
+const DefaultMetadataKeyLabel = "metadata-hmac"
+
+// metadataMACDomain separates metadata MACs from any other use of the key
+const metadataMACDomain = "hsm-service/metadata/v1\n"
+
+var (
+	ErrMetadataUnsigned          = errors.New("metadata is not signed")
+	ErrMetadataSignatureMismatch = errors.New("metadata signature mismatch")
+	ErrMetadataRollback          = errors.New("metadata generation rolled back")
+)
+
+// MetadataMAC computes a MAC over data (HMAC-SHA256 with an HSM-resident key)
+type MetadataMAC func(data []byte) ([]byte, error)
+
+// MetadataSigningConfig enables HSM-authenticated metadata.yaml
+type MetadataSigningConfig struct {
+	Enabled        bool   `yaml:"enabled"`
+	KeyLabel       string `yaml:"key_label"`       // HMAC key label in HSM (default: metadata-hmac)
+	GenerationFile string `yaml:"generation_file"` // Highest accepted generation (default: metadata-generation next to metadata_file)
+}
+
+// GetKeyLabel returns the HMAC key label with default applied
+func (c *MetadataSigningConfig) GetKeyLabel() string {
+	if c.KeyLabel == "" {
+		return DefaultMetadataKeyLabel
+	}
+	return c.KeyLabel
+}
+
+// MetadataGenerationFilePath returns the file that records the highest
+// metadata generation accepted on this host
+func (c *HSMConfig) MetadataGenerationFilePath() string {
+	if c.MetadataSigning.GenerationFile != "" {
+		return c.MetadataSigning.GenerationFile
+	}
+	metadataPath := c.MetadataFile
+	if metadataPath == "" {
+		metadataPath = "metadata.yaml"
+	}
+	return filepath.Join(filepath.Dir(metadataPath), "metadata-generation")
+}
+
+// CanonicalMetadata returns the byte string covered by the metadata MAC:
+// the metadata without its signature, as JSON (map keys sorted)
+func CanonicalMetadata(meta *Metadata) ([]byte, error) {
+	unsigned := *meta
+	unsigned.Signature = ""
+
+	data, err := json.Marshal(&unsigned)
+	if err != nil {
+		return nil, fmt.Errorf("marshal canonical metadata: %w", err)
+	}
+	return append([]byte(metadataMACDomain), data...), nil
+}
+
+// SignMetadata increments the generation counter and sets the signature
+func SignMetadata(meta *Metadata, mac MetadataMAC) error {
+	meta.Generation++
+
+	data, err := CanonicalMetadata(meta)
+	if err != nil {
+		return err
+	}
+	sum, err := mac(data)
+	if err != nil {
+		return fmt.Errorf("compute metadata MAC: %w", err)
+	}
+	meta.Signature = hex.EncodeToString(sum)
+	return nil
+}
+
+// VerifyMetadata checks the signature and refuses generations below minGeneration
+func VerifyMetadata(meta *Metadata, mac MetadataMAC, minGeneration uint64) error {
+	if meta.Signature == "" {
+		return ErrMetadataUnsigned
+	}
+	want, err := hex.DecodeString(meta.Signature)
+	if err != nil {
+		return fmt.Errorf("%w: invalid encoding", ErrMetadataSignatureMismatch)
+	}
+
+	data, err := CanonicalMetadata(meta)
+	if err != nil {
+		return err
+	}
+	got, err := mac(data)
+	if err != nil {
+		return fmt.Errorf("compute metadata MAC: %w", err)
+	}
+	if !hmac.Equal(got, want) {
+		return ErrMetadataSignatureMismatch
+	}
+
+	if meta.Generation < minGeneration {
+		return fmt.Errorf("%w: generation %d, already accepted %d", ErrMetadataRollback, meta.Generation, minGeneration)
+	}
+	return nil
+}
+
+// LoadVerifiedMetadata loads metadata from store and verifies it when mac
+// is set. Returns the revision to pass to SaveSignedMetadata.
+func LoadVerifiedMetadata(store MetadataStore, mac MetadataMAC, minGeneration uint64) (*Metadata, string, error) {
+	meta, revision, err := store.Load()
+	if err != nil {
+		return nil, "", err
+	}
+	if mac == nil {
+		return meta, revision, nil
+	}
+	if err := VerifyMetadata(meta, mac, minGeneration); err != nil {
+		return nil, "", fmt.Errorf("verify metadata: %w", err)
+	}
+	return meta, revision, nil
+}
+
+// SaveSignedMetadata signs metadata (when mac is set) and stores it if the
+// store is still at revision (ErrMetadataConflict otherwise)
+func SaveSignedMetadata(store MetadataStore, revision string, meta *Metadata, mac MetadataMAC) (string, error) {
+	if mac != nil {
+		if err := SignMetadata(meta, mac); err != nil {
+			return "", err
+		}
+	}
+	return store.CompareAndSwap(revision, meta)
+}
+
+// LoadGeneration reads the highest accepted metadata generation (0 if missing)
+func LoadGeneration(path string) (uint64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("read generation file: %w", err)
+	}
+	gen, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("parse generation file: %w", err)
+	}
+	return gen, nil
+}
+
+// SaveGeneration records the highest accepted metadata generation
+func SaveGeneration(path string, gen uint64) error {
+	return WriteFileAtomic(path, []byte(strconv.FormatUint(gen, 10)+"\n"), 0644)
+}
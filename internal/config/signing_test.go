@@ -0,0 +1,148 @@
+package config
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testMAC is a software stand-in for the HSM-resident HMAC key
+func testMAC(key string) MetadataMAC {
+	return func(data []byte) ([]byte, error) {
+		h := hmac.New(sha256.New, []byte(key))
+		h.Write(data)
+		return h.Sum(nil), nil
+	}
+}
+
+func newSigningMetadata() *Metadata {
+	created := time.Now()
+	return &Metadata{Rotation: map[string]KeyMetadata{
+		"exchange": {
+			Current:              "kek-exchange-v2",
+			RotationIntervalDays: 90,
+			Versions: []KeyVersion{
+				{Label: "kek-exchange-v1", Version: 1, CreatedAt: &created, State: KeyStateDecryptOnly},
+				{Label: "kek-exchange-v2", Version: 2, CreatedAt: &created, State: KeyStateActive},
+			},
+		},
+	}}
+}
+
+func TestSignedMetadata_RoundTrip(t *testing.T) {
+	store := NewFileMetadataStore(filepath.Join(t.TempDir(), "metadata.yaml"))
+	mac := testMAC("secret")
+
+	meta := newSigningMetadata()
+	if _, err := SaveSignedMetadata(store, "", meta, mac); err != nil {
+		t.Fatalf("SaveSignedMetadata() error = %v", err)
+	}
+	if meta.Generation != 1 || meta.Signature == "" {
+		t.Fatalf("generation = %d, signature = %q", meta.Generation, meta.Signature)
+	}
+
+	loaded, revision, err := LoadVerifiedMetadata(store, mac, 1)
+	if err != nil {
+		t.Fatalf("LoadVerifiedMetadata() error = %v", err)
+	}
+
+	// Re-signing increments the generation
+	if _, err := SaveSignedMetadata(store, revision, loaded, mac); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Generation != 2 {
+		t.Errorf("generation = %d, want 2", loaded.Generation)
+	}
+}
+
+func TestVerifyMetadata_Rejects(t *testing.T) {
+	mac := testMAC("secret")
+
+	t.Run("unsigned", func(t *testing.T) {
+		err := VerifyMetadata(newSigningMetadata(), mac, 0)
+		if !errors.Is(err, ErrMetadataUnsigned) {
+			t.Errorf("error = %v, want ErrMetadataUnsigned", err)
+		}
+	})
+
+	t.Run("current switched", func(t *testing.T) {
+		meta := newSigningMetadata()
+		if err := SignMetadata(meta, mac); err != nil {
+			t.Fatal(err)
+		}
+		keyMeta := meta.Rotation["exchange"]
+		keyMeta.Current = "kek-exchange-v1"
+		meta.Rotation["exchange"] = keyMeta
+
+		if err := VerifyMetadata(meta, mac, 0); !errors.Is(err, ErrMetadataSignatureMismatch) {
+			t.Errorf("error = %v, want ErrMetadataSignatureMismatch", err)
+		}
+	})
+
+	t.Run("generation edited", func(t *testing.T) {
+		meta := newSigningMetadata()
+		if err := SignMetadata(meta, mac); err != nil {
+			t.Fatal(err)
+		}
+		meta.Generation = 100
+
+		if err := VerifyMetadata(meta, mac, 0); !errors.Is(err, ErrMetadataSignatureMismatch) {
+			t.Errorf("error = %v, want ErrMetadataSignatureMismatch", err)
+		}
+	})
+
+	t.Run("wrong key", func(t *testing.T) {
+		meta := newSigningMetadata()
+		if err := SignMetadata(meta, testMAC("other")); err != nil {
+			t.Fatal(err)
+		}
+		if err := VerifyMetadata(meta, mac, 0); !errors.Is(err, ErrMetadataSignatureMismatch) {
+			t.Errorf("error = %v, want ErrMetadataSignatureMismatch", err)
+		}
+	})
+
+	t.Run("rollback", func(t *testing.T) {
+		meta := newSigningMetadata()
+		if err := SignMetadata(meta, mac); err != nil {
+			t.Fatal(err)
+		}
+		if err := VerifyMetadata(meta, mac, 2); !errors.Is(err, ErrMetadataRollback) {
+			t.Errorf("error = %v, want ErrMetadataRollback", err)
+		}
+	})
+}
+
+func TestLoadVerifiedMetadata_SigningDisabled(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metadata.yaml")
+	if err := SaveMetadata(path, newSigningMetadata()); err != nil {
+		t.Fatal(err)
+	}
+	store := NewFileMetadataStore(path)
+
+	if _, _, err := LoadVerifiedMetadata(store, nil, 0); err != nil {
+		t.Errorf("unsigned metadata must load when signing is disabled: %v", err)
+	}
+	if _, _, err := LoadVerifiedMetadata(store, testMAC("secret"), 0); !errors.Is(err, ErrMetadataUnsigned) {
+		t.Errorf("error = %v, want ErrMetadataUnsigned", err)
+	}
+}
+
+func TestGenerationFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metadata-generation")
+
+	gen, err := LoadGeneration(path)
+	if err != nil || gen != 0 {
+		t.Fatalf("missing file: gen = %d, err = %v", gen, err)
+	}
+
+	if err := SaveGeneration(path, 42); err != nil {
+		t.Fatal(err)
+	}
+	gen, err = LoadGeneration(path)
+	if err != nil || gen != 42 {
+		t.Errorf("gen = %d, err = %v, want 42", gen, err)
+	}
+}
@@ -0,0 +1,195 @@
+package config
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Metadata store backends
+const (
+	MetadataBackendFile = "file"
+	MetadataBackendBolt = "bolt"
+)
+
+var (
+	ErrMetadataNotFound = errors.New("metadata not found")
+	ErrMetadataConflict = errors.New("metadata was changed concurrently")
+)
+
+// MetadataStoreConfig selects where rotation metadata is stored
+type MetadataStoreConfig struct {
+	Backend string `yaml:"backend"` // file (default: hsm.metadata_file) or bolt
+	Path    string `yaml:"path"`    // bolt database (default: metadata.db next to metadata_file)
+}
+
+// Validate checks the backend name
+func (c *MetadataStoreConfig) Validate() error {
+	switch c.Backend {
+	case "", MetadataBackendFile, MetadataBackendBolt:
+		return nil
+	default:
+		return fmt.Errorf("backend must be '%s' or '%s', got '%s'", MetadataBackendFile, MetadataBackendBolt, c.Backend)
+	}
+}
+
+// MetadataStore holds rotation metadata. Every stored state has an opaque
+// revision: writers pass the revision they loaded to CompareAndSwap, so a
+// change made in between (another replica, hsm-admin, a manual edit) is
+// detected instead of silently overwritten. Writers still take Lock around
+// load+swap to serialize with each other.
+type MetadataStore interface {
+	// Load returns the stored metadata and its revision
+	// (ErrMetadataNotFound if nothing is stored yet)
+	Load() (*Metadata, string, error)
+
+	// Revision returns the current revision ("" if nothing is stored yet)
+	Revision() (string, error)
+
+	// CompareAndSwap stores meta if the store is still at revision
+	// ("" = empty store), otherwise returns ErrMetadataConflict.
+	// Returns the new revision.
+	CompareAndSwap(revision string, meta *Metadata) (string, error)
+
+	// Watch sends the new revision whenever it differs from the last one
+	// seen (starting at since) until ctx is done. Changes are picked up
+	// from inotify events, or at the latest after interval.
+	Watch(ctx context.Context, since string, interval time.Duration) <-chan string
+
+	// Lock acquires the exclusive writer lock; the returned function releases it
+	Lock() (func(), error)
+
+	// Backup writes the stored metadata as YAML into backupDir
+	// (empty: backups/ next to the store) and returns the backup path
+	Backup(backupDir string) (string, error)
+
+	// String describes the store for logs
+	String() string
+}
+
+// OpenMetadataStore returns the store selected by hsm.metadata_store
+func OpenMetadataStore(c *HSMConfig) (MetadataStore, error) {
+	metadataPath := c.MetadataFile
+	if metadataPath == "" {
+		metadataPath = "metadata.yaml"
+	}
+
+	switch c.MetadataStore.Backend {
+	case "", MetadataBackendFile:
+		return NewFileMetadataStore(metadataPath), nil
+	case MetadataBackendBolt:
+		path := c.MetadataStore.Path
+		if path == "" {
+			path = filepath.Join(filepath.Dir(metadataPath), "metadata.db")
+		}
+		return NewBoltMetadataStore(path), nil
+	default:
+		return nil, fmt.Errorf("unknown metadata store backend: %s", c.MetadataStore.Backend)
+	}
+}
+
+// FileMetadataStore keeps metadata in a YAML file (metadata.yaml).
+// The revision is a hash of the file content.
+type FileMetadataStore struct {
+	path string
+}
+
+// NewFileMetadataStore returns a store backed by the YAML file at path
+func NewFileMetadataStore(path string) *FileMetadataStore {
+	return &FileMetadataStore{path: path}
+}
+
+// Load reads and parses the metadata file
+func (s *FileMetadataStore) Load() (*Metadata, string, error) {
+	data, err := s.read()
+	if err != nil {
+		return nil, "", err
+	}
+
+	var meta Metadata
+	if err := yaml.Unmarshal(data, &meta); err != nil {
+		return nil, "", fmt.Errorf("parse metadata YAML: %w", err)
+	}
+	return &meta, contentRevision(data), nil
+}
+
+// Revision hashes the current file content
+func (s *FileMetadataStore) Revision() (string, error) {
+	data, err := s.read()
+	if errors.Is(err, ErrMetadataNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return contentRevision(data), nil
+}
+
+// CompareAndSwap atomically replaces the file if its content is unchanged.
+// The check and the rename are not atomic for writers that skip Lock.
+func (s *FileMetadataStore) CompareAndSwap(revision string, meta *Metadata) (string, error) {
+	current, err := s.Revision()
+	if err != nil {
+		return "", err
+	}
+	if current != revision {
+		return "", fmt.Errorf("%w: %s", ErrMetadataConflict, s.path)
+	}
+
+	data, err := yaml.Marshal(meta)
+	if err != nil {
+		return "", fmt.Errorf("marshal metadata to YAML: %w", err)
+	}
+	if err := WriteFileAtomic(s.path, data, 0644); err != nil {
+		return "", fmt.Errorf("write metadata file: %w", err)
+	}
+	return contentRevision(data), nil
+}
+
+// Watch reports content changes (inotify on the directory, polling fallback)
+func (s *FileMetadataStore) Watch(ctx context.Context, since string, interval time.Duration) <-chan string {
+	return watchRevision(ctx, s.path, since, interval, s.Revision)
+}
+
+// Lock takes the flock on <path>.lock
+func (s *FileMetadataStore) Lock() (func(), error) {
+	return LockMetadata(s.path)
+}
+
+// Backup copies the file into backupDir
+func (s *FileMetadataStore) Backup(backupDir string) (string, error) {
+	if backupDir == "" {
+		backupDir = filepath.Join(filepath.Dir(s.path), "backups")
+	}
+	return BackupMetadata(s.path, backupDir)
+}
+
+// String returns the file path
+func (s *FileMetadataStore) String() string {
+	return s.path
+}
+
+// read returns the raw file content
+func (s *FileMetadataStore) read() ([]byte, error) {
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: %s", ErrMetadataNotFound, s.path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read metadata file: %w", err)
+	}
+	return data, nil
+}
+
+// contentRevision derives a revision from stored bytes
+func contentRevision(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,169 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"gopkg.in/yaml.v3"
+)
+
+// boltOpenTimeout bounds the wait for another process holding the database
+const boltOpenTimeout = 5 * time.Second
+
+var (
+	boltBucket      = []byte("metadata")
+	boltDocumentKey = []byte("document") // metadata as YAML (same format as metadata.yaml)
+	boltRevisionKey = []byte("revision") // decimal counter, incremented on every swap
+)
+
+// BoltMetadataStore keeps metadata in an embedded bbolt database.
+// The database is opened per operation: bbolt allows a single writer
+// process, and the service and hsm-admin must both be able to use it.
+type BoltMetadataStore struct {
+	path string
+}
+
+// NewBoltMetadataStore returns a store backed by the bbolt database at path
+func NewBoltMetadataStore(path string) *BoltMetadataStore {
+	return &BoltMetadataStore{path: path}
+}
+
+// Load reads the metadata document and its revision
+func (s *BoltMetadataStore) Load() (*Metadata, string, error) {
+	var meta Metadata
+	var revision string
+	err := s.view(func(document []byte, rev string) error {
+		if document == nil {
+			return fmt.Errorf("%w: %s", ErrMetadataNotFound, s.path)
+		}
+		if err := yaml.Unmarshal(document, &meta); err != nil {
+			return fmt.Errorf("parse metadata YAML: %w", err)
+		}
+		revision = rev
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return &meta, revision, nil
+}
+
+// Revision reads the revision counter
+func (s *BoltMetadataStore) Revision() (string, error) {
+	var revision string
+	err := s.view(func(_ []byte, rev string) error {
+		revision = rev
+		return nil
+	})
+	return revision, err
+}
+
+// CompareAndSwap stores meta in one write transaction if the revision matches
+func (s *BoltMetadataStore) CompareAndSwap(revision string, meta *Metadata) (string, error) {
+	document, err := yaml.Marshal(meta)
+	if err != nil {
+		return "", fmt.Errorf("marshal metadata to YAML: %w", err)
+	}
+
+	db, err := bolt.Open(s.path, 0640, &bolt.Options{Timeout: boltOpenTimeout})
+	if err != nil {
+		return "", fmt.Errorf("open metadata database: %w", err)
+	}
+	defer db.Close()
+
+	var next string
+	err = db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(boltBucket)
+		if err != nil {
+			return err
+		}
+
+		current := string(b.Get(boltRevisionKey))
+		if current != revision {
+			return fmt.Errorf("%w: %s at revision %s, expected %s", ErrMetadataConflict, s.path, current, revision)
+		}
+
+		counter := uint64(0)
+		if current != "" {
+			if counter, err = strconv.ParseUint(current, 10, 64); err != nil {
+				return fmt.Errorf("parse metadata revision: %w", err)
+			}
+		}
+		next = strconv.FormatUint(counter+1, 10)
+
+		if err := b.Put(boltDocumentKey, document); err != nil {
+			return err
+		}
+		return b.Put(boltRevisionKey, []byte(next))
+	})
+	if err != nil {
+		return "", fmt.Errorf("update metadata database: %w", err)
+	}
+	return next, nil
+}
+
+// Watch reports revision changes (writes modify the database file in place)
+func (s *BoltMetadataStore) Watch(ctx context.Context, since string, interval time.Duration) <-chan string {
+	return watchRevision(ctx, s.path, since, interval, s.Revision)
+}
+
+// Lock takes the flock on <path>.lock (the same writer lock as the file store)
+func (s *BoltMetadataStore) Lock() (func(), error) {
+	return LockMetadata(s.path)
+}
+
+// Backup exports the document as <name>.yaml.backup-<timestamp>, so backups
+// can be restored with 'hsm-admin metadata restore' into either backend
+func (s *BoltMetadataStore) Backup(backupDir string) (string, error) {
+	if backupDir == "" {
+		backupDir = filepath.Join(filepath.Dir(s.path), "backups")
+	}
+
+	var document []byte
+	err := s.view(func(doc []byte, _ string) error {
+		if doc == nil {
+			return fmt.Errorf("%w: %s", ErrMetadataNotFound, s.path)
+		}
+		document = append([]byte(nil), doc...)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	name := strings.TrimSuffix(filepath.Base(s.path), filepath.Ext(s.path)) + ".yaml"
+	return writeMetadataBackup(name, document, backupDir)
+}
+
+// String returns the database path
+func (s *BoltMetadataStore) String() string {
+	return "bolt:" + s.path
+}
+
+// view runs fn on the stored document (nil if empty) and revision
+// Slices passed to fn are only valid during the call.
+func (s *BoltMetadataStore) view(fn func(document []byte, revision string) error) error {
+	if _, err := os.Stat(s.path); os.IsNotExist(err) {
+		return fn(nil, "")
+	}
+
+	db, err := bolt.Open(s.path, 0640, &bolt.Options{Timeout: boltOpenTimeout, ReadOnly: true})
+	if err != nil {
+		return fmt.Errorf("open metadata database: %w", err)
+	}
+	defer db.Close()
+
+	return db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltBucket)
+		if b == nil {
+			return fn(nil, "")
+		}
+		return fn(b.Get(boltDocumentKey), string(b.Get(boltRevisionKey)))
+	})
+}
@@ -0,0 +1,143 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testStores(t *testing.T) map[string]MetadataStore {
+	dir := t.TempDir()
+	return map[string]MetadataStore{
+		MetadataBackendFile: NewFileMetadataStore(filepath.Join(dir, "metadata.yaml")),
+		MetadataBackendBolt: NewBoltMetadataStore(filepath.Join(dir, "metadata.db")),
+	}
+}
+
+func TestMetadataStore_CompareAndSwap(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			if _, _, err := store.Load(); !errors.Is(err, ErrMetadataNotFound) {
+				t.Fatalf("empty store: err = %v, want ErrMetadataNotFound", err)
+			}
+			if rev, err := store.Revision(); err != nil || rev != "" {
+				t.Fatalf("empty store: revision = %q, err = %v", rev, err)
+			}
+
+			rev1, err := store.CompareAndSwap("", newSigningMetadata())
+			if err != nil {
+				t.Fatalf("create: %v", err)
+			}
+
+			loaded, rev, err := store.Load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rev != rev1 || loaded.Rotation["exchange"].Current != "kek-exchange-v2" {
+				t.Fatalf("loaded revision %q (want %q), current %q", rev, rev1, loaded.Rotation["exchange"].Current)
+			}
+
+			keyMeta := loaded.Rotation["exchange"]
+			keyMeta.Current = "kek-exchange-v1"
+			loaded.Rotation["exchange"] = keyMeta
+			rev2, err := store.CompareAndSwap(rev1, loaded)
+			if err != nil {
+				t.Fatalf("swap: %v", err)
+			}
+			if rev2 == rev1 {
+				t.Error("revision unchanged after swap")
+			}
+
+			// A writer still holding the old revision must not overwrite the change
+			if _, err := store.CompareAndSwap(rev1, newSigningMetadata()); !errors.Is(err, ErrMetadataConflict) {
+				t.Errorf("stale swap: err = %v, want ErrMetadataConflict", err)
+			}
+			if _, err := store.CompareAndSwap("", newSigningMetadata()); !errors.Is(err, ErrMetadataConflict) {
+				t.Errorf("create over existing: err = %v, want ErrMetadataConflict", err)
+			}
+		})
+	}
+}
+
+func TestMetadataStore_Watch(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			rev, err := store.CompareAndSwap("", newSigningMetadata())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			changes := store.Watch(ctx, rev, 10*time.Millisecond)
+
+			meta, _, err := store.Load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			meta.Generation = 7
+			next, err := store.CompareAndSwap(rev, meta)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			select {
+			case got := <-changes:
+				if got != next {
+					t.Errorf("watched revision = %q, want %q", got, next)
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatal("no change notification")
+			}
+
+			cancel()
+			for range changes {
+			}
+		})
+	}
+}
+
+func TestMetadataStore_Backup(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			if _, err := store.CompareAndSwap("", newSigningMetadata()); err != nil {
+				t.Fatal(err)
+			}
+
+			backupPath, err := store.Backup(filepath.Join(t.TempDir(), "backups"))
+			if err != nil {
+				t.Fatalf("Backup() error = %v", err)
+			}
+
+			// Backups of every backend are plain YAML
+			backup, err := LoadMetadata(backupPath)
+			if err != nil {
+				t.Fatalf("backup is not loadable YAML: %v", err)
+			}
+			if backup.Rotation["exchange"].Current != "kek-exchange-v2" {
+				t.Errorf("backup current = %q", backup.Rotation["exchange"].Current)
+			}
+		})
+	}
+}
+
+func TestOpenMetadataStore(t *testing.T) {
+	store, err := OpenMetadataStore(&HSMConfig{MetadataFile: "/data/metadata.yaml"})
+	if err != nil || store.String() != "/data/metadata.yaml" {
+		t.Errorf("default store = %v, err = %v", store, err)
+	}
+
+	store, err = OpenMetadataStore(&HSMConfig{
+		MetadataFile:  "/data/metadata.yaml",
+		MetadataStore: MetadataStoreConfig{Backend: MetadataBackendBolt},
+	})
+	if err != nil || store.String() != "bolt:/data/metadata.db" {
+		t.Errorf("bolt store = %v, err = %v", store, err)
+	}
+
+	if err := (&MetadataStoreConfig{Backend: "sqlite"}).Validate(); err == nil {
+		t.Error("unknown backend accepted")
+	}
+}
@@ -0,0 +1,46 @@
+package config
+
+import "fmt"
+
+// CABundlePaths returns all trusted client CA bundles: ca_path followed by
+// ca_paths, without duplicates
+func (c *TLSConfig) CABundlePaths() []string {
+	seen := make(map[string]bool)
+	var paths []string
+	for _, path := range append([]string{c.CAPath}, c.CAPaths...) {
+		if path == "" || seen[path] {
+			continue
+		}
+		seen[path] = true
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+// GetExpiryWarningDays returns the certificate expiry warning threshold
+func (c *TLSConfig) GetExpiryWarningDays() int {
+	if c.ExpiryWarningDays == 0 {
+		return 30 // Default
+	}
+	return c.ExpiryWarningDays
+}
+
+// validateTLS checks the certificate, the key source and the client CA bundles
+func validateTLS(c *TLSConfig) error {
+	if c.CertPath == "" {
+		return fmt.Errorf("server.tls.cert_path is required")
+	}
+	switch {
+	case c.KeyPath == "" && c.KeyLabel == "":
+		return fmt.Errorf("server.tls.key_path or server.tls.key_label is required")
+	case c.KeyPath != "" && c.KeyLabel != "":
+		return fmt.Errorf("server.tls.key_path and server.tls.key_label are mutually exclusive")
+	}
+	if len(c.CABundlePaths()) == 0 {
+		return fmt.Errorf("server.tls.ca_path or server.tls.ca_paths is required")
+	}
+	if c.ExpiryWarningDays < 0 {
+		return fmt.Errorf("server.tls.expiry_warning_days must not be negative, got %d", c.ExpiryWarningDays)
+	}
+	return nil
+}
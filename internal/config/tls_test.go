@@ -0,0 +1,47 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTLSConfig_CABundlePaths(t *testing.T) {
+	c := TLSConfig{CAPath: "ca.crt", CAPaths: []string{"ca-new.crt", "ca.crt", ""}}
+	want := []string{"ca.crt", "ca-new.crt"}
+	if got := c.CABundlePaths(); !reflect.DeepEqual(got, want) {
+		t.Errorf("CABundlePaths() = %v, want %v", got, want)
+	}
+
+	// ca_paths alone is enough
+	if err := validateTLS(&TLSConfig{CertPath: "s.crt", KeyPath: "s.key", CAPaths: []string{"ca-new.crt"}}); err != nil {
+		t.Errorf("validateTLS with ca_paths only: %v", err)
+	}
+	if err := validateTLS(&TLSConfig{CertPath: "s.crt", KeyPath: "s.key"}); err == nil {
+		t.Error("validateTLS should require a CA bundle")
+	}
+	if err := validateTLS(&TLSConfig{CertPath: "s.crt", KeyPath: "s.key", CAPath: "ca.crt", ExpiryWarningDays: -1}); err == nil {
+		t.Error("validateTLS should reject negative expiry_warning_days")
+	}
+}
+
+func TestValidateTLS_KeySource(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     TLSConfig
+		wantErr bool
+	}{
+		{"key file", TLSConfig{CertPath: "s.crt", KeyPath: "s.key", CAPath: "ca.crt"}, false},
+		{"HSM key", TLSConfig{CertPath: "s.crt", KeyLabel: "tls-hsm-service", CAPath: "ca.crt"}, false},
+		{"no key", TLSConfig{CertPath: "s.crt", CAPath: "ca.crt"}, true},
+		{"both", TLSConfig{CertPath: "s.crt", KeyPath: "s.key", KeyLabel: "tls-hsm-service", CAPath: "ca.crt"}, true},
+		{"no cert", TLSConfig{KeyLabel: "tls-hsm-service", CAPath: "ca.crt"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateTLS(&tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateTLS() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
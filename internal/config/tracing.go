@@ -0,0 +1,88 @@
+package config
+
+import (
+	"fmt"
+	"net"
+)
+
+// OTLP transports
+const (
+	TracingProtocolGRPC = "grpc" // OTLP/gRPC (default port 4317)
+	TracingProtocolHTTP = "http" // OTLP/HTTP protobuf (default port 4318)
+)
+
+// TracingConfig defines OpenTelemetry tracing: spans are exported over OTLP
+// to a collector; W3C trace context of incoming requests is honored
+type TracingConfig struct {
+	Enabled     bool              `yaml:"enabled"`
+	Protocol    string            `yaml:"protocol"`     // grpc or http (default: grpc)
+	Endpoint    string            `yaml:"endpoint"`     // Collector host:port (default: localhost:4317, or 4318 for http)
+	Insecure    bool              `yaml:"insecure"`     // Plaintext connection to the collector
+	CAPath      string            `yaml:"ca_path"`      // CA of the collector (default: system roots)
+	Headers     map[string]string `yaml:"headers"`      // Sent with every export (e.g. authentication)
+	ServiceName string            `yaml:"service_name"` // service.name resource attribute (default: hsm-service)
+	SampleRatio *float64          `yaml:"sample_ratio"` // Fraction of new traces sampled, 0-1 (default: 0.1)
+	ParentBased *bool             `yaml:"parent_based"` // Follow the caller's sampling decision (default: true)
+}
+
+// GetProtocol returns the OTLP transport
+func (c *TracingConfig) GetProtocol() string {
+	if c.Protocol == "" {
+		return TracingProtocolGRPC
+	}
+	return c.Protocol
+}
+
+// GetEndpoint returns the collector address
+func (c *TracingConfig) GetEndpoint() string {
+	if c.Endpoint != "" {
+		return c.Endpoint
+	}
+	if c.GetProtocol() == TracingProtocolHTTP {
+		return "localhost:4318"
+	}
+	return "localhost:4317"
+}
+
+// GetServiceName returns the service.name of exported spans
+func (c *TracingConfig) GetServiceName() string {
+	if c.ServiceName == "" {
+		return "hsm-service"
+	}
+	return c.ServiceName
+}
+
+// GetSampleRatio returns the fraction of new traces sampled
+func (c *TracingConfig) GetSampleRatio() float64 {
+	if c.SampleRatio == nil {
+		return 0.1
+	}
+	return *c.SampleRatio
+}
+
+// GetParentBased reports whether a sampled caller forces sampling (and vice versa)
+func (c *TracingConfig) GetParentBased() bool {
+	return c.ParentBased == nil || *c.ParentBased
+}
+
+// Validate checks the protocol, endpoint and sampling settings
+func (c *TracingConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	switch c.GetProtocol() {
+	case TracingProtocolGRPC, TracingProtocolHTTP:
+	default:
+		return fmt.Errorf("protocol must be grpc or http, got %q", c.Protocol)
+	}
+	if _, _, err := net.SplitHostPort(c.GetEndpoint()); err != nil {
+		return fmt.Errorf("endpoint must be host:port: %w", err)
+	}
+	if c.Insecure && c.CAPath != "" {
+		return fmt.Errorf("ca_path and insecure are mutually exclusive")
+	}
+	if r := c.GetSampleRatio(); r < 0 || r > 1 {
+		return fmt.Errorf("sample_ratio must be between 0 and 1, got %g", r)
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package config
+
+import "testing"
+
+func TestTracingConfig_Validate(t *testing.T) {
+	c := &TracingConfig{Enabled: true}
+	if err := c.Validate(); err != nil {
+		t.Errorf("Validate() with defaults: %v", err)
+	}
+	if c.GetEndpoint() != "localhost:4317" || c.GetSampleRatio() != 0.1 || !c.GetParentBased() {
+		t.Errorf("unexpected defaults: %s %g %v", c.GetEndpoint(), c.GetSampleRatio(), c.GetParentBased())
+	}
+	if got := (&TracingConfig{Protocol: TracingProtocolHTTP}).GetEndpoint(); got != "localhost:4318" {
+		t.Errorf("GetEndpoint() for http = %s, want localhost:4318", got)
+	}
+
+	ratio := 1.5
+	for _, bad := range []TracingConfig{
+		{Enabled: true, Protocol: "zipkin"},
+		{Enabled: true, Endpoint: "collector"},
+		{Enabled: true, SampleRatio: &ratio},
+		{Enabled: true, Insecure: true, CAPath: "/etc/ca.crt"},
+	} {
+		if err := bad.Validate(); err == nil {
+			t.Errorf("Validate(%+v) should fail", bad)
+		}
+	}
+
+	// Settings are not checked while tracing is disabled
+	if err := (&TracingConfig{Protocol: "zipkin"}).Validate(); err != nil {
+		t.Errorf("Validate() disabled: %v", err)
+	}
+}
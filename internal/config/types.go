@@ -7,59 +7,83 @@ type Config struct {
 	Server    ServerConfig    `yaml:"server"`
 	HSM       HSMConfig       `yaml:"hsm"`
 	ACL       ACLConfig       `yaml:"acl"`
+	PKI       PKIConfig       `yaml:"pki"`
 	RateLimit RateLimitConfig `yaml:"rate_limit"`
 	Logging   LoggingConfig   `yaml:"logging"`
+	Audit     AuditConfig     `yaml:"audit"`
+	Tracing   TracingConfig   `yaml:"tracing"`
 }
 
 // ServerConfig defines HTTP server configuration
 type ServerConfig struct {
-	Port  string       `yaml:"port"`
-	TLS   TLSConfig    `yaml:"tls"`
-	HTTP2 *HTTP2Config `yaml:"http2,omitempty"` // HTTP/2 configuration (optional)
+	Port     string       `yaml:"port"`
+	TLS      TLSConfig    `yaml:"tls"`
+	HTTP2    *HTTP2Config `yaml:"http2,omitempty"` // HTTP/2 configuration (optional)
+	AdminOUs []string     `yaml:"admin_ous"`       // Client OUs allowed to call /admin/*, /audit/events and /pki/sign-csr (default: [Admin])
 }
 
 // TLSConfig defines TLS certificate paths
 type TLSConfig struct {
-	CertPath string `yaml:"cert_path"`
-	KeyPath  string `yaml:"key_path"`
-	CAPath   string `yaml:"ca_path"`
+	CertPath          string   `yaml:"cert_path"`
+	KeyPath           string   `yaml:"key_path"`
+	KeyLabel          string   `yaml:"key_label,omitempty"` // RSA/ECDSA key pair on the PKCS#11 token (instead of key_path)
+	CAPath            string   `yaml:"ca_path"`
+	CAPaths           []string `yaml:"ca_paths,omitempty"`  // Additional trusted client CA bundles (old + new CA during CA rotation)
+	ExpiryWarningDays int      `yaml:"expiry_warning_days"` // Warn N days before a server or CA certificate expires (default: 30)
 }
 
 // HSMConfig defines HSM/PKCS#11 configuration
 type HSMConfig struct {
-	PKCS11Lib        string               `yaml:"pkcs11_lib"`
-	SlotID           string               `yaml:"slot_id"`
-	PIN              string               `yaml:"pin"`
-	MetadataFile     string               `yaml:"metadata_file"`      // Path to metadata.yaml for rotation state
-	MaxVersions      int                  `yaml:"max_versions"`       // Maximum versions to keep (default: 3)
-	CleanupAfterDays int                  `yaml:"cleanup_after_days"` // Auto-cleanup versions older than N days (default: 30)
-	Keys             map[string]KeyConfig `yaml:"keys"`
+	PKCS11Lib          string                `yaml:"pkcs11_lib"`
+	SlotID             string                `yaml:"slot_id"`
+	Environment        string                `yaml:"environment"` // Value of {env} in key label templates
+	PIN                string                `yaml:"pin"`
+	MetadataFile       string                `yaml:"metadata_file"`         // Path to metadata.yaml for rotation state
+	MetadataBackupDir  string                `yaml:"metadata_backup_dir"`   // Metadata backups before each change (default: backups/ next to metadata_file)
+	MetadataStore      MetadataStoreConfig   `yaml:"metadata_store"`        // Metadata backend (default: file = metadata_file)
+	MaxVersions        int                   `yaml:"max_versions"`          // Maximum versions to keep (default: 3)
+	CleanupAfterDays   int                   `yaml:"cleanup_after_days"`    // Auto-cleanup versions older than N days (default: 30)
+	OverlapDays        int                   `yaml:"overlap_days"`          // Superseded versions cannot be destroyed for N days (default: 7)
+	RetentionDays      int                   `yaml:"retention_days"`        // Superseded versions move to disabled after N days (default: 0 = never)
+	AutoRotation       AutoRotationConfig    `yaml:"auto_rotation"`         // In-service rotation scheduler (opt-in)
+	UsageFile          string                `yaml:"usage_file"`            // Per-key-version usage statistics (default: key-usage.json next to metadata_file)
+	CleanupMinIdleDays int                   `yaml:"cleanup_min_idle_days"` // Cleanup refuses versions used within N days (default: 30)
+	MetadataSigning    MetadataSigningConfig `yaml:"metadata_signing"`      // HSM-authenticated metadata.yaml (opt-in)
+	Keys               map[string]KeyConfig  `yaml:"keys"`
 }
 
 // KeyConfig defines individual key configuration (static)
 type KeyConfig struct {
-	Type string `yaml:"type"` // "aes" or "rsa"
-	Mode string `yaml:"mode"` // "shared" (AAD=context+OU) or "private" (AAD=context+clientCN), default: "private"
+	Type          string `yaml:"type"`                     // "aes" or "rsa"
+	Mode          string `yaml:"mode"`                     // "shared" (AAD=context+OU) or "private" (AAD=context+clientCN), default: "private"
+	LabelTemplate string `yaml:"label_template,omitempty"` // Label of new versions, e.g. "kek-{context}-{env}-v{version}" (default: current label with -v<N> replaced)
 }
 
 // KeyVersion represents a single version of a key
 type KeyVersion struct {
-	Label     string     `yaml:"label"`
-	Version   int        `yaml:"version"`
-	CreatedAt *time.Time `yaml:"created_at"`
-	Checksum  string     `yaml:"checksum,omitempty"` // SHA-256 of key attributes (label+id) for integrity
+	Label          string     `yaml:"label"`
+	Version        int        `yaml:"version"`
+	CreatedAt      *time.Time `yaml:"created_at"`
+	Checksum       string     `yaml:"checksum,omitempty"`         // SHA-256 of key attributes (label+id) for integrity
+	State          KeyState   `yaml:"state,omitempty"`            // Lifecycle state (empty = active if current, else decrypt_only)
+	StateChangedAt *time.Time `yaml:"state_changed_at,omitempty"` // Last state transition
+	DestroyAfter   *time.Time `yaml:"destroy_after,omitempty"`    // End of waiting period (scheduled_for_destruction)
 }
 
 // KeyMetadata defines dynamic key rotation metadata
 type KeyMetadata struct {
 	Current              string       `yaml:"current"`                          // Current active version label
 	RotationIntervalDays int          `yaml:"rotation_interval_days,omitempty"` // Rotation interval in days (e.g., 90 for PCI DSS)
+	OverlapDays          int          `yaml:"overlap_days,omitempty"`           // Overrides hsm.overlap_days for this context
+	RetentionDays        int          `yaml:"retention_days,omitempty"`         // Overrides hsm.retention_days for this context
 	Versions             []KeyVersion `yaml:"versions"`                         // All versions (for overlap period)
 }
 
 // Metadata represents the metadata.yaml structure
 type Metadata struct {
-	Rotation map[string]KeyMetadata `yaml:"rotation"`
+	Generation uint64                 `yaml:"generation,omitempty"` // Incremented on every signed change (rollback protection)
+	Rotation   map[string]KeyMetadata `yaml:"rotation"`
+	Signature  string                 `yaml:"signature,omitempty"` // HMAC-SHA256 (hex) over CanonicalMetadata, computed in the HSM
 }
 
 // ACLConfig defines access control configuration
@@ -76,6 +100,9 @@ type RateLimitConfig struct {
 
 // LoggingConfig defines logging configuration
 type LoggingConfig struct {
-	Level  string `yaml:"level"`  // debug, info, warn, error
-	Format string `yaml:"format"` // json, text
+	Level           string              `yaml:"level"`  // debug, info, warn, error (default: info)
+	Format          string              `yaml:"format"` // json, text (default: json)
+	LogOutputConfig `yaml:",inline"`    // Service log destination
+	Audit           *LogOutputConfig    `yaml:"audit"`         // Separate destination for audit events (default: the service log)
+	AuditOutputs    []AuditOutputConfig `yaml:"audit_outputs"` // SIEM destinations for audit events (syslog, CEF, LEEF)
 }
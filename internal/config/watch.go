@@ -0,0 +1,127 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchDebounce collapses the burst of events of a single save
+// (write temp file, chmod, rename) into one check
+const watchDebounce = 100 * time.Millisecond
+
+// FileRevision returns a hash of the file content ("" if it does not exist)
+// Symlinks are followed, so a ConfigMap-style swap changes the revision.
+func FileRevision(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("read %s: %w", path, err)
+	}
+	return contentRevision(data), nil
+}
+
+// WatchFile sends the new FileRevision whenever the content of path changes
+// (starting at since; "" when the file is deleted) until ctx is done
+func WatchFile(ctx context.Context, path, since string, interval time.Duration) <-chan string {
+	return watchRevision(ctx, path, since, interval, func() (string, error) {
+		return FileRevision(path)
+	})
+}
+
+// watchRevision sends revision() whenever it changes. The directory of path
+// is watched with inotify rather than the file itself: editors and
+// WriteFileAtomic replace the file by rename, and Kubernetes ConfigMaps swap
+// a ..data symlink, both of which a watch on the old inode never sees.
+// Events only trigger a revision check; interval polling stays on as a
+// fallback for filesystems without inotify (NFS) and symlinks leaving the
+// directory. The channel holds at most one pending revision.
+func watchRevision(ctx context.Context, path, since string, interval time.Duration, revision func() (string, error)) <-chan string {
+	changes := make(chan string, 1)
+
+	dir, name := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+
+	var events <-chan fsnotify.Event
+	var errs <-chan error
+	watcher, err := fsnotify.NewWatcher()
+	if err == nil {
+		if err = watcher.Add(dir); err != nil {
+			watcher.Close()
+		}
+	}
+	if err != nil {
+		slog.Warn("file watch unavailable, polling only", "path", path, "interval", interval, "error", err)
+		watcher = nil
+	} else {
+		events = watcher.Events
+		errs = watcher.Errors
+	}
+
+	go func() {
+		defer close(changes)
+		if watcher != nil {
+			defer watcher.Close()
+		}
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		var debounce <-chan time.Time
+		last := since
+		check := func() {
+			current, err := revision()
+			if err != nil {
+				slog.Warn("file change check failed", "path", path, "error", err)
+				return
+			}
+			if current == last {
+				return
+			}
+			last = current
+			select {
+			case changes <- current:
+			default:
+			}
+		}
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok := <-events:
+				if !ok {
+					events = nil
+					continue
+				}
+				base := filepath.Base(event.Name)
+				if base == name || strings.HasPrefix(base, "..") {
+					debounce = time.After(watchDebounce)
+				}
+			case err, ok := <-errs:
+				if !ok {
+					errs = nil
+					continue
+				}
+				slog.Warn("file watch error", "path", path, "error", err)
+			case <-debounce:
+				debounce = nil
+				check()
+			case <-ticker.C:
+				check()
+			}
+		}
+	}()
+
+	return changes
+}
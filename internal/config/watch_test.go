@@ -0,0 +1,90 @@
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// waitRevision waits for the next change notification (polling is effectively off)
+func waitRevision(t *testing.T, changes <-chan string) string {
+	t.Helper()
+	select {
+	case rev := <-changes:
+		return rev
+	case <-time.After(2 * time.Second):
+		t.Fatal("no change notification")
+		return ""
+	}
+}
+
+func TestWatchFile_AtomicRename(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "revoked.yaml")
+	if err := os.WriteFile(path, []byte("revoked: []\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	since, _ := FileRevision(path)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changes := WatchFile(ctx, path, since, time.Hour)
+
+	// Same size, same second: an mtime comparison could miss this edit
+	if err := WriteFileAtomic(path, []byte("revoked: [1]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	want, _ := FileRevision(path)
+	if got := waitRevision(t, changes); got != want {
+		t.Errorf("revision = %q, want %q", got, want)
+	}
+
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if got := waitRevision(t, changes); got != "" {
+		t.Errorf("revision after delete = %q, want empty", got)
+	}
+}
+
+func TestWatchFile_SymlinkSwap(t *testing.T) {
+	// Kubernetes ConfigMap layout: revoked.yaml -> ..data/revoked.yaml,
+	// ..data -> ..<timestamp>; updates replace the ..data symlink
+	dir := t.TempDir()
+	for name, content := range map[string]string{"..v1": "revoked: []\n", "..v2": "revoked: [cn]\n"} {
+		if err := os.Mkdir(filepath.Join(dir, name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name, "revoked.yaml"), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("..v1", filepath.Join(dir, "..data")); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "revoked.yaml")
+	if err := os.Symlink(filepath.Join("..data", "revoked.yaml"), path); err != nil {
+		t.Fatal(err)
+	}
+	since, _ := FileRevision(path)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changes := WatchFile(ctx, path, since, time.Hour)
+
+	if err := os.Symlink("..v2", filepath.Join(dir, "..data_tmp")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(filepath.Join(dir, "..data_tmp"), filepath.Join(dir, "..data")); err != nil {
+		t.Fatal(err)
+	}
+
+	want, _ := FileRevision(path)
+	if want == since {
+		t.Fatal("test setup: revision unchanged after swap")
+	}
+	if got := waitRevision(t, changes); got != want {
+		t.Errorf("revision = %q, want %q", got, want)
+	}
+}
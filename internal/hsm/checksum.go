@@ -0,0 +1,118 @@
+package hsm
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ThalesGroup/crypto11"
+	"github.com/miekg/pkcs11"
+)
+
+// checksumPrefix marks checksums computed by ComputeKeyChecksum
+// (older metadata contains label-only SHA-256 checksums without prefix)
+const checksumPrefix = "v2:"
+
+// checksumDomain separates checksum digests from any other SHA-256 use
+const checksumDomain = "hsm-service/kek-checksum/v2\n"
+
+// ErrChecksumMismatch is returned when a key does not match its stored checksum
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// kcvNonce is the fixed GCM nonce for the key check value. Service
+// encryption uses random 96-bit nonces, so a collision is negligible.
+var kcvNonce = make([]byte, 12)
+
+// checksumAttributes are the key attributes covered by the checksum, in order
+var checksumAttributes = []uint{
+	pkcs11.CKA_ID,
+	pkcs11.CKA_KEY_TYPE,
+	pkcs11.CKA_VALUE_LEN,
+	pkcs11.CKA_SENSITIVE,
+	pkcs11.CKA_EXTRACTABLE,
+}
+
+// ComputeKeyChecksum computes the integrity checksum of a KEK:
+// a key check value (AES-GCM of a zero block with a fixed nonce, so it
+// depends on the key material) plus a digest of its security attributes.
+// Format: v2:<kcv, 6 hex>:<sha256 of kcv and attributes>
+func ComputeKeyChecksum(ctx *crypto11.Context, key *crypto11.SecretKey) (string, error) {
+	gcm, err := key.NewGCM()
+	if err != nil {
+		return "", fmt.Errorf("failed to create GCM: %w", err)
+	}
+	kcv := gcm.Seal(nil, kcvNonce, make([]byte, 16), nil)
+
+	attrs, err := ctx.GetAttributes(key, checksumAttributes)
+	if err != nil {
+		return "", fmt.Errorf("failed to read key attributes: %w", err)
+	}
+	ordered := make([]*pkcs11.Attribute, 0, len(checksumAttributes))
+	for _, t := range checksumAttributes {
+		attr, ok := attrs[t]
+		if !ok {
+			return "", fmt.Errorf("key attribute 0x%x not available", t)
+		}
+		ordered = append(ordered, attr)
+	}
+
+	return formatChecksum(kcv, ordered), nil
+}
+
+// formatChecksum encodes the KCV and the attribute digest
+func formatChecksum(kcv []byte, attrs []*pkcs11.Attribute) string {
+	h := sha256.New()
+	h.Write([]byte(checksumDomain))
+	writeField(h.Write, kcv)
+	for _, attr := range attrs {
+		var t [8]byte
+		binary.BigEndian.PutUint64(t[:], uint64(attr.Type))
+		h.Write(t[:])
+		writeField(h.Write, attr.Value)
+	}
+
+	return checksumPrefix + hex.EncodeToString(kcv[:3]) + ":" + hex.EncodeToString(h.Sum(nil))
+}
+
+// writeField writes a length-prefixed value
+func writeField(write func([]byte) (int, error), value []byte) {
+	var l [4]byte
+	binary.BigEndian.PutUint32(l[:], uint32(len(value)))
+	write(l[:])
+	write(value)
+}
+
+// IsLegacyChecksum reports whether a stored checksum is the old label-only format
+func IsLegacyChecksum(checksum string) bool {
+	return checksum != "" && !strings.HasPrefix(checksum, checksumPrefix)
+}
+
+// legacyChecksum is the old label-only checksum (detects label edits only)
+func legacyChecksum(label string) string {
+	h := sha256.Sum256([]byte(label))
+	return hex.EncodeToString(h[:])
+}
+
+// VerifyKeyChecksum compares a stored checksum with the key in the HSM
+// Label-only checksums are still accepted (legacy=true) until
+// 'hsm-admin update-checksums' migrates them
+func VerifyKeyChecksum(ctx *crypto11.Context, label string, key *crypto11.SecretKey, expected string) (legacy bool, err error) {
+	if IsLegacyChecksum(expected) {
+		if legacyChecksum(label) != expected {
+			return true, ErrChecksumMismatch
+		}
+		return true, nil
+	}
+
+	computed, err := ComputeKeyChecksum(ctx, key)
+	if err != nil {
+		return false, err
+	}
+	if computed != expected {
+		return false, fmt.Errorf("%w (expected %s, got %s)", ErrChecksumMismatch, expected, computed)
+	}
+	return false, nil
+}
@@ -0,0 +1,62 @@
+package hsm
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/miekg/pkcs11"
+)
+
+func testChecksumAttributes(extractable bool) []*pkcs11.Attribute {
+	return []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_ID, []byte{0x01, 0x02}),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_AES),
+		pkcs11.NewAttribute(pkcs11.CKA_VALUE_LEN, 32),
+		pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, extractable),
+	}
+}
+
+func TestFormatChecksum(t *testing.T) {
+	kcv := []byte{0xab, 0xcd, 0xef, 0x01, 0x02}
+	sum := formatChecksum(kcv, testChecksumAttributes(false))
+
+	if !strings.HasPrefix(sum, "v2:abcdef:") {
+		t.Errorf("checksum = %s, want prefix v2:abcdef:", sum)
+	}
+	if sum != formatChecksum(kcv, testChecksumAttributes(false)) {
+		t.Error("checksum is not deterministic")
+	}
+	if IsLegacyChecksum(sum) {
+		t.Error("new checksum detected as legacy")
+	}
+
+	// Different key material (KCV) must change the checksum
+	if sum == formatChecksum([]byte{0xab, 0xcd, 0xef, 0x01, 0x03}, testChecksumAttributes(false)) {
+		t.Error("checksum does not cover the full KCV")
+	}
+	// Weakened attributes must change the checksum
+	if sum == formatChecksum(kcv, testChecksumAttributes(true)) {
+		t.Error("checksum does not cover CKA_EXTRACTABLE")
+	}
+}
+
+func TestVerifyKeyChecksum_Legacy(t *testing.T) {
+	label := "kek-exchange-v1"
+	stored := legacyChecksum(label)
+
+	if !IsLegacyChecksum(stored) {
+		t.Fatal("label-only checksum not detected as legacy")
+	}
+
+	legacy, err := VerifyKeyChecksum(nil, label, nil, stored)
+	if err != nil || !legacy {
+		t.Errorf("legacy = %v, err = %v, want legacy match", legacy, err)
+	}
+
+	legacy, err = VerifyKeyChecksum(nil, "kek-exchange-v2", nil, stored)
+	if !legacy || !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("legacy = %v, err = %v, want ErrChecksumMismatch", legacy, err)
+	}
+}
@@ -4,6 +4,9 @@ import (
 	"crypto/rand"
 	"crypto/sha256"
 	"errors"
+	"fmt"
+
+	"github.com/titaev-lv/hsm-service/internal/config"
 )
 
 var (
@@ -15,8 +18,26 @@ var (
 
 	// ErrDecryptionFailed is returned when decryption fails (AAD mismatch or corrupted data)
 	ErrDecryptionFailed = errors.New("decryption failed")
+
+	// ErrKeyDisabled is returned when the key version's lifecycle state forbids the operation
+	ErrKeyDisabled = errors.New("key version disabled")
 )
 
+// KeyStateError is returned when a key version's lifecycle state refuses the
+// operation (matches ErrKeyDisabled)
+type KeyStateError struct {
+	Label string
+	State config.KeyState
+}
+
+func (e *KeyStateError) Error() string {
+	return fmt.Sprintf("%v: %s is %s", ErrKeyDisabled, e.Label, e.State)
+}
+
+func (e *KeyStateError) Unwrap() error {
+	return ErrKeyDisabled
+}
+
 // ReadRandom fills the buffer with cryptographically secure random bytes
 func ReadRandom(buf []byte) (int, error) {
 	return rand.Read(buf)
@@ -1,16 +1,20 @@
 package hsm
 
+import "context"
+
 // CryptoProvider is the interface for encryption/decryption operations
 type CryptoProvider interface {
 	// Encrypt encrypts plaintext, returns ciphertext and key label
+	// ctx: request context (carries the trace span)
 	// ou: organizational unit from client certificate (for shared mode)
 	// clientCN: common name from client certificate (for private mode)
-	Encrypt(plaintext []byte, context, ou, clientCN string) (ciphertext []byte, keyLabel string, err error)
+	Encrypt(ctx context.Context, plaintext []byte, keyContext, ou, clientCN string) (ciphertext []byte, keyLabel string, err error)
 
 	// Decrypt decrypts ciphertext using the specified key label
+	// ctx: request context (carries the trace span)
 	// ou: organizational unit from client certificate (for shared mode)
 	// clientCN: common name from client certificate (for private mode)
-	Decrypt(ciphertext []byte, context, ou, clientCN, keyLabel string) ([]byte, error)
+	Decrypt(ctx context.Context, ciphertext []byte, keyContext, ou, clientCN, keyLabel string) ([]byte, error)
 
 	// GetKeyLabels returns all available key labels
 	GetKeyLabels() []string
@@ -19,11 +23,14 @@ type CryptoProvider interface {
 	HasKey(label string) bool
 
 	// GetKeyLabelByContext returns the current key label for a context
-	GetKeyLabelByContext(context string) (string, error)
+	GetKeyLabelByContext(keyContext string) (string, error)
 
 	// GetKeyMetadata returns metadata for a key
 	GetKeyMetadata(label string) (*KeyMetadata, error)
 
 	// GetKeysNeedingRotation returns keys that need rotation
 	GetKeysNeedingRotation() []string
+
+	// GetKeyUsage returns usage statistics for a key version
+	GetKeyUsage(label string) KeyUsage
 }
@@ -0,0 +1,80 @@
+package hsm
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/ThalesGroup/crypto11"
+)
+
+// KEKSizeBits is the size of AES key-encryption keys generated on the token
+const KEKSizeBits = 256
+
+// GenerateKEK generates a new AES-256 KEK inside the HSM.
+// The key is token-resident, sensitive and non-extractable, so the key
+// material never leaves the token. CKA_ID is derived from the current
+// timestamp (same scheme as cmd/create-kek).
+func GenerateKEK(ctx *crypto11.Context, label string) (*crypto11.SecretKey, error) {
+	if label == "" {
+		return nil, fmt.Errorf("KEK label is required")
+	}
+
+	// Refuse to create a second object with the same label
+	existing, err := ctx.FindKey(nil, []byte(label))
+	if err != nil {
+		return nil, fmt.Errorf("failed to search for existing key %s: %w", label, err)
+	}
+	if existing != nil {
+		return nil, fmt.Errorf("key with label %s already exists in HSM", label)
+	}
+
+	id := make([]byte, 8)
+	binary.BigEndian.PutUint64(id, uint64(time.Now().UnixNano()))
+
+	key, err := ctx.GenerateSecretKeyWithLabel(id, []byte(label), KEKSizeBits, crypto11.CipherAES)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate KEK %s: %w", label, err)
+	}
+
+	return key, nil
+}
+
+// VerifyKEK checks that a freshly generated KEK is usable by performing
+// an AES-GCM encrypt/decrypt round trip through the HSM.
+func VerifyKEK(key *crypto11.SecretKey) error {
+	gcm, err := key.NewGCM()
+	if err != nil {
+		return fmt.Errorf("failed to create GCM: %w", err)
+	}
+	return VerifyAEAD(gcm)
+}
+
+// VerifyAEAD performs an encrypt/decrypt round trip with a random probe
+// and fails if the decrypted data does not match.
+func VerifyAEAD(aead cipher.AEAD) error {
+	probe := make([]byte, 32)
+	if _, err := ReadRandom(probe); err != nil {
+		return fmt.Errorf("failed to generate probe: %w", err)
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := ReadRandom(nonce); err != nil {
+		return fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	aad := []byte("hsm-kek-verify")
+	sealed := aead.Seal(nil, nonce, probe, aad)
+
+	opened, err := aead.Open(nil, nonce, sealed, aad)
+	if err != nil {
+		return fmt.Errorf("round trip decrypt failed: %w", err)
+	}
+	if !bytes.Equal(opened, probe) {
+		return fmt.Errorf("round trip mismatch")
+	}
+
+	return nil
+}
@@ -0,0 +1,47 @@
+package hsm
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"testing"
+)
+
+// brokenAEAD corrupts ciphertext on Seal to simulate a faulty key
+type brokenAEAD struct {
+	cipher.AEAD
+}
+
+func (b brokenAEAD) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	out := b.AEAD.Seal(dst, nonce, plaintext, additionalData)
+	out[0] ^= 0xff
+	return out
+}
+
+func newTestGCM(t *testing.T) cipher.AEAD {
+	t.Helper()
+	key := make([]byte, 32)
+	if _, err := ReadRandom(key); err != nil {
+		t.Fatal(err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return gcm
+}
+
+func TestVerifyAEAD(t *testing.T) {
+	if err := VerifyAEAD(newTestGCM(t)); err != nil {
+		t.Errorf("VerifyAEAD() on valid cipher error = %v", err)
+	}
+}
+
+func TestVerifyAEAD_Broken(t *testing.T) {
+	if err := VerifyAEAD(brokenAEAD{newTestGCM(t)}); err == nil {
+		t.Error("VerifyAEAD() on broken cipher should fail")
+	}
+}
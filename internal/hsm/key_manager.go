@@ -2,15 +2,18 @@ package hsm
 
 import (
 	"context"
+	"crypto"
 	"crypto/cipher"
 	"fmt"
 	"log/slog"
-	"os"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/ThalesGroup/crypto11"
 	"github.com/titaev-lv/hsm-service/internal/config"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // KeyManager manages HSM keys with hot reload capability
@@ -22,13 +25,22 @@ type KeyManager struct {
 	keys           map[string]cipher.AEAD  // label -> GCM cipher
 	contextToLabel map[string]string       // context -> current label
 	metadata       map[string]*KeyMetadata // label -> metadata
+	destroyed      map[string]bool         // labels of destroyed versions (decrypt refused)
 	mu             sync.RWMutex
 
-	// Metadata file tracking
-	metadataFile string
-	lastModTime  time.Time
-	hsmConfig    *config.HSMConfig
-	config       *config.Config // Full config for accessing key modes
+	// Metadata store tracking
+	store     config.MetadataStore
+	revision  string // Revision of the loaded metadata (protected by mu)
+	hsmConfig *config.HSMConfig
+	config    *config.Config // Full config for accessing key modes
+
+	// Per-key-version usage statistics (persisted on each reload tick)
+	usage *UsageTracker
+
+	// Metadata authentication (nil MAC: signing disabled)
+	metadataMAC    config.MetadataMAC
+	generation     uint64 // Highest accepted metadata generation (protected by mu)
+	generationFile string
 
 	// Auto-reload control
 	stopReload chan struct{}
@@ -36,14 +48,49 @@ type KeyManager struct {
 	stopOnce   sync.Once
 }
 
-// NewKeyManager creates a new KeyManager with initial state
-func NewKeyManager(ctx *crypto11.Context, cfg *config.Config, metadata *config.Metadata) (*KeyManager, error) {
+// NewKeyManager creates a new KeyManager with initial state from store
+func NewKeyManager(ctx *crypto11.Context, cfg *config.Config, store config.MetadataStore) (*KeyManager, error) {
 	km := &KeyManager{
-		ctx:          ctx,
-		metadataFile: cfg.HSM.MetadataFile,
-		hsmConfig:    &cfg.HSM,
-		config:       cfg, // Store full config
-		stopReload:   make(chan struct{}),
+		ctx:        ctx,
+		store:      store,
+		hsmConfig:  &cfg.HSM,
+		config:     cfg, // Store full config
+		stopReload: make(chan struct{}),
+	}
+
+	metadata, revision, err := store.Load()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load metadata: %w", err)
+	}
+
+	// Load usage statistics (a corrupt file must not block startup)
+	usage, err := NewUsageTracker(cfg.HSM.UsageFilePath())
+	if err != nil {
+		slog.Warn("failed to load key usage file, starting with empty statistics",
+			"path", cfg.HSM.UsageFilePath(),
+			"error", err)
+		usage, _ = NewUsageTracker(cfg.HSM.UsageFilePath())
+		usage.dirty = true // overwrite the corrupt file on next flush
+	}
+	km.usage = usage
+
+	// Verify metadata signature and generation (opt-in)
+	if cfg.HSM.MetadataSigning.Enabled {
+		mac, err := NewMetadataMAC(ctx, cfg.HSM.MetadataSigning.GetKeyLabel())
+		if err != nil {
+			return nil, err
+		}
+		km.metadataMAC = mac
+		km.generationFile = cfg.HSM.MetadataGenerationFilePath()
+
+		minGeneration, err := config.LoadGeneration(km.generationFile)
+		if err != nil {
+			return nil, err
+		}
+		if err := config.VerifyMetadata(metadata, mac, minGeneration); err != nil {
+			return nil, fmt.Errorf("metadata authentication failed: %w", err)
+		}
+		km.generation = minGeneration
 	}
 
 	// Load initial state
@@ -51,10 +98,9 @@ func NewKeyManager(ctx *crypto11.Context, cfg *config.Config, metadata *config.M
 		return nil, fmt.Errorf("failed to load initial keys: %w", err)
 	}
 
-	// Set initial modTime
-	if info, err := os.Stat(km.metadataFile); err == nil {
-		km.lastModTime = info.ModTime()
-	}
+	km.acceptGeneration(metadata.Generation)
+	km.setRevision(revision)
+	MetadataLastReloadSuccess.SetToCurrentTime()
 
 	return km, nil
 }
@@ -64,6 +110,7 @@ func (km *KeyManager) loadKeys(metadata *config.Metadata) error {
 	newKeys := make(map[string]cipher.AEAD)
 	newContextToLabel := make(map[string]string)
 	newMetadata := make(map[string]*KeyMetadata)
+	newDestroyed := make(map[string]bool)
 
 	for context, keyConfig := range km.hsmConfig.Keys {
 		if keyConfig.Type != "aes" {
@@ -79,8 +126,21 @@ func (km *KeyManager) loadKeys(metadata *config.Metadata) error {
 		// Save context -> current label mapping
 		newContextToLabel[context] = meta.Current
 
+		// Current version must be active
+		if cur := findVersion(meta.Versions, meta.Current); cur != nil {
+			if state := cur.EffectiveState(meta.Current); state != config.KeyStateActive {
+				return fmt.Errorf("current KEK %s is in state %s, expected %s", meta.Current, state, config.KeyStateActive)
+			}
+		}
+
 		// Load all versions of the key
 		for _, version := range meta.Versions {
+			// Destroyed versions no longer exist in the HSM
+			if version.EffectiveState(meta.Current) == config.KeyStateDestroyed {
+				newDestroyed[version.Label] = true
+				continue
+			}
+
 			// Find key by label
 			secretKey, err := km.ctx.FindKey(nil, []byte(version.Label))
 			if err != nil {
@@ -97,13 +157,18 @@ func (km *KeyManager) loadKeys(metadata *config.Metadata) error {
 
 			// Verify checksum if available
 			if version.Checksum != "" {
-				computedChecksum := computeKeyChecksum(version.Label, secretKey)
-				if computedChecksum != version.Checksum {
-					return fmt.Errorf("KEK integrity verification failed for %s: checksum mismatch", version.Label)
+				legacy, err := VerifyKeyChecksum(km.ctx, version.Label, secretKey, version.Checksum)
+				if err != nil {
+					return fmt.Errorf("KEK integrity verification failed for %s: %w", version.Label, err)
+				}
+				if legacy {
+					slog.Warn("label-only KEK checksum cannot detect key substitution, run 'hsm-admin update-checksums'",
+						"label", version.Label)
+				} else {
+					slog.Info("KEK integrity verified",
+						"label", version.Label,
+						"checksum", version.Checksum[:9])
 				}
-				slog.Info("KEK integrity verified",
-					"label", version.Label,
-					"checksum", computedChecksum[:8])
 			}
 
 			// Create GCM cipher
@@ -118,29 +183,14 @@ func (km *KeyManager) loadKeys(metadata *config.Metadata) error {
 			// Cache the GCM cipher
 			newKeys[version.Label] = gcm
 
-			// Store metadata
-			createdAt := time.Now()
-			if version.CreatedAt != nil {
-				createdAt = *version.CreatedAt
-			}
-
-			// Get rotation interval from metadata (with fallback to 90 days)
-			rotationIntervalDays := meta.RotationIntervalDays
-			if rotationIntervalDays == 0 {
-				rotationIntervalDays = 90 // Default: 90 days (PCI DSS compliant)
-			}
-			rotationInterval := time.Duration(rotationIntervalDays) * 24 * time.Hour
-
-			newMetadata[version.Label] = &KeyMetadata{
-				Label:            version.Label,
-				Version:          version.Version,
-				CreatedAt:        createdAt,
-				RotationInterval: rotationInterval,
-			}
+			// Store metadata (rotation interval from metadata, default 90 days)
+			newMetadata[version.Label] = versionMetadata(meta, version)
+			newMetadata[version.Label].Context = context
 
 			slog.Info("Loaded KEK",
 				"label", version.Label,
-				"version", version.Version)
+				"version", version.Version,
+				"state", newMetadata[version.Label].State)
 		}
 
 		// Ensure current version was loaded
@@ -158,71 +208,106 @@ func (km *KeyManager) loadKeys(metadata *config.Metadata) error {
 	km.keys = newKeys
 	km.contextToLabel = newContextToLabel
 	km.metadata = newMetadata
+	km.destroyed = newDestroyed
 	km.mu.Unlock()
 
+	km.updateKeyMetrics(time.Now())
+
 	return nil
 }
 
-// StartAutoReload starts automatic metadata reload
+// updateKeyMetrics refreshes the key age, rotation due and loaded versions
+// gauges (on load and on every reload tick)
+func (km *KeyManager) updateKeyMetrics(now time.Time) {
+	km.mu.RLock()
+	defer km.mu.RUnlock()
+
+	versions := make(map[string]int)
+	for _, meta := range km.metadata {
+		versions[meta.Context]++
+	}
+
+	for context, label := range km.contextToLabel {
+		KeyVersionsLoaded.WithLabelValues(context).Set(float64(versions[context]))
+
+		meta, ok := km.metadata[label]
+		if !ok {
+			continue
+		}
+		KeyAge.WithLabelValues(context).Set(now.Sub(meta.CreatedAt).Seconds())
+		if meta.RotationInterval > 0 {
+			KeyRotationDue.WithLabelValues(context).Set(meta.CreatedAt.Add(meta.RotationInterval).Sub(now).Seconds())
+		}
+	}
+}
+
+// StartAutoReload watches the metadata store and reloads keys on changes
 func (km *KeyManager) StartAutoReload(interval time.Duration) {
+	watchCtx, cancelWatch := context.WithCancel(context.Background())
+	changes := km.store.Watch(watchCtx, km.loadedRevision(), interval)
+
 	km.reloadWg.Add(1)
 	go func() {
 		defer km.reloadWg.Done()
+		defer cancelWatch()
 
 		ticker := time.NewTicker(interval)
 		defer ticker.Stop()
 
+		var lastRetentionCheck time.Time
 		for {
 			select {
+			case revision, ok := <-changes:
+				if !ok {
+					changes = nil
+					continue
+				}
+				// Changes written by this process were already reloaded
+				if revision == km.loadedRevision() {
+					continue
+				}
+				slog.Info("metadata changed", "store", km.store.String(), "revision", revision)
+				if err := km.ReloadMetadata(); err != nil {
+					slog.Error("metadata reload failed", "error", err)
+				}
 			case <-ticker.C:
-				if km.metadataChanged() {
-					if err := km.ReloadMetadata(); err != nil {
-						slog.Error("metadata reload failed", "error", err)
-					}
+				km.flushUsage()
+				km.updateKeyMetrics(time.Now())
+				if time.Since(lastRetentionCheck) >= retentionCheckInterval {
+					km.enforceRetention()
+					lastRetentionCheck = time.Now()
 				}
 			case <-km.stopReload:
 				slog.Info("Stopping metadata auto-reload")
+				km.flushUsage()
 				return
 			}
 		}
 	}()
 
-	slog.Info("Started metadata auto-reload", "interval", interval)
+	slog.Info("Started metadata auto-reload", "store", km.store.String(), "interval", interval)
 }
 
-// metadataChanged checks if metadata file has been modified
-func (km *KeyManager) metadataChanged() bool {
-	info, err := os.Stat(km.metadataFile)
-	if err != nil {
-		if os.IsNotExist(err) {
-			slog.Warn("metadata file not found", "path", km.metadataFile)
-		}
-		return false
-	}
-
-	if info.ModTime().After(km.lastModTime) {
-		km.lastModTime = info.ModTime()
-		slog.Info("metadata file changed", "path", km.metadataFile)
-		return true
-	}
-
-	return false
-}
-
-// ReloadMetadata reloads metadata and keys from file
+// ReloadMetadata reloads metadata and keys from the metadata store
 func (km *KeyManager) ReloadMetadata() error {
-	// 1. Load new metadata from file
-	newMetadata, err := config.LoadMetadata(km.metadataFile)
+	// 1. Load new metadata from the store
+	// (unsigned, tampered or rolled-back metadata is refused when signing is enabled)
+	newMetadata, revision, err := config.LoadVerifiedMetadata(km.store, km.metadataMAC, km.acceptedGeneration())
 	if err != nil {
 		slog.Warn("metadata reload skipped due to load error", "error", err)
+		RecordMetadataReload(err)
 		return err
 	}
 
 	// 2. Load keys with new metadata (validates before applying)
 	if err := km.loadKeys(newMetadata); err != nil {
 		slog.Error("failed to load keys from new metadata", "error", err)
+		RecordMetadataReload(err)
 		return err
 	}
+	km.acceptGeneration(newMetadata.Generation)
+	km.setRevision(revision)
+	RecordMetadataReload(nil)
 
 	slog.Info("KEK hot reload successful",
 		"contexts", len(km.contextToLabel),
@@ -231,6 +316,47 @@ func (km *KeyManager) ReloadMetadata() error {
 	return nil
 }
 
+// loadedRevision returns the store revision of the loaded metadata
+func (km *KeyManager) loadedRevision() string {
+	km.mu.RLock()
+	defer km.mu.RUnlock()
+	return km.revision
+}
+
+// setRevision records the store revision of the loaded metadata
+func (km *KeyManager) setRevision(revision string) {
+	km.mu.Lock()
+	km.revision = revision
+	km.mu.Unlock()
+}
+
+// acceptedGeneration returns the highest accepted metadata generation
+func (km *KeyManager) acceptedGeneration() uint64 {
+	km.mu.RLock()
+	defer km.mu.RUnlock()
+	return km.generation
+}
+
+// acceptGeneration records a newly accepted metadata generation, so that
+// older (validly signed) copies are refused, also after a restart
+func (km *KeyManager) acceptGeneration(generation uint64) {
+	if km.metadataMAC == nil {
+		return
+	}
+
+	km.mu.Lock()
+	if generation <= km.generation {
+		km.mu.Unlock()
+		return
+	}
+	km.generation = generation
+	km.mu.Unlock()
+
+	if err := config.SaveGeneration(km.generationFile, generation); err != nil {
+		slog.Warn("failed to record metadata generation", "path", km.generationFile, "error", err)
+	}
+}
+
 // StopAutoReload stops the auto-reload goroutine gracefully
 func (km *KeyManager) StopAutoReload(ctx context.Context) error {
 	km.stopOnce.Do(func() {
@@ -254,20 +380,23 @@ func (km *KeyManager) StopAutoReload(ctx context.Context) error {
 }
 
 // Encrypt encrypts plaintext using the current active key for the context
-func (km *KeyManager) Encrypt(plaintext []byte, context, ou, clientCN string) (ciphertext []byte, keyLabel string, err error) {
+func (km *KeyManager) Encrypt(ctx context.Context, plaintext []byte, keyContext, ou, clientCN string) (ciphertext []byte, keyLabel string, err error) {
+	ctx, span := tracer.Start(ctx, "KeyManager.Encrypt", trace.WithAttributes(attribute.String("hsm.context", keyContext)))
+	defer func() { endSpan(span, err) }()
+
 	// Get current key label for context
 	km.mu.RLock()
-	label, exists := km.contextToLabel[context]
+	label, exists := km.contextToLabel[keyContext]
 	km.mu.RUnlock()
 
 	if !exists {
-		return nil, "", fmt.Errorf("no key configured for context: %s", context)
+		return nil, "", fmt.Errorf("no key configured for context: %s", keyContext)
 	}
 
 	// Get key mode from config
-	keyConfig, exists := km.config.HSM.Keys[context]
+	keyConfig, exists := km.config.HSM.Keys[keyContext]
 	if !exists {
-		return nil, "", fmt.Errorf("no key configured for context: %s", context)
+		return nil, "", fmt.Errorf("no key configured for context: %s", keyContext)
 	}
 
 	// Get GCM cipher
@@ -280,40 +409,69 @@ func (km *KeyManager) Encrypt(plaintext []byte, context, ou, clientCN string) (c
 	}
 
 	// Build AAD based on mode
-	aad := BuildAAD(context, ou, clientCN, keyConfig.Mode)
+	aad := BuildAAD(keyContext, ou, clientCN, keyConfig.Mode)
 
 	// Generate nonce
 	nonce := make([]byte, gcm.NonceSize())
 	if _, err := ReadRandom(nonce); err != nil {
+		KeyOperationFailuresTotal.WithLabelValues(label, "encrypt").Inc()
 		return nil, "", fmt.Errorf("failed to generate nonce: %w", err)
 	}
 
-	// Encrypt
+	// Encrypt (on the token: separate span to tell PKCS#11 time from the rest)
+	span.SetAttributes(attribute.String("hsm.key_label", label))
+	_, seal := tracer.Start(ctx, "pkcs11.Seal", trace.WithAttributes(attribute.Int("hsm.payload_bytes", len(plaintext))))
+	start := time.Now()
 	ciphertext = gcm.Seal(nonce, nonce, plaintext, aad)
+	PKCS11OperationDuration.WithLabelValues("seal").Observe(time.Since(start).Seconds())
+	seal.End()
+
+	if km.usage != nil {
+		km.usage.RecordEncrypt(label)
+	}
 
 	return ciphertext, label, nil
 }
 
 // Decrypt decrypts ciphertext using the specified key label
-func (km *KeyManager) Decrypt(ciphertext []byte, context, ou, clientCN, keyLabel string) ([]byte, error) {
+func (km *KeyManager) Decrypt(ctx context.Context, ciphertext []byte, keyContext, ou, clientCN, keyLabel string) (plaintext []byte, err error) {
+	ctx, span := tracer.Start(ctx, "KeyManager.Decrypt", trace.WithAttributes(
+		attribute.String("hsm.context", keyContext),
+		attribute.String("hsm.key_label", keyLabel),
+	))
+	defer func() { endSpan(span, err) }()
+
 	// Get key mode from config
-	keyConfig, exists := km.config.HSM.Keys[context]
+	keyConfig, exists := km.config.HSM.Keys[keyContext]
 	if !exists {
-		return nil, fmt.Errorf("no key configured for context: %s", context)
+		return nil, fmt.Errorf("no key configured for context: %s", keyContext)
 	}
 
-	// Get GCM cipher
+	// Get GCM cipher and lifecycle state
 	km.mu.RLock()
 	gcm, exists := km.keys[keyLabel]
+	meta := km.metadata[keyLabel]
+	destroyed := km.destroyed[keyLabel]
 	km.mu.RUnlock()
 
 	if !exists {
+		if destroyed {
+			KeyOperationFailuresTotal.WithLabelValues(keyLabel, "decrypt").Inc()
+			return nil, &KeyStateError{Label: keyLabel, State: config.KeyStateDestroyed}
+		}
 		return nil, fmt.Errorf("%w: %s", ErrKeyNotFound, keyLabel)
 	}
 
+	// Enforce lifecycle state (disabled / scheduled for destruction refuse decrypt)
+	if meta != nil && !meta.State.CanDecrypt() {
+		KeyOperationFailuresTotal.WithLabelValues(keyLabel, "decrypt").Inc()
+		return nil, &KeyStateError{Label: keyLabel, State: meta.State}
+	}
+
 	// Validate ciphertext length
 	nonceSize := gcm.NonceSize()
 	if len(ciphertext) < nonceSize {
+		KeyOperationFailuresTotal.WithLabelValues(keyLabel, "decrypt").Inc()
 		return nil, ErrInvalidCiphertext
 	}
 
@@ -322,14 +480,23 @@ func (km *KeyManager) Decrypt(ciphertext []byte, context, ou, clientCN, keyLabel
 	encrypted := ciphertext[nonceSize:]
 
 	// Build AAD based on mode
-	aad := BuildAAD(context, ou, clientCN, keyConfig.Mode)
-
-	// Decrypt
-	plaintext, err := gcm.Open(nil, nonce, encrypted, aad)
+	aad := BuildAAD(keyContext, ou, clientCN, keyConfig.Mode)
+
+	// Decrypt (on the token)
+	_, open := tracer.Start(ctx, "pkcs11.Open", trace.WithAttributes(attribute.Int("hsm.payload_bytes", len(encrypted))))
+	start := time.Now()
+	plaintext, err = gcm.Open(nil, nonce, encrypted, aad)
+	PKCS11OperationDuration.WithLabelValues("open").Observe(time.Since(start).Seconds())
+	endSpan(open, err)
 	if err != nil {
+		KeyOperationFailuresTotal.WithLabelValues(keyLabel, "decrypt").Inc()
 		return nil, fmt.Errorf("%w: %v", ErrDecryptionFailed, err)
 	}
 
+	if km.usage != nil {
+		km.usage.RecordDecrypt(keyLabel)
+	}
+
 	return plaintext, nil
 }
 
@@ -355,13 +522,13 @@ func (km *KeyManager) HasKey(label string) bool {
 }
 
 // GetKeyLabelByContext returns the current key label for a context
-func (km *KeyManager) GetKeyLabelByContext(context string) (string, error) {
+func (km *KeyManager) GetKeyLabelByContext(keyContext string) (string, error) {
 	km.mu.RLock()
 	defer km.mu.RUnlock()
 
-	label, exists := km.contextToLabel[context]
+	label, exists := km.contextToLabel[keyContext]
 	if !exists {
-		return "", fmt.Errorf("no key configured for context: %s", context)
+		return "", fmt.Errorf("no key configured for context: %s", keyContext)
 	}
 	return label, nil
 }
@@ -378,6 +545,39 @@ func (km *KeyManager) GetKeyMetadata(label string) (*KeyMetadata, error) {
 	return meta, nil
 }
 
+// GetContextsNeedingRotation returns contexts whose current key is past its rotation interval
+func (km *KeyManager) GetContextsNeedingRotation() []string {
+	km.mu.RLock()
+	defer km.mu.RUnlock()
+
+	var contexts []string
+	for context, label := range km.contextToLabel {
+		if meta, ok := km.metadata[label]; ok && meta.NeedsRotation() {
+			contexts = append(contexts, context)
+		}
+	}
+	sort.Strings(contexts)
+	return contexts
+}
+
+// GetKeyUsage returns usage statistics for a key version
+func (km *KeyManager) GetKeyUsage(label string) KeyUsage {
+	if km.usage == nil {
+		return KeyUsage{}
+	}
+	return km.usage.Get(label)
+}
+
+// flushUsage persists usage statistics, logging failures
+func (km *KeyManager) flushUsage() {
+	if km.usage == nil {
+		return
+	}
+	if err := km.usage.Flush(); err != nil {
+		slog.Warn("failed to persist key usage", "error", err)
+	}
+}
+
 // GetKeysNeedingRotation returns keys that need rotation
 func (km *KeyManager) GetKeysNeedingRotation() []string {
 	km.mu.RLock()
@@ -392,8 +592,17 @@ func (km *KeyManager) GetKeysNeedingRotation() []string {
 	return needsRotation
 }
 
-// Close closes the underlying PKCS#11 context
+// Signer returns the signing key pair labelled label from the token
+// (server.tls.key_label, pki.ca_key_label). Looked up on every call, so a
+// key pair replaced under the same label is picked up on reload.
+func (km *KeyManager) Signer(label string) (crypto.Signer, error) {
+	return FindSigner(km.ctx, label)
+}
+
+// Close persists usage statistics and closes the underlying PKCS#11 context
+// (in-process users such as hsm-admin reencrypt never run the reload loop)
 func (km *KeyManager) Close() error {
+	km.flushUsage()
 	if km.ctx != nil {
 		return km.ctx.Close()
 	}
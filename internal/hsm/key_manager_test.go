@@ -2,10 +2,14 @@ package hsm
 
 import (
 	"context"
+	"crypto/cipher"
+	"errors"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/titaev-lv/hsm-service/internal/config"
 )
 
@@ -142,3 +146,134 @@ func TestKeyManagerThreadSafety(t *testing.T) {
 
 	// If we reach here without race detector errors, test passes
 }
+
+// newTestKeyManager builds a KeyManager backed by software AES-GCM ciphers
+func newTestKeyManager(t *testing.T) *KeyManager {
+	t.Helper()
+	return &KeyManager{
+		keys: map[string]cipher.AEAD{
+			"kek-test-v1": newTestGCM(t),
+			"kek-test-v2": newTestGCM(t),
+		},
+		contextToLabel: map[string]string{"test": "kek-test-v2"},
+		metadata: map[string]*KeyMetadata{
+			"kek-test-v1": {Label: "kek-test-v1", Version: 1, State: config.KeyStateDecryptOnly},
+			"kek-test-v2": {Label: "kek-test-v2", Version: 2, State: config.KeyStateActive},
+		},
+		config: &config.Config{HSM: config.HSMConfig{Keys: map[string]config.KeyConfig{
+			"test": {Type: "aes", Mode: "private"},
+		}}},
+	}
+}
+
+func TestKeyManagerDecrypt_EnforcesKeyState(t *testing.T) {
+	km := newTestKeyManager(t)
+
+	// Encrypt with the old version directly (simulates data written before rotation)
+	km.contextToLabel["test"] = "kek-test-v1"
+	ciphertext, label, err := km.Encrypt(context.Background(), []byte("secret"), "test", "OU", "client")
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+	km.contextToLabel["test"] = "kek-test-v2"
+
+	// decrypt_only can decrypt
+	if _, err := km.Decrypt(context.Background(), ciphertext, "test", "OU", "client", label); err != nil {
+		t.Fatalf("Decrypt() with decrypt_only key error = %v", err)
+	}
+
+	// disabled and scheduled_for_destruction refuse decrypt
+	for _, state := range []config.KeyState{config.KeyStateDisabled, config.KeyStateScheduledForDestruction} {
+		km.metadata[label].State = state
+		_, err := km.Decrypt(context.Background(), ciphertext, "test", "OU", "client", label)
+		var stateErr *KeyStateError
+		if !errors.Is(err, ErrKeyDisabled) || !errors.As(err, &stateErr) || stateErr.State != state {
+			t.Errorf("Decrypt() with %s key error = %v, want ErrKeyDisabled with the state", state, err)
+		}
+	}
+
+	// Destroyed versions are not loaded but still report their state
+	delete(km.keys, label)
+	delete(km.metadata, label)
+	km.destroyed = map[string]bool{label: true}
+	_, err = km.Decrypt(context.Background(), ciphertext, "test", "OU", "client", label)
+	var stateErr *KeyStateError
+	if !errors.As(err, &stateErr) || stateErr.State != config.KeyStateDestroyed {
+		t.Errorf("Decrypt() with destroyed key error = %v, want KeyStateError(destroyed)", err)
+	}
+}
+
+func TestKeyManagerKeyMetrics(t *testing.T) {
+	km := newTestKeyManager(t)
+	now := time.Now()
+	created := now.Add(-30 * 24 * time.Hour)
+	km.metadata["kek-test-v1"].Context = "test"
+	km.metadata["kek-test-v2"].Context = "test"
+	km.metadata["kek-test-v2"].CreatedAt = created
+	km.metadata["kek-test-v2"].RotationInterval = 90 * 24 * time.Hour
+
+	km.updateKeyMetrics(now)
+
+	if got := testutil.ToFloat64(KeyVersionsLoaded.WithLabelValues("test")); got != 2 {
+		t.Errorf("hsm_key_versions_loaded = %v, want 2", got)
+	}
+	if got := testutil.ToFloat64(KeyAge.WithLabelValues("test")); got != (30 * 24 * time.Hour).Seconds() {
+		t.Errorf("hsm_key_age_seconds = %v, want 30 days", got)
+	}
+	if got := testutil.ToFloat64(KeyRotationDue.WithLabelValues("test")); got != (60 * 24 * time.Hour).Seconds() {
+		t.Errorf("hsm_key_rotation_due_seconds = %v, want 60 days", got)
+	}
+
+	// Overdue keys report a negative value
+	km.metadata["kek-test-v2"].RotationInterval = 7 * 24 * time.Hour
+	km.updateKeyMetrics(now)
+	if got := testutil.ToFloat64(KeyRotationDue.WithLabelValues("test")); got >= 0 {
+		t.Errorf("hsm_key_rotation_due_seconds for overdue key = %v, want negative", got)
+	}
+}
+
+func TestKeyManagerDecrypt_RecordsFailures(t *testing.T) {
+	km := newTestKeyManager(t)
+	ciphertext, label, err := km.Encrypt(context.Background(), []byte("secret"), "test", "OU", "client")
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+
+	failures := testutil.ToFloat64(KeyOperationFailuresTotal.WithLabelValues(label, "decrypt"))
+	ciphertext[len(ciphertext)-1] ^= 0xff
+	if _, err := km.Decrypt(context.Background(), ciphertext, "test", "OU", "client", label); !errors.Is(err, ErrDecryptionFailed) {
+		t.Fatalf("Decrypt() of tampered ciphertext error = %v, want ErrDecryptionFailed", err)
+	}
+	if got := testutil.ToFloat64(KeyOperationFailuresTotal.WithLabelValues(label, "decrypt")) - failures; got != 1 {
+		t.Errorf("hsm_key_operation_failures_total increased by %v, want 1", got)
+	}
+}
+
+func TestKeyManagerClose_FlushesUsage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "key-usage.json")
+	usage, err := NewUsageTracker(path)
+	if err != nil {
+		t.Fatalf("NewUsageTracker() error = %v", err)
+	}
+	km := newTestKeyManager(t)
+	km.usage = usage
+
+	ciphertext, label, err := km.Encrypt(context.Background(), []byte("secret"), "test", "OU", "client")
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+	if _, err := km.Decrypt(context.Background(), ciphertext, "test", "OU", "client", label); err != nil {
+		t.Fatalf("Decrypt() error = %v", err)
+	}
+	if err := km.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	stored, err := LoadKeyUsage(path)
+	if err != nil {
+		t.Fatalf("LoadKeyUsage() error = %v", err)
+	}
+	if u := stored[label]; u.EncryptCount != 1 || u.DecryptCount != 1 {
+		t.Errorf("%s usage after Close = %+v, want 1 encrypt and 1 decrypt", label, u)
+	}
+}
@@ -0,0 +1,327 @@
+package hsm
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/ThalesGroup/crypto11"
+	"github.com/miekg/pkcs11"
+	"github.com/titaev-lv/hsm-service/internal/config"
+)
+
+// Key roles known to the service
+const (
+	KeyRoleKEK         = "kek"
+	KeyRoleMetadataMAC = "metadata-hmac"
+	KeyRoleAuditMAC    = "audit-hmac"
+)
+
+// Audit finding severities
+const (
+	SeverityFail = "fail"
+	SeverityWarn = "warn"
+)
+
+// KeyAttributes holds the PKCS#11 attributes of a secret key relevant for compliance
+type KeyAttributes struct {
+	Label            string `json:"label"`
+	ID               string `json:"id"` // hex
+	KeyType          string `json:"key_type"`
+	ValueLen         uint64 `json:"value_len"`
+	Token            bool   `json:"token"`
+	Private          bool   `json:"private"`
+	Sensitive        bool   `json:"sensitive"`
+	Extractable      bool   `json:"extractable"`
+	AlwaysSensitive  bool   `json:"always_sensitive"`
+	NeverExtractable bool   `json:"never_extractable"`
+	Encrypt          bool   `json:"encrypt"`
+	Decrypt          bool   `json:"decrypt"`
+	Wrap             bool   `json:"wrap"`
+	Unwrap           bool   `json:"unwrap"`
+	Sign             bool   `json:"sign"`
+	Verify           bool   `json:"verify"`
+	Derive           bool   `json:"derive"`
+}
+
+// ExpectedKey describes a key referenced by service configuration or metadata
+type ExpectedKey struct {
+	Role      string // KeyRoleKEK, KeyRoleMetadataMAC or KeyRoleAuditMAC
+	Context   string // KEK context
+	Destroyed bool   // Marked destroyed in metadata, must be absent from the token
+}
+
+// KeyAuditFinding is a single failed or questionable check
+type KeyAuditFinding struct {
+	Label    string `json:"label"`
+	Check    string `json:"check"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+// KeyAuditEntry is the audit result for one key on the token
+type KeyAuditEntry struct {
+	KeyAttributes
+	Role    string `json:"role"` // kek, metadata-hmac, audit-hmac, orphan
+	Context string `json:"context,omitempty"`
+	Passed  bool   `json:"passed"`
+}
+
+// KeyAuditReport is the result of AuditKeys
+type KeyAuditReport struct {
+	GeneratedAt time.Time         `json:"generated_at"`
+	Token       string            `json:"token"`
+	Keys        []KeyAuditEntry   `json:"keys"`
+	Findings    []KeyAuditFinding `json:"findings"`
+	Failures    int               `json:"failures"`
+	Warnings    int               `json:"warnings"`
+	Passed      bool              `json:"passed"`
+}
+
+// keyAuditAttributes are read for every secret key
+var keyAuditAttributes = []uint{
+	pkcs11.CKA_LABEL,
+	pkcs11.CKA_ID,
+	pkcs11.CKA_KEY_TYPE,
+	pkcs11.CKA_VALUE_LEN,
+	pkcs11.CKA_TOKEN,
+	pkcs11.CKA_PRIVATE,
+	pkcs11.CKA_SENSITIVE,
+	pkcs11.CKA_EXTRACTABLE,
+	pkcs11.CKA_ALWAYS_SENSITIVE,
+	pkcs11.CKA_NEVER_EXTRACTABLE,
+	pkcs11.CKA_ENCRYPT,
+	pkcs11.CKA_DECRYPT,
+	pkcs11.CKA_WRAP,
+	pkcs11.CKA_UNWRAP,
+	pkcs11.CKA_SIGN,
+	pkcs11.CKA_VERIFY,
+	pkcs11.CKA_DERIVE,
+}
+
+// ListKeyAttributes reads the attributes of all secret keys on the token
+func ListKeyAttributes(ctx *crypto11.Context) ([]KeyAttributes, error) {
+	keys, err := ctx.FindAllKeys()
+	if err != nil {
+		return nil, fmt.Errorf("failed to enumerate keys: %w", err)
+	}
+
+	result := make([]KeyAttributes, 0, len(keys))
+	for _, key := range keys {
+		attrs, err := ctx.GetAttributes(key, keyAuditAttributes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read key attributes: %w", err)
+		}
+		result = append(result, parseKeyAttributes(attrs))
+	}
+	return result, nil
+}
+
+// parseKeyAttributes converts raw PKCS#11 attributes
+func parseKeyAttributes(attrs crypto11.AttributeSet) KeyAttributes {
+	b := func(t uint) bool {
+		a, ok := attrs[t]
+		return ok && len(a.Value) > 0 && a.Value[0] != 0
+	}
+	raw := func(t uint) []byte {
+		if a, ok := attrs[t]; ok {
+			return a.Value
+		}
+		return nil
+	}
+
+	return KeyAttributes{
+		Label:            string(raw(pkcs11.CKA_LABEL)),
+		ID:               hex.EncodeToString(raw(pkcs11.CKA_ID)),
+		KeyType:          keyTypeName(attrUlong(raw(pkcs11.CKA_KEY_TYPE))),
+		ValueLen:         attrUlong(raw(pkcs11.CKA_VALUE_LEN)),
+		Token:            b(pkcs11.CKA_TOKEN),
+		Private:          b(pkcs11.CKA_PRIVATE),
+		Sensitive:        b(pkcs11.CKA_SENSITIVE),
+		Extractable:      b(pkcs11.CKA_EXTRACTABLE),
+		AlwaysSensitive:  b(pkcs11.CKA_ALWAYS_SENSITIVE),
+		NeverExtractable: b(pkcs11.CKA_NEVER_EXTRACTABLE),
+		Encrypt:          b(pkcs11.CKA_ENCRYPT),
+		Decrypt:          b(pkcs11.CKA_DECRYPT),
+		Wrap:             b(pkcs11.CKA_WRAP),
+		Unwrap:           b(pkcs11.CKA_UNWRAP),
+		Sign:             b(pkcs11.CKA_SIGN),
+		Verify:           b(pkcs11.CKA_VERIFY),
+		Derive:           b(pkcs11.CKA_DERIVE),
+	}
+}
+
+// attrUlong decodes a CK_ULONG attribute value (native byte order)
+func attrUlong(v []byte) uint64 {
+	switch len(v) {
+	case 8:
+		return binary.NativeEndian.Uint64(v)
+	case 4:
+		return uint64(binary.NativeEndian.Uint32(v))
+	}
+	return 0
+}
+
+// keyTypeName returns a readable CKA_KEY_TYPE
+func keyTypeName(t uint64) string {
+	switch uint(t) {
+	case pkcs11.CKK_AES:
+		return "AES"
+	case pkcs11.CKK_GENERIC_SECRET:
+		return "GENERIC_SECRET"
+	case pkcs11.CKK_SHA256_HMAC:
+		return "SHA256_HMAC"
+	case pkcs11.CKK_DES3:
+		return "DES3"
+	}
+	return fmt.Sprintf("0x%x", t)
+}
+
+// ExpectedKeysFromMetadata returns the keys the service expects on the token
+func ExpectedKeysFromMetadata(metadata *config.Metadata, hsmCfg *config.HSMConfig) map[string]ExpectedKey {
+	expected := make(map[string]ExpectedKey)
+	for context, keyMeta := range metadata.Rotation {
+		for _, v := range keyMeta.Versions {
+			expected[v.Label] = ExpectedKey{
+				Role:      KeyRoleKEK,
+				Context:   context,
+				Destroyed: v.EffectiveState(keyMeta.Current) == config.KeyStateDestroyed,
+			}
+		}
+	}
+	if hsmCfg.MetadataSigning.Enabled {
+		expected[hsmCfg.MetadataSigning.GetKeyLabel()] = ExpectedKey{Role: KeyRoleMetadataMAC}
+	}
+	return expected
+}
+
+// AuditKeys checks token keys against compliance rules and the expected inventory:
+// every key must be token-resident, sensitive and non-extractable (and always
+// have been), limited to the mechanisms of its role, and referenced by metadata
+func AuditKeys(token string, keys []KeyAttributes, expected map[string]ExpectedKey) *KeyAuditReport {
+	report := &KeyAuditReport{
+		GeneratedAt: time.Now().UTC(),
+		Token:       token,
+		Keys:        make([]KeyAuditEntry, 0, len(keys)),
+		Findings:    []KeyAuditFinding{},
+	}
+
+	add := func(label, check, severity, format string, args ...any) {
+		report.Findings = append(report.Findings, KeyAuditFinding{
+			Label:    label,
+			Check:    check,
+			Severity: severity,
+			Message:  fmt.Sprintf(format, args...),
+		})
+	}
+
+	onToken := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		onToken[k.Label] = true
+		entry := KeyAuditEntry{KeyAttributes: k, Role: "orphan"}
+		before := len(report.Findings)
+
+		exp, known := expected[k.Label]
+		switch {
+		case !known:
+			add(k.Label, "inventory", SeverityFail, "key is not referenced in metadata (orphan)")
+		case exp.Destroyed:
+			entry.Role, entry.Context = exp.Role, exp.Context
+			add(k.Label, "inventory", SeverityFail, "key is marked destroyed in metadata but still present on the token")
+		default:
+			entry.Role, entry.Context = exp.Role, exp.Context
+		}
+
+		if !k.Token {
+			add(k.Label, "token", SeverityFail, "key is not token-resident (CKA_TOKEN=false)")
+		}
+		if !k.Sensitive {
+			add(k.Label, "sensitive", SeverityFail, "key is not sensitive (CKA_SENSITIVE=false)")
+		}
+		if k.Extractable {
+			add(k.Label, "extractable", SeverityFail, "key is extractable (CKA_EXTRACTABLE=true)")
+		}
+		if !k.AlwaysSensitive || !k.NeverExtractable {
+			add(k.Label, "history", SeverityFail, "key material may have been exposed (ALWAYS_SENSITIVE=%t, NEVER_EXTRACTABLE=%t)",
+				k.AlwaysSensitive, k.NeverExtractable)
+		}
+		auditMechanisms(k, entry.Role, add)
+
+		entry.Passed = true
+		for _, f := range report.Findings[before:] {
+			if f.Severity == SeverityFail {
+				entry.Passed = false
+			}
+		}
+		report.Keys = append(report.Keys, entry)
+	}
+
+	// Keys referenced in metadata but absent from the token
+	missing := make([]string, 0)
+	for label, exp := range expected {
+		if !exp.Destroyed && !onToken[label] {
+			missing = append(missing, label)
+		}
+	}
+	sort.Strings(missing)
+	for _, label := range missing {
+		add(label, "inventory", SeverityFail, "key is referenced in metadata (%s) but missing from the token", expected[label].Role)
+	}
+
+	sort.Slice(report.Keys, func(i, j int) bool { return report.Keys[i].Label < report.Keys[j].Label })
+	for _, f := range report.Findings {
+		if f.Severity == SeverityFail {
+			report.Failures++
+		} else {
+			report.Warnings++
+		}
+	}
+	report.Passed = report.Failures == 0
+
+	return report
+}
+
+// auditMechanisms checks usage flags against the intended mechanisms of a role
+func auditMechanisms(k KeyAttributes, role string, add func(label, check, severity, format string, args ...any)) {
+	switch role {
+	case KeyRoleKEK:
+		// AES-GCM encrypt/decrypt only
+		if k.KeyType != "AES" {
+			add(k.Label, "key_type", SeverityFail, "KEK has key type %s, expected AES", k.KeyType)
+		}
+		if k.ValueLen != 32 {
+			add(k.Label, "key_size", SeverityFail, "KEK is %d bits, expected 256", k.ValueLen*8)
+		}
+		if k.Sign || k.Verify || k.Derive {
+			add(k.Label, "mechanisms", SeverityFail, "KEK allows sign/verify/derive (sign=%t verify=%t derive=%t)", k.Sign, k.Verify, k.Derive)
+		}
+		if k.Wrap || k.Unwrap {
+			add(k.Label, "mechanisms", SeverityWarn, "KEK allows wrap/unwrap, which the service does not use")
+		}
+	case KeyRoleMetadataMAC, KeyRoleAuditMAC:
+		// HMAC sign/verify only
+		if k.Encrypt || k.Decrypt || k.Wrap || k.Unwrap || k.Derive {
+			add(k.Label, "mechanisms", SeverityFail, "%s key allows encrypt/decrypt/wrap/unwrap/derive", role)
+		}
+	}
+}
+
+// Strict turns warnings into failures
+func (r *KeyAuditReport) Strict() {
+	for i := range r.Findings {
+		if r.Findings[i].Severity != SeverityWarn {
+			continue
+		}
+		r.Findings[i].Severity = SeverityFail
+		for j := range r.Keys {
+			if r.Keys[j].Label == r.Findings[i].Label {
+				r.Keys[j].Passed = false
+			}
+		}
+	}
+	r.Failures += r.Warnings
+	r.Warnings = 0
+	r.Passed = r.Failures == 0
+}
@@ -0,0 +1,145 @@
+package hsm
+
+import (
+	"testing"
+
+	"github.com/ThalesGroup/crypto11"
+	"github.com/miekg/pkcs11"
+	"github.com/titaev-lv/hsm-service/internal/config"
+)
+
+// compliantKEK returns attributes of a correctly generated KEK
+func compliantKEK(label string) KeyAttributes {
+	return KeyAttributes{
+		Label:            label,
+		KeyType:          "AES",
+		ValueLen:         32,
+		Token:            true,
+		Private:          true,
+		Sensitive:        true,
+		AlwaysSensitive:  true,
+		NeverExtractable: true,
+		Encrypt:          true,
+		Decrypt:          true,
+	}
+}
+
+func findingChecks(report *KeyAuditReport, label string) map[string]string {
+	checks := make(map[string]string)
+	for _, f := range report.Findings {
+		if f.Label == label {
+			checks[f.Check] = f.Severity
+		}
+	}
+	return checks
+}
+
+func TestAuditKeys_Pass(t *testing.T) {
+	expected := map[string]ExpectedKey{
+		"kek-exchange-v1": {Role: KeyRoleKEK, Context: "exchange"},
+	}
+	report := AuditKeys("hsm-token", []KeyAttributes{compliantKEK("kek-exchange-v1")}, expected)
+
+	if !report.Passed || report.Failures != 0 || len(report.Findings) != 0 {
+		t.Fatalf("report = %+v, want pass without findings", report)
+	}
+	if report.Keys[0].Role != KeyRoleKEK || report.Keys[0].Context != "exchange" {
+		t.Errorf("entry = %+v", report.Keys[0])
+	}
+}
+
+func TestAuditKeys_Failures(t *testing.T) {
+	extractable := compliantKEK("kek-exchange-v1")
+	extractable.Extractable = true
+	extractable.NeverExtractable = false
+
+	orphan := compliantKEK("kek-unknown")
+
+	destroyed := compliantKEK("kek-exchange-v0")
+
+	expected := map[string]ExpectedKey{
+		"kek-exchange-v0": {Role: KeyRoleKEK, Context: "exchange", Destroyed: true},
+		"kek-exchange-v1": {Role: KeyRoleKEK, Context: "exchange"},
+		"kek-exchange-v2": {Role: KeyRoleKEK, Context: "exchange"},
+	}
+
+	report := AuditKeys("hsm-token", []KeyAttributes{extractable, orphan, destroyed}, expected)
+	if report.Passed {
+		t.Fatal("report passed, want failure")
+	}
+
+	checks := findingChecks(report, "kek-exchange-v1")
+	if checks["extractable"] != SeverityFail || checks["history"] != SeverityFail {
+		t.Errorf("extractable key findings = %v", checks)
+	}
+	if findingChecks(report, "kek-unknown")["inventory"] != SeverityFail {
+		t.Error("orphan key not flagged")
+	}
+	if findingChecks(report, "kek-exchange-v0")["inventory"] != SeverityFail {
+		t.Error("destroyed key still on token not flagged")
+	}
+	if findingChecks(report, "kek-exchange-v2")["inventory"] != SeverityFail {
+		t.Error("missing key not flagged")
+	}
+}
+
+func TestAuditKeys_MechanismsAndStrict(t *testing.T) {
+	kek := compliantKEK("kek-exchange-v1")
+	kek.Wrap = true
+	kek.Unwrap = true
+
+	expected := map[string]ExpectedKey{"kek-exchange-v1": {Role: KeyRoleKEK}}
+	report := AuditKeys("hsm-token", []KeyAttributes{kek}, expected)
+
+	if !report.Passed || report.Warnings != 1 {
+		t.Fatalf("passed = %v, warnings = %d, want pass with 1 warning", report.Passed, report.Warnings)
+	}
+
+	report.Strict()
+	if report.Passed || report.Failures != 1 || report.Keys[0].Passed {
+		t.Errorf("strict: passed = %v, failures = %d", report.Passed, report.Failures)
+	}
+}
+
+func TestExpectedKeysFromMetadata(t *testing.T) {
+	metadata := &config.Metadata{Rotation: map[string]config.KeyMetadata{
+		"exchange": {
+			Current: "kek-exchange-v2",
+			Versions: []config.KeyVersion{
+				{Label: "kek-exchange-v1", State: config.KeyStateDestroyed},
+				{Label: "kek-exchange-v2"},
+			},
+		},
+	}}
+	hsmCfg := &config.HSMConfig{MetadataSigning: config.MetadataSigningConfig{Enabled: true}}
+
+	expected := ExpectedKeysFromMetadata(metadata, hsmCfg)
+	if !expected["kek-exchange-v1"].Destroyed || expected["kek-exchange-v2"].Destroyed {
+		t.Errorf("destroyed flags = %+v", expected)
+	}
+	if expected[config.DefaultMetadataKeyLabel].Role != KeyRoleMetadataMAC {
+		t.Error("metadata HMAC key not expected")
+	}
+}
+
+func TestParseKeyAttributes(t *testing.T) {
+	set := crypto11.AttributeSet{}
+	for _, a := range []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, "kek-exchange-v1"),
+		pkcs11.NewAttribute(pkcs11.CKA_ID, []byte{0xbe, 0xef}),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_AES),
+		pkcs11.NewAttribute(pkcs11.CKA_VALUE_LEN, 32),
+		pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, false),
+	} {
+		set[a.Type] = a
+	}
+
+	k := parseKeyAttributes(set)
+	if k.Label != "kek-exchange-v1" || k.ID != "beef" || k.KeyType != "AES" || k.ValueLen != 32 {
+		t.Errorf("parsed = %+v", k)
+	}
+	if !k.Sensitive || k.Extractable {
+		t.Errorf("flags: sensitive = %v, extractable = %v", k.Sensitive, k.Extractable)
+	}
+}
@@ -0,0 +1,66 @@
+package hsm
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"fmt"
+	"time"
+
+	"github.com/ThalesGroup/crypto11"
+)
+
+// Key pair types for GenerateKeyPair
+const (
+	KeyPairTypeECDSA = "ecdsa" // P-256
+	KeyPairTypeRSA   = "rsa"   // 3072 bit
+)
+
+// GenerateKeyPair creates an RSA or ECDSA signing key pair on the token
+// (HTTPS server key, PKI CA key). The private key is sensitive and
+// non-extractable (crypto11 template defaults); only the public key leaves
+// the HSM (in a CSR or certificate).
+func GenerateKeyPair(ctx *crypto11.Context, label, keyType string) (crypto11.Signer, error) {
+	existing, err := ctx.FindKeyPair(nil, []byte(label))
+	if err != nil {
+		return nil, fmt.Errorf("failed to check for existing key pair: %w", err)
+	}
+	if existing != nil {
+		return nil, fmt.Errorf("key pair with label %s already exists in HSM", label)
+	}
+
+	id := []byte(fmt.Sprintf("kp-%d", time.Now().UnixNano()))
+	var signer crypto11.Signer
+	switch keyType {
+	case KeyPairTypeECDSA:
+		signer, err = ctx.GenerateECDSAKeyPairWithLabel(id, []byte(label), elliptic.P256())
+	case KeyPairTypeRSA:
+		signer, err = ctx.GenerateRSAKeyPairWithLabel(id, []byte(label), 3072)
+	default:
+		return nil, fmt.Errorf("unsupported key type %q (use %s or %s)", keyType, KeyPairTypeECDSA, KeyPairTypeRSA)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key pair: %w", err)
+	}
+	return signer, nil
+}
+
+// FindSigner returns the RSA/ECDSA key pair labelled label as a
+// crypto.Signer; signing operations are performed by the HSM
+func FindSigner(ctx *crypto11.Context, label string) (crypto.Signer, error) {
+	signer, err := ctx.FindKeyPair(nil, []byte(label))
+	if err != nil {
+		return nil, fmt.Errorf("failed to find key pair %s: %w", label, err)
+	}
+	if signer == nil {
+		return nil, fmt.Errorf("key pair %s not found in HSM", label)
+	}
+
+	switch signer.Public().(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey:
+		return signer, nil
+	default:
+		return nil, fmt.Errorf("key pair %s: unsupported key type %T", label, signer.Public())
+	}
+}
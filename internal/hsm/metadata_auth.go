@@ -0,0 +1,77 @@
+package hsm
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ThalesGroup/crypto11"
+	"github.com/miekg/pkcs11"
+	"github.com/titaev-lv/hsm-service/internal/config"
+)
+
+// MetadataKeySizeBits is the size of the metadata and audit HMAC keys
+const MetadataKeySizeBits = 256
+
+// GenerateMetadataKey creates the HSM-resident HMAC key used to sign metadata.yaml
+// The key is sensitive and non-extractable (crypto11 template defaults)
+func GenerateMetadataKey(ctx *crypto11.Context, label string) (*crypto11.SecretKey, error) {
+	return generateHMACKey(ctx, label, "mac")
+}
+
+// GenerateAuditKey creates the HSM-resident HMAC key that signs audit log checkpoints
+func GenerateAuditKey(ctx *crypto11.Context, label string) (*crypto11.SecretKey, error) {
+	return generateHMACKey(ctx, label, "audit")
+}
+
+// generateHMACKey creates a 256-bit generic secret key for CKM_SHA256_HMAC
+func generateHMACKey(ctx *crypto11.Context, label, idPrefix string) (*crypto11.SecretKey, error) {
+	existing, err := ctx.FindKey(nil, []byte(label))
+	if err != nil {
+		return nil, fmt.Errorf("failed to check for existing key: %w", err)
+	}
+	if existing != nil {
+		return nil, fmt.Errorf("key with label %s already exists in HSM", label)
+	}
+
+	id := []byte(fmt.Sprintf("%s-%d", idPrefix, time.Now().UnixNano()))
+	key, err := ctx.GenerateSecretKeyWithLabel(id, []byte(label), MetadataKeySizeBits, crypto11.CipherGeneric)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate HMAC key %s: %w", label, err)
+	}
+	return key, nil
+}
+
+// NewMetadataMAC returns HMAC-SHA256 computed in the HSM with the key labelled label
+func NewMetadataMAC(ctx *crypto11.Context, label string) (config.MetadataMAC, error) {
+	return NewHMAC(ctx, label)
+}
+
+// NewHMAC returns HMAC-SHA256 computed in the HSM with the key labelled label
+func NewHMAC(ctx *crypto11.Context, label string) (func(data []byte) ([]byte, error), error) {
+	key, err := ctx.FindKey(nil, []byte(label))
+	if err != nil {
+		return nil, fmt.Errorf("failed to find HMAC key %s: %w", label, err)
+	}
+	if key == nil {
+		return nil, fmt.Errorf("HMAC key %s not found in HSM", label)
+	}
+
+	return func(data []byte) ([]byte, error) {
+		h, err := key.NewHMAC(pkcs11.CKM_SHA256_HMAC, 0)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := h.Write(data); err != nil {
+			return nil, err
+		}
+		return h.Sum(nil), nil
+	}, nil
+}
+
+// MetadataMACFromConfig returns the metadata MAC when signing is enabled, nil otherwise
+func MetadataMACFromConfig(ctx *crypto11.Context, cfg *config.HSMConfig) (config.MetadataMAC, error) {
+	if !cfg.MetadataSigning.Enabled {
+		return nil, nil
+	}
+	return NewMetadataMAC(ctx, cfg.MetadataSigning.GetKeyLabel())
+}
@@ -0,0 +1,129 @@
+package hsm
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Prometheus metrics for key lifecycle operations
+var (
+	// Key rotations by context, trigger (scheduled/manual) and status
+	KeyRotationsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "hsm_key_rotations_total",
+			Help: "Total number of key rotations by context, trigger, and status",
+		},
+		[]string{"context", "trigger", "status"},
+	)
+
+	// Timestamp of the last successful rotation per context
+	KeyRotationLastSuccess = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "hsm_key_rotation_last_success_timestamp_seconds",
+			Help: "Unix timestamp of the last successful key rotation by context",
+		},
+		[]string{"context"},
+	)
+
+	// Successful encrypt/decrypt operations per key version
+	KeyOperationsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "hsm_key_operations_total",
+			Help: "Total number of successful operations by key label and operation (encrypt/decrypt)",
+		},
+		[]string{"label", "operation"},
+	)
+
+	// Last time a key version was used (survives restarts via the usage file)
+	KeyLastUsed = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "hsm_key_last_used_timestamp_seconds",
+			Help: "Unix timestamp of the last encrypt or decrypt operation by key label",
+		},
+		[]string{"label"},
+	)
+)
+
+// RecordRotation records a rotation attempt
+func RecordRotation(context, trigger, status string) {
+	KeyRotationsTotal.WithLabelValues(context, trigger, status).Inc()
+	if status == "success" {
+		KeyRotationLastSuccess.WithLabelValues(context).SetToCurrentTime()
+	}
+}
+
+// Prometheus metrics for key state and HSM health
+var (
+	// Age of the current key version per context
+	KeyAge = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "hsm_key_age_seconds",
+			Help: "Age of the current key version in seconds by context",
+		},
+		[]string{"context"},
+	)
+
+	// Time left until the current version is due for rotation (negative: overdue)
+	KeyRotationDue = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "hsm_key_rotation_due_seconds",
+			Help: "Seconds until the current key version is due for rotation by context (negative when overdue)",
+		},
+		[]string{"context"},
+	)
+
+	// Key versions loaded from the token per context
+	KeyVersionsLoaded = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "hsm_key_versions_loaded",
+			Help: "Number of key versions loaded from the HSM by context",
+		},
+		[]string{"context"},
+	)
+
+	// Failed encrypt/decrypt operations per key version
+	KeyOperationFailuresTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "hsm_key_operation_failures_total",
+			Help: "Total number of failed operations by key label and operation (encrypt/decrypt)",
+		},
+		[]string{"label", "operation"},
+	)
+
+	// Metadata reloads by status (success/failure)
+	MetadataReloadsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "hsm_metadata_reloads_total",
+			Help: "Total number of metadata reloads by status",
+		},
+		[]string{"status"},
+	)
+
+	// Timestamp of the last successful metadata load
+	MetadataLastReloadSuccess = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "hsm_metadata_last_reload_success_timestamp_seconds",
+			Help: "Unix timestamp of the last successful metadata load",
+		},
+	)
+
+	// Latency of the cryptographic calls on the token
+	PKCS11OperationDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "hsm_pkcs11_operation_duration_seconds",
+			Help:    "Duration of PKCS#11 operations in seconds by operation (seal, open)",
+			Buckets: []float64{0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1},
+		},
+		[]string{"operation"},
+	)
+)
+
+// RecordMetadataReload records a metadata reload attempt
+func RecordMetadataReload(err error) {
+	if err != nil {
+		MetadataReloadsTotal.WithLabelValues("failure").Inc()
+		return
+	}
+	MetadataReloadsTotal.WithLabelValues("success").Inc()
+	MetadataLastReloadSuccess.SetToCurrentTime()
+}
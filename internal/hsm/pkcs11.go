@@ -2,8 +2,6 @@ package hsm
 
 import (
 	"crypto/cipher"
-	"crypto/sha256"
-	"encoding/hex"
 	"fmt"
 	"log"
 	"time"
@@ -15,9 +13,11 @@ import (
 // KeyMetadata holds metadata for a KEK
 type KeyMetadata struct {
 	Label            string
+	Context          string
 	CreatedAt        time.Time
 	RotationInterval time.Duration
 	Version          int
+	State            config.KeyState
 }
 
 // NeedsRotation checks if the key needs rotation based on its metadata
@@ -37,20 +37,6 @@ type HSMContext struct {
 	metadata       map[string]*KeyMetadata // label -> metadata
 }
 
-// computeKeyChecksum computes SHA-256 hash of key attributes for integrity verification
-// Uses label + key handle pointer as unique identifier (best we can do without extracting key material)
-func computeKeyChecksum(label string, secretKey *crypto11.SecretKey) string {
-	// We can't extract actual key material from HSM (by design)
-	// Instead, compute hash of: label + object handle address
-	// This detects label tampering and key substitution
-	h := sha256.New()
-	h.Write([]byte(label))
-	// Note: This is a simplified integrity check.
-	// Full integrity would require HMAC of key attributes stored in metadata
-	// For production: extend metadata.yaml to store CKA_ID, CKA_CLASS, CKA_KEY_TYPE
-	return hex.EncodeToString(h.Sum(nil))
-}
-
 // InitHSM initializes the PKCS#11 context and loads all configured keys
 func InitHSM(cfg *config.HSMConfig, metadata *config.Metadata, pin string) (*HSMContext, error) {
 	// 1. Configure crypto11
@@ -102,13 +88,16 @@ func InitHSM(cfg *config.HSMConfig, metadata *config.Metadata, pin string) (*HSM
 
 			// Verify key integrity (if checksum is stored in metadata)
 			if version.Checksum != "" {
-				computedChecksum := computeKeyChecksum(version.Label, secretKey)
-				if computedChecksum != version.Checksum {
+				legacy, err := VerifyKeyChecksum(ctx, version.Label, secretKey, version.Checksum)
+				if err != nil {
 					ctx.Close()
-					return nil, fmt.Errorf("KEK integrity verification failed for %s: checksum mismatch (expected %s, got %s)",
-						version.Label, version.Checksum, computedChecksum)
+					return nil, fmt.Errorf("KEK integrity verification failed for %s: %w", version.Label, err)
+				}
+				if legacy {
+					log.Printf("Warning: label-only checksum for %s cannot detect key substitution - run 'hsm-admin update-checksums'", version.Label)
+				} else {
+					log.Printf("KEK integrity verified: %s (checksum: %s)", version.Label, version.Checksum[:9])
 				}
-				log.Printf("KEK integrity verified: %s (checksum: %s)", version.Label, computedChecksum[:8])
 			} else {
 				// No checksum in metadata - first time load or migration
 				log.Printf("Warning: No checksum for %s - consider running 'hsm-admin update-checksums'", version.Label)
@@ -0,0 +1,242 @@
+package hsm
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/titaev-lv/hsm-service/internal/config"
+)
+
+// Reconcile issue kinds
+const (
+	IssueCurrentMissing   = "current_missing"   // Current version absent from the token (fatal)
+	IssueDangling         = "dangling"          // Non-current version absent from the token
+	IssueOrphan           = "orphan"            // AES key on the token not referenced in metadata
+	IssueDestroyedPresent = "destroyed_present" // Version marked destroyed but still on the token
+)
+
+// ReconcileIssue is a difference between metadata and the token
+type ReconcileIssue struct {
+	Kind    string
+	Context string // Empty for orphans that match no context
+	Label   string
+	Version int // Parsed version for orphans
+}
+
+// String describes the issue
+func (i ReconcileIssue) String() string {
+	switch i.Kind {
+	case IssueCurrentMissing:
+		return fmt.Sprintf("%s: current version %s is missing from the token", i.Context, i.Label)
+	case IssueDangling:
+		return fmt.Sprintf("%s: version %s is missing from the token (dangling)", i.Context, i.Label)
+	case IssueDestroyedPresent:
+		return fmt.Sprintf("%s: version %s is marked destroyed but still on the token", i.Context, i.Label)
+	case IssueOrphan:
+		if i.Context == "" {
+			return fmt.Sprintf("key %s is on the token but not in metadata (orphan, no matching context)", i.Label)
+		}
+		return fmt.Sprintf("key %s is on the token but not in metadata (orphan, context %s v%d)", i.Label, i.Context, i.Version)
+	}
+	return fmt.Sprintf("%s: %s %s", i.Context, i.Kind, i.Label)
+}
+
+// ReconcileOptions selects which issues ApplyReconcile fixes
+type ReconcileOptions struct {
+	AddOrphans    bool // Add orphans matching a context as decrypt_only versions
+	PruneDangling bool // Remove non-current versions missing from the token
+}
+
+// ReconcileMetadata compares metadata with the keys on the token
+// hsmCfg provides label templates and the metadata HMAC key label (ignored)
+func ReconcileMetadata(metadata *config.Metadata, tokenKeys []KeyAttributes, hsmCfg *config.HSMConfig) []ReconcileIssue {
+	onToken := make(map[string]bool, len(tokenKeys))
+	for _, k := range tokenKeys {
+		onToken[k.Label] = true
+	}
+
+	var issues []ReconcileIssue
+	inMetadata := make(map[string]bool)
+
+	contexts := sortedContexts(metadata)
+	for _, context := range contexts {
+		keyMeta := metadata.Rotation[context]
+		for _, v := range keyMeta.Versions {
+			inMetadata[v.Label] = true
+			destroyed := v.EffectiveState(keyMeta.Current) == config.KeyStateDestroyed
+
+			switch {
+			case destroyed && onToken[v.Label]:
+				issues = append(issues, ReconcileIssue{Kind: IssueDestroyedPresent, Context: context, Label: v.Label})
+			case destroyed:
+			case !onToken[v.Label] && v.Label == keyMeta.Current:
+				issues = append(issues, ReconcileIssue{Kind: IssueCurrentMissing, Context: context, Label: v.Label})
+			case !onToken[v.Label]:
+				issues = append(issues, ReconcileIssue{Kind: IssueDangling, Context: context, Label: v.Label})
+			}
+		}
+		if findVersion(keyMeta.Versions, keyMeta.Current) == nil {
+			issues = append(issues, ReconcileIssue{Kind: IssueCurrentMissing, Context: context, Label: keyMeta.Current})
+		}
+	}
+
+	orphans := make([]KeyAttributes, 0)
+	for _, k := range tokenKeys {
+		if !inMetadata[k.Label] && k.Label != hsmCfg.MetadataSigning.GetKeyLabel() && k.KeyType == "AES" {
+			orphans = append(orphans, k)
+		}
+	}
+	sort.Slice(orphans, func(i, j int) bool { return orphans[i].Label < orphans[j].Label })
+	for _, k := range orphans {
+		issue := ReconcileIssue{Kind: IssueOrphan, Label: k.Label}
+		issue.Context, issue.Version = matchOrphanContext(metadata, hsmCfg, contexts, k.Label)
+		issues = append(issues, issue)
+	}
+
+	return issues
+}
+
+// matchOrphanContext finds the context whose label template (configured or
+// derived from the current label, as for rotation) produced the orphan label
+func matchOrphanContext(metadata *config.Metadata, hsmCfg *config.HSMConfig, contexts []string, label string) (string, int) {
+	for _, context := range contexts {
+		keyMeta := metadata.Rotation[context]
+		current := findVersion(keyMeta.Versions, keyMeta.Current)
+		if current == nil {
+			continue
+		}
+		tmpl, err := labelTemplateFor(context, *current, hsmCfg.ContextLabelTemplate(context))
+		if err != nil {
+			continue
+		}
+		if version, ok := config.MatchLabel(tmpl, label); ok {
+			return context, version
+		}
+	}
+	return "", 0
+}
+
+// HasCurrentMissing reports whether any context's current version is missing
+func HasCurrentMissing(issues []ReconcileIssue) bool {
+	for _, i := range issues {
+		if i.Kind == IssueCurrentMissing {
+			return true
+		}
+	}
+	return false
+}
+
+// ApplyReconcile returns a copy of metadata with the selected issues fixed and
+// descriptions of the changes. Missing current versions and destroyed keys
+// still on the token are never fixed automatically.
+func ApplyReconcile(metadata *config.Metadata, issues []ReconcileIssue, opts ReconcileOptions, now time.Time) (*config.Metadata, []string) {
+	fixed := cloneMetadata(metadata)
+	var changes []string
+
+	for _, issue := range issues {
+		switch {
+		case issue.Kind == IssueDangling && opts.PruneDangling:
+			keyMeta := fixed.Rotation[issue.Context]
+			kept := keyMeta.Versions[:0]
+			for _, v := range keyMeta.Versions {
+				if v.Label != issue.Label {
+					kept = append(kept, v)
+				}
+			}
+			keyMeta.Versions = kept
+			fixed.Rotation[issue.Context] = keyMeta
+			changes = append(changes, fmt.Sprintf("%s: - %s (pruned dangling version)", issue.Context, issue.Label))
+
+		case issue.Kind == IssueOrphan && opts.AddOrphans && issue.Context != "":
+			keyMeta := fixed.Rotation[issue.Context]
+			keyMeta.Versions = append(keyMeta.Versions, config.KeyVersion{
+				Label:          issue.Label,
+				Version:        issue.Version,
+				CreatedAt:      &now, // Real creation time is unknown
+				State:          config.KeyStateDecryptOnly,
+				StateChangedAt: &now,
+			})
+			sort.SliceStable(keyMeta.Versions, func(i, j int) bool {
+				return keyMeta.Versions[i].Version < keyMeta.Versions[j].Version
+			})
+			fixed.Rotation[issue.Context] = keyMeta
+			changes = append(changes, fmt.Sprintf("%s: + %s v%d (adopted orphan as decrypt_only)", issue.Context, issue.Label, issue.Version))
+		}
+	}
+
+	return fixed, changes
+}
+
+// cloneMetadata copies metadata so version slices can be changed safely
+func cloneMetadata(metadata *config.Metadata) *config.Metadata {
+	clone := *metadata
+	clone.Rotation = make(map[string]config.KeyMetadata, len(metadata.Rotation))
+	for name, keyMeta := range metadata.Rotation {
+		keyMeta.Versions = append([]config.KeyVersion(nil), keyMeta.Versions...)
+		clone.Rotation[name] = keyMeta
+	}
+	return &clone
+}
+
+// DiffMetadata describes how metadata would change from old to new
+func DiffMetadata(old, new *config.Metadata) []string {
+	var diff []string
+
+	names := make(map[string]bool)
+	for name := range old.Rotation {
+		names[name] = true
+	}
+	for name := range new.Rotation {
+		names[name] = true
+	}
+	contexts := make([]string, 0, len(names))
+	for name := range names {
+		contexts = append(contexts, name)
+	}
+	sort.Strings(contexts)
+
+	for _, context := range contexts {
+		o, inOld := old.Rotation[context]
+		n, inNew := new.Rotation[context]
+		switch {
+		case !inOld:
+			diff = append(diff, fmt.Sprintf("%s: + context (current %s, %d versions)", context, n.Current, len(n.Versions)))
+			continue
+		case !inNew:
+			diff = append(diff, fmt.Sprintf("%s: - context (current %s)", context, o.Current))
+			continue
+		}
+
+		if o.Current != n.Current {
+			diff = append(diff, fmt.Sprintf("%s: current %s -> %s", context, o.Current, n.Current))
+		}
+		for _, v := range n.Versions {
+			prev := findVersion(o.Versions, v.Label)
+			if prev == nil {
+				diff = append(diff, fmt.Sprintf("%s: + %s v%d", context, v.Label, v.Version))
+				continue
+			}
+			if from, to := prev.EffectiveState(o.Current), v.EffectiveState(n.Current); from != to {
+				diff = append(diff, fmt.Sprintf("%s: %s state %s -> %s", context, v.Label, from, to))
+			}
+		}
+		for _, v := range o.Versions {
+			if findVersion(n.Versions, v.Label) == nil {
+				diff = append(diff, fmt.Sprintf("%s: - %s v%d", context, v.Label, v.Version))
+			}
+		}
+	}
+
+	return diff
+}
+
+// sortedContexts returns metadata context names in order
+func sortedContexts(metadata *config.Metadata) []string {
+	contexts := make([]string, 0, len(metadata.Rotation))
+	for name := range metadata.Rotation {
+		contexts = append(contexts, name)
+	}
+	sort.Strings(contexts)
+	return contexts
+}
@@ -0,0 +1,153 @@
+package hsm
+
+import (
+	"testing"
+	"time"
+
+	"github.com/titaev-lv/hsm-service/internal/config"
+)
+
+func reconcileMetadata() *config.Metadata {
+	return &config.Metadata{Rotation: map[string]config.KeyMetadata{
+		"exchange": {
+			Current: "kek-exchange-v3",
+			Versions: []config.KeyVersion{
+				{Label: "kek-exchange-v1", Version: 1, State: config.KeyStateDestroyed},
+				{Label: "kek-exchange-v2", Version: 2},
+				{Label: "kek-exchange-v3", Version: 3},
+			},
+		},
+	}}
+}
+
+func issueKinds(issues []ReconcileIssue) map[string]string {
+	kinds := make(map[string]string)
+	for _, i := range issues {
+		kinds[i.Label] = i.Kind
+	}
+	return kinds
+}
+
+func TestReconcileMetadata(t *testing.T) {
+	tokenKeys := []KeyAttributes{
+		compliantKEK("kek-exchange-v1"), // destroyed but present
+		compliantKEK("kek-exchange-v3"),
+		compliantKEK("kek-exchange-v4"), // orphan of exchange
+		compliantKEK("kek-other"),       // orphan without context
+		{Label: "metadata-hmac", KeyType: "GENERIC_SECRET"},
+	}
+
+	issues := ReconcileMetadata(reconcileMetadata(), tokenKeys, &config.HSMConfig{})
+	kinds := issueKinds(issues)
+
+	want := map[string]string{
+		"kek-exchange-v1": IssueDestroyedPresent,
+		"kek-exchange-v2": IssueDangling,
+		"kek-exchange-v4": IssueOrphan,
+		"kek-other":       IssueOrphan,
+	}
+	if len(kinds) != len(want) {
+		t.Fatalf("issues = %v, want %v", kinds, want)
+	}
+	for label, kind := range want {
+		if kinds[label] != kind {
+			t.Errorf("%s: kind = %q, want %q", label, kinds[label], kind)
+		}
+	}
+	if HasCurrentMissing(issues) {
+		t.Error("current reported missing")
+	}
+	for _, i := range issues {
+		if i.Label == "kek-exchange-v4" && (i.Context != "exchange" || i.Version != 4) {
+			t.Errorf("orphan context = %q v%d, want exchange v4", i.Context, i.Version)
+		}
+		if i.Label == "kek-other" && i.Context != "" {
+			t.Errorf("unmatched orphan context = %q", i.Context)
+		}
+	}
+}
+
+func TestReconcileMetadata_CurrentMissing(t *testing.T) {
+	issues := ReconcileMetadata(reconcileMetadata(), []KeyAttributes{compliantKEK("kek-exchange-v2")}, &config.HSMConfig{})
+	if !HasCurrentMissing(issues) {
+		t.Fatalf("issues = %v, want current_missing", issues)
+	}
+}
+
+func TestApplyReconcile(t *testing.T) {
+	metadata := reconcileMetadata()
+	tokenKeys := []KeyAttributes{compliantKEK("kek-exchange-v3"), compliantKEK("kek-exchange-v4")}
+	issues := ReconcileMetadata(metadata, tokenKeys, &config.HSMConfig{})
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	fixed, changes := ApplyReconcile(metadata, issues, ReconcileOptions{AddOrphans: true, PruneDangling: true}, now)
+	if len(changes) != 2 {
+		t.Fatalf("changes = %v, want 2", changes)
+	}
+
+	versions := fixed.Rotation["exchange"].Versions
+	labels := make([]string, len(versions))
+	for i, v := range versions {
+		labels[i] = v.Label
+	}
+	if len(labels) != 3 || labels[0] != "kek-exchange-v1" || labels[1] != "kek-exchange-v3" || labels[2] != "kek-exchange-v4" {
+		t.Errorf("versions = %v", labels)
+	}
+	if versions[2].State != config.KeyStateDecryptOnly {
+		t.Errorf("adopted state = %s, want decrypt_only", versions[2].State)
+	}
+	if fixed.Rotation["exchange"].Current != "kek-exchange-v3" {
+		t.Error("current changed")
+	}
+
+	// The input is left untouched so callers can diff it against the result
+	if len(metadata.Rotation["exchange"].Versions) != 3 || metadata.Rotation["exchange"].Versions[1].Label != "kek-exchange-v2" {
+		t.Error("ApplyReconcile modified its input")
+	}
+	if diff := DiffMetadata(metadata, fixed); len(diff) != 2 {
+		t.Errorf("diff = %v, want 2 lines", diff)
+	}
+}
+
+func TestDiffMetadata(t *testing.T) {
+	old := reconcileMetadata()
+	new := reconcileMetadata()
+	keyMeta := new.Rotation["exchange"]
+	keyMeta.Current = "kek-exchange-v2"
+	keyMeta.Versions = keyMeta.Versions[:2]
+	new.Rotation["exchange"] = keyMeta
+	new.Rotation["payments"] = config.KeyMetadata{Current: "kek-payments-v1"}
+
+	diff := DiffMetadata(old, new)
+	want := []string{
+		"exchange: current kek-exchange-v3 -> kek-exchange-v2",
+		"exchange: kek-exchange-v2 state decrypt_only -> active",
+		"exchange: - kek-exchange-v3 v3",
+		"payments: + context (current kek-payments-v1, 0 versions)",
+	}
+	if len(diff) != len(want) {
+		t.Fatalf("diff = %q, want %q", diff, want)
+	}
+	for i := range want {
+		if diff[i] != want[i] {
+			t.Errorf("diff[%d] = %q, want %q", i, diff[i], want[i])
+		}
+	}
+}
+
+func TestReconcileMetadata_LabelTemplate(t *testing.T) {
+	metadata := &config.Metadata{Rotation: map[string]config.KeyMetadata{
+		"vault": {
+			Current:  "vault-key-1",
+			Versions: []config.KeyVersion{{Label: "vault-key-1", Version: 1}},
+		},
+	}}
+	hsmCfg := &config.HSMConfig{Keys: map[string]config.KeyConfig{
+		"vault": {LabelTemplate: "{context}-key-{version}"},
+	}}
+
+	issues := ReconcileMetadata(metadata, []KeyAttributes{compliantKEK("vault-key-1"), compliantKEK("vault-key-2")}, hsmCfg)
+	if len(issues) != 1 || issues[0].Context != "vault" || issues[0].Version != 2 {
+		t.Errorf("issues = %+v, want orphan vault v2", issues)
+	}
+}
@@ -0,0 +1,125 @@
+package hsm
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/titaev-lv/hsm-service/internal/config"
+)
+
+// retentionCheckInterval is how often the auto-reload loop enforces retention
+const retentionCheckInterval = time.Hour
+
+// RetentionTransition is a version moved to disabled after its retention period
+type RetentionTransition struct {
+	Context       string
+	Label         string
+	Version       int
+	RetentionDays int
+	SupersededAt  time.Time
+}
+
+// retentionExpired lists transitions due in metadata
+func retentionExpired(metadata *config.Metadata, hsmCfg *config.HSMConfig, now time.Time) []RetentionTransition {
+	var due []RetentionTransition
+	for _, contextName := range sortedContexts(metadata) {
+		keyMeta := metadata.Rotation[contextName]
+		policy := hsmCfg.RetentionPolicy(keyMeta)
+		for _, label := range policy.RetentionExpired(&keyMeta, now) {
+			v := findVersion(keyMeta.Versions, label)
+			due = append(due, RetentionTransition{
+				Context:       contextName,
+				Label:         label,
+				Version:       v.Version,
+				RetentionDays: policy.RetentionDays,
+				SupersededAt:  *keyMeta.SupersededAt(label),
+			})
+		}
+	}
+	return due
+}
+
+// EnforceRetention moves decrypt_only versions past their retention period
+// to disabled in a single locked metadata update (same lock, backup and
+// signing as RotateContext). Returns the applied transitions and the
+// metadata generation written (0 if nothing changed or signing is disabled).
+func EnforceRetention(store config.MetadataStore, hsmCfg *config.HSMConfig, opts RotateOptions, now time.Time) ([]RetentionTransition, uint64, error) {
+	// Cheap check without the lock: nothing to do in the common case
+	metadata, _, err := store.Load()
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to load metadata: %w", err)
+	}
+	if len(retentionExpired(metadata, hsmCfg, now)) == 0 {
+		return nil, 0, nil
+	}
+
+	unlock, err := store.Lock()
+	if err != nil {
+		return nil, 0, err
+	}
+	defer unlock()
+
+	metadata, revision, err := config.LoadVerifiedMetadata(store, opts.MAC, opts.MinGeneration)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to load metadata: %w", err)
+	}
+
+	transitions := retentionExpired(metadata, hsmCfg, now)
+	if len(transitions) == 0 {
+		return nil, 0, nil
+	}
+	for _, t := range transitions {
+		keyMeta := metadata.Rotation[t.Context]
+		if err := keyMeta.TransitionVersion(t.Label, config.KeyStateDisabled, now, 0); err != nil {
+			return nil, 0, err
+		}
+		metadata.Rotation[t.Context] = keyMeta
+	}
+
+	if _, err := store.Backup(opts.BackupDir); err != nil {
+		slog.Warn("failed to back up metadata", "error", err)
+	}
+
+	if _, err := config.SaveSignedMetadata(store, revision, metadata, opts.MAC); err != nil {
+		return nil, 0, fmt.Errorf("failed to write metadata: %w", err)
+	}
+
+	return transitions, metadata.Generation, nil
+}
+
+// enforceRetention applies retention transitions with audit records and
+// reloads keys so disabled versions stop decrypting immediately
+func (km *KeyManager) enforceRetention() {
+	transitions, _, err := EnforceRetention(km.store, km.hsmConfig, RotateOptions{
+		BackupDir:     km.hsmConfig.MetadataBackupDirPath(),
+		MAC:           km.metadataMAC,
+		MinGeneration: km.acceptedGeneration(),
+	}, time.Now())
+	if err != nil {
+		auditLog().Error("key state transition",
+			"trigger", "retention",
+			"outcome", "failure",
+			"error", err)
+		return
+	}
+
+	for _, t := range transitions {
+		auditLog().Info("key state transition",
+			"trigger", "retention",
+			"context", t.Context,
+			"label", t.Label,
+			"version", t.Version,
+			"from", config.KeyStateDecryptOnly,
+			"to", config.KeyStateDisabled,
+			"retention_days", t.RetentionDays,
+			"superseded_at", t.SupersededAt,
+			"outcome", "success")
+	}
+
+	if len(transitions) > 0 {
+		if err := km.ReloadMetadata(); err != nil {
+			slog.Error("reload after retention enforcement failed", "error", err)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package hsm
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/titaev-lv/hsm-service/internal/config"
+)
+
+func TestEnforceRetention(t *testing.T) {
+	dir := t.TempDir()
+	metadataPath := filepath.Join(dir, "metadata.yaml")
+
+	now := time.Now().UTC()
+	old := now.AddDate(0, -6, 0)
+	rotated := now.AddDate(0, 0, -40)
+	recent := now.AddDate(0, 0, -5)
+	metadata := &config.Metadata{Rotation: map[string]config.KeyMetadata{
+		"exchange": {
+			Current: "kek-exchange-v3",
+			Versions: []config.KeyVersion{
+				{Label: "kek-exchange-v1", Version: 1, CreatedAt: &old},
+				{Label: "kek-exchange-v2", Version: 2, CreatedAt: &rotated},
+				{Label: "kek-exchange-v3", Version: 3, CreatedAt: &recent},
+			},
+		},
+	}}
+	if err := config.SaveMetadata(metadataPath, metadata); err != nil {
+		t.Fatal(err)
+	}
+
+	hsmCfg := &config.HSMConfig{MetadataFile: metadataPath, RetentionDays: 30}
+	transitions, _, err := EnforceRetention(config.NewFileMetadataStore(metadataPath), hsmCfg, RotateOptions{}, now)
+	if err != nil {
+		t.Fatalf("EnforceRetention: %v", err)
+	}
+	// v1 was superseded 40 days ago, v2 only 5 days ago
+	if len(transitions) != 1 || transitions[0].Label != "kek-exchange-v1" {
+		t.Fatalf("transitions = %+v, want kek-exchange-v1", transitions)
+	}
+
+	saved, err := config.LoadMetadata(metadataPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyMeta := saved.Rotation["exchange"]
+	if state := keyMeta.Versions[0].EffectiveState(keyMeta.Current); state != config.KeyStateDisabled {
+		t.Errorf("v1 state = %s, want disabled", state)
+	}
+	if state := keyMeta.Versions[1].EffectiveState(keyMeta.Current); state != config.KeyStateDecryptOnly {
+		t.Errorf("v2 state = %s, want decrypt_only", state)
+	}
+	if entries, _ := os.ReadDir(filepath.Join(dir, "backups")); len(entries) != 1 {
+		t.Errorf("backups = %d, want 1", len(entries))
+	}
+
+	// Second run has nothing left to do
+	transitions, _, err = EnforceRetention(config.NewFileMetadataStore(metadataPath), hsmCfg, RotateOptions{}, now)
+	if err != nil || len(transitions) != 0 {
+		t.Errorf("second run: transitions = %v, err = %v", transitions, err)
+	}
+}
+
+func TestEnforceRetention_Disabled(t *testing.T) {
+	metadataPath := filepath.Join(t.TempDir(), "metadata.yaml")
+	old := time.Now().AddDate(-1, 0, 0)
+	recent := time.Now().AddDate(0, -6, 0)
+	metadata := &config.Metadata{Rotation: map[string]config.KeyMetadata{
+		"exchange": {
+			Current: "kek-exchange-v2",
+			Versions: []config.KeyVersion{
+				{Label: "kek-exchange-v1", Version: 1, CreatedAt: &old},
+				{Label: "kek-exchange-v2", Version: 2, CreatedAt: &recent},
+			},
+		},
+	}}
+	if err := config.SaveMetadata(metadataPath, metadata); err != nil {
+		t.Fatal(err)
+	}
+
+	transitions, _, err := EnforceRetention(config.NewFileMetadataStore(metadataPath), &config.HSMConfig{}, RotateOptions{}, time.Now())
+	if err != nil || len(transitions) != 0 {
+		t.Errorf("retention_days unset: transitions = %v, err = %v", transitions, err)
+	}
+}
@@ -0,0 +1,406 @@
+package hsm
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/ThalesGroup/crypto11"
+	"github.com/titaev-lv/hsm-service/internal/config"
+)
+
+// ErrRotationNotDue is returned when RotateOptions.OnlyIfDue is set and the
+// current key of the context is still within its rotation interval
+var ErrRotationNotDue = errors.New("rotation not due")
+
+// RotateOptions controls RotateContext behaviour
+type RotateOptions struct {
+	// OnlyIfDue re-checks the rotation interval after the metadata lock is
+	// taken and returns ErrRotationNotDue if the context was already rotated
+	// (e.g. by hsm-admin while the scheduler waited for the lock)
+	OnlyIfDue bool
+
+	// BackupDir receives a copy of the metadata before it is changed
+	// (empty: backups/ next to the metadata store)
+	BackupDir string
+
+	// MAC signs the updated metadata (nil: metadata signing disabled);
+	// loaded metadata must verify and be at least MinGeneration
+	MAC           config.MetadataMAC
+	MinGeneration uint64
+
+	// LabelTemplate names the new version (HSMConfig.ContextLabelTemplate;
+	// empty: the current label with its -v<N> suffix replaced)
+	LabelTemplate string
+}
+
+// RotationResult describes a completed rotation
+type RotationResult struct {
+	Context    string
+	OldLabel   string
+	OldVersion int
+	NewLabel   string
+	NewVersion int
+	BackupPath string
+	Generation uint64 // Metadata generation written (signed metadata only)
+}
+
+// RotateContext rotates the KEK of a context as a single transaction:
+// lock metadata, generate the new key in the HSM, verify it with a GCM
+// round trip, write metadata atomically, unlock. If anything fails after
+// the key was generated, the new HSM object is deleted again.
+func RotateContext(ctx *crypto11.Context, store config.MetadataStore, contextName string, opts RotateOptions) (*RotationResult, error) {
+	// 1. Acquire exclusive lock (shared with hsm-admin commands)
+	unlock, err := store.Lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	// 2. Load metadata (after acquiring lock)
+	metadata, revision, err := config.LoadVerifiedMetadata(store, opts.MAC, opts.MinGeneration)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load metadata: %w", err)
+	}
+
+	keyMeta, found := metadata.Rotation[contextName]
+	if !found {
+		return nil, fmt.Errorf("context %s not found in metadata", contextName)
+	}
+
+	currentVersion := findVersion(keyMeta.Versions, keyMeta.Current)
+	if currentVersion == nil {
+		return nil, fmt.Errorf("current version %s not found in versions list", keyMeta.Current)
+	}
+	if opts.OnlyIfDue && !versionMetadata(keyMeta, *currentVersion).NeedsRotation() {
+		return nil, ErrRotationNotDue
+	}
+
+	// 3. Derive new label (increment from highest version, not current)
+	plan, err := PlanRotation(metadata, contextName, opts.LabelTemplate)
+	if err != nil {
+		return nil, err
+	}
+	newLabel := plan.NewLabel
+
+	// 4. Generate new KEK inside the HSM
+	newKey, err := GenerateKEK(ctx, newLabel)
+	if err != nil {
+		return nil, err
+	}
+
+	// From here on, any failure must remove the new HSM object so that
+	// the token and metadata stay consistent
+	committed := false
+	defer func() {
+		if committed {
+			return
+		}
+		slog.Warn("rolling back rotation, deleting new KEK", "label", newLabel)
+		if err := newKey.Delete(); err != nil {
+			slog.Error("rotation rollback failed, remove key manually",
+				"label", newLabel,
+				"error", err)
+		}
+	}()
+
+	// 5. Verify new KEK with an encrypt/decrypt round trip
+	if err := VerifyKEK(newKey); err != nil {
+		return nil, fmt.Errorf("new KEK %s failed verification: %w", newLabel, err)
+	}
+	checksum, err := ComputeKeyChecksum(ctx, newKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute checksum for %s: %w", newLabel, err)
+	}
+
+	// 6. Add new version to metadata, previous version becomes decrypt-only
+	if err := applyRotationPlan(metadata, plan, checksum, time.Now()); err != nil {
+		return nil, err
+	}
+
+	result := &RotationResult{
+		Context:    contextName,
+		OldLabel:   plan.OldLabel,
+		OldVersion: plan.OldVersion,
+		NewLabel:   plan.NewLabel,
+		NewVersion: plan.NewVersion,
+	}
+
+	// 7. Backup old metadata (best effort)
+	if backupPath, err := store.Backup(opts.BackupDir); err != nil {
+		slog.Warn("failed to back up metadata", "error", err)
+	} else {
+		result.BackupPath = backupPath
+	}
+
+	// 8. Write updated metadata atomically (refused if it changed since step 2)
+	if _, err := config.SaveSignedMetadata(store, revision, metadata, opts.MAC); err != nil {
+		return nil, fmt.Errorf("failed to write metadata: %w", err)
+	}
+	committed = true
+	result.Generation = metadata.Generation
+
+	return result, nil
+}
+
+// RotationPlan describes the version a rotation creates
+type RotationPlan struct {
+	Context    string
+	OldLabel   string
+	OldVersion int
+	NewLabel   string
+	NewVersion int
+}
+
+// PlanRotation derives the next version of a context without touching the HSM
+// The version number comes from metadata; labelTemplate is as in RotateOptions
+func PlanRotation(metadata *config.Metadata, contextName, labelTemplate string) (*RotationPlan, error) {
+	keyMeta, found := metadata.Rotation[contextName]
+	if !found {
+		return nil, fmt.Errorf("context %s not found in metadata", contextName)
+	}
+
+	currentVersion := findVersion(keyMeta.Versions, keyMeta.Current)
+	if currentVersion == nil {
+		return nil, fmt.Errorf("current version %s not found in versions list", keyMeta.Current)
+	}
+
+	tmpl, err := labelTemplateFor(contextName, *currentVersion, labelTemplate)
+	if err != nil {
+		return nil, err
+	}
+	newVersion := highestVersion(keyMeta.Versions) + 1
+	newLabel := config.RenderLabel(tmpl, newVersion)
+	if findVersion(keyMeta.Versions, newLabel) != nil {
+		return nil, fmt.Errorf("version %s already exists, cannot create duplicate", newLabel)
+	}
+
+	return &RotationPlan{
+		Context:    contextName,
+		OldLabel:   currentVersion.Label,
+		OldVersion: currentVersion.Version,
+		NewLabel:   newLabel,
+		NewVersion: newVersion,
+	}, nil
+}
+
+// PreviewRotation returns the rotation plan and a copy of metadata as the
+// rotation would leave it (without the new key checksum)
+func PreviewRotation(metadata *config.Metadata, contextName, labelTemplate string, now time.Time) (*RotationPlan, *config.Metadata, error) {
+	plan, err := PlanRotation(metadata, contextName, labelTemplate)
+	if err != nil {
+		return nil, nil, err
+	}
+	preview := cloneMetadata(metadata)
+	if err := applyRotationPlan(preview, plan, "", now); err != nil {
+		return nil, nil, err
+	}
+	return plan, preview, nil
+}
+
+// applyRotationPlan adds the new version as current and moves the previous
+// current version to decrypt_only
+func applyRotationPlan(metadata *config.Metadata, plan *RotationPlan, checksum string, now time.Time) error {
+	keyMeta := metadata.Rotation[plan.Context]
+	pinEffectiveStates(&keyMeta)
+	keyMeta.Versions = append(keyMeta.Versions, config.KeyVersion{
+		Label:          plan.NewLabel,
+		Version:        plan.NewVersion,
+		CreatedAt:      &now,
+		Checksum:       checksum,
+		State:          config.KeyStateActive,
+		StateChangedAt: &now,
+	})
+	keyMeta.Current = plan.NewLabel
+	if err := keyMeta.TransitionVersion(plan.OldLabel, config.KeyStateDecryptOnly, now, 0); err != nil {
+		return err
+	}
+	metadata.Rotation[plan.Context] = keyMeta
+	return nil
+}
+
+// DueContexts returns contexts whose current version has passed its
+// rotation interval, in name order
+func DueContexts(metadata *config.Metadata) []string {
+	var due []string
+	for _, contextName := range sortedContexts(metadata) {
+		keyMeta := metadata.Rotation[contextName]
+		current := findVersion(keyMeta.Versions, keyMeta.Current)
+		if current != nil && versionMetadata(keyMeta, *current).NeedsRotation() {
+			due = append(due, contextName)
+		}
+	}
+	return due
+}
+
+// RollbackResult describes a completed rollback
+type RollbackResult struct {
+	Context     string
+	FromLabel   string // Version that was current, now decrypt_only
+	FromVersion int
+	ToLabel     string // Previous version, current again
+	ToVersion   int
+	BackupPath  string
+	Generation  uint64 // Metadata generation written (signed metadata only)
+}
+
+// previousVersion returns the highest decrypt_only version older than current
+func previousVersion(keyMeta config.KeyMetadata, current *config.KeyVersion) *config.KeyVersion {
+	var prev *config.KeyVersion
+	for i := range keyMeta.Versions {
+		v := &keyMeta.Versions[i]
+		if v.Version >= current.Version || v.EffectiveState(keyMeta.Current) != config.KeyStateDecryptOnly {
+			continue
+		}
+		if prev == nil || v.Version > prev.Version {
+			prev = v
+		}
+	}
+	return prev
+}
+
+// RollbackContext points current back at the previous decrypt_only version
+// of a context. The rolled-back version stays on the token as decrypt_only,
+// so data already encrypted with it remains readable.
+func RollbackContext(ctx *crypto11.Context, store config.MetadataStore, contextName string, opts RotateOptions) (*RollbackResult, error) {
+	unlock, err := store.Lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	metadata, revision, err := config.LoadVerifiedMetadata(store, opts.MAC, opts.MinGeneration)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load metadata: %w", err)
+	}
+
+	keyMeta, found := metadata.Rotation[contextName]
+	if !found {
+		return nil, fmt.Errorf("context %s not found in metadata", contextName)
+	}
+	current := findVersion(keyMeta.Versions, keyMeta.Current)
+	if current == nil {
+		return nil, fmt.Errorf("current version %s not found in versions list", keyMeta.Current)
+	}
+	prev := previousVersion(keyMeta, current)
+	if prev == nil {
+		return nil, fmt.Errorf("context %s has no earlier decrypt_only version to roll back to", contextName)
+	}
+
+	result := &RollbackResult{
+		Context:     contextName,
+		FromLabel:   current.Label,
+		FromVersion: current.Version,
+		ToLabel:     prev.Label,
+		ToVersion:   prev.Version,
+	}
+
+	// The previous key must still be usable before it becomes current
+	key, err := ctx.FindKey(nil, []byte(prev.Label))
+	if err != nil {
+		return nil, fmt.Errorf("failed to find key %s: %w", prev.Label, err)
+	}
+	if key == nil {
+		return nil, fmt.Errorf("key %s not found in HSM", prev.Label)
+	}
+	if err := VerifyKEK(key); err != nil {
+		return nil, fmt.Errorf("key %s failed verification: %w", prev.Label, err)
+	}
+
+	if err := applyRollback(&keyMeta, result.ToLabel, time.Now()); err != nil {
+		return nil, err
+	}
+	metadata.Rotation[contextName] = keyMeta
+
+	if backupPath, err := store.Backup(opts.BackupDir); err != nil {
+		slog.Warn("failed to back up metadata", "error", err)
+	} else {
+		result.BackupPath = backupPath
+	}
+
+	if _, err := config.SaveSignedMetadata(store, revision, metadata, opts.MAC); err != nil {
+		return nil, fmt.Errorf("failed to write metadata: %w", err)
+	}
+	result.Generation = metadata.Generation
+
+	return result, nil
+}
+
+// applyRollback makes label current again; the current version becomes decrypt_only
+func applyRollback(keyMeta *config.KeyMetadata, label string, now time.Time) error {
+	from := keyMeta.Current
+	pinEffectiveStates(keyMeta)
+	keyMeta.Current = label
+	if err := keyMeta.TransitionVersion(from, config.KeyStateDecryptOnly, now, 0); err != nil {
+		return err
+	}
+	return keyMeta.TransitionVersion(label, config.KeyStateActive, now, 0)
+}
+
+// pinEffectiveStates records the implicit state of versions written before
+// lifecycle states existed, so changing Current does not change them
+func pinEffectiveStates(keyMeta *config.KeyMetadata) {
+	for i := range keyMeta.Versions {
+		keyMeta.Versions[i].State = keyMeta.Versions[i].EffectiveState(keyMeta.Current)
+	}
+}
+
+// findVersion returns the version with the given label, or nil
+func findVersion(versions []config.KeyVersion, label string) *config.KeyVersion {
+	for i := range versions {
+		if versions[i].Label == label {
+			return &versions[i]
+		}
+	}
+	return nil
+}
+
+// highestVersion returns the highest version number among all versions
+func highestVersion(versions []config.KeyVersion) int {
+	highest := 0
+	for _, v := range versions {
+		if v.Version > highest {
+			highest = v.Version
+		}
+	}
+	return highest
+}
+
+// labelTemplateFor returns the expanded label template of a context.
+// Without a configured template the current label must end in -v<N>, N
+// being its version in metadata (so "kek-dev-vault-v1" keeps its prefix).
+func labelTemplateFor(contextName string, current config.KeyVersion, configured string) (string, error) {
+	if configured != "" {
+		return configured, nil
+	}
+	suffix := fmt.Sprintf("-v%d", current.Version)
+	if current.Version < 1 || !strings.HasSuffix(current.Label, suffix) {
+		return "", fmt.Errorf("cannot derive next label from %s (version %d): set hsm.keys.%s.label_template",
+			current.Label, current.Version, contextName)
+	}
+	return strings.TrimSuffix(current.Label, suffix) + "-v" + config.LabelPlaceholderVersion, nil
+}
+
+// versionMetadata builds KeyMetadata for a version using the context's rotation policy
+func versionMetadata(keyMeta config.KeyMetadata, version config.KeyVersion) *KeyMetadata {
+	createdAt := time.Now()
+	if version.CreatedAt != nil {
+		createdAt = *version.CreatedAt
+	}
+
+	// Get rotation interval from metadata (with fallback to 90 days)
+	rotationIntervalDays := keyMeta.RotationIntervalDays
+	if rotationIntervalDays == 0 {
+		rotationIntervalDays = 90 // Default: 90 days (PCI DSS compliant)
+	}
+
+	return &KeyMetadata{
+		Label:            version.Label,
+		Version:          version.Version,
+		CreatedAt:        createdAt,
+		RotationInterval: time.Duration(rotationIntervalDays) * 24 * time.Hour,
+		State:            version.EffectiveState(keyMeta.Current),
+	}
+}
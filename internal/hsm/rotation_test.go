@@ -372,3 +372,139 @@ func TestRotateKey_ConcurrentRotation(t *testing.T) {
 	// 3. Verify only one rotation proceeds
 	// 4. Verify no data corruption
 }
+
+func TestPreviewRotation(t *testing.T) {
+	created := time.Now().Add(-24 * time.Hour)
+	metadata := &config.Metadata{Rotation: map[string]config.KeyMetadata{
+		"exchange": {
+			Current: "kek-exchange-v2",
+			Versions: []config.KeyVersion{
+				{Label: "kek-exchange-v1", Version: 1, CreatedAt: &created},
+				{Label: "kek-exchange-v2", Version: 2, CreatedAt: &created},
+			},
+		},
+	}}
+
+	plan, preview, err := PreviewRotation(metadata, "exchange", "", time.Now())
+	if err != nil {
+		t.Fatalf("PreviewRotation: %v", err)
+	}
+	if plan.OldLabel != "kek-exchange-v2" || plan.NewLabel != "kek-exchange-v3" || plan.NewVersion != 3 {
+		t.Errorf("plan = %+v", plan)
+	}
+
+	keyMeta := preview.Rotation["exchange"]
+	if keyMeta.Current != "kek-exchange-v3" || len(keyMeta.Versions) != 3 {
+		t.Errorf("preview current = %s, versions = %d", keyMeta.Current, len(keyMeta.Versions))
+	}
+	if state := keyMeta.Versions[1].EffectiveState(keyMeta.Current); state != config.KeyStateDecryptOnly {
+		t.Errorf("old current state = %s, want decrypt_only", state)
+	}
+	if metadata.Rotation["exchange"].Current != "kek-exchange-v2" || len(metadata.Rotation["exchange"].Versions) != 2 {
+		t.Error("PreviewRotation modified its input")
+	}
+
+	if _, _, err := PreviewRotation(metadata, "missing", "", time.Now()); err == nil {
+		t.Error("expected error for unknown context")
+	}
+}
+
+func TestDueContexts(t *testing.T) {
+	old := time.Now().Add(-100 * 24 * time.Hour)
+	recent := time.Now().Add(-24 * time.Hour)
+	metadata := &config.Metadata{Rotation: map[string]config.KeyMetadata{
+		"exchange": {
+			Current:  "kek-exchange-v1",
+			Versions: []config.KeyVersion{{Label: "kek-exchange-v1", Version: 1, CreatedAt: &old}},
+		},
+		"2fa": {
+			Current:  "kek-2fa-v1",
+			Versions: []config.KeyVersion{{Label: "kek-2fa-v1", Version: 1, CreatedAt: &recent}},
+		},
+		"audit": {
+			Current:              "kek-audit-v1",
+			RotationIntervalDays: 30,
+			Versions:             []config.KeyVersion{{Label: "kek-audit-v1", Version: 1, CreatedAt: &old}},
+		},
+	}}
+
+	due := DueContexts(metadata)
+	if len(due) != 2 || due[0] != "audit" || due[1] != "exchange" {
+		t.Errorf("due = %v, want [audit exchange]", due)
+	}
+}
+
+func TestPreviousVersion(t *testing.T) {
+	keyMeta := config.KeyMetadata{
+		Current: "kek-exchange-v4",
+		Versions: []config.KeyVersion{
+			{Label: "kek-exchange-v1", Version: 1},
+			{Label: "kek-exchange-v2", Version: 2},
+			{Label: "kek-exchange-v3", Version: 3, State: config.KeyStateDisabled},
+			{Label: "kek-exchange-v4", Version: 4},
+		},
+	}
+
+	prev := previousVersion(keyMeta, &keyMeta.Versions[3])
+	if prev == nil || prev.Label != "kek-exchange-v2" {
+		t.Fatalf("previous = %+v, want kek-exchange-v2 (v3 is disabled)", prev)
+	}
+
+	if prev := previousVersion(keyMeta, &keyMeta.Versions[0]); prev != nil {
+		t.Errorf("previous of v1 = %s, want none", prev.Label)
+	}
+}
+
+func TestApplyRollback(t *testing.T) {
+	keyMeta := config.KeyMetadata{
+		Current: "kek-exchange-v2",
+		Versions: []config.KeyVersion{
+			{Label: "kek-exchange-v1", Version: 1},
+			{Label: "kek-exchange-v2", Version: 2},
+		},
+	}
+
+	if err := applyRollback(&keyMeta, "kek-exchange-v1", time.Now()); err != nil {
+		t.Fatalf("applyRollback: %v", err)
+	}
+	if keyMeta.Current != "kek-exchange-v1" {
+		t.Errorf("current = %s, want kek-exchange-v1", keyMeta.Current)
+	}
+	if keyMeta.Versions[0].State != config.KeyStateActive || keyMeta.Versions[1].State != config.KeyStateDecryptOnly {
+		t.Errorf("states = %s, %s, want active, decrypt_only", keyMeta.Versions[0].State, keyMeta.Versions[1].State)
+	}
+}
+
+func TestLabelTemplateFor(t *testing.T) {
+	tests := []struct {
+		name       string
+		current    config.KeyVersion
+		configured string
+		wantLabel  string
+		wantErr    bool
+	}{
+		{"legacy", config.KeyVersion{Label: "kek-exchange-v1", Version: 1}, "", "kek-exchange-v2", false},
+		{"two -v separators", config.KeyVersion{Label: "kek-dev-vault-v1", Version: 1}, "", "kek-dev-vault-v2", false},
+		{"configured template", config.KeyVersion{Label: "kek-exchange-v1", Version: 1}, "kek-exchange-prod-v{version}", "kek-exchange-prod-v2", false},
+		{"label without version suffix", config.KeyVersion{Label: "kek-exchange", Version: 1}, "", "", true},
+		{"suffix does not match metadata version", config.KeyVersion{Label: "kek-exchange-v3", Version: 1}, "", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, err := labelTemplateFor("exchange", tt.current, tt.configured)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("expected error, got template %q", tmpl)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if label := config.RenderLabel(tmpl, 2); label != tt.wantLabel {
+				t.Errorf("label = %q, want %q", label, tt.wantLabel)
+			}
+		})
+	}
+}
@@ -0,0 +1,154 @@
+package hsm
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/titaev-lv/hsm-service/internal/audit"
+	"github.com/titaev-lv/hsm-service/internal/config"
+)
+
+// RotationScheduler rotates keys whose rotation interval has passed
+// It only acts inside configured maintenance windows and uses the same
+// metadata lock as 'hsm-admin rotate'. New versions become active through
+// KeyManager hot reload, no restart required.
+type RotationScheduler struct {
+	km  *KeyManager
+	cfg *config.AutoRotationConfig
+
+	// Replaceable for tests
+	now    func() time.Time
+	rotate func(contextName string) (*RotationResult, error)
+	reload func() error
+
+	stop     chan struct{}
+	wg       sync.WaitGroup
+	stopOnce sync.Once
+}
+
+// NewRotationScheduler creates a scheduler for the given KeyManager
+func NewRotationScheduler(km *KeyManager, cfg *config.AutoRotationConfig) *RotationScheduler {
+	return &RotationScheduler{
+		km:  km,
+		cfg: cfg,
+		now: time.Now,
+		rotate: func(contextName string) (*RotationResult, error) {
+			return RotateContext(km.ctx, km.store, contextName, RotateOptions{
+				OnlyIfDue:     true,
+				BackupDir:     km.hsmConfig.MetadataBackupDirPath(),
+				MAC:           km.metadataMAC,
+				MinGeneration: km.acceptedGeneration(),
+				LabelTemplate: km.hsmConfig.ContextLabelTemplate(contextName),
+			})
+		},
+		reload: km.ReloadMetadata,
+		stop:   make(chan struct{}),
+	}
+}
+
+// Start runs the scheduler loop in the background
+func (s *RotationScheduler) Start() {
+	interval := s.cfg.GetCheckInterval()
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				s.RunOnce()
+			case <-s.stop:
+				slog.Info("Stopping key rotation scheduler")
+				return
+			}
+		}
+	}()
+
+	slog.Info("Started key rotation scheduler",
+		"interval", interval,
+		"maintenance_windows", len(s.cfg.MaintenanceWindows))
+}
+
+// RunOnce rotates every due context if the current time is inside a
+// maintenance window. Returns the number of successful rotations.
+func (s *RotationScheduler) RunOnce() int {
+	due := s.km.GetContextsNeedingRotation()
+	if len(due) == 0 {
+		return 0
+	}
+
+	if !s.cfg.InMaintenanceWindow(s.now()) {
+		slog.Info("keys need rotation, waiting for maintenance window", "contexts", due)
+		return 0
+	}
+
+	rotated := 0
+	for _, contextName := range due {
+		result, err := s.rotate(contextName)
+		if errors.Is(err, ErrRotationNotDue) {
+			slog.Info("context already rotated, skipping", "context", contextName)
+			continue
+		}
+		if err != nil {
+			RecordRotation(contextName, "scheduled", "failure")
+			auditLog().Error("key rotation",
+				"trigger", "scheduled",
+				"context", contextName,
+				"outcome", "failure",
+				"error", err)
+			continue
+		}
+
+		RecordRotation(contextName, "scheduled", "success")
+		auditLog().Info("key rotation",
+			"trigger", "scheduled",
+			"context", contextName,
+			"outcome", "success",
+			"old_label", result.OldLabel,
+			"old_version", result.OldVersion,
+			"new_label", result.NewLabel,
+			"new_version", result.NewVersion)
+		rotated++
+	}
+
+	// Apply immediately instead of waiting for the next hot reload tick
+	if rotated > 0 {
+		if err := s.reload(); err != nil {
+			slog.Error("reload after scheduled rotation failed", "error", err)
+		}
+	}
+
+	return rotated
+}
+
+// Stop stops the scheduler loop gracefully
+func (s *RotationScheduler) Stop(ctx context.Context) error {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+	})
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// auditLog returns a logger for audit events emitted by the hsm package
+func auditLog() *slog.Logger {
+	return audit.Logger()
+}
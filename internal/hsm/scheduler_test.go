@@ -0,0 +1,102 @@
+package hsm
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/titaev-lv/hsm-service/internal/config"
+)
+
+// newSchedulerTestKeyManager builds a KeyManager with in-memory state only
+func newSchedulerTestKeyManager(createdAt time.Time) *KeyManager {
+	return &KeyManager{
+		contextToLabel: map[string]string{
+			"exchange-key": "kek-exchange-v1",
+			"2fa":          "kek-2fa-v1",
+		},
+		metadata: map[string]*KeyMetadata{
+			"kek-exchange-v1": {Label: "kek-exchange-v1", Version: 1, CreatedAt: createdAt, RotationInterval: 90 * 24 * time.Hour},
+			"kek-2fa-v1":      {Label: "kek-2fa-v1", Version: 1, CreatedAt: time.Now(), RotationInterval: 90 * 24 * time.Hour},
+		},
+		stopReload: make(chan struct{}),
+	}
+}
+
+func TestRotationScheduler_RotatesDueContexts(t *testing.T) {
+	km := newSchedulerTestKeyManager(time.Now().AddDate(0, 0, -100))
+	s := NewRotationScheduler(km, &config.AutoRotationConfig{Enabled: true})
+
+	var rotated []string
+	reloads := 0
+	s.rotate = func(contextName string) (*RotationResult, error) {
+		rotated = append(rotated, contextName)
+		return &RotationResult{Context: contextName, OldLabel: "kek-exchange-v1", NewLabel: "kek-exchange-v2", NewVersion: 2}, nil
+	}
+	s.reload = func() error { reloads++; return nil }
+
+	if n := s.RunOnce(); n != 1 {
+		t.Fatalf("RunOnce() = %d, want 1", n)
+	}
+	if len(rotated) != 1 || rotated[0] != "exchange-key" {
+		t.Errorf("rotated = %v, want [exchange-key]", rotated)
+	}
+	if reloads != 1 {
+		t.Errorf("reloads = %d, want 1", reloads)
+	}
+}
+
+func TestRotationScheduler_OutsideMaintenanceWindow(t *testing.T) {
+	km := newSchedulerTestKeyManager(time.Now().AddDate(0, 0, -100))
+	s := NewRotationScheduler(km, &config.AutoRotationConfig{
+		Enabled:            true,
+		MaintenanceWindows: []config.MaintenanceWindow{{Start: "02:00", End: "03:00"}},
+	})
+	s.now = func() time.Time { return time.Date(2026, 1, 10, 12, 0, 0, 0, time.UTC) }
+	s.rotate = func(contextName string) (*RotationResult, error) {
+		t.Fatalf("rotate called outside maintenance window for %s", contextName)
+		return nil, nil
+	}
+
+	if n := s.RunOnce(); n != 0 {
+		t.Errorf("RunOnce() = %d, want 0", n)
+	}
+}
+
+func TestRotationScheduler_NotDueAndFailures(t *testing.T) {
+	km := newSchedulerTestKeyManager(time.Now().AddDate(0, 0, -100))
+	km.metadata["kek-2fa-v1"].CreatedAt = time.Now().AddDate(0, 0, -100)
+
+	s := NewRotationScheduler(km, &config.AutoRotationConfig{Enabled: true})
+	s.rotate = func(contextName string) (*RotationResult, error) {
+		if contextName == "2fa" {
+			return nil, ErrRotationNotDue
+		}
+		return nil, errors.New("HSM unavailable")
+	}
+	s.reload = func() error {
+		t.Fatal("reload should not be called when nothing was rotated")
+		return nil
+	}
+
+	if n := s.RunOnce(); n != 0 {
+		t.Errorf("RunOnce() = %d, want 0", n)
+	}
+}
+
+func TestRotationScheduler_Stop(t *testing.T) {
+	km := newSchedulerTestKeyManager(time.Now())
+	s := NewRotationScheduler(km, &config.AutoRotationConfig{Enabled: true, CheckInterval: "1m"})
+	s.Start()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := s.Stop(ctx); err != nil {
+		t.Fatalf("Stop() error = %v", err)
+	}
+	// Second stop must not panic
+	if err := s.Stop(ctx); err != nil {
+		t.Fatalf("second Stop() error = %v", err)
+	}
+}
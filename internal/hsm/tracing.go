@@ -0,0 +1,20 @@
+package hsm
+
+import (
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer creates the spans of key operations (a no-op until a tracer
+// provider is installed)
+var tracer = otel.Tracer("github.com/titaev-lv/hsm-service/internal/hsm")
+
+// endSpan ends span, recording err (nil = success)
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,49 @@
+package hsm
+
+import (
+	"context"
+	"testing"
+
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestKeyManager_Spans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	prev := tracer
+	tracer = provider.Tracer("test")
+	t.Cleanup(func() { tracer = prev })
+
+	km := newTestKeyManager(t)
+	ctx, parent := provider.Tracer("test").Start(context.Background(), "request")
+	ciphertext, label, err := km.Encrypt(ctx, []byte("secret"), "test", "OU", "client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := km.Decrypt(ctx, ciphertext, "test", "OU", "other-client", label); err == nil {
+		t.Fatal("Decrypt() with another client's AAD should fail")
+	}
+	parent.End()
+
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, s := range recorder.Ended() {
+		spans[s.Name()] = s
+	}
+	enc, seal := spans["KeyManager.Encrypt"], spans["pkcs11.Seal"]
+	if enc == nil || seal == nil {
+		t.Fatalf("missing encrypt spans: %v", recorder.Ended())
+	}
+	if enc.Parent().SpanID() != parent.SpanContext().SpanID() || seal.Parent().SpanID() != enc.SpanContext().SpanID() {
+		t.Error("encrypt spans are not nested under the request span")
+	}
+
+	dec, open := spans["KeyManager.Decrypt"], spans["pkcs11.Open"]
+	if dec == nil || open == nil {
+		t.Fatalf("missing decrypt spans: %v", recorder.Ended())
+	}
+	if dec.Status().Code != codes.Error || open.Status().Code != codes.Error {
+		t.Errorf("failed decrypt not recorded as error: %v / %v", dec.Status(), open.Status())
+	}
+}
@@ -0,0 +1,190 @@
+package hsm
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/titaev-lv/hsm-service/internal/config"
+)
+
+// KeyUsage holds usage statistics for a single key version
+type KeyUsage struct {
+	EncryptCount  uint64     `json:"encrypt_count"`
+	DecryptCount  uint64     `json:"decrypt_count"`
+	LastEncryptAt *time.Time `json:"last_encrypt_at,omitempty"`
+	LastDecryptAt *time.Time `json:"last_decrypt_at,omitempty"`
+}
+
+// LastUsed returns the most recent encrypt or decrypt time (zero if never used)
+func (u KeyUsage) LastUsed() time.Time {
+	var last time.Time
+	if u.LastEncryptAt != nil {
+		last = *u.LastEncryptAt
+	}
+	if u.LastDecryptAt != nil && u.LastDecryptAt.After(last) {
+		last = *u.LastDecryptAt
+	}
+	return last
+}
+
+// usageFile is the on-disk format of the usage file
+type usageFile struct {
+	UpdatedAt time.Time           `json:"updated_at"`
+	Keys      map[string]KeyUsage `json:"keys"`
+}
+
+// UsageTracker tracks per-key-version usage and persists it to a local file
+// so that counters and last-used timestamps survive restarts
+type UsageTracker struct {
+	path  string
+	mu    sync.Mutex
+	usage map[string]*KeyUsage // label -> usage
+	dirty bool
+}
+
+// NewUsageTracker creates a tracker, loading previous state from path if it exists
+// An empty path keeps statistics in memory only
+func NewUsageTracker(path string) (*UsageTracker, error) {
+	t := &UsageTracker{
+		path:  path,
+		usage: make(map[string]*KeyUsage),
+	}
+
+	if path == "" {
+		return t, nil
+	}
+
+	stored, err := LoadKeyUsage(path)
+	if err != nil {
+		return nil, err
+	}
+	for label, u := range stored {
+		t.usage[label] = &u
+		if last := u.LastUsed(); !last.IsZero() {
+			KeyLastUsed.WithLabelValues(label).Set(float64(last.Unix()))
+		}
+	}
+
+	return t, nil
+}
+
+// LoadKeyUsage reads a usage file written by UsageTracker
+// A missing file is not an error and yields empty statistics
+func LoadKeyUsage(path string) (map[string]KeyUsage, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return map[string]KeyUsage{}, nil
+		}
+		return nil, fmt.Errorf("read usage file: %w", err)
+	}
+
+	var f usageFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("parse usage file: %w", err)
+	}
+	if f.Keys == nil {
+		f.Keys = map[string]KeyUsage{}
+	}
+	return f.Keys, nil
+}
+
+// RecordEncrypt records a successful encryption with the given key version
+func (t *UsageTracker) RecordEncrypt(label string) {
+	now := time.Now()
+
+	t.mu.Lock()
+	u := t.entry(label)
+	u.EncryptCount++
+	u.LastEncryptAt = &now
+	t.dirty = true
+	t.mu.Unlock()
+
+	KeyOperationsTotal.WithLabelValues(label, "encrypt").Inc()
+	KeyLastUsed.WithLabelValues(label).Set(float64(now.Unix()))
+}
+
+// RecordDecrypt records a successful decryption with the given key version
+func (t *UsageTracker) RecordDecrypt(label string) {
+	now := time.Now()
+
+	t.mu.Lock()
+	u := t.entry(label)
+	u.DecryptCount++
+	u.LastDecryptAt = &now
+	t.dirty = true
+	t.mu.Unlock()
+
+	KeyOperationsTotal.WithLabelValues(label, "decrypt").Inc()
+	KeyLastUsed.WithLabelValues(label).Set(float64(now.Unix()))
+}
+
+// entry returns the usage entry for label, creating it (caller holds mu)
+func (t *UsageTracker) entry(label string) *KeyUsage {
+	u, ok := t.usage[label]
+	if !ok {
+		u = &KeyUsage{}
+		t.usage[label] = u
+	}
+	return u
+}
+
+// Get returns a copy of the usage statistics for a key version
+func (t *UsageTracker) Get(label string) KeyUsage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if u, ok := t.usage[label]; ok {
+		return *u
+	}
+	return KeyUsage{}
+}
+
+// Flush writes statistics to disk atomically if they changed since the last flush
+func (t *UsageTracker) Flush() error {
+	if t.path == "" {
+		return nil
+	}
+
+	t.mu.Lock()
+	if !t.dirty {
+		t.mu.Unlock()
+		return nil
+	}
+	snapshot := usageFile{
+		UpdatedAt: time.Now().UTC(),
+		Keys:      make(map[string]KeyUsage, len(t.usage)),
+	}
+	for label, u := range t.usage {
+		snapshot.Keys[label] = *u
+	}
+	t.dirty = false
+	t.mu.Unlock()
+
+	if err := writeUsageFile(t.path, snapshot); err != nil {
+		// Keep the changes pending so the next flush retries
+		t.mu.Lock()
+		t.dirty = true
+		t.mu.Unlock()
+		return err
+	}
+
+	return nil
+}
+
+// writeUsageFile writes the usage file atomically
+func writeUsageFile(path string, snapshot usageFile) error {
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal usage: %w", err)
+	}
+
+	if err := config.WriteFileAtomic(path, data, 0644); err != nil {
+		return fmt.Errorf("write usage file: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,71 @@
+package hsm
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestUsageTracker_PersistsAcrossRestarts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "key-usage.json")
+
+	tracker, err := NewUsageTracker(path)
+	if err != nil {
+		t.Fatalf("NewUsageTracker() error = %v", err)
+	}
+
+	tracker.RecordEncrypt("kek-test-v1")
+	tracker.RecordEncrypt("kek-test-v1")
+	tracker.RecordDecrypt("kek-test-v1")
+	tracker.RecordDecrypt("kek-test-v2")
+
+	if err := tracker.Flush(); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+
+	// Simulate restart
+	restored, err := NewUsageTracker(path)
+	if err != nil {
+		t.Fatalf("NewUsageTracker() after restart error = %v", err)
+	}
+
+	u := restored.Get("kek-test-v1")
+	if u.EncryptCount != 2 || u.DecryptCount != 1 {
+		t.Errorf("kek-test-v1 usage = %+v, want 2 encrypts and 1 decrypt", u)
+	}
+	if u.LastUsed().IsZero() || time.Since(u.LastUsed()) > time.Minute {
+		t.Errorf("kek-test-v1 LastUsed() = %v, want recent", u.LastUsed())
+	}
+
+	if restored.Get("kek-unknown").LastUsed() != (time.Time{}) {
+		t.Error("unknown key should have zero LastUsed()")
+	}
+}
+
+func TestUsageTracker_FlushOnlyWhenDirty(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "key-usage.json")
+
+	tracker, err := NewUsageTracker(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tracker.Flush(); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("Flush() without changes should not create the file")
+	}
+}
+
+func TestLoadKeyUsage_Corrupt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "key-usage.json")
+	if err := os.WriteFile(path, []byte("{not json"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := LoadKeyUsage(path); err == nil {
+		t.Error("LoadKeyUsage() on corrupt file should fail")
+	}
+}
@@ -0,0 +1,344 @@
+package pki
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/titaev-lv/hsm-service/internal/config"
+)
+
+// ErrRejected marks requests refused by policy (bad CSR, OU not allowed,
+// lifetime too long) as opposed to signing or storage failures
+var ErrRejected = errors.New("certificate request rejected")
+
+// clockSkew backdates NotBefore so clients with a slightly late clock accept
+// a freshly issued certificate
+const clockSkew = time.Minute
+
+// CA issues short-lived client certificates signed by a key pair on the
+// token. The OU of every certificate must be an acl.mappings entry, so an
+// issued certificate can only reach the contexts configured for its OU.
+type CA struct {
+	cfg      *config.PKIConfig
+	cert     *x509.Certificate
+	certPEM  []byte
+	signer   crypto.Signer
+	registry *Registry
+	mappings func() map[string][]string // Current acl.mappings (changes on SIGHUP)
+	revoked  func(cn string) bool       // CN revoked as a whole in acl.revoked_file (entry without serial)
+	adminOUs []string                   // server.admin_ous
+	now      func() time.Time
+}
+
+// IssueRequest describes one certificate to issue
+type IssueRequest struct {
+	CSR          *x509.CertificateRequest
+	TTL          time.Duration // 0 = pki.default_ttl
+	IssuedBy     string        // Requesting admin (client CN or operator)
+	AllowAdminOU bool          // Allow server.admin_ous (hsm-admin only, never over the API)
+}
+
+// Issued is an issued certificate with its registry record
+type Issued struct {
+	Certificate *x509.Certificate
+	PEM         []byte
+	Record      IssuedRecord
+}
+
+// NewCA loads the issuing CA certificate and checks it belongs to signer;
+// adminOUs may request certificates over the API but are never issued there.
+// revoked reports CNs whose every certificate is rejected by the service:
+// certificates are not issued for them.
+func NewCA(cfg *config.PKIConfig, certPath string, signer crypto.Signer, registry *Registry, mappings func() map[string][]string, revoked func(cn string) bool, adminOUs []string) (*CA, error) {
+	certPEM, err := os.ReadFile(certPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA certificate: %w", err)
+	}
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("no certificate found in %s", certPath)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CA certificate: %w", err)
+	}
+	if !cert.IsCA || cert.KeyUsage&x509.KeyUsageCertSign == 0 {
+		return nil, fmt.Errorf("%s is not a CA certificate (CA:TRUE, keyCertSign required)", certPath)
+	}
+	public, ok := signer.Public().(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !public.Equal(cert.PublicKey) {
+		return nil, fmt.Errorf("CA key pair %s does not match certificate %s", cfg.GetCAKeyLabel(), certPath)
+	}
+
+	return &CA{
+		cfg:      cfg,
+		cert:     cert,
+		certPEM:  pem.EncodeToMemory(block),
+		signer:   signer,
+		registry: registry,
+		mappings: mappings,
+		revoked:  revoked,
+		adminOUs: adminOUs,
+		now:      time.Now,
+	}, nil
+}
+
+// Certificate returns the issuing CA certificate
+func (ca *CA) Certificate() *x509.Certificate {
+	return ca.cert
+}
+
+// CertificatePEM returns the issuing CA certificate as PEM
+func (ca *CA) CertificatePEM() []byte {
+	return ca.certPEM
+}
+
+// KeyLabel returns the label of the CA key pair on the token
+func (ca *CA) KeyLabel() string {
+	return ca.cfg.GetCAKeyLabel()
+}
+
+// IsAdminOU reports whether ou may request certificates over the API
+func (ca *CA) IsAdminOU(ou string) bool {
+	return slices.Contains(ca.adminOUs, ou)
+}
+
+// Issue validates the CSR against the policy, signs it in the HSM and
+// records the serial. A certificate is only returned once it is recorded.
+func (ca *CA) Issue(req IssueRequest) (*Issued, error) {
+	csr := req.CSR
+	if err := csr.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("%w: invalid CSR signature: %v", ErrRejected, err)
+	}
+	if err := checkPublicKey(csr.PublicKey); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrRejected, err)
+	}
+
+	cn := csr.Subject.CommonName
+	if err := checkNameSegment("CN", cn); err != nil {
+		return nil, err
+	}
+	if len(csr.Subject.OrganizationalUnit) != 1 {
+		return nil, fmt.Errorf("%w: CSR must contain exactly one OU, got %d", ErrRejected, len(csr.Subject.OrganizationalUnit))
+	}
+	ou := csr.Subject.OrganizationalUnit[0]
+	if err := checkNameSegment("OU", ou); err != nil {
+		return nil, err
+	}
+	if err := ca.checkOU(ou, req.AllowAdminOU); err != nil {
+		return nil, err
+	}
+	// A certificate for a CN revoked without serial would be rejected on arrival
+	if ca.revoked != nil && ca.revoked(cn) {
+		return nil, fmt.Errorf("%w: CN %s is revoked in acl.revoked_file (entry without serial)", ErrRejected, cn)
+	}
+
+	ttl := req.TTL
+	if ttl == 0 {
+		ttl = ca.cfg.GetDefaultTTL()
+	}
+	if ttl < 0 || ttl > ca.cfg.GetMaxTTL() {
+		return nil, fmt.Errorf("%w: lifetime %s exceeds pki.max_ttl %s", ErrRejected, ttl, ca.cfg.GetMaxTTL())
+	}
+
+	now := ca.now()
+	notAfter := now.Add(ttl)
+	if notAfter.After(ca.cert.NotAfter) {
+		notAfter = ca.cert.NotAfter
+	}
+	if !notAfter.After(now) {
+		return nil, fmt.Errorf("CA certificate expired at %s", ca.cert.NotAfter.Format(time.RFC3339))
+	}
+
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, err
+	}
+	spiffeID := SPIFFEID(ca.cfg.GetTrustDomain(), ou, cn)
+
+	keyUsage := x509.KeyUsageDigitalSignature
+	if _, ok := csr.PublicKey.(*rsa.PublicKey); ok {
+		keyUsage |= x509.KeyUsageKeyEncipherment
+	}
+	// Only CN, OU and the SPIFFE ID are taken over: SANs and extensions
+	// requested in the CSR are ignored
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: cn, OrganizationalUnit: []string{ou}},
+		NotBefore:             now.Add(-clockSkew),
+		NotAfter:              notAfter,
+		KeyUsage:              keyUsage,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		URIs:                  []*url.URL{spiffeID},
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, csr.PublicKey, ca.signer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign certificate: %w", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse issued certificate: %w", err)
+	}
+
+	record := IssuedRecord{
+		Serial:    FormatSerial(cert.SerialNumber),
+		CN:        cn,
+		OU:        ou,
+		SPIFFEID:  spiffeID.String(),
+		NotBefore: cert.NotBefore.UTC(),
+		NotAfter:  cert.NotAfter.UTC(),
+		IssuedAt:  now.UTC(),
+		IssuedBy:  req.IssuedBy,
+	}
+	if err := ca.registry.Record(record); err != nil {
+		return nil, fmt.Errorf("failed to record issued certificate %s: %w", record.Serial, err)
+	}
+
+	return &Issued{
+		Certificate: cert,
+		PEM:         pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		Record:      record,
+	}, nil
+}
+
+// checkOU allows OUs with an acl.mappings entry, and admin OUs on request
+func (ca *CA) checkOU(ou string, allowAdmin bool) error {
+	if ca.IsAdminOU(ou) {
+		if allowAdmin {
+			return nil
+		}
+		return fmt.Errorf("%w: admin OU %s can only be issued with hsm-admin", ErrRejected, ou)
+	}
+	if _, ok := ca.mappings()[ou]; !ok {
+		return fmt.Errorf("%w: OU %s is not configured in acl.mappings", ErrRejected, ou)
+	}
+	return nil
+}
+
+// SPIFFEID returns spiffe://<trust domain>/ou/<ou>/cn/<cn>
+func SPIFFEID(trustDomain, ou, cn string) *url.URL {
+	return &url.URL{Scheme: "spiffe", Host: trustDomain, Path: "/ou/" + ou + "/cn/" + cn}
+}
+
+// FormatSerial formats a serial number like 'openssl x509 -serial' (hex, upper case)
+func FormatSerial(serial *big.Int) string {
+	return fmt.Sprintf("%X", serial)
+}
+
+// NormalizeSerial converts a serial from revoked.yaml (hex, optionally with
+// leading zeros or colons) to the FormatSerial form. "" and "unknown" (written
+// by pki/scripts/revoke-cert.sh without a certificate) mean no serial.
+func NormalizeSerial(serial string) (string, error) {
+	serial = strings.ReplaceAll(strings.TrimSpace(serial), ":", "")
+	if serial == "" || strings.EqualFold(serial, "unknown") {
+		return "", nil
+	}
+	n, ok := new(big.Int).SetString(serial, 16)
+	if !ok || n.Sign() < 0 {
+		return "", fmt.Errorf("invalid serial %q: hex expected", serial)
+	}
+	return FormatSerial(n), nil
+}
+
+// checkNameSegment restricts CN/OU to characters valid in a SPIFFE ID path
+// segment, so the identity is unambiguous
+func checkNameSegment(field, value string) error {
+	if value == "" || len(value) > 64 {
+		return fmt.Errorf("%w: %s must be 1-64 characters", ErrRejected, field)
+	}
+	for _, r := range value {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '-' || r == '_') {
+			return fmt.Errorf("%w: %s %q contains %q (allowed: letters, digits, '.', '-', '_')", ErrRejected, field, value, r)
+		}
+	}
+	return nil
+}
+
+// checkPublicKey accepts RSA >= 2048 bit, ECDSA and Ed25519 keys
+func checkPublicKey(key crypto.PublicKey) error {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if k.N.BitLen() < 2048 {
+			return fmt.Errorf("RSA key too short: %d bits (minimum 2048)", k.N.BitLen())
+		}
+	case *ecdsa.PublicKey, ed25519.PublicKey:
+	default:
+		return fmt.Errorf("unsupported public key type %T", key)
+	}
+	return nil
+}
+
+// randomSerial returns a positive 127-bit random serial number
+func randomSerial() (*big.Int, error) {
+	limit := new(big.Int).Lsh(big.NewInt(1), 127)
+	serial, err := rand.Int(rand.Reader, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial number: %w", err)
+	}
+	return serial.Add(serial, big.NewInt(1)), nil
+}
+
+// NewCACertificate creates a self-signed issuing CA certificate for signer
+func NewCACertificate(signer crypto.Signer, cn string, validity time.Duration) ([]byte, error) {
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             now.Add(-clockSkew),
+		NotAfter:              now.Add(validity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true, // Issues end-entity certificates only
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, signer.Public(), signer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create CA certificate: %w", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), nil
+}
+
+// NewCARequest creates a CSR for signer, for an issuing CA chained to an
+// existing (offline) root
+func NewCARequest(signer crypto.Signer, cn string) ([]byte, error) {
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: cn},
+	}, signer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create CA CSR: %w", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}), nil
+}
+
+// ParseCSR parses a PEM-encoded certificate signing request
+func ParseCSR(data []byte) (*x509.CertificateRequest, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return nil, fmt.Errorf("%w: no CERTIFICATE REQUEST PEM block found", ErrRejected)
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid CSR: %v", ErrRejected, err)
+	}
+	return csr, nil
+}
@@ -0,0 +1,158 @@
+package pki
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/titaev-lv/hsm-service/internal/config"
+)
+
+// newTestCA creates a CA with an in-memory key standing in for the HSM key pair
+func newTestCA(t *testing.T) (*CA, *Registry) {
+	t.Helper()
+	dir := t.TempDir()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM, err := NewCACertificate(key, "Test Issuing CA", 365*24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPath := filepath.Join(dir, "issuing-ca.crt")
+	if err := os.WriteFile(certPath, certPEM, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	registry := NewRegistry(filepath.Join(dir, "issued.yaml"))
+	mappings := map[string][]string{"Trading": {"exchange-key"}, "2FA": {"2fa"}}
+	cfg := &config.PKIConfig{TrustDomain: "example.org", MaxTTL: "72h"}
+	ca, err := NewCA(cfg, certPath, key, registry, func() map[string][]string { return mappings }, nil, []string{"Admin"})
+	if err != nil {
+		t.Fatalf("NewCA failed: %v", err)
+	}
+	return ca, registry
+}
+
+func newTestCSR(t *testing.T, cn string, ous ...string) *x509.CertificateRequest {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: cn, OrganizationalUnit: ous},
+		DNSNames: []string{"ignored.example.com"},
+	}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return csr
+}
+
+func TestCA_Issue(t *testing.T) {
+	ca, registry := newTestCA(t)
+
+	issued, err := ca.Issue(IssueRequest{CSR: newTestCSR(t, "trading-service-1", "Trading"), IssuedBy: "admin-1"})
+	if err != nil {
+		t.Fatalf("Issue failed: %v", err)
+	}
+	cert := issued.Certificate
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Certificate())
+	if _, err := cert.Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}); err != nil {
+		t.Errorf("issued certificate does not verify: %v", err)
+	}
+	if got := cert.Subject.OrganizationalUnit; len(got) != 1 || got[0] != "Trading" {
+		t.Errorf("OU = %v, want [Trading]", got)
+	}
+	if len(cert.URIs) != 1 || cert.URIs[0].String() != "spiffe://example.org/ou/Trading/cn/trading-service-1" {
+		t.Errorf("URIs = %v, want SPIFFE ID", cert.URIs)
+	}
+	if len(cert.DNSNames) != 0 {
+		t.Errorf("SANs from the CSR must be ignored, got %v", cert.DNSNames)
+	}
+	if lifetime := cert.NotAfter.Sub(cert.NotBefore); lifetime > 24*time.Hour+2*clockSkew {
+		t.Errorf("lifetime = %s, want default 24h", lifetime)
+	}
+
+	rec, err := registry.Find(FormatSerial(cert.SerialNumber))
+	if err != nil {
+		t.Fatalf("issued serial not recorded: %v", err)
+	}
+	if rec.CN != "trading-service-1" || rec.IssuedBy != "admin-1" || rec.SPIFFEID != cert.URIs[0].String() {
+		t.Errorf("unexpected registry record: %+v", rec)
+	}
+}
+
+func TestCA_IssuePolicy(t *testing.T) {
+	ca, registry := newTestCA(t)
+
+	tests := []struct {
+		name string
+		req  IssueRequest
+	}{
+		{"OU not in acl.mappings", IssueRequest{CSR: newTestCSR(t, "svc", "Marketing")}},
+		{"admin OU over the API", IssueRequest{CSR: newTestCSR(t, "ops-1", "Admin")}},
+		{"no OU", IssueRequest{CSR: newTestCSR(t, "svc")}},
+		{"two OUs", IssueRequest{CSR: newTestCSR(t, "svc", "Trading", "2FA")}},
+		{"CN with path separator", IssueRequest{CSR: newTestCSR(t, "svc/../admin", "Trading")}},
+		{"lifetime above max_ttl", IssueRequest{CSR: newTestCSR(t, "svc", "Trading"), TTL: 96 * time.Hour}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ca.Issue(tt.req); !errors.Is(err, ErrRejected) {
+				t.Errorf("Issue() error = %v, want ErrRejected", err)
+			}
+		})
+	}
+
+	records, _ := registry.List()
+	if len(records) != 0 {
+		t.Errorf("rejected requests must not be recorded, got %d records", len(records))
+	}
+
+	// hsm-admin may issue admin certificates
+	if _, err := ca.Issue(IssueRequest{CSR: newTestCSR(t, "ops-1", "Admin"), AllowAdminOU: true}); err != nil {
+		t.Errorf("admin OU with AllowAdminOU: %v", err)
+	}
+}
+
+func TestCA_IssueRevokedCN(t *testing.T) {
+	ca, _ := newTestCA(t)
+	ca.revoked = func(cn string) bool { return cn == "revoked-service" }
+
+	if _, err := ca.Issue(IssueRequest{CSR: newTestCSR(t, "revoked-service", "Trading")}); !errors.Is(err, ErrRejected) {
+		t.Errorf("Issue() error = %v, want ErrRejected for a CN revoked without serial", err)
+	}
+	if _, err := ca.Issue(IssueRequest{CSR: newTestCSR(t, "trading-service-1", "Trading")}); err != nil {
+		t.Errorf("Issue() for another CN: %v", err)
+	}
+}
+
+func TestNewCA_KeyMismatch(t *testing.T) {
+	ca, registry := newTestCA(t)
+	certPath := filepath.Join(t.TempDir(), "ca.crt")
+	if err := os.WriteFile(certPath, ca.CertificatePEM(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	other, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, err := NewCA(&config.PKIConfig{}, certPath, other, registry, func() map[string][]string { return nil }, nil, nil)
+	if err == nil {
+		t.Error("NewCA should fail when the key pair does not match the certificate")
+	}
+}
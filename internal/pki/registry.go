@@ -0,0 +1,235 @@
+package pki
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/titaev-lv/hsm-service/internal/config"
+	"gopkg.in/yaml.v3"
+)
+
+// ErrNotIssued is returned for serials missing from the registry
+var ErrNotIssued = errors.New("certificate not found in issued registry")
+
+// IssuedRecord is one issued certificate in the registry
+type IssuedRecord struct {
+	Serial           string     `yaml:"serial"`
+	CN               string     `yaml:"cn"`
+	OU               string     `yaml:"ou"`
+	SPIFFEID         string     `yaml:"spiffe_id"`
+	NotBefore        time.Time  `yaml:"not_before"`
+	NotAfter         time.Time  `yaml:"not_after"`
+	IssuedAt         time.Time  `yaml:"issued_at"`
+	IssuedBy         string     `yaml:"issued_by"`
+	RevokedAt        *time.Time `yaml:"revoked_at,omitempty"`
+	RevocationReason string     `yaml:"revocation_reason,omitempty"`
+}
+
+// issuedFile is the on-disk format of issued.yaml
+type issuedFile struct {
+	Issued []IssuedRecord `yaml:"issued"`
+}
+
+// Registry records every issued certificate in a YAML file (issued.yaml).
+// Writers (service, hsm-admin) serialize on the flock of <path>.lock.
+type Registry struct {
+	path string
+}
+
+// NewRegistry returns the registry stored at path
+func NewRegistry(path string) *Registry {
+	return &Registry{path: path}
+}
+
+// Path returns the registry file path
+func (r *Registry) Path() string {
+	return r.path
+}
+
+// Record appends an issued certificate
+func (r *Registry) Record(rec IssuedRecord) error {
+	return r.update(func(f *issuedFile) error {
+		f.Issued = append(f.Issued, rec)
+		return nil
+	})
+}
+
+// List returns all records in issue order
+func (r *Registry) List() ([]IssuedRecord, error) {
+	f, err := r.load()
+	if err != nil {
+		return nil, err
+	}
+	return f.Issued, nil
+}
+
+// Find returns the record of serial
+func (r *Registry) Find(serial string) (*IssuedRecord, error) {
+	records, err := r.List()
+	if err != nil {
+		return nil, err
+	}
+	for i := range records {
+		if records[i].Serial == serial {
+			return &records[i], nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrNotIssued, serial)
+}
+
+// MarkRevoked records the revocation of serial and returns the updated record
+func (r *Registry) MarkRevoked(serial, reason string, at time.Time) (*IssuedRecord, error) {
+	var revoked *IssuedRecord
+	err := r.update(func(f *issuedFile) error {
+		for i := range f.Issued {
+			if f.Issued[i].Serial != serial {
+				continue
+			}
+			if f.Issued[i].RevokedAt != nil {
+				return fmt.Errorf("certificate %s already revoked at %s", serial, f.Issued[i].RevokedAt.Format(time.RFC3339))
+			}
+			revokedAt := at.UTC()
+			f.Issued[i].RevokedAt = &revokedAt
+			f.Issued[i].RevocationReason = reason
+			rec := f.Issued[i]
+			revoked = &rec
+			return nil
+		}
+		return fmt.Errorf("%w: %s", ErrNotIssued, serial)
+	})
+	return revoked, err
+}
+
+// update applies fn to the registry under the writer lock
+func (r *Registry) update(fn func(*issuedFile) error) error {
+	unlock, err := config.LockMetadata(r.path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	f, err := r.load()
+	if err != nil {
+		return err
+	}
+	if err := fn(f); err != nil {
+		return err
+	}
+
+	data, err := yaml.Marshal(f)
+	if err != nil {
+		return fmt.Errorf("marshal issued registry: %w", err)
+	}
+	if err := config.WriteFileAtomic(r.path, data, 0644); err != nil {
+		return fmt.Errorf("write issued registry: %w", err)
+	}
+	return nil
+}
+
+// load reads the registry (empty if the file does not exist yet)
+func (r *Registry) load() (*issuedFile, error) {
+	var f issuedFile
+	data, err := os.ReadFile(r.path)
+	if os.IsNotExist(err) {
+		return &f, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read issued registry: %w", err)
+	}
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("parse issued registry %s: %w", r.path, err)
+	}
+	return &f, nil
+}
+
+// AppendRevoked adds the certificate serial to revoked.yaml (acl.revoked_file),
+// keeping existing entries and their fields as they are. The service rejects
+// the listed serial only, so a certificate reissued to cn stays valid; it
+// reloads the file on change. Returns false if serial is already listed.
+func AppendRevoked(path, cn, serial, reason string, at time.Time) (bool, error) {
+	serial, err := NormalizeSerial(serial)
+	if err != nil {
+		return false, err
+	}
+	if serial == "" {
+		return false, fmt.Errorf("serial is required")
+	}
+
+	unlock, err := config.LockMetadata(path)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	doc, err := loadRevoked(path)
+	if err != nil {
+		return false, err
+	}
+	for _, entry := range doc.Revoked {
+		if listed, _ := entrySerial(entry); listed == serial {
+			return false, nil
+		}
+	}
+
+	doc.Revoked = append(doc.Revoked, map[string]any{
+		"cn":           cn,
+		"serial":       serial,
+		"revoked_date": at.UTC().Format(time.RFC3339), // Same fields as pki/scripts/revoke-cert.sh
+		"reason":       reason,
+	})
+	out, err := yaml.Marshal(doc)
+	if err != nil {
+		return false, fmt.Errorf("marshal revoked list: %w", err)
+	}
+	if err := config.WriteFileAtomic(path, out, 0644); err != nil {
+		return false, fmt.Errorf("write revoked list: %w", err)
+	}
+	return true, nil
+}
+
+// RevokedCNs returns the CNs revoked as a whole in revoked.yaml: entries
+// without a serial, which the service matches by CN
+func RevokedCNs(path string) (map[string]bool, error) {
+	doc, err := loadRevoked(path)
+	if err != nil {
+		return nil, err
+	}
+	cns := make(map[string]bool)
+	for _, entry := range doc.Revoked {
+		if serial, err := entrySerial(entry); err == nil && serial == "" {
+			if cn, ok := entry["cn"].(string); ok {
+				cns[cn] = true
+			}
+		}
+	}
+	return cns, nil
+}
+
+// revokedDoc is revoked.yaml with entries kept as they are
+type revokedDoc struct {
+	Revoked []map[string]any `yaml:"revoked"`
+}
+
+// loadRevoked reads revoked.yaml (a missing file is an empty list)
+func loadRevoked(path string) (*revokedDoc, error) {
+	var doc revokedDoc
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("read revoked list: %w", err)
+	}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("parse revoked list %s: %w", path, err)
+	}
+	return &doc, nil
+}
+
+// entrySerial returns the normalized serial of a revoked.yaml entry ("" = none)
+func entrySerial(entry map[string]any) (string, error) {
+	v, ok := entry["serial"]
+	if !ok || v == nil {
+		return "", nil
+	}
+	return NormalizeSerial(fmt.Sprint(v))
+}
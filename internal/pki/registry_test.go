@@ -0,0 +1,85 @@
+package pki
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRegistry_MarkRevoked(t *testing.T) {
+	registry := NewRegistry(filepath.Join(t.TempDir(), "issued.yaml"))
+	for _, serial := range []string{"0A", "0B"} {
+		if err := registry.Record(IssuedRecord{Serial: serial, CN: "svc-" + serial, OU: "Trading"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rec, err := registry.MarkRevoked("0B", "key-compromise", time.Now())
+	if err != nil {
+		t.Fatalf("MarkRevoked failed: %v", err)
+	}
+	if rec.CN != "svc-0B" || rec.RevokedAt == nil {
+		t.Errorf("unexpected record: %+v", rec)
+	}
+	if _, err := registry.MarkRevoked("0B", "key-compromise", time.Now()); err == nil {
+		t.Error("second revocation should fail")
+	}
+	if _, err := registry.MarkRevoked("FF", "key-compromise", time.Now()); err == nil {
+		t.Error("unknown serial should fail")
+	}
+
+	first, _ := registry.Find("0A")
+	if first.RevokedAt != nil {
+		t.Error("other records must stay unrevoked")
+	}
+}
+
+func TestAppendRevoked(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "revoked.yaml")
+	existing := "revoked:\n  - cn: old-service\n    serial: '05'\n    revoked_date: '2026-01-03T10:00:00Z'\n    reason: compromised\n"
+	if err := os.WriteFile(path, []byte(existing), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	added, err := AppendRevoked(path, "trading-service-1", "3F", "superseded", time.Now())
+	if err != nil || !added {
+		t.Fatalf("AppendRevoked = %v, %v", added, err)
+	}
+	// The ACL checker rejects duplicate serials, so a second entry is not written
+	if added, _ := AppendRevoked(path, "trading-service-1", "003f", "superseded", time.Now()); added {
+		t.Error("duplicate serial should not be appended")
+	}
+	// Another certificate of the same CN is revoked separately
+	if added, err := AppendRevoked(path, "trading-service-1", "40", "superseded", time.Now()); err != nil || !added {
+		t.Errorf("AppendRevoked(40) = %v, %v", added, err)
+	}
+
+	data, _ := os.ReadFile(path)
+	content := string(data)
+	for _, want := range []string{"old-service", "revoked_date", "trading-service-1"} {
+		if !strings.Contains(content, want) {
+			t.Errorf("revoked.yaml lacks %q:\n%s", want, content)
+		}
+	}
+	if strings.Count(content, "trading-service-1") != 2 {
+		t.Errorf("trading-service-1 should be listed once per serial:\n%s", content)
+	}
+}
+
+func TestRevokedCNs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "revoked.yaml")
+	list := "revoked:\n  - cn: legacy-service\n  - cn: script-service\n    serial: unknown\n  - cn: trading-service-1\n    serial: '3F'\n"
+	if err := os.WriteFile(path, []byte(list), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cns, err := RevokedCNs(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cns["legacy-service"] || !cns["script-service"] || cns["trading-service-1"] {
+		t.Errorf("RevokedCNs = %v, want entries without serial only", cns)
+	}
+}
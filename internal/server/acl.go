@@ -11,24 +11,30 @@ import (
 	"time"
 
 	"github.com/titaev-lv/hsm-service/internal/config"
+	"github.com/titaev-lv/hsm-service/internal/pki"
+	"go.opentelemetry.io/otel/attribute"
 	"gopkg.in/yaml.v3"
 )
 
 // ACLChecker handles authorization checks based on OU and revocation
 type ACLChecker struct {
-	config       *config.ACLConfig
-	revoked      map[string]bool // CN -> revoked
-	revokedMutex sync.RWMutex
+	config         *config.ACLConfig // Replaced on config reload (protected by configMutex)
+	configMutex    sync.RWMutex
+	revoked        map[string]bool // CN -> revoked (entries without serial: every certificate of the CN)
+	revokedSerials map[string]bool // Serial (pki.FormatSerial) -> revoked
+	revokedMutex   sync.RWMutex
 
 	// Hot reload support
-	reloadInterval time.Duration
-	lastModTime    time.Time
-	stopReload     chan struct{}
-	reloadWg       sync.WaitGroup
-	stopOnce       sync.Once
+	reloadInterval  time.Duration // Polling fallback when inotify events are unavailable
+	revokedRevision string        // FileRevision of the loaded revoked.yaml ("" = no file)
+	stopReload      chan struct{}
+	reloadWg        sync.WaitGroup
+	stopOnce        sync.Once
 }
 
-// RevokedList represents the structure of revoked.yaml
+// RevokedList represents the structure of revoked.yaml: an entry with a
+// serial revokes that certificate only, an entry without one (lists written
+// before serial-based revocation) every certificate with the CN
 type RevokedList struct {
 	Revoked []struct {
 		CN     string `yaml:"cn"`
@@ -43,7 +49,7 @@ func NewACLChecker(cfg *config.ACLConfig) (*ACLChecker, error) {
 	checker := &ACLChecker{
 		config:         cfg,
 		revoked:        make(map[string]bool),
-		reloadInterval: 30 * time.Second, // Check every 30 seconds
+		reloadInterval: 30 * time.Second, // Fallback poll; changes normally arrive via inotify
 		stopReload:     make(chan struct{}),
 	}
 
@@ -51,6 +57,7 @@ func NewACLChecker(cfg *config.ACLConfig) (*ACLChecker, error) {
 	if err := checker.LoadRevoked(); err != nil {
 		return nil, fmt.Errorf("failed to load revoked list: %w", err)
 	}
+	RevokedLastReloadSuccess.SetToCurrentTime()
 
 	// Start auto-reload goroutine
 	checker.StartAutoReload()
@@ -58,24 +65,34 @@ func NewACLChecker(cfg *config.ACLConfig) (*ACLChecker, error) {
 	return checker, nil
 }
 
-// StartAutoReload starts background goroutine for periodic reload
+// StartAutoReload watches revoked.yaml and reloads it on every change
 func (a *ACLChecker) StartAutoReload() {
+	revokedFile := a.currentConfig().RevokedFile
+	a.revokedMutex.RLock()
+	since := a.revokedRevision
+	a.revokedMutex.RUnlock()
+
+	watchCtx, cancelWatch := context.WithCancel(context.Background())
+	changes := config.WatchFile(watchCtx, revokedFile, since, a.reloadInterval)
+
 	a.reloadWg.Add(1)
 	go func() {
 		defer a.reloadWg.Done()
-
-		ticker := time.NewTicker(a.reloadInterval)
-		defer ticker.Stop()
+		defer cancelWatch()
 
 		slog.Info("started revoked.yaml auto-reload",
-			"interval", a.reloadInterval.String(),
-			"file", a.config.RevokedFile)
+			"fallback_interval", a.reloadInterval.String(),
+			"file", revokedFile)
 
 		for {
 			select {
-			case <-ticker.C:
+			case _, ok := <-changes:
+				if !ok {
+					changes = nil
+					continue
+				}
 				if err := a.TryReload(); err != nil {
-					slog.Warn("auto-reload failed", "path", a.config.RevokedFile)
+					slog.Warn("auto-reload failed", "path", revokedFile)
 					// Don't expose error details in logs
 				}
 			case <-a.stopReload:
@@ -107,31 +124,44 @@ func (a *ACLChecker) StopAutoReload(ctx context.Context) error {
 	}
 }
 
-// TryReload attempts to reload revoked.yaml if file was modified
+// TryReload attempts to reload revoked.yaml if its content changed
 // Returns nil if successful or file unchanged
 func (a *ACLChecker) TryReload() error {
-	// Get file info
-	info, err := os.Stat(a.config.RevokedFile)
+	return a.reload(false)
+}
+
+// ForceReload reloads revoked.yaml even if its content is unchanged (SIGHUP)
+func (a *ACLChecker) ForceReload() error {
+	return a.reload(true)
+}
+
+// reload reloads revoked.yaml, keeping the old list on validation errors
+func (a *ACLChecker) reload(force bool) error {
+	revokedFile := a.currentConfig().RevokedFile
+
+	// Content hash instead of mtime: same-second edits are not missed
+	revision, err := config.FileRevision(revokedFile)
 	if err != nil {
-		if os.IsNotExist(err) {
-			// File deleted - clear revoked list
-			a.revokedMutex.Lock()
-			a.revoked = make(map[string]bool)
-			a.lastModTime = time.Time{}
-			a.revokedMutex.Unlock()
-			slog.Info("revoked.yaml deleted, cleared revocation list")
-			return nil
-		}
-		return fmt.Errorf("failed to stat file: %w", err)
+		RecordRevokedReload(err)
+		return fmt.Errorf("failed to read file: %w", err)
+	}
+	if revision == "" {
+		// File deleted - clear revoked list
+		a.revokedMutex.Lock()
+		a.revoked = make(map[string]bool)
+		a.revokedSerials = make(map[string]bool)
+		a.revokedRevision = ""
+		a.revokedMutex.Unlock()
+		slog.Info("revoked.yaml deleted, cleared revocation list")
+		RecordRevokedReload(nil)
+		return nil
 	}
 
-	// Check if file was modified (protected read)
-	modTime := info.ModTime()
 	a.revokedMutex.RLock()
-	lastMod := a.lastModTime
+	loaded := a.revokedRevision
 	a.revokedMutex.RUnlock()
 
-	if !modTime.After(lastMod) {
+	if revision == loaded && !force {
 		// File not changed
 		return nil
 	}
@@ -140,27 +170,51 @@ func (a *ACLChecker) TryReload() error {
 	if err := a.LoadRevokedSafe(); err != nil {
 		// Keep old data on error
 		slog.Warn("revoked.yaml reload skipped due to validation error",
-			"path", a.config.RevokedFile)
+			"path", revokedFile)
+		RecordRevokedReload(err)
 		return err
 	}
 
-	// Update modification time on success (protected write)
+	// Record the loaded revision on success (protected write)
 	a.revokedMutex.Lock()
-	a.lastModTime = modTime
-	revokedCount := len(a.revoked)
+	a.revokedRevision = revision
+	revokedCount := len(a.revoked) + len(a.revokedSerials)
 	a.revokedMutex.Unlock()
 
 	slog.Info("revoked.yaml reloaded successfully",
-		"path", a.config.RevokedFile,
+		"path", revokedFile,
 		"count", revokedCount)
+	RecordRevokedReload(nil)
 
 	return nil
 }
 
+// UpdateMappings replaces the OU -> context mappings (config.yaml reload)
+func (a *ACLChecker) UpdateMappings(mappings map[string][]string) {
+	a.configMutex.Lock()
+	defer a.configMutex.Unlock()
+
+	cfg := *a.config
+	cfg.Mappings = mappings
+	a.config = &cfg
+}
+
+// Mappings returns the OU -> context mappings in effect
+func (a *ACLChecker) Mappings() map[string][]string {
+	return a.currentConfig().Mappings
+}
+
+// currentConfig returns the ACL configuration in effect
+func (a *ACLChecker) currentConfig() *config.ACLConfig {
+	a.configMutex.RLock()
+	defer a.configMutex.RUnlock()
+	return a.config
+}
+
 // LoadRevokedSafe loads and validates revoked.yaml without updating state on error
 func (a *ACLChecker) LoadRevokedSafe() error {
 	// Read file
-	data, err := os.ReadFile(a.config.RevokedFile)
+	data, err := os.ReadFile(a.currentConfig().RevokedFile)
 	if err != nil {
 		return fmt.Errorf("failed to read file: %w", err)
 	}
@@ -176,18 +230,13 @@ func (a *ACLChecker) LoadRevokedSafe() error {
 		return fmt.Errorf("validation failed: %w", err)
 	}
 
-	// Build new map
-	newRevoked := make(map[string]bool)
-	for _, cert := range revokedList.Revoked {
-		if cert.CN == "" {
-			return fmt.Errorf("empty CN in revoked list")
-		}
-		newRevoked[cert.CN] = true
-	}
+	// Build new maps
+	newRevoked, newSerials := buildRevoked(&revokedList)
 
 	// Atomic update
 	a.revokedMutex.Lock()
 	a.revoked = newRevoked
+	a.revokedSerials = newSerials
 	a.revokedMutex.Unlock()
 
 	return nil
@@ -199,12 +248,24 @@ func (a *ACLChecker) validateRevokedList(list *RevokedList) error {
 		return errors.New("nil revoked list")
 	}
 
-	// Check for duplicate CNs
+	// Check for duplicate CNs (entries without serial) and serials
 	seen := make(map[string]bool)
+	seenSerials := make(map[string]bool)
 	for i, cert := range list.Revoked {
 		if cert.CN == "" {
 			return fmt.Errorf("entry %d has empty CN", i)
 		}
+		serial, err := pki.NormalizeSerial(cert.Serial)
+		if err != nil {
+			return fmt.Errorf("entry %d: %w", i, err)
+		}
+		if serial != "" {
+			if seenSerials[serial] {
+				return fmt.Errorf("duplicate serial: %s", serial)
+			}
+			seenSerials[serial] = true
+			continue
+		}
 		if seen[cert.CN] {
 			return fmt.Errorf("duplicate CN: %s", cert.CN)
 		}
@@ -214,15 +275,31 @@ func (a *ACLChecker) validateRevokedList(list *RevokedList) error {
 	return nil
 }
 
+// buildRevoked splits a validated revoked list into CN and serial entries
+func buildRevoked(list *RevokedList) (cns, serials map[string]bool) {
+	cns = make(map[string]bool)
+	serials = make(map[string]bool)
+	for _, cert := range list.Revoked {
+		if serial, _ := pki.NormalizeSerial(cert.Serial); serial != "" {
+			serials[serial] = true
+		} else {
+			cns[cert.CN] = true
+		}
+	}
+	return cns, serials
+}
+
 // LoadRevoked loads the revoked certificates list from YAML (initial load)
 func (a *ACLChecker) LoadRevoked() error {
-	data, err := os.ReadFile(a.config.RevokedFile)
+	revokedFile := a.currentConfig().RevokedFile
+	data, err := os.ReadFile(revokedFile)
 	if err != nil {
 		// If file doesn't exist, start with empty list
 		if os.IsNotExist(err) {
 			a.revokedMutex.Lock()
 			a.revoked = make(map[string]bool)
-			a.lastModTime = time.Time{}
+			a.revokedSerials = make(map[string]bool)
+			a.revokedRevision = ""
 			a.revokedMutex.Unlock()
 			return nil
 		}
@@ -239,16 +316,13 @@ func (a *ACLChecker) LoadRevoked() error {
 		return fmt.Errorf("validation failed: %w", err)
 	}
 
-	// Build revoked map
+	// Build revoked maps
 	a.revokedMutex.Lock()
-	a.revoked = make(map[string]bool)
-	for _, cert := range revokedList.Revoked {
-		a.revoked[cert.CN] = true
-	}
+	a.revoked, a.revokedSerials = buildRevoked(&revokedList)
 
-	// Get initial modification time
-	if info, err := os.Stat(a.config.RevokedFile); err == nil {
-		a.lastModTime = info.ModTime()
+	// Remember the loaded content for change detection
+	if revision, err := config.FileRevision(revokedFile); err == nil {
+		a.revokedRevision = revision
 	}
 	a.revokedMutex.Unlock()
 
@@ -256,19 +330,17 @@ func (a *ACLChecker) LoadRevoked() error {
 }
 
 // CheckAccess verifies if a client certificate has access to the specified context
-func (a *ACLChecker) CheckAccess(cert *x509.Certificate, context string) error {
+// (ctx carries the trace span of the request)
+func (a *ACLChecker) CheckAccess(ctx context.Context, cert *x509.Certificate, keyContext string) (err error) {
+	_, span := startSpan(ctx, "ACLChecker.CheckAccess", attribute.String("hsm.context", keyContext))
+	defer func() { endSpan(span, err) }()
+
 	if cert == nil {
 		return errors.New("certificate is nil")
 	}
 
-	cn := cert.Subject.CommonName
-
 	// 1. Check revoked list
-	a.revokedMutex.RLock()
-	revoked := a.revoked[cn]
-	a.revokedMutex.RUnlock()
-
-	if revoked {
+	if a.IsCertRevoked(cert) {
 		// Metrics: track revocation failure
 		RecordRevocationFailure()
 		// Don't expose CN in error (information disclosure)
@@ -282,7 +354,7 @@ func (a *ACLChecker) CheckAccess(cert *x509.Certificate, context string) error {
 	ou := cert.Subject.OrganizationalUnit[0]
 
 	// 3. Check OU permissions
-	allowedContexts, ok := a.config.Mappings[ou]
+	allowedContexts, ok := a.currentConfig().Mappings[ou]
 	if !ok {
 		// Don't expose OU in error (information disclosure)
 		return errors.New("access denied: unknown organizational unit")
@@ -290,7 +362,7 @@ func (a *ACLChecker) CheckAccess(cert *x509.Certificate, context string) error {
 
 	// 4. Check if context is allowed for this OU
 	for _, allowed := range allowedContexts {
-		if allowed == context {
+		if allowed == keyContext {
 			return nil // Access granted
 		}
 	}
@@ -299,9 +371,21 @@ func (a *ACLChecker) CheckAccess(cert *x509.Certificate, context string) error {
 	return errors.New("access denied: insufficient permissions")
 }
 
-// IsRevoked checks if a certificate is revoked by CN
+// IsRevoked checks if every certificate with this CN is revoked (entry
+// without serial)
 func (a *ACLChecker) IsRevoked(cn string) bool {
 	a.revokedMutex.RLock()
 	defer a.revokedMutex.RUnlock()
 	return a.revoked[cn]
 }
+
+// IsCertRevoked checks if a certificate is revoked by its serial or by a
+// CN entry without serial
+func (a *ACLChecker) IsCertRevoked(cert *x509.Certificate) bool {
+	a.revokedMutex.RLock()
+	defer a.revokedMutex.RUnlock()
+	if cert.SerialNumber != nil && a.revokedSerials[pki.FormatSerial(cert.SerialNumber)] {
+		return true
+	}
+	return a.revoked[cert.Subject.CommonName]
+}
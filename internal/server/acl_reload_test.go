@@ -2,11 +2,13 @@ package server
 
 import (
 	"context"
+	"math/big"
 	"os"
 	"path/filepath"
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/titaev-lv/hsm-service/internal/config"
 )
 
@@ -18,7 +20,6 @@ func TestACLAutoReload(t *testing.T) {
 	// Initial content
 	initialYAML := `revoked:
   - cn: "test1.example.com"
-    serial: "1234"
     reason: "compromised"
     date: "2024-01-01"
 `
@@ -69,11 +70,9 @@ func TestACLAutoReload(t *testing.T) {
 	// Update file with new content
 	updatedYAML := `revoked:
   - cn: "test1.example.com"
-    serial: "1234"
     reason: "compromised"
     date: "2024-01-01"
   - cn: "test2.example.com"
-    serial: "5678"
     reason: "key-compromise"
     date: "2024-01-02"
 `
@@ -101,7 +100,6 @@ func TestACLReloadInvalidYAML(t *testing.T) {
 	// Valid initial content
 	validYAML := `revoked:
   - cn: "test1.example.com"
-    serial: "1234"
     reason: "compromised"
     date: "2024-01-01"
 `
@@ -134,7 +132,6 @@ func TestACLReloadInvalidYAML(t *testing.T) {
 	// Write invalid YAML
 	invalidYAML := `revoked:
   - cn: "test2.example.com"
-    serial: "5678"
     reason: "test
     date: invalid [unclosed quote
 `
@@ -143,10 +140,14 @@ func TestACLReloadInvalidYAML(t *testing.T) {
 	}
 
 	// Try to reload
+	failures := testutil.ToFloat64(RevokedReloadsTotal.WithLabelValues("failure"))
 	err = checker.TryReload()
 	if err == nil {
 		t.Error("Expected error for invalid YAML")
 	}
+	if got := testutil.ToFloat64(RevokedReloadsTotal.WithLabelValues("failure")) - failures; got != 1 {
+		t.Errorf("hsm_revoked_reloads_total{status=failure} increased by %v, want 1", got)
+	}
 
 	// Old data should be preserved
 	if !checker.IsRevoked("test1.example.com") {
@@ -163,7 +164,6 @@ func TestACLReloadEmptyCN(t *testing.T) {
 
 	validYAML := `revoked:
   - cn: "test1.example.com"
-    serial: "1234"
     reason: "compromised"
     date: "2024-01-01"
 `
@@ -189,7 +189,6 @@ func TestACLReloadEmptyCN(t *testing.T) {
 	// Write YAML with empty CN
 	invalidYAML := `revoked:
   - cn: ""
-    serial: "5678"
     reason: "test"
     date: "2024-01-02"
 `
@@ -215,7 +214,6 @@ func TestACLReloadDuplicateCN(t *testing.T) {
 
 	validYAML := `revoked:
   - cn: "test1.example.com"
-    serial: "1234"
     reason: "compromised"
     date: "2024-01-01"
 `
@@ -241,11 +239,9 @@ func TestACLReloadDuplicateCN(t *testing.T) {
 	// Write YAML with duplicate CNs
 	duplicateYAML := `revoked:
   - cn: "test2.example.com"
-    serial: "5678"
     reason: "compromised"
     date: "2024-01-02"
   - cn: "test2.example.com"
-    serial: "9999"
     reason: "duplicate"
     date: "2024-01-03"
 `
@@ -268,13 +264,76 @@ func TestACLReloadDuplicateCN(t *testing.T) {
 	}
 }
 
+func TestACLRevokedSerial(t *testing.T) {
+	tmpDir := t.TempDir()
+	revokedFile := filepath.Join(tmpDir, "revoked.yaml")
+
+	// Two certificates of one CN revoked by serial, one CN revoked as a whole
+	validYAML := `revoked:
+  - cn: "test1.example.com"
+    serial: "01"
+  - cn: "test1.example.com"
+    serial: "0A:0B"
+  - cn: "test2.example.com"
+`
+	if err := os.WriteFile(revokedFile, []byte(validYAML), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	checker, err := NewACLChecker(&config.ACLConfig{RevokedFile: revokedFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		checker.StopAutoReload(ctx)
+	}()
+
+	tests := []struct {
+		cn      string
+		serial  int64
+		revoked bool
+	}{
+		{"test1.example.com", 1, true},
+		{"test1.example.com", 0x0A0B, true},
+		{"test1.example.com", 2, false}, // Reissued certificate
+		{"test2.example.com", 2, true},
+	}
+	for _, tt := range tests {
+		cert := createTestCert(tt.cn, "operations")
+		cert.SerialNumber = big.NewInt(tt.serial)
+		if got := checker.IsCertRevoked(cert); got != tt.revoked {
+			t.Errorf("IsCertRevoked(%s, %X) = %v, want %v", tt.cn, tt.serial, got, tt.revoked)
+		}
+	}
+	if checker.IsRevoked("test1.example.com") {
+		t.Error("CN revoked by serial must not be revoked as a whole")
+	}
+
+	// Duplicate serials and malformed serials are rejected, the old list is kept
+	for _, invalidYAML := range []string{
+		"revoked:\n  - cn: a\n    serial: \"0F\"\n  - cn: b\n    serial: \"f\"\n",
+		"revoked:\n  - cn: a\n    serial: \"not-hex\"\n",
+	} {
+		if err := os.WriteFile(revokedFile, []byte(invalidYAML), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := checker.TryReload(); err == nil {
+			t.Errorf("Expected error for:\n%s", invalidYAML)
+		}
+	}
+	if !checker.IsRevoked("test2.example.com") {
+		t.Error("Old data should be preserved")
+	}
+}
+
 func TestACLReloadFileDeleted(t *testing.T) {
 	tmpDir := t.TempDir()
 	revokedFile := filepath.Join(tmpDir, "revoked.yaml")
 
 	validYAML := `revoked:
   - cn: "test1.example.com"
-    serial: "1234"
     reason: "compromised"
     date: "2024-01-01"
 `
@@ -352,3 +411,19 @@ func TestACLStopAutoReload(t *testing.T) {
 	err = checker.StopAutoReload(ctx2)
 	// Should not hang or panic
 }
+
+func TestACLUpdateMappings(t *testing.T) {
+	original := &config.ACLConfig{
+		Mappings: map[string][]string{"operations": {"user-keys"}},
+	}
+	checker := &ACLChecker{config: original, revoked: make(map[string]bool)}
+
+	checker.UpdateMappings(map[string][]string{"operations": {"user-keys", "exchange-keys"}})
+
+	if got := checker.currentConfig().Mappings["operations"]; len(got) != 2 {
+		t.Errorf("mappings after update = %v, want 2 contexts", got)
+	}
+	if len(original.Mappings["operations"]) != 1 {
+		t.Error("UpdateMappings must not modify the original config")
+	}
+}
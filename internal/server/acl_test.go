@@ -1,6 +1,7 @@
 package server
 
 import (
+"context"
 "crypto/rand"
 "crypto/rsa"
 "crypto/x509"
@@ -41,7 +42,6 @@ revokedFile := filepath.Join(tmpDir, "revoked.yaml")
 
 revokedYAML := `revoked:
   - cn: revoked-service
-    serial: "123456"
     reason: compromised
     date: "2026-01-01"
 `
@@ -133,7 +133,7 @@ checker, _ := NewACLChecker(cfg)
 
 // Trading OU should have access to exchange-key
 cert := createTestCert("trading-service-1", "Trading")
-err := checker.CheckAccess(cert, "exchange-key")
+err := checker.CheckAccess(context.Background(), cert, "exchange-key")
 if err != nil {
 t.Errorf("CheckAccess should allow Trading OU to access exchange-key: %v", err)
 }
@@ -156,7 +156,7 @@ checker, _ := NewACLChecker(cfg)
 
 // Trading OU should NOT have access to 2fa context
 cert := createTestCert("trading-service-1", "Trading")
-err := checker.CheckAccess(cert, "2fa")
+err := checker.CheckAccess(context.Background(), cert, "2fa")
 if err == nil {
 t.Error("CheckAccess should deny Trading OU access to 2fa context")
 }
@@ -168,7 +168,7 @@ revokedFile := filepath.Join(tmpDir, "revoked.yaml")
 
 revokedYAML := `revoked:
   - cn: revoked-service
-    serial: "123"
+    serial: "01"
     reason: compromised
     date: "2026-01-01"
 `
@@ -185,7 +185,7 @@ checker, _ := NewACLChecker(cfg)
 
 // Revoked certificate should be denied
 cert := createTestCert("revoked-service", "Trading")
-err := checker.CheckAccess(cert, "exchange-key")
+err := checker.CheckAccess(context.Background(), cert, "exchange-key")
 if err == nil {
 t.Error("CheckAccess should deny revoked certificate")
 }
@@ -207,7 +207,7 @@ checker, _ := NewACLChecker(cfg)
 cert := createTestCert("no-ou-service", "")
 cert.Subject.OrganizationalUnit = []string{} // Remove OU
 
-err := checker.CheckAccess(cert, "exchange-key")
+err := checker.CheckAccess(context.Background(), cert, "exchange-key")
 if err == nil {
 t.Error("CheckAccess should deny certificate without OU")
 }
@@ -229,7 +229,7 @@ checker, _ := NewACLChecker(cfg)
 
 // Unknown OU should be denied
 cert := createTestCert("unknown-service", "UnknownOU")
-err := checker.CheckAccess(cert, "exchange-key")
+err := checker.CheckAccess(context.Background(), cert, "exchange-key")
 if err == nil {
 t.Error("CheckAccess should deny unknown OU")
 }
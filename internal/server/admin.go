@@ -0,0 +1,88 @@
+package server
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/titaev-lv/hsm-service/internal/audit"
+)
+
+// LogLevelRequest is the body of PUT /admin/log-level
+type LogLevelRequest struct {
+	Level string `json:"level"` // debug, info, warn, error
+}
+
+// LogLevelResponse reports the service log level
+type LogLevelResponse struct {
+	Level string `json:"level"`
+}
+
+// LogLevelHandler handles /admin/log-level requests: GET returns the service
+// log level, PUT changes it until the next restart (logging.level applies again)
+func LogLevelHandler(adminOUs []string, aclChecker *ACLChecker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ev := audit.EventFrom(r)
+		ev.Operation = audit.OpLogLevel
+
+		if r.Method != http.MethodGet && r.Method != http.MethodPut {
+			respondAuditError(w, ev, http.StatusMethodNotAllowed, "only GET and PUT allowed")
+			return
+		}
+		clientCN, ok := requireAdmin(w, r, ev, "/admin/log-level", adminOUs, aclChecker)
+		if !ok {
+			return
+		}
+
+		if r.Method == http.MethodPut {
+			const maxRequestSize = 1024 // {"level": "..."}
+			r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+			var req LogLevelRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				respondAuditError(w, ev, http.StatusBadRequest, "invalid JSON")
+				return
+			}
+			level, err := ParseLogLevel(req.Level)
+			if err != nil || req.Level == "" {
+				respondAuditError(w, ev, http.StatusBadRequest, "level must be debug, info, warn or error")
+				return
+			}
+			previous := LogLevel()
+			SetLogLevel(level)
+			slog.Warn("service log level changed", "client_cn", clientCN, "from", previous.String(), "to", level.String())
+		}
+
+		RecordRequest("/admin/log-level", clientCN, "success")
+		respondJSON(w, http.StatusOK, LogLevelResponse{Level: strings.ToLower(LogLevel().String())})
+		ev.Emit(http.StatusOK)
+	}
+}
+
+// requireAdmin checks that the client certificate is not revoked and its OU
+// is one of adminOUs; on failure it responds and emits the audit event
+func requireAdmin(w http.ResponseWriter, r *http.Request, ev *audit.Event, endpoint string, adminOUs []string, aclChecker *ACLChecker) (string, bool) {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		respondAuditError(w, ev, http.StatusUnauthorized, "no client certificate")
+		return "", false
+	}
+	clientCert := r.TLS.PeerCertificates[0]
+	clientCN := clientCert.Subject.CommonName
+
+	// Revocation first, like CheckAccess
+	if aclChecker.IsCertRevoked(clientCert) {
+		RecordRevocationFailure()
+		RecordRequest(endpoint, clientCN, "acl_denied")
+		respondAuditError(w, ev, http.StatusForbidden, "certificate revoked")
+		return "", false
+	}
+	if len(clientCert.Subject.OrganizationalUnit) == 0 || !slices.Contains(adminOUs, clientCert.Subject.OrganizationalUnit[0]) {
+		slog.Warn("admin request denied: not an admin OU", "client_cn", clientCN, "endpoint", endpoint)
+		RecordACLFailure()
+		RecordRequest(endpoint, clientCN, "acl_denied")
+		respondAuditError(w, ev, http.StatusForbidden, "access denied: insufficient permissions")
+		return "", false
+	}
+	return clientCN, true
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLogLevelHandler(t *testing.T) {
+	t.Cleanup(func() { SetLogLevel(slog.LevelInfo) })
+	handler := LogLevelHandler([]string{"Admin"}, newTestAuditACL(t))
+
+	req := createRequestWithCert("PUT", "/admin/log-level", []byte(`{"level":"debug"}`), "admin-1", "Admin")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if LogLevel() != slog.LevelDebug {
+		t.Errorf("LogLevel() = %s, want DEBUG", LogLevel())
+	}
+
+	req = createRequestWithCert("GET", "/admin/log-level", nil, "admin-1", "Admin")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	var resp LogLevelResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Level != "debug" {
+		t.Errorf("Expected level debug, got %q", resp.Level)
+	}
+}
+
+func TestLogLevelHandler_Denied(t *testing.T) {
+	t.Cleanup(func() { SetLogLevel(slog.LevelInfo) })
+	handler := LogLevelHandler([]string{"Admin"}, newTestAuditACL(t))
+
+	tests := []struct {
+		name   string
+		method string
+		body   string
+		cn, ou string
+		status int
+	}{
+		{"non-admin OU", "PUT", `{"level":"debug"}`, "trading-service-1", "Trading", http.StatusForbidden},
+		{"revoked admin", "PUT", `{"level":"debug"}`, "revoked-admin", "Admin", http.StatusForbidden},
+		{"POST", "POST", `{"level":"debug"}`, "admin-1", "Admin", http.StatusMethodNotAllowed},
+		{"unknown level", "PUT", `{"level":"trace"}`, "admin-1", "Admin", http.StatusBadRequest},
+		{"empty level", "PUT", `{}`, "admin-1", "Admin", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, createRequestWithCert(tt.method, "/admin/log-level", []byte(tt.body), tt.cn, tt.ou))
+			if w.Code != tt.status {
+				t.Errorf("Expected status %d, got %d", tt.status, w.Code)
+			}
+			if LogLevel() != slog.LevelInfo {
+				t.Errorf("LogLevel() changed to %s", LogLevel())
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/titaev-lv/hsm-service/internal/audit"
+)
+
+// AuditEventsHandler handles /audit/events requests (query of the audit index)
+// Only clients with an OU from server.admin_ous may read the audit log
+func AuditEventsHandler(index *audit.Index, adminOUs []string, aclChecker *ACLChecker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ev := audit.EventFrom(r)
+		ev.Operation = audit.OpQuery
+
+		// Only accept GET
+		if r.Method != http.MethodGet {
+			respondAuditError(w, ev, http.StatusMethodNotAllowed, "only GET allowed")
+			return
+		}
+
+		// 1-2. Client certificate and admin check
+		clientCN, ok := requireAdmin(w, r, ev, "/audit/events", adminOUs, aclChecker)
+		if !ok {
+			return
+		}
+
+		// 3. Parse filters
+		q, format, err := parseAuditQuery(r.URL.Query())
+		if err != nil {
+			respondAuditError(w, ev, http.StatusBadRequest, err.Error())
+			return
+		}
+		ev.Context = q.Context
+
+		// 4. Query the index
+		res, err := index.Query(q)
+		if err != nil {
+			slog.Error("audit query failed", "client_cn", clientCN, "error", err)
+			RecordRequest("/audit/events", clientCN, "error")
+			respondAuditError(w, ev, http.StatusInternalServerError, "audit query failed")
+			return
+		}
+		RecordRequest("/audit/events", clientCN, "success")
+
+		// 5. Respond (JSON page, or one export page with the cursor in a header)
+		switch format {
+		case "jsonl":
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			setNextCursor(w, res.NextCursor)
+			w.WriteHeader(http.StatusOK)
+			audit.WriteJSONL(w, res.Events)
+		case "csv":
+			w.Header().Set("Content-Type", "text/csv")
+			setNextCursor(w, res.NextCursor)
+			w.WriteHeader(http.StatusOK)
+			audit.WriteCSV(w, res.Events, q.Cursor == 0)
+		default:
+			respondJSON(w, http.StatusOK, res)
+		}
+		ev.Emit(http.StatusOK)
+	}
+}
+
+// setNextCursor sets X-Next-Cursor for export formats (absent on the last page)
+func setNextCursor(w http.ResponseWriter, cursor uint64) {
+	if cursor != 0 {
+		w.Header().Set("X-Next-Cursor", strconv.FormatUint(cursor, 10))
+	}
+}
+
+// parseAuditQuery reads the filters, cursor, limit and format of /audit/events
+func parseAuditQuery(v url.Values) (audit.Query, string, error) {
+	q := audit.Query{
+		ClientCN:  v.Get("client_cn"),
+		ClientOU:  v.Get("client_ou"),
+		Context:   v.Get("context"),
+		Operation: v.Get("operation"),
+		Outcome:   v.Get("outcome"),
+	}
+
+	var err error
+	if s := v.Get("from"); s != "" {
+		if q.From, err = audit.ParseTimeBound(s, false); err != nil {
+			return q, "", fmt.Errorf("invalid from (RFC 3339 or YYYY-MM-DD)")
+		}
+	}
+	if s := v.Get("to"); s != "" {
+		if q.To, err = audit.ParseTimeBound(s, true); err != nil {
+			return q, "", fmt.Errorf("invalid to (RFC 3339 or YYYY-MM-DD)")
+		}
+	}
+	for _, p := range []struct {
+		name string
+		dst  *int
+	}{
+		{"key_version", &q.KeyVersion},
+		{"limit", &q.Limit},
+	} {
+		if s := v.Get(p.name); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 0 {
+				return q, "", fmt.Errorf("invalid %s", p.name)
+			}
+			*p.dst = n
+		}
+	}
+	if s := v.Get("cursor"); s != "" {
+		if q.Cursor, err = strconv.ParseUint(s, 10, 64); err != nil {
+			return q, "", fmt.Errorf("invalid cursor")
+		}
+	}
+
+	format := v.Get("format")
+	switch format {
+	case "", "json", "jsonl", "csv":
+	default:
+		return q, "", fmt.Errorf("invalid format")
+	}
+	return q, format, nil
+}
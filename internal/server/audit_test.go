@@ -0,0 +1,117 @@
+package server
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/titaev-lv/hsm-service/internal/audit"
+	"github.com/titaev-lv/hsm-service/internal/config"
+)
+
+// newTestAuditIndex writes request events for the given clients to an audit
+// log and indexes it
+func newTestAuditIndex(t *testing.T, clients ...string) *audit.Index {
+	t.Helper()
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "audit.log")
+
+	sink, err := audit.OpenSink(&config.AuditConfig{File: logPath}, func(data []byte) ([]byte, error) {
+		return []byte("test-mac"), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, cn := range clients {
+		sink.Append(slog.LevelInfo, audit.EventMessage, map[string]any{
+			"operation": audit.OpEncrypt,
+			"client_cn": cn,
+			"context":   "exchange-key",
+			"outcome":   audit.OutcomeSuccess,
+		})
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	index := audit.NewIndex(filepath.Join(dir, "audit-index.db"))
+	if _, err := index.Sync(logPath); err != nil {
+		t.Fatal(err)
+	}
+	return index
+}
+
+func newTestAuditACL(t *testing.T) *ACLChecker {
+	t.Helper()
+	revokedFile := filepath.Join(t.TempDir(), "revoked.yaml")
+	os.WriteFile(revokedFile, []byte("revoked:\n  - cn: revoked-admin\n"), 0644)
+	aclChecker, err := NewACLChecker(&config.ACLConfig{RevokedFile: revokedFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { aclChecker.StopAutoReload(t.Context()) })
+	return aclChecker
+}
+
+func TestAuditEventsHandler(t *testing.T) {
+	handler := AuditEventsHandler(newTestAuditIndex(t, "svc-1", "svc-2", "svc-1"), []string{"Admin"}, newTestAuditACL(t))
+
+	req := createRequestWithCert("GET", "/audit/events?client_cn=svc-1&from=2020-01-01&limit=1", nil, "admin-1", "Admin")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var page audit.QueryResult
+	if err := json.NewDecoder(w.Body).Decode(&page); err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Events) != 1 || page.Events[0].Seq != 1 || page.NextCursor != 1 {
+		t.Fatalf("unexpected first page: %+v", page)
+	}
+
+	// Second page as CSV (no header after the first page)
+	req = createRequestWithCert("GET", "/audit/events?client_cn=svc-1&cursor=1&format=csv", nil, "admin-1", "Admin")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "text/csv" {
+		t.Fatalf("Expected CSV, got %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	if !strings.HasPrefix(w.Body.String(), "3,") || w.Header().Get("X-Next-Cursor") != "" {
+		t.Errorf("unexpected second page: %q (next cursor %q)", w.Body.String(), w.Header().Get("X-Next-Cursor"))
+	}
+}
+
+func TestAuditEventsHandler_Denied(t *testing.T) {
+	handler := AuditEventsHandler(newTestAuditIndex(t, "svc-1"), []string{"Admin"}, newTestAuditACL(t))
+
+	tests := []struct {
+		name   string
+		method string
+		url    string
+		cn, ou string
+		status int
+	}{
+		{"non-admin OU", "GET", "/audit/events", "trading-service-1", "Trading", http.StatusForbidden},
+		{"revoked admin", "GET", "/audit/events", "revoked-admin", "Admin", http.StatusForbidden},
+		{"POST", "POST", "/audit/events", "admin-1", "Admin", http.StatusMethodNotAllowed},
+		{"bad time", "GET", "/audit/events?from=yesterday", "admin-1", "Admin", http.StatusBadRequest},
+		{"bad format", "GET", "/audit/events?format=xml", "admin-1", "Admin", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, createRequestWithCert(tt.method, tt.url, nil, tt.cn, tt.ou))
+			if w.Code != tt.status {
+				t.Errorf("Expected status %d, got %d", tt.status, w.Code)
+			}
+		})
+	}
+}
@@ -3,10 +3,17 @@ package server
 import (
 	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log/slog"
 	"net/http"
+	"slices"
+	"sort"
+	"time"
 
+	"github.com/titaev-lv/hsm-service/internal/audit"
 	"github.com/titaev-lv/hsm-service/internal/hsm"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // Request/Response types
@@ -40,6 +47,22 @@ type HealthResponse struct {
 	KEKStatus    map[string]string `json:"kek_status"`
 }
 
+type KeyInfo struct {
+	Label        string     `json:"label"`
+	Context      string     `json:"context"`
+	Version      int        `json:"version"`
+	State        string     `json:"state"`
+	Current      bool       `json:"current"`
+	CreatedAt    time.Time  `json:"created_at"`
+	EncryptCount uint64     `json:"encrypt_count"`
+	DecryptCount uint64     `json:"decrypt_count"`
+	LastUsedAt   *time.Time `json:"last_used_at,omitempty"`
+}
+
+type KeysResponse struct {
+	Keys []KeyInfo `json:"keys"`
+}
+
 // Helper functions
 func respondJSON(w http.ResponseWriter, status int, data interface{}) {
 	w.Header().Set("Content-Type", "application/json")
@@ -51,12 +74,29 @@ func respondError(w http.ResponseWriter, status int, message string) {
 	respondJSON(w, status, ErrorResponse{Error: message})
 }
 
+// respondAuditError writes an error response and emits the request's audit
+// event with the message as reason (messages never carry plaintext)
+func respondAuditError(w http.ResponseWriter, ev *audit.Event, status int, message string) {
+	ev.Reason = message
+	respondError(w, status, message)
+	ev.Emit(status)
+}
+
 // EncryptHandler handles /encrypt requests
 func EncryptHandler(keyManager hsm.CryptoProvider, aclChecker *ACLChecker) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		ev := audit.EventFrom(r)
+		ev.Operation = audit.OpEncrypt
+
+		ctx, span := startSpan(r.Context(), "EncryptHandler")
+		defer func() {
+			span.SetAttributes(attribute.String("hsm.context", ev.Context), attribute.String("hsm.outcome", ev.Outcome))
+			span.End()
+		}()
+
 		// Only accept POST
 		if r.Method != http.MethodPost {
-			respondError(w, http.StatusMethodNotAllowed, "only POST allowed")
+			respondAuditError(w, ev, http.StatusMethodNotAllowed, "only POST allowed")
 			return
 		}
 
@@ -66,15 +106,18 @@ func EncryptHandler(keyManager hsm.CryptoProvider, aclChecker *ACLChecker) http.
 
 		// 1. Parse request
 		var req EncryptRequest
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		_, decode := startSpan(ctx, "json.decode")
+		err := json.NewDecoder(r.Body).Decode(&req)
+		endSpan(decode, err)
+		if err != nil {
 			slog.Warn("invalid JSON in request", "path", r.URL.Path, "method", r.Method)
-			respondError(w, http.StatusBadRequest, "invalid JSON")
+			respondAuditError(w, ev, http.StatusBadRequest, "invalid JSON")
 			return
 		}
 
 		// 2. Extract client certificate
 		if len(r.TLS.PeerCertificates) == 0 {
-			respondError(w, http.StatusUnauthorized, "no client certificate")
+			respondAuditError(w, ev, http.StatusUnauthorized, "no client certificate")
 			return
 		}
 		clientCert := r.TLS.PeerCertificates[0]
@@ -86,8 +129,10 @@ func EncryptHandler(keyManager hsm.CryptoProvider, aclChecker *ACLChecker) http.
 			clientOU = clientCert.Subject.OrganizationalUnit[0]
 		}
 
+		ev.Context = req.Context
+
 		// 3. ACL check
-		if err := aclChecker.CheckAccess(clientCert, req.Context); err != nil {
+		if err := aclChecker.CheckAccess(ctx, clientCert, req.Context); err != nil {
 			slog.Warn("ACL check failed",
 				"client_cn", clientCN,
 				"context", req.Context,
@@ -96,16 +141,17 @@ func EncryptHandler(keyManager hsm.CryptoProvider, aclChecker *ACLChecker) http.
 			// Metrics: track ACL failure (security monitoring)
 			RecordACLFailure()
 			RecordRequest("/encrypt", clientCN, "acl_denied")
-			respondError(w, http.StatusForbidden, err.Error())
+			respondAuditError(w, ev, http.StatusForbidden, err.Error())
 			return
 		}
 
 		// 4. Decode plaintext from base64
 		plaintext, err := base64.StdEncoding.DecodeString(req.Plaintext)
 		if err != nil {
-			respondError(w, http.StatusBadRequest, "invalid base64 plaintext")
+			respondAuditError(w, ev, http.StatusBadRequest, "invalid base64 plaintext")
 			return
 		}
+		ev.PayloadBytes = len(plaintext)
 		// Zero plaintext memory after use (security: prevent memory dumps)
 		defer func() {
 			for i := range plaintext {
@@ -115,7 +161,7 @@ func EncryptHandler(keyManager hsm.CryptoProvider, aclChecker *ACLChecker) http.
 
 		// 5. Encrypt with context, OU, and clientCN
 		// AAD will be built based on key's mode (shared=OU, private=CN)
-		ciphertext, keyID, err := keyManager.Encrypt(plaintext, req.Context, clientOU, clientCN)
+		ciphertext, keyID, err := keyManager.Encrypt(ctx, plaintext, req.Context, clientOU, clientCN)
 		if err != nil {
 			slog.Error("encryption failed",
 				"client_cn", clientCN,
@@ -126,10 +172,15 @@ func EncryptHandler(keyManager hsm.CryptoProvider, aclChecker *ACLChecker) http.
 			RecordHSMError("encrypt")
 			RecordEncryptOp(req.Context, "failure")
 			RecordRequest("/encrypt", clientCN, "error")
-			respondError(w, http.StatusInternalServerError, "encryption failed")
+			respondAuditError(w, ev, http.StatusInternalServerError, "encryption failed")
 			return
 		}
 
+		ev.KeyID = keyID
+		if meta, err := keyManager.GetKeyMetadata(keyID); err == nil {
+			ev.KeyVersion = meta.Version
+		}
+
 		// Metrics: track successful encryption
 		RecordEncryptOp(req.Context, "success")
 		RecordRequest("/encrypt", clientCN, "success")
@@ -139,16 +190,28 @@ func EncryptHandler(keyManager hsm.CryptoProvider, aclChecker *ACLChecker) http.
 			Ciphertext: base64.StdEncoding.EncodeToString(ciphertext),
 			KeyID:      keyID,
 		}
+		_, encode := startSpan(ctx, "json.encode")
 		respondJSON(w, http.StatusOK, resp)
+		encode.End()
+		ev.Emit(http.StatusOK)
 	}
 }
 
 // DecryptHandler handles /decrypt requests
 func DecryptHandler(keyManager hsm.CryptoProvider, aclChecker *ACLChecker) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		ev := audit.EventFrom(r)
+		ev.Operation = audit.OpDecrypt
+
+		ctx, span := startSpan(r.Context(), "DecryptHandler")
+		defer func() {
+			span.SetAttributes(attribute.String("hsm.context", ev.Context), attribute.String("hsm.outcome", ev.Outcome))
+			span.End()
+		}()
+
 		// Only accept POST
 		if r.Method != http.MethodPost {
-			respondError(w, http.StatusMethodNotAllowed, "only POST allowed")
+			respondAuditError(w, ev, http.StatusMethodNotAllowed, "only POST allowed")
 			return
 		}
 
@@ -158,22 +221,27 @@ func DecryptHandler(keyManager hsm.CryptoProvider, aclChecker *ACLChecker) http.
 
 		// 1. Parse request
 		var req DecryptRequest
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		_, decode := startSpan(ctx, "json.decode")
+		err := json.NewDecoder(r.Body).Decode(&req)
+		endSpan(decode, err)
+		if err != nil {
 			slog.Warn("invalid JSON in request", "path", r.URL.Path, "method", r.Method)
-			respondError(w, http.StatusBadRequest, "invalid JSON")
+			respondAuditError(w, ev, http.StatusBadRequest, "invalid JSON")
 			return
 		}
 
 		// 2. Extract client certificate
 		if len(r.TLS.PeerCertificates) == 0 {
-			respondError(w, http.StatusUnauthorized, "no client certificate")
+			respondAuditError(w, ev, http.StatusUnauthorized, "no client certificate")
 			return
 		}
 		clientCert := r.TLS.PeerCertificates[0]
 		clientCN := clientCert.Subject.CommonName
 
+		ev.Context = req.Context
+
 		// 3. ACL check
-		if err := aclChecker.CheckAccess(clientCert, req.Context); err != nil {
+		if err := aclChecker.CheckAccess(ctx, clientCert, req.Context); err != nil {
 			slog.Warn("ACL check failed",
 				"client_cn", clientCN,
 				"context", req.Context,
@@ -182,16 +250,17 @@ func DecryptHandler(keyManager hsm.CryptoProvider, aclChecker *ACLChecker) http.
 			// Metrics: track ACL failure (security monitoring)
 			RecordACLFailure()
 			RecordRequest("/decrypt", clientCN, "acl_denied")
-			respondError(w, http.StatusForbidden, err.Error())
+			respondAuditError(w, ev, http.StatusForbidden, err.Error())
 			return
 		}
 
 		// 4. Decode ciphertext from base64
 		ciphertext, err := base64.StdEncoding.DecodeString(req.Ciphertext)
 		if err != nil {
-			respondError(w, http.StatusBadRequest, "invalid base64 ciphertext")
+			respondAuditError(w, ev, http.StatusBadRequest, "invalid base64 ciphertext")
 			return
 		}
+		ev.PayloadBytes = len(ciphertext)
 		// Zero ciphertext memory after use (security: prevent memory dumps)
 		defer func() {
 			for i := range ciphertext {
@@ -205,9 +274,27 @@ func DecryptHandler(keyManager hsm.CryptoProvider, aclChecker *ACLChecker) http.
 			clientOU = clientCert.Subject.OrganizationalUnit[0]
 		}
 
+		ev.KeyID = req.KeyID
+		if meta, err := keyManager.GetKeyMetadata(req.KeyID); err == nil {
+			ev.KeyVersion = meta.Version
+		}
+
 		// 5. Decrypt with context, OU, clientCN, and keyID
 		// AAD will be rebuilt based on key's mode (shared=OU, private=CN)
-		plaintext, err := keyManager.Decrypt(ciphertext, req.Context, clientOU, clientCN, req.KeyID)
+		plaintext, err := keyManager.Decrypt(ctx, ciphertext, req.Context, clientOU, clientCN, req.KeyID)
+		var stateErr *hsm.KeyStateError
+		if errors.As(err, &stateErr) {
+			slog.Warn("decryption refused by key state",
+				"client_cn", clientCN,
+				"context", req.Context,
+				"key_id", req.KeyID,
+				"state", stateErr.State,
+			)
+			RecordDecryptOp(req.Context, "failure")
+			RecordRequest("/decrypt", clientCN, "key_disabled")
+			respondAuditError(w, ev, http.StatusForbidden, fmt.Sprintf("decryption refused: key version is %s", stateErr.State))
+			return
+		}
 		if err != nil {
 			slog.Warn("decryption failed",
 				"client_cn", clientCN,
@@ -220,7 +307,7 @@ func DecryptHandler(keyManager hsm.CryptoProvider, aclChecker *ACLChecker) http.
 			RecordDecryptOp(req.Context, "failure")
 			RecordRequest("/decrypt", clientCN, "error")
 			// Don't expose internal error details
-			respondError(w, http.StatusBadRequest, "decryption failed")
+			respondAuditError(w, ev, http.StatusBadRequest, "decryption failed")
 			return
 		}
 		// Zero plaintext memory after use (security: prevent memory dumps)
@@ -238,7 +325,10 @@ func DecryptHandler(keyManager hsm.CryptoProvider, aclChecker *ACLChecker) http.
 		resp := DecryptResponse{
 			Plaintext: base64.StdEncoding.EncodeToString(plaintext),
 		}
+		_, encode := startSpan(ctx, "json.encode")
 		respondJSON(w, http.StatusOK, resp)
+		encode.End()
+		ev.Emit(http.StatusOK)
 	}
 }
 
@@ -271,3 +361,79 @@ func HealthHandler(keyManager hsm.CryptoProvider) http.HandlerFunc {
 		respondJSON(w, httpStatus, status)
 	}
 }
+
+// KeysHandler handles /keys requests (loaded key versions with usage statistics):
+// admin OUs (server.admin_ous) see every context, other clients only the
+// contexts their OU is mapped to in acl.mappings
+func KeysHandler(keyManager hsm.CryptoProvider, adminOUs []string, aclChecker *ACLChecker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ev := audit.EventFrom(r)
+
+		if r.Method != http.MethodGet {
+			respondAuditError(w, ev, http.StatusMethodNotAllowed, "only GET allowed")
+			return
+		}
+		if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+			respondAuditError(w, ev, http.StatusUnauthorized, "no client certificate")
+			return
+		}
+		clientCert := r.TLS.PeerCertificates[0]
+		clientCN := clientCert.Subject.CommonName
+
+		if aclChecker.IsCertRevoked(clientCert) {
+			RecordRevocationFailure()
+			RecordRequest("/keys", clientCN, "acl_denied")
+			respondAuditError(w, ev, http.StatusForbidden, "certificate revoked")
+			return
+		}
+		var ou string
+		if len(clientCert.Subject.OrganizationalUnit) > 0 {
+			ou = clientCert.Subject.OrganizationalUnit[0]
+		}
+		admin := ou != "" && slices.Contains(adminOUs, ou)
+		allowedContexts, mapped := aclChecker.Mappings()[ou]
+		if !admin && !mapped {
+			slog.Warn("keys request denied: unknown organizational unit", "client_cn", clientCN)
+			RecordACLFailure()
+			RecordRequest("/keys", clientCN, "acl_denied")
+			respondAuditError(w, ev, http.StatusForbidden, "access denied: unknown organizational unit")
+			return
+		}
+
+		labels := keyManager.GetKeyLabels()
+		sort.Strings(labels)
+
+		resp := KeysResponse{Keys: make([]KeyInfo, 0, len(labels))}
+		for _, label := range labels {
+			meta, err := keyManager.GetKeyMetadata(label)
+			if err != nil {
+				continue
+			}
+			// Contexts outside the OU's mapping are not disclosed
+			if !admin && !slices.Contains(allowedContexts, meta.Context) {
+				continue
+			}
+			usage := keyManager.GetKeyUsage(label)
+
+			info := KeyInfo{
+				Label:        label,
+				Context:      meta.Context,
+				Version:      meta.Version,
+				State:        string(meta.State),
+				CreatedAt:    meta.CreatedAt,
+				EncryptCount: usage.EncryptCount,
+				DecryptCount: usage.DecryptCount,
+			}
+			if current, err := keyManager.GetKeyLabelByContext(meta.Context); err == nil {
+				info.Current = current == label
+			}
+			if last := usage.LastUsed(); !last.IsZero() {
+				info.LastUsedAt = &last
+			}
+			resp.Keys = append(resp.Keys, info)
+		}
+
+		RecordRequest("/keys", clientCN, "success")
+		respondJSON(w, http.StatusOK, resp)
+	}
+}
@@ -2,6 +2,7 @@ package server
 
 import (
 	"bytes"
+	"context"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
@@ -9,13 +10,16 @@ import (
 	"crypto/x509"
 	"encoding/base64"
 	"encoding/json"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/titaev-lv/hsm-service/internal/audit"
 	"github.com/titaev-lv/hsm-service/internal/config"
 	"github.com/titaev-lv/hsm-service/internal/hsm"
 )
@@ -24,14 +28,19 @@ import (
 type mockKeyManager struct {
 	keys           map[string]cipher.AEAD
 	contextToLabel map[string]string
+	labelContext   map[string]string // Label -> context reported by GetKeyMetadata
+	decryptErr     error             // Returned by Decrypt when set
 }
 
-func (m *mockKeyManager) Encrypt(plaintext []byte, context, ou, clientCN string) ([]byte, string, error) {
+func (m *mockKeyManager) Encrypt(ctx context.Context, plaintext []byte, keyContext, ou, clientCN string) ([]byte, string, error) {
 	// Return mock encrypted data
 	return []byte("mock-ciphertext"), "mock-key-v1", nil
 }
 
-func (m *mockKeyManager) Decrypt(ciphertext []byte, context, ou, clientCN, keyLabel string) ([]byte, error) {
+func (m *mockKeyManager) Decrypt(ctx context.Context, ciphertext []byte, keyContext, ou, clientCN, keyLabel string) ([]byte, error) {
+	if m.decryptErr != nil {
+		return nil, m.decryptErr
+	}
 	// Return mock decrypted data
 	return []byte("mock-plaintext"), nil
 }
@@ -49,7 +58,7 @@ func (m *mockKeyManager) HasKey(label string) bool {
 	return exists
 }
 
-func (m *mockKeyManager) GetKeyLabelByContext(context string) (string, error) {
+func (m *mockKeyManager) GetKeyLabelByContext(keyContext string) (string, error) {
 	return "mock-key-v1", nil
 }
 
@@ -57,6 +66,7 @@ func (m *mockKeyManager) GetKeyMetadata(label string) (*hsm.KeyMetadata, error)
 	// Return mock metadata
 	return &hsm.KeyMetadata{
 		Label:            label,
+		Context:          m.labelContext[label],
 		CreatedAt:        time.Now(),
 		RotationInterval: 0,
 		Version:          1,
@@ -68,6 +78,11 @@ func (m *mockKeyManager) GetKeysNeedingRotation() []string {
 	return []string{}
 }
 
+func (m *mockKeyManager) GetKeyUsage(label string) hsm.KeyUsage {
+	last := time.Date(2026, 1, 10, 12, 0, 0, 0, time.UTC)
+	return hsm.KeyUsage{EncryptCount: 5, DecryptCount: 3, LastDecryptAt: &last}
+}
+
 // createMockKeyManager creates a mock KeyManager for testing
 func createMockKeyManager() *mockKeyManager {
 	// Create a test AES key
@@ -85,6 +100,9 @@ func createMockKeyManager() *mockKeyManager {
 			"exchange-key": "mock-key-v1",
 			"2fa":          "mock-key-v1",
 		},
+		labelContext: map[string]string{
+			"mock-key-v1": "exchange-key",
+		},
 	}
 }
 
@@ -217,6 +235,109 @@ func TestHealthHandler(t *testing.T) {
 	}
 }
 
+// newTestKeysACL returns an ACL checker mapping Trading to exchange-key only
+func newTestKeysACL(t *testing.T) *ACLChecker {
+	t.Helper()
+	revokedFile := filepath.Join(t.TempDir(), "revoked.yaml")
+	os.WriteFile(revokedFile, []byte("revoked:\n  - cn: revoked-service\n"), 0644)
+	aclChecker, err := NewACLChecker(&config.ACLConfig{
+		RevokedFile: revokedFile,
+		Mappings:    map[string][]string{"Trading": {"exchange-key"}, "2FA": {"2fa"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { aclChecker.StopAutoReload(t.Context()) })
+	return aclChecker
+}
+
+func TestKeysHandler(t *testing.T) {
+	keyManager := createMockKeyManager()
+
+	handler := KeysHandler(keyManager, []string{"Admin"}, newTestKeysACL(t))
+
+	req := createRequestWithCert("GET", "/keys", nil, "trading-service-1", "Trading")
+	w := httptest.NewRecorder()
+
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+
+	var resp KeysResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to parse keys response: %v", err)
+	}
+
+	if len(resp.Keys) != 1 {
+		t.Fatalf("Expected 1 key, got %d", len(resp.Keys))
+	}
+	key := resp.Keys[0]
+	if key.Label != "mock-key-v1" || key.EncryptCount != 5 || key.DecryptCount != 3 {
+		t.Errorf("Unexpected key info: %+v", key)
+	}
+	if key.LastUsedAt == nil {
+		t.Error("Expected last_used_at to be set")
+	}
+}
+
+func TestKeysHandler_Access(t *testing.T) {
+	keyManager := createMockKeyManager()
+	keyManager.keys["mock-2fa-v1"] = keyManager.keys["mock-key-v1"]
+	keyManager.labelContext["mock-2fa-v1"] = "2fa"
+	handler := KeysHandler(keyManager, []string{"Admin"}, newTestKeysACL(t))
+
+	tests := []struct {
+		name   string
+		cn, ou string
+		status int
+		labels []string
+	}{
+		{"admin sees every context", "admin-1", "Admin", http.StatusOK, []string{"mock-2fa-v1", "mock-key-v1"}},
+		{"mapped OU sees its contexts", "trading-service-1", "Trading", http.StatusOK, []string{"mock-key-v1"}},
+		{"unmapped OU", "unknown-1", "Unknown", http.StatusForbidden, nil},
+		{"no OU", "no-ou-1", "", http.StatusForbidden, nil},
+		{"revoked", "revoked-service", "Trading", http.StatusForbidden, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, createRequestWithCert("GET", "/keys", nil, tt.cn, tt.ou))
+			if w.Code != tt.status {
+				t.Fatalf("Expected status %d, got %d: %s", tt.status, w.Code, w.Body.String())
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			var resp KeysResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			var labels []string
+			for _, key := range resp.Keys {
+				labels = append(labels, key.Label)
+			}
+			if strings.Join(labels, ",") != strings.Join(tt.labels, ",") {
+				t.Errorf("Expected keys %v, got %v", tt.labels, labels)
+			}
+		})
+	}
+}
+
+func TestKeysHandler_MethodNotAllowed(t *testing.T) {
+	handler := KeysHandler(createMockKeyManager(), []string{"Admin"}, newTestKeysACL(t))
+
+	req := createRequestWithCert("POST", "/keys", nil, "admin-1", "Admin")
+	w := httptest.NewRecorder()
+
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status 405, got %d", w.Code)
+	}
+}
+
 func TestRespondJSON(t *testing.T) {
 	w := httptest.NewRecorder()
 
@@ -251,3 +372,137 @@ func TestRespondError(t *testing.T) {
 		t.Errorf("Expected error 'test error', got '%s'", resp.Error)
 	}
 }
+
+// captureLog redirects the default logger (and so the audit logger) into a buffer
+func captureLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	prev := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, nil)))
+	t.Cleanup(func() { slog.SetDefault(prev) })
+	return &buf
+}
+
+// auditEvents returns the request audit events from a captured log
+func auditEvents(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var events []map[string]any
+	for _, line := range bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n")) {
+		var rec map[string]any
+		if err := json.Unmarshal(line, &rec); err != nil {
+			t.Fatalf("invalid log line %q: %v", line, err)
+		}
+		if rec["msg"] == audit.EventMessage && rec["component"] == "audit" {
+			events = append(events, rec)
+		}
+	}
+	return events
+}
+
+func TestEncryptHandler_AuditEvent(t *testing.T) {
+	tmpDir := t.TempDir()
+	revokedFile := filepath.Join(tmpDir, "revoked.yaml")
+	os.WriteFile(revokedFile, []byte("revoked: []"), 0644)
+	aclChecker, _ := NewACLChecker(&config.ACLConfig{
+		RevokedFile: revokedFile,
+		Mappings:    map[string][]string{"Trading": {"exchange-key"}},
+	})
+	handler := AuditLogMiddleware(EncryptHandler(createMockKeyManager(), aclChecker))
+
+	const secret = "4111-1111-1111-1111"
+	tests := []struct {
+		name    string
+		context string
+		status  int
+		want    map[string]any
+	}{
+		{"success", "exchange-key", http.StatusOK, map[string]any{
+			"outcome": audit.OutcomeSuccess, "key_id": "mock-key-v1", "key_version": float64(1),
+			"payload_bytes": float64(len(secret)),
+		}},
+		{"acl denied", "2fa", http.StatusForbidden, map[string]any{
+			"outcome": audit.OutcomeDenied, "reason": "access denied: insufficient permissions",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := captureLog(t)
+			body, _ := json.Marshal(EncryptRequest{Context: tt.context, Plaintext: base64.StdEncoding.EncodeToString([]byte(secret))})
+			req := createRequestWithCert("POST", "/encrypt", body, "trading-service-1", "Trading")
+			req.Header.Set(audit.RequestIDHeader, "req-42")
+			w := httptest.NewRecorder()
+
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tt.status {
+				t.Fatalf("Expected status %d, got %d", tt.status, w.Code)
+			}
+			if got := w.Header().Get(audit.RequestIDHeader); got != "req-42" {
+				t.Errorf("X-Request-ID = %q, want req-42", got)
+			}
+			events := auditEvents(t, buf)
+			if len(events) != 1 {
+				t.Fatalf("expected exactly one audit event, got %d", len(events))
+			}
+			ev := events[0]
+			for k, v := range map[string]any{
+				"request_id": "req-42", "operation": audit.OpEncrypt, "context": tt.context,
+				"status": float64(tt.status), "client_cn": "trading-service-1", "client_serial": "1",
+			} {
+				if ev[k] != v {
+					t.Errorf("%s = %v, want %v", k, ev[k], v)
+				}
+			}
+			for k, v := range tt.want {
+				if ev[k] != v {
+					t.Errorf("%s = %v, want %v", k, ev[k], v)
+				}
+			}
+			if fp, _ := ev["client_fingerprint"].(string); len(fp) != 64 {
+				t.Errorf("client_fingerprint = %q, want SHA-256 hex", fp)
+			}
+			if strings.Contains(buf.String(), secret) || strings.Contains(buf.String(), base64.StdEncoding.EncodeToString([]byte(secret))) {
+				t.Error("plaintext leaked into the log")
+			}
+		})
+	}
+}
+
+func TestDecryptHandler_KeyStateAuditEvent(t *testing.T) {
+	tmpDir := t.TempDir()
+	revokedFile := filepath.Join(tmpDir, "revoked.yaml")
+	os.WriteFile(revokedFile, []byte("revoked: []"), 0644)
+	aclChecker, _ := NewACLChecker(&config.ACLConfig{
+		RevokedFile: revokedFile,
+		Mappings:    map[string][]string{"Trading": {"exchange-key"}},
+	})
+
+	for _, state := range []config.KeyState{config.KeyStateDisabled, config.KeyStateDestroyed} {
+		t.Run(string(state), func(t *testing.T) {
+			km := createMockKeyManager()
+			km.decryptErr = &hsm.KeyStateError{Label: "mock-key-v1", State: state}
+			handler := AuditLogMiddleware(DecryptHandler(km, aclChecker))
+
+			buf := captureLog(t)
+			body, _ := json.Marshal(DecryptRequest{
+				Context:    "exchange-key",
+				Ciphertext: base64.StdEncoding.EncodeToString([]byte("ciphertext")),
+				KeyID:      "mock-key-v1",
+			})
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, createRequestWithCert("POST", "/decrypt", body, "trading-service-1", "Trading"))
+
+			if w.Code != http.StatusForbidden {
+				t.Fatalf("Expected status 403, got %d", w.Code)
+			}
+			events := auditEvents(t, buf)
+			if len(events) != 1 {
+				t.Fatalf("expected exactly one audit event, got %d", len(events))
+			}
+			want := "decryption refused: key version is " + string(state)
+			if events[0]["reason"] != want || events[0]["outcome"] != audit.OutcomeDenied {
+				t.Errorf("reason = %v, outcome = %v; want %q, denied", events[0]["reason"], events[0]["outcome"], want)
+			}
+		})
+	}
+}
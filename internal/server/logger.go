@@ -1,49 +1,118 @@
 package server
 
 import (
+	"fmt"
+	"io"
+	"log"
 	"log/slog"
 	"os"
 	"strings"
 
+	"github.com/titaev-lv/hsm-service/internal/audit"
 	"github.com/titaev-lv/hsm-service/internal/config"
+	"gopkg.in/natefinch/lumberjack.v2"
 )
 
-// InitLogger initializes the global slog logger based on configuration
+// logLevel is the service log level, changed at runtime through /admin/log-level
+var logLevel = new(slog.LevelVar)
+
+// InitLogger initializes the global slog logger based on configuration:
+// level, format and destinations of the service log and, with logging.audit,
+// a separate destination for audit events. The standard log package writes
+// to the service log destinations too.
 func InitLogger(cfg *config.LoggingConfig) error {
-	var level slog.Level
-	switch cfg.Level {
-	case "debug":
-		level = slog.LevelDebug
-	case "info":
-		level = slog.LevelInfo
-	case "warn":
-		level = slog.LevelWarn
-	case "error":
-		level = slog.LevelError
-	default:
+	level, err := ParseLogLevel(cfg.Level)
+	if err != nil {
 		level = slog.LevelInfo
 	}
+	logLevel.Set(level)
 
-	opts := &slog.HandlerOptions{
-		Level: level,
+	w, err := logWriter(&cfg.LogOutputConfig, cfg.GetFile())
+	if err != nil {
+		return err
 	}
+	slog.SetDefault(slog.New(newLogHandler(cfg.GetFormat(), w, &slog.HandlerOptions{Level: logLevel})))
+	log.SetOutput(w)
 
-	var handler slog.Handler
-	if cfg.Format == "json" {
-		handler = slog.NewJSONHandler(os.Stdout, opts)
-	} else {
-		handler = slog.NewTextHandler(os.Stdout, opts)
+	if cfg.Audit == nil {
+		audit.SetLogger(nil)
+		return nil
+	}
+	aw, err := logWriter(cfg.Audit, cfg.Audit.File)
+	if err != nil {
+		return fmt.Errorf("audit: %w", err)
 	}
+	// Audit events are kept regardless of the service log level
+	audit.SetLogger(slog.New(newLogHandler(cfg.GetFormat(), aw, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	return nil
+}
 
-	logger := slog.New(handler)
-	slog.SetDefault(logger)
+// newLogHandler returns a JSON or text handler
+func newLogHandler(format string, w io.Writer, opts *slog.HandlerOptions) slog.Handler {
+	if format == "text" {
+		return slog.NewTextHandler(w, opts)
+	}
+	return slog.NewJSONHandler(w, opts)
+}
 
-	return nil
+// logWriter returns the writer for the configured destinations; the file is
+// rotated by size (A09:2021 security requirement)
+func logWriter(cfg *config.LogOutputConfig, file string) (io.Writer, error) {
+	var writers []io.Writer
+	for _, out := range cfg.GetOutputs() {
+		switch out {
+		case config.LogOutputStdout:
+			writers = append(writers, os.Stdout)
+		case config.LogOutputStderr:
+			writers = append(writers, os.Stderr)
+		case config.LogOutputFile:
+			writers = append(writers, &lumberjack.Logger{
+				Filename:   file,
+				MaxSize:    cfg.GetMaxSizeMB(),
+				MaxBackups: cfg.GetMaxBackups(),
+				MaxAge:     cfg.GetMaxAgeDays(),
+				Compress:   cfg.GetCompress(),
+			})
+		default:
+			return nil, fmt.Errorf("unknown log output %q", out)
+		}
+	}
+	if len(writers) == 1 {
+		return writers[0], nil
+	}
+	return io.MultiWriter(writers...), nil
+}
+
+// ParseLogLevel parses debug, info, warn or error (case-insensitive)
+func ParseLogLevel(s string) (slog.Level, error) {
+	switch strings.ToLower(s) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info", "":
+		return slog.LevelInfo, nil
+	case "warn":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	default:
+		return slog.LevelInfo, fmt.Errorf("unknown log level %q (debug, info, warn or error)", s)
+	}
+}
+
+// LogLevel returns the current service log level
+func LogLevel() slog.Level {
+	return logLevel.Level()
+}
+
+// SetLogLevel changes the service log level at runtime
+func SetLogLevel(level slog.Level) {
+	logLevel.Set(level)
 }
 
 // AuditLogger returns a logger specifically for audit events
+// (also written to the hash-chained audit log when audit.enabled)
 func AuditLogger() *slog.Logger {
-	return slog.With("component", "audit")
+	return audit.Logger()
 }
 
 // SanitizeForLog removes or redacts sensitive fields from log data
@@ -1,36 +1,55 @@
 package server
 
 import (
+	"log"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
+	"github.com/titaev-lv/hsm-service/internal/audit"
+
 	"github.com/titaev-lv/hsm-service/internal/config"
 )
 
 func TestInitLogger(t *testing.T) {
+	restoreLogger(t)
+	stdout := config.LogOutputConfig{Outputs: []string{config.LogOutputStdout}}
+
 	tests := []struct {
 		name   string
 		config *config.LoggingConfig
+		level  slog.Level
 	}{
 		{
 			name: "json format",
 			config: &config.LoggingConfig{
-				Level:  "info",
-				Format: "json",
+				Level:           "info",
+				Format:          "json",
+				LogOutputConfig: stdout,
 			},
+			level: slog.LevelInfo,
 		},
 		{
 			name: "text format",
 			config: &config.LoggingConfig{
-				Level:  "debug",
-				Format: "text",
+				Level:           "debug",
+				Format:          "text",
+				LogOutputConfig: stdout,
 			},
+			level: slog.LevelDebug,
 		},
 		{
 			name: "default level",
 			config: &config.LoggingConfig{
-				Level:  "unknown",
-				Format: "json",
+				Level:           "unknown",
+				Format:          "json",
+				LogOutputConfig: stdout,
 			},
+			level: slog.LevelInfo,
 		},
 	}
 
@@ -40,10 +59,60 @@ func TestInitLogger(t *testing.T) {
 			if err != nil {
 				t.Errorf("InitLogger() error = %v", err)
 			}
+			if LogLevel() != tt.level {
+				t.Errorf("LogLevel() = %s, want %s", LogLevel(), tt.level)
+			}
 		})
 	}
 }
 
+func TestInitLogger_FileAndAuditDestination(t *testing.T) {
+	restoreLogger(t)
+	dir := t.TempDir()
+	appFile := filepath.Join(dir, "hsm-service.log")
+	auditFile := filepath.Join(dir, "audit-events.log")
+
+	err := InitLogger(&config.LoggingConfig{
+		Level:           "warn",
+		LogOutputConfig: config.LogOutputConfig{Outputs: []string{config.LogOutputFile}, File: appFile},
+		Audit:           &config.LogOutputConfig{Outputs: []string{config.LogOutputFile}, File: auditFile},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	slog.Info("below level")
+	slog.Warn("service warning")
+	AuditLogger().Info("audit event")
+
+	// Runtime level change applies to the service log only
+	SetLogLevel(slog.LevelDebug)
+	slog.Debug("debug after change")
+
+	app, _ := os.ReadFile(appFile)
+	auditLog, _ := os.ReadFile(auditFile)
+	if strings.Contains(string(app), "below level") || !strings.Contains(string(app), "service warning") ||
+		!strings.Contains(string(app), "debug after change") {
+		t.Errorf("unexpected service log:\n%s", app)
+	}
+	if strings.Contains(string(app), "audit event") || !strings.Contains(string(auditLog), `"msg":"audit event"`) {
+		t.Errorf("audit event not in its own destination:\nservice: %s\naudit: %s", app, auditLog)
+	}
+}
+
+// restoreLogger undoes InitLogger for the following tests
+func restoreLogger(t *testing.T) {
+	t.Helper()
+	previous := slog.Default()
+	t.Cleanup(func() {
+		slog.SetDefault(previous)
+		log.SetOutput(os.Stderr)
+		log.SetFlags(log.LstdFlags)
+		audit.SetLogger(nil)
+		SetLogLevel(slog.LevelInfo)
+	})
+}
+
 func TestSanitizeForLog(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -129,3 +198,32 @@ func TestSanitizeForLog(t *testing.T) {
 		})
 	}
 }
+
+func TestAuditEvents_IgnoreServiceLogLevel(t *testing.T) {
+	restoreLogger(t)
+	logFile := filepath.Join(t.TempDir(), "hsm-service.log")
+	err := InitLogger(&config.LoggingConfig{
+		LogOutputConfig: config.LogOutputConfig{Outputs: []string{config.LogOutputFile}, File: logFile},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The level change itself is audited at Info, after the level is raised
+	handler := LogLevelHandler([]string{"Admin"}, newTestAuditACL(t))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, createRequestWithCert("PUT", "/admin/log-level", []byte(`{"level":"error"}`), "admin-1", "Admin"))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	slog.Warn("service warning")
+	AuditLogger().Info("audit event")
+
+	data, _ := os.ReadFile(logFile)
+	if strings.Contains(string(data), "service warning") {
+		t.Errorf("service log ignores the runtime level:\n%s", data)
+	}
+	if !strings.Contains(string(data), `"operation":"admin.log_level"`) || !strings.Contains(string(data), `"msg":"audit event"`) {
+		t.Errorf("audit events filtered by the service log level:\n%s", data)
+	}
+}
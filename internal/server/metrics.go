@@ -1,6 +1,9 @@
 package server
 
 import (
+	"net"
+	"net/http"
+
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 )
@@ -77,7 +80,42 @@ var (
 		[]string{"operation"},
 	)
 
-	// Active connections gauge
+	// TLS certificate expiry (server certificate and trusted client CAs)
+	TLSCertificateExpiry = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "hsm_tls_certificate_expiry_timestamp_seconds",
+			Help: "NotAfter of the loaded TLS certificates as Unix timestamp by type (server, ca), subject CN and serial",
+		},
+		[]string{"type", "subject", "serial"},
+	)
+
+	// Client certificates issued by the mini-CA (/pki/sign-csr)
+	PKICertificatesIssuedTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "hsm_pki_certificates_issued_total",
+			Help: "Total number of client certificate requests by status (issued, rejected, error)",
+		},
+		[]string{"status"},
+	)
+
+	// revoked.yaml reloads by status (success/failure)
+	RevokedReloadsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "hsm_revoked_reloads_total",
+			Help: "Total number of revoked.yaml reloads by status",
+		},
+		[]string{"status"},
+	)
+
+	// Timestamp of the last successful revoked.yaml reload
+	RevokedLastReloadSuccess = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "hsm_revoked_last_reload_success_timestamp_seconds",
+			Help: "Unix timestamp of the last successful revoked.yaml load",
+		},
+	)
+
+	// Active connections gauge (updated from http.Server.ConnState)
 	ActiveConnections = promauto.NewGauge(
 		prometheus.GaugeOpts{
 			Name: "hsm_active_connections",
@@ -122,3 +160,29 @@ func RecordRateLimitHit(clientCN string) {
 func RecordHSMError(operation string) {
 	HSMErrorsTotal.WithLabelValues(operation).Inc()
 }
+
+// RecordRevokedReload records a revoked.yaml reload attempt
+func RecordRevokedReload(err error) {
+	if err != nil {
+		RevokedReloadsTotal.WithLabelValues("failure").Inc()
+		return
+	}
+	RevokedReloadsTotal.WithLabelValues("success").Inc()
+	RevokedLastReloadSuccess.SetToCurrentTime()
+}
+
+// trackConnState keeps ActiveConnections in sync with the connections of
+// the HTTP server (hijacked connections leave the server's accounting)
+func trackConnState(_ net.Conn, state http.ConnState) {
+	switch state {
+	case http.StateNew:
+		ActiveConnections.Inc()
+	case http.StateClosed, http.StateHijacked:
+		ActiveConnections.Dec()
+	}
+}
+
+// RecordPKIIssue records a certificate signing request
+func RecordPKIIssue(status string) {
+	PKICertificatesIssuedTotal.WithLabelValues(status).Inc()
+}
@@ -6,44 +6,48 @@ import (
 	"sync"
 	"time"
 
+	"github.com/titaev-lv/hsm-service/internal/audit"
+	"go.opentelemetry.io/otel/attribute"
 	"golang.org/x/time/rate"
 )
 
-// AuditLogMiddleware logs all requests with client information and duration
+// AuditLogMiddleware starts the audit event of every request (request ID,
+// client certificate) and emits it for handlers that do not emit their own
 func AuditLogMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
 
-		// Extract client certificate info
-		var clientCN string
-		var clientOU string
-		if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
-			cert := r.TLS.PeerCertificates[0]
-			clientCN = cert.Subject.CommonName
-			if len(cert.Subject.OrganizationalUnit) > 0 {
-				clientOU = cert.Subject.OrganizationalUnit[0]
-			}
-		}
+		ev := audit.NewRequestEvent(r)
+		w.Header().Set(audit.RequestIDHeader, ev.RequestID)
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
 
 		// Call next handler
-		next.ServeHTTP(w, r)
+		next.ServeHTTP(sw, r.WithContext(audit.WithEvent(r.Context(), ev)))
 
 		// Record request duration metric
 		duration := time.Since(start).Seconds()
 		RequestDuration.WithLabelValues(r.URL.Path).Observe(duration)
 
-		// Log audit event
-		AuditLogger().Info("request",
-			"method", r.Method,
-			"path", r.URL.Path,
-			"client_cn", clientCN,
-			"client_ou", clientOU,
-			"remote_addr", r.RemoteAddr,
-			"duration_ms", time.Since(start).Milliseconds(),
-		)
+		// Log audit event (no-op if the handler already emitted it)
+		ev.Emit(sw.status)
 	})
 }
 
+// statusWriter remembers the response status for the audit event
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
 // RecoveryMiddleware recovers from panics and logs them
 func RecoveryMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -54,6 +58,7 @@ func RecoveryMiddleware(next http.Handler) http.Handler {
 					"method", r.Method,
 					"path", r.URL.Path,
 				)
+				audit.EventFrom(r).Reason = "internal error"
 				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 			}
 		}()
@@ -158,6 +163,7 @@ func RateLimitMiddleware(limiter *RateLimiter) func(http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			// Extract client CN from certificate
 			if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+				audit.EventFrom(r).Reason = "client certificate required"
 				http.Error(w, "Unauthorized", http.StatusUnauthorized)
 				return
 			}
@@ -165,7 +171,11 @@ func RateLimitMiddleware(limiter *RateLimiter) func(http.Handler) http.Handler {
 			clientCN := r.TLS.PeerCertificates[0].Subject.CommonName
 
 			// Check rate limit
-			if !limiter.GetLimiter(clientCN).Allow() {
+			_, span := startSpan(r.Context(), "RateLimiter.Allow")
+			allowed := limiter.GetLimiter(clientCN).Allow()
+			span.SetAttributes(attribute.Bool("ratelimit.allowed", allowed))
+			span.End()
+			if !allowed {
 				slog.Warn("rate limit exceeded",
 					"client_cn", clientCN,
 					"path", r.URL.Path,
@@ -173,7 +183,7 @@ func RateLimitMiddleware(limiter *RateLimiter) func(http.Handler) http.Handler {
 				// Metrics: track rate limit hit
 				RecordRateLimitHit(clientCN)
 				w.Header().Set("Retry-After", "1")
-				respondError(w, http.StatusTooManyRequests, "rate limit exceeded")
+				respondAuditError(w, audit.EventFrom(r), http.StatusTooManyRequests, "rate limit exceeded")
 				return
 			}
 
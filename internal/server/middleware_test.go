@@ -1,9 +1,13 @@
 package server
 
 import (
+	"bytes"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/titaev-lv/hsm-service/internal/audit"
 )
 
 // Rate Limiting Tests
@@ -133,3 +137,74 @@ func TestRateLimitMiddleware_NoCert(t *testing.T) {
 		t.Errorf("Expected status 401, got %d", w.Code)
 	}
 }
+
+func TestTrackConnState(t *testing.T) {
+	before := testutil.ToFloat64(ActiveConnections)
+	trackConnState(nil, http.StateNew)
+	trackConnState(nil, http.StateActive)
+	trackConnState(nil, http.StateIdle)
+	if got := testutil.ToFloat64(ActiveConnections) - before; got != 1 {
+		t.Errorf("ActiveConnections after new connection = %v, want +1", got)
+	}
+
+	trackConnState(nil, http.StateClosed)
+	if got := testutil.ToFloat64(ActiveConnections); got != before {
+		t.Errorf("ActiveConnections after close = %v, want %v", got, before)
+	}
+}
+
+func TestMiddlewareStack_AuditsRateLimitAndPanic(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/panic", func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})
+	handler := applyMiddleware(mux, NewRateLimiter(1, 1))
+
+	tests := []struct {
+		name    string
+		path    string
+		status  int
+		outcome string
+		reason  string
+	}{
+		{"allowed", "/ok", http.StatusOK, audit.OutcomeSuccess, ""},
+		{"rate limited", "/ok", http.StatusTooManyRequests, audit.OutcomeDenied, "rate limit exceeded"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := captureLog(t)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, createRequestWithCert("GET", tt.path, nil, "audit-client", "TestOU"))
+			assertSingleAuditEvent(t, buf, w.Code, tt.status, tt.outcome, tt.reason)
+		})
+	}
+
+	t.Run("panic", func(t *testing.T) {
+		buf := captureLog(t)
+		w := httptest.NewRecorder()
+		h := applyMiddleware(mux, NewRateLimiter(10, 10))
+		h.ServeHTTP(w, createRequestWithCert("GET", "/panic", nil, "panic-client", "TestOU"))
+		assertSingleAuditEvent(t, buf, w.Code, http.StatusInternalServerError, audit.OutcomeError, "internal error")
+	})
+}
+
+func assertSingleAuditEvent(t *testing.T, buf *bytes.Buffer, code, status int, outcome, reason string) {
+	t.Helper()
+	if code != status {
+		t.Fatalf("Expected status %d, got %d", status, code)
+	}
+	events := auditEvents(t, buf)
+	if len(events) != 1 {
+		t.Fatalf("expected exactly one audit event, got %d", len(events))
+	}
+	ev := events[0]
+	if ev["status"] != float64(status) || ev["outcome"] != outcome {
+		t.Errorf("status = %v, outcome = %v; want %d, %s", ev["status"], ev["outcome"], status, outcome)
+	}
+	if got, _ := ev["reason"].(string); got != reason {
+		t.Errorf("reason = %q, want %q", got, reason)
+	}
+}
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/titaev-lv/hsm-service/internal/audit"
+	"github.com/titaev-lv/hsm-service/internal/pki"
+)
+
+type SignCSRRequest struct {
+	CSR string `json:"csr"`           // PEM
+	TTL string `json:"ttl,omitempty"` // e.g. "24h" (default: pki.default_ttl)
+}
+
+type SignCSRResponse struct {
+	Certificate   string    `json:"certificate"`    // PEM
+	CACertificate string    `json:"ca_certificate"` // PEM
+	Serial        string    `json:"serial"`
+	SPIFFEID      string    `json:"spiffe_id"`
+	NotAfter      time.Time `json:"not_after"`
+}
+
+// PKISignHandler handles /pki/sign-csr requests
+// Only clients with an OU from server.admin_ous may request certificates
+func PKISignHandler(ca *pki.CA, aclChecker *ACLChecker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ev := audit.EventFrom(r)
+		ev.Operation = audit.OpSignCSR
+
+		// Only accept POST
+		if r.Method != http.MethodPost {
+			respondAuditError(w, ev, http.StatusMethodNotAllowed, "only POST allowed")
+			return
+		}
+
+		// Limit request body size (DoS protection)
+		const maxRequestSize = 64 * 1024 // 64KB is plenty for a CSR
+		r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+
+		// 1. Extract client certificate
+		if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+			respondAuditError(w, ev, http.StatusUnauthorized, "no client certificate")
+			return
+		}
+		clientCert := r.TLS.PeerCertificates[0]
+		clientCN := clientCert.Subject.CommonName
+
+		// 2. Admin check (revocation first, like CheckAccess)
+		if aclChecker.IsCertRevoked(clientCert) {
+			RecordRevocationFailure()
+			RecordRequest("/pki/sign-csr", clientCN, "acl_denied")
+			respondAuditError(w, ev, http.StatusForbidden, "certificate revoked")
+			return
+		}
+		if len(clientCert.Subject.OrganizationalUnit) == 0 || !ca.IsAdminOU(clientCert.Subject.OrganizationalUnit[0]) {
+			slog.Warn("PKI sign request denied: not an admin OU", "client_cn", clientCN)
+			RecordACLFailure()
+			RecordRequest("/pki/sign-csr", clientCN, "acl_denied")
+			respondAuditError(w, ev, http.StatusForbidden, "access denied: insufficient permissions")
+			return
+		}
+
+		// 3. Parse request
+		var req SignCSRRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			slog.Warn("invalid JSON in request", "path", r.URL.Path, "method", r.Method)
+			respondAuditError(w, ev, http.StatusBadRequest, "invalid JSON")
+			return
+		}
+		var ttl time.Duration
+		if req.TTL != "" {
+			var err error
+			if ttl, err = time.ParseDuration(req.TTL); err != nil || ttl <= 0 {
+				respondAuditError(w, ev, http.StatusBadRequest, "invalid ttl")
+				return
+			}
+		}
+		ev.PayloadBytes = len(req.CSR)
+		csr, err := pki.ParseCSR([]byte(req.CSR))
+		if err != nil {
+			RecordPKIIssue("rejected")
+			RecordRequest("/pki/sign-csr", clientCN, "rejected")
+			respondAuditError(w, ev, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		// 4. Issue (policy check, HSM signature, registry record)
+		issued, err := ca.Issue(pki.IssueRequest{CSR: csr, TTL: ttl, IssuedBy: clientCN})
+		if err != nil {
+			if errors.Is(err, pki.ErrRejected) {
+				AuditLogger().Warn("certificate request rejected",
+					"client_cn", clientCN,
+					"subject_cn", csr.Subject.CommonName,
+					"subject_ou", csr.Subject.OrganizationalUnit,
+					"error", err,
+				)
+				RecordPKIIssue("rejected")
+				RecordRequest("/pki/sign-csr", clientCN, "rejected")
+				respondAuditError(w, ev, http.StatusBadRequest, err.Error())
+				return
+			}
+			slog.Error("certificate issue failed", "client_cn", clientCN, "error", err)
+			RecordHSMError("pki_sign")
+			RecordPKIIssue("error")
+			RecordRequest("/pki/sign-csr", clientCN, "error")
+			respondAuditError(w, ev, http.StatusInternalServerError, "certificate issue failed")
+			return
+		}
+
+		rec := issued.Record
+		ev.KeyID = ca.KeyLabel()
+		AuditLogger().Info("certificate issued",
+			"client_cn", clientCN,
+			"serial", rec.Serial,
+			"subject_cn", rec.CN,
+			"subject_ou", rec.OU,
+			"spiffe_id", rec.SPIFFEID,
+			"not_after", rec.NotAfter,
+		)
+		RecordPKIIssue("issued")
+		RecordRequest("/pki/sign-csr", clientCN, "success")
+
+		// 5. Respond
+		respondJSON(w, http.StatusOK, SignCSRResponse{
+			Certificate:   string(issued.PEM),
+			CACertificate: string(ca.CertificatePEM()),
+			Serial:        rec.Serial,
+			SPIFFEID:      rec.SPIFFEID,
+			NotAfter:      rec.NotAfter,
+		})
+		ev.Emit(http.StatusOK)
+	}
+}
@@ -0,0 +1,165 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/titaev-lv/hsm-service/internal/config"
+	"github.com/titaev-lv/hsm-service/internal/pki"
+)
+
+// newTestPKI creates a mini-CA with an in-memory key and an ACL checker
+// that has revoked-admin in revoked.yaml
+func newTestPKI(t *testing.T) (*pki.CA, *ACLChecker) {
+	t.Helper()
+	tmpDir := t.TempDir()
+
+	revokedFile := filepath.Join(tmpDir, "revoked.yaml")
+	os.WriteFile(revokedFile, []byte("revoked:\n  - cn: revoked-admin\n"), 0644)
+	aclChecker, err := NewACLChecker(&config.ACLConfig{
+		RevokedFile: revokedFile,
+		Mappings:    map[string][]string{"Trading": {"exchange-key"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { aclChecker.StopAutoReload(t.Context()) })
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	certPEM, err := pki.NewCACertificate(key, "Test Issuing CA", 365*24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPath := filepath.Join(tmpDir, "issuing-ca.crt")
+	os.WriteFile(certPath, certPEM, 0644)
+
+	registry := pki.NewRegistry(filepath.Join(tmpDir, "issued.yaml"))
+	ca, err := pki.NewCA(&config.PKIConfig{}, certPath, key, registry, aclChecker.Mappings, aclChecker.IsRevoked, []string{"Admin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ca, aclChecker
+}
+
+func newSignCSRBody(t *testing.T, cn, ou, ttl string) []byte {
+	t.Helper()
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: cn, OrganizationalUnit: []string{ou}},
+	}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := json.Marshal(SignCSRRequest{
+		CSR: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})),
+		TTL: ttl,
+	})
+	return body
+}
+
+func TestPKISignHandler(t *testing.T) {
+	ca, aclChecker := newTestPKI(t)
+	handler := PKISignHandler(ca, aclChecker)
+
+	req := createRequestWithCert("POST", "/pki/sign-csr", newSignCSRBody(t, "trading-service-2", "Trading", "12h"), "admin-1", "Admin")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp SignCSRResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.SPIFFEID != "spiffe://hsm-service.local/ou/Trading/cn/trading-service-2" {
+		t.Errorf("spiffe_id = %q", resp.SPIFFEID)
+	}
+	if resp.Serial == "" || resp.Certificate == "" || resp.CACertificate == "" {
+		t.Errorf("incomplete response: %+v", resp)
+	}
+}
+
+func TestPKISignHandler_Denied(t *testing.T) {
+	ca, aclChecker := newTestPKI(t)
+	handler := PKISignHandler(ca, aclChecker)
+
+	tests := []struct {
+		name     string
+		clientCN string
+		clientOU string
+		body     []byte
+		want     int
+	}{
+		{"non-admin client", "trading-service-1", "Trading", newSignCSRBody(t, "svc", "Trading", ""), http.StatusForbidden},
+		{"revoked admin", "revoked-admin", "Admin", newSignCSRBody(t, "svc", "Trading", ""), http.StatusForbidden},
+		{"admin OU requested", "admin-1", "Admin", newSignCSRBody(t, "admin-2", "Admin", ""), http.StatusBadRequest},
+		{"OU not in acl.mappings", "admin-1", "Admin", newSignCSRBody(t, "svc", "Marketing", ""), http.StatusBadRequest},
+		{"ttl above max_ttl", "admin-1", "Admin", newSignCSRBody(t, "svc", "Trading", "720h"), http.StatusBadRequest},
+		{"CN revoked without serial", "admin-1", "Admin", newSignCSRBody(t, "revoked-admin", "Trading", ""), http.StatusBadRequest},
+		{"invalid CSR", "admin-1", "Admin", []byte(`{"csr":"not a csr"}`), http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := createRequestWithCert("POST", "/pki/sign-csr", tt.body, tt.clientCN, tt.clientOU)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Errorf("Expected status %d, got %d: %s", tt.want, w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestPKI_RevokeThenReissue(t *testing.T) {
+	ca, aclChecker := newTestPKI(t)
+	handler := PKISignHandler(ca, aclChecker)
+
+	issue := func() *x509.Certificate {
+		t.Helper()
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, createRequestWithCert("POST", "/pki/sign-csr", newSignCSRBody(t, "trading-service-2", "Trading", ""), "admin-1", "Admin"))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+		var resp SignCSRResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		block, _ := pem.Decode([]byte(resp.Certificate))
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert
+	}
+
+	first := issue()
+	revokedFile := aclChecker.currentConfig().RevokedFile
+	if _, err := pki.AppendRevoked(revokedFile, "trading-service-2", pki.FormatSerial(first.SerialNumber), "superseded", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := aclChecker.TryReload(); err != nil {
+		t.Fatal(err)
+	}
+	if err := aclChecker.CheckAccess(t.Context(), first, "exchange-key"); err == nil {
+		t.Error("revoked certificate should be denied")
+	}
+
+	// The replacement for the same CN is issued and accepted
+	second := issue()
+	if err := aclChecker.CheckAccess(t.Context(), second, "exchange-key"); err != nil {
+		t.Errorf("reissued certificate should be accepted: %v", err)
+	}
+}
@@ -1,16 +1,17 @@
 package server
 
 import (
+	"crypto"
 	"crypto/tls"
-	"crypto/x509"
 	"fmt"
 	"net/http"
-	"os"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/titaev-lv/hsm-service/internal/audit"
 	"github.com/titaev-lv/hsm-service/internal/config"
 	"github.com/titaev-lv/hsm-service/internal/hsm"
+	"github.com/titaev-lv/hsm-service/internal/pki"
 	"golang.org/x/net/http2"
 )
 
@@ -21,30 +22,15 @@ type Server struct {
 	aclChecker  *ACLChecker
 	rateLimiter *RateLimiter
 	config      *config.ServerConfig
+	tls         *tlsMaterial
 }
 
 // NewServer creates a new HSM server with TLS and mTLS configuration
-func NewServer(cfg *config.ServerConfig, keyManager *hsm.KeyManager, aclChecker *ACLChecker, rateLimiter *RateLimiter) (*Server, error) {
-	// 1. Load server certificate
-	serverCert, err := tls.LoadX509KeyPair(
-		cfg.TLS.CertPath,
-		cfg.TLS.KeyPath,
-	)
-	if err != nil {
-		return nil, fmt.Errorf("failed to load server certificate: %w", err)
-	}
-
-	// 2. Load CA for client verification
-	caCert, err := os.ReadFile(cfg.TLS.CAPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read CA certificate: %w", err)
-	}
-	caCertPool := x509.NewCertPool()
-	if !caCertPool.AppendCertsFromPEM(caCert) {
-		return nil, fmt.Errorf("failed to parse CA certificate")
-	}
-
-	// 3. TLS Config with mTLS
+// ca enables /pki/sign-csr (nil when pki.enabled is false), auditIndex
+// enables /audit/events (nil when audit.enabled is false); /audit/events and
+// /admin/* are restricted to server.admin_ous
+func NewServer(cfg *config.ServerConfig, keyManager *hsm.KeyManager, aclChecker *ACLChecker, rateLimiter *RateLimiter, ca *pki.CA, auditIndex *audit.Index) (*Server, error) {
+	// 1. TLS Config with mTLS
 	// Security: TLS 1.3 only (no TLS 1.2 fallback)
 	// Rationale:
 	//   - TLS 1.3 removes weak algorithms (RC4, 3DES, MD5, SHA-1)
@@ -54,10 +40,8 @@ func NewServer(cfg *config.ServerConfig, keyManager *hsm.KeyManager, aclChecker
 	//   - PCI DSS 4.0 strongly recommends TLS 1.3+
 	// Trade-off: Clients MUST support TLS 1.3 (all modern clients do since 2018)
 	tlsConfig := &tls.Config{
-		Certificates: []tls.Certificate{serverCert},
-		ClientAuth:   tls.RequireAndVerifyClientCert,
-		ClientCAs:    caCertPool,
-		MinVersion:   tls.VersionTLS13, // No TLS 1.2 fallback - intentional security decision
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		MinVersion: tls.VersionTLS13, // No TLS 1.2 fallback - intentional security decision
 		CipherSuites: []uint16{
 			// TLS 1.3 cipher suites (only these two are needed)
 			// See RFC 8446 - TLS 1.3 defines only 5 cipher suites, these 2 cover 99.9% of clients
@@ -71,29 +55,52 @@ func NewServer(cfg *config.ServerConfig, keyManager *hsm.KeyManager, aclChecker
 		// - Tickets auto-expire after 24 hours
 		// Security: Client certificate is still verified on EVERY request (not just handshake)
 		SessionTicketsDisabled: false, // Enable session tickets (default in Go, but explicit for clarity)
+		// Set explicitly: configs returned by GetConfigForClient are used as-is,
+		// without the ALPN defaults net/http adds to the server config
+		NextProtos: []string{"h2", "http/1.1"},
+	}
+
+	// Certificate and client CA bundles are read from files and reloaded
+	// on change (or ReloadTLS) without dropping connections
+	// With key_label the private key stays on the token: handshakes are
+	// signed by the HSM through crypto11's crypto.Signer
+	var signer func() (crypto.Signer, error)
+	if cfg.TLS.KeyLabel != "" {
+		signer = func() (crypto.Signer, error) {
+			return keyManager.Signer(cfg.TLS.KeyLabel)
+		}
+	}
+	material, err := newTLSMaterial(cfg.TLS, tlsConfig.Clone(), signer)
+	if err != nil {
+		return nil, err
 	}
+	tlsConfig.GetCertificate = material.getCertificate
+	tlsConfig.GetConfigForClient = material.getConfigForClient
 
-	// 4. Create HTTP router
+	// 2. Create HTTP router
 	mux := http.NewServeMux()
 
 	// Register endpoints
 	mux.HandleFunc("/encrypt", EncryptHandler(keyManager, aclChecker))
 	mux.HandleFunc("/decrypt", DecryptHandler(keyManager, aclChecker))
 	mux.HandleFunc("/health", HealthHandler(keyManager))
+	adminOUs := cfg.GetAdminOUs()
+	mux.HandleFunc("/keys", KeysHandler(keyManager, adminOUs, aclChecker))
+	if ca != nil {
+		mux.HandleFunc("/pki/sign-csr", PKISignHandler(ca, aclChecker))
+	}
+	if auditIndex != nil {
+		mux.HandleFunc("/audit/events", AuditEventsHandler(auditIndex, adminOUs, aclChecker))
+	}
+	mux.HandleFunc("/admin/log-level", LogLevelHandler(adminOUs, aclChecker))
 
 	// Register Prometheus metrics endpoint (A09:2021 monitoring requirement)
 	mux.Handle("/metrics", promhttp.Handler())
 
-	// 5. Apply middleware stack (rate limit -> audit -> recovery -> request log)
-	handler := RateLimitMiddleware(rateLimiter)(
-		RecoveryMiddleware(
-			AuditLogMiddleware(
-				RequestLogMiddleware(mux),
-			),
-		),
-	)
+	// 3. Apply middleware stack
+	handler := applyMiddleware(mux, rateLimiter)
 
-	// 6. Create HTTP server
+	// 4. Create HTTP server
 	httpServer := &http.Server{
 		Addr:      ":" + cfg.Port,
 		Handler:   handler,
@@ -104,9 +111,10 @@ func NewServer(cfg *config.ServerConfig, keyManager *hsm.KeyManager, aclChecker
 		IdleTimeout:       60 * time.Second,
 		ReadHeaderTimeout: 5 * time.Second,
 		MaxHeaderBytes:    1 << 20, // 1 MB
+		ConnState:         trackConnState,
 	}
 
-	// 7. Configure HTTP/2 for maximum throughput (if enabled)
+	// 5. Configure HTTP/2 for maximum throughput (if enabled)
 	// Rationale: Default HTTP/2 settings (MaxConcurrentStreams=250) bottleneck high-load scenarios
 	// On dedicated HSM machines we can use aggressive settings to maximize CPU/RAM utilization
 	if cfg.HTTP2 != nil {
@@ -129,12 +137,15 @@ func NewServer(cfg *config.ServerConfig, keyManager *hsm.KeyManager, aclChecker
 		}
 	}
 
+	material.StartAutoReload()
+
 	return &Server{
 		httpServer:  httpServer,
 		keyManager:  keyManager,
 		aclChecker:  aclChecker,
 		rateLimiter: rateLimiter,
 		config:      cfg,
+		tls:         material,
 	}, nil
 }
 
@@ -144,8 +155,30 @@ func (s *Server) Start() error {
 	return s.httpServer.ListenAndServeTLS("", "")
 }
 
+// ReloadTLS re-reads the server certificate, key and client CA bundles.
+// New handshakes use the new files; on error the old ones stay active.
+func (s *Server) ReloadTLS() error {
+	return s.tls.Reload()
+}
+
 // Shutdown gracefully shuts down the server
 func (s *Server) Shutdown() error {
 	// KeyManager closing is handled in main.go shutdown sequence
+	s.tls.StopAutoReload()
 	return nil
 }
+
+// applyMiddleware wraps the handler in the middleware stack
+// (tracing -> audit -> rate limit -> recovery -> request log): audit sits
+// outside rate limiting and recovery so 429s and panics are audited too
+func applyMiddleware(next http.Handler, rateLimiter *RateLimiter) http.Handler {
+	return TracingMiddleware(
+		AuditLogMiddleware(
+			RateLimitMiddleware(rateLimiter)(
+				RecoveryMiddleware(
+					RequestLogMiddleware(next),
+				),
+			),
+		),
+	)
+}
@@ -0,0 +1,320 @@
+package server
+
+import (
+	"context"
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/titaev-lv/hsm-service/internal/config"
+)
+
+const (
+	// tlsReloadInterval is the polling fallback when inotify events are unavailable
+	tlsReloadInterval = 30 * time.Second
+
+	// tlsExpiryCheckInterval repeats expiry warnings for unchanged certificates
+	tlsExpiryCheckInterval = 24 * time.Hour
+)
+
+// tlsCertInfo describes a loaded certificate for expiry checks
+type tlsCertInfo struct {
+	kind     string // server or ca
+	path     string
+	subject  string
+	serial   string
+	notAfter time.Time
+}
+
+// tlsMaterial holds the server certificate and client CA pool read from the
+// server.tls files. Reload swaps them without restarting the listener: new
+// handshakes use the new material, established connections are unaffected.
+type tlsMaterial struct {
+	paths  config.TLSConfig
+	base   *tls.Config                   // Protocol settings shared by every handshake
+	signer func() (crypto.Signer, error) // HSM key pair (server.tls.key_label); nil = key_path
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	current *tls.Config // base + certificate + client CAs
+	certs   []tlsCertInfo
+
+	stopWatch context.CancelFunc
+	watchWg   sync.WaitGroup
+}
+
+// newTLSMaterial loads the TLS files once; base must not be modified afterwards
+func newTLSMaterial(paths config.TLSConfig, base *tls.Config, signer func() (crypto.Signer, error)) (*tlsMaterial, error) {
+	m := &tlsMaterial{paths: paths, base: base, signer: signer}
+	if err := m.Reload(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Reload re-reads the certificate, key and all CA bundles. On error the
+// previous material stays in use.
+func (m *tlsMaterial) Reload() error {
+	cert, err := m.loadKeyPair()
+	if err != nil {
+		return fmt.Errorf("failed to load server certificate: %w", err)
+	}
+	leaf := cert.Leaf
+	if leaf == nil {
+		if leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return fmt.Errorf("failed to parse server certificate: %w", err)
+		}
+	}
+	certs := []tlsCertInfo{newTLSCertInfo("server", m.paths.CertPath, leaf)}
+
+	// All bundles are trusted at once, so clients of the old and the new CA
+	// are both accepted during CA rotation
+	caCertPool := x509.NewCertPool()
+	for _, path := range m.paths.CABundlePaths() {
+		caCerts, err := readCABundle(path)
+		if err != nil {
+			return err
+		}
+		for _, caCert := range caCerts {
+			caCertPool.AddCert(caCert)
+			certs = append(certs, newTLSCertInfo("ca", path, caCert))
+		}
+	}
+
+	// One config per reload (not per handshake). It sets no session ticket
+	// keys, so crypto/tls uses the server config's automatically rotated
+	// keys and tickets stay valid across reloads; a resumed session is
+	// still checked against the current client CA pool
+	current := m.base.Clone()
+	current.Certificates = []tls.Certificate{cert}
+	current.ClientCAs = caCertPool
+	current.GetCertificate = nil
+	current.GetConfigForClient = nil
+
+	m.mu.Lock()
+	m.cert = &cert
+	m.current = current
+	m.certs = certs
+	m.mu.Unlock()
+
+	TLSCertificateExpiry.Reset()
+	for _, c := range certs {
+		TLSCertificateExpiry.WithLabelValues(c.kind, c.subject, c.serial).Set(float64(c.notAfter.Unix()))
+	}
+
+	slog.Info("TLS material loaded",
+		"cert", m.paths.CertPath,
+		"cert_not_after", leaf.NotAfter,
+		"ca_bundles", m.paths.CABundlePaths(),
+		"ca_certs", len(certs)-1)
+	m.checkExpiry(time.Now())
+	return nil
+}
+
+// loadKeyPair loads the server certificate with its private key from
+// key_path, or with the HSM signer when key_label is configured
+func (m *tlsMaterial) loadKeyPair() (tls.Certificate, error) {
+	if m.signer == nil {
+		return tls.LoadX509KeyPair(m.paths.CertPath, m.paths.KeyPath)
+	}
+
+	certPEM, err := os.ReadFile(m.paths.CertPath)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	var cert tls.Certificate
+	for {
+		var block *pem.Block
+		block, certPEM = pem.Decode(certPEM)
+		if block == nil {
+			break
+		}
+		if block.Type == "CERTIFICATE" {
+			cert.Certificate = append(cert.Certificate, block.Bytes)
+		}
+	}
+	if len(cert.Certificate) == 0 {
+		return tls.Certificate{}, fmt.Errorf("no certificate found in %s", m.paths.CertPath)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	signer, err := m.signer()
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	// Same check as tls.X509KeyPair: a certificate for another key would
+	// only fail later, in every handshake
+	public, ok := signer.Public().(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !public.Equal(leaf.PublicKey) {
+		return tls.Certificate{}, fmt.Errorf("HSM key pair %s does not match certificate %s", m.paths.KeyLabel, m.paths.CertPath)
+	}
+
+	cert.PrivateKey = signer
+	cert.Leaf = leaf
+	return cert, nil
+}
+
+// StartAutoReload reloads the material whenever the certificate, key or a
+// CA bundle changes, and repeats expiry warnings daily
+func (m *tlsMaterial) StartAutoReload() {
+	ctx, cancel := context.WithCancel(context.Background())
+	m.stopWatch = cancel
+
+	// Fan in the watches of all files; one pending reload is enough
+	trigger := make(chan string, 1)
+	paths := []string{m.paths.CertPath}
+	if m.signer == nil {
+		paths = append(paths, m.paths.KeyPath)
+	}
+	paths = append(paths, m.paths.CABundlePaths()...)
+	for _, path := range paths {
+		since, err := config.FileRevision(path)
+		if err != nil {
+			slog.Warn("TLS file revision check failed", "path", path, "error", err)
+		}
+		changes := config.WatchFile(ctx, path, since, tlsReloadInterval)
+
+		m.watchWg.Add(1)
+		go func(path string) {
+			defer m.watchWg.Done()
+			for range changes {
+				select {
+				case trigger <- path:
+				default:
+				}
+			}
+		}(path)
+	}
+
+	m.watchWg.Add(1)
+	go func() {
+		defer m.watchWg.Done()
+
+		ticker := time.NewTicker(tlsExpiryCheckInterval)
+		defer ticker.Stop()
+
+		slog.Info("started TLS certificate auto-reload", "files", paths)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case path := <-trigger:
+				// Certificate and key are usually replaced one after the
+				// other: the first event may see a mismatched pair, the
+				// second one loads it
+				if err := m.Reload(); err != nil {
+					slog.Warn("TLS auto-reload failed, keeping previous certificates",
+						"changed", path, "error", err)
+				}
+			case now := <-ticker.C:
+				m.checkExpiry(now)
+			}
+		}
+	}()
+}
+
+// StopAutoReload stops the file watches
+func (m *tlsMaterial) StopAutoReload() {
+	if m.stopWatch != nil {
+		m.stopWatch()
+	}
+	m.watchWg.Wait()
+}
+
+// expiring returns the loaded certificates that expire within the warning threshold
+func (m *tlsMaterial) expiring(now time.Time) []tlsCertInfo {
+	threshold := time.Duration(m.paths.GetExpiryWarningDays()) * 24 * time.Hour
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var result []tlsCertInfo
+	for _, c := range m.certs {
+		if c.notAfter.Sub(now) < threshold {
+			result = append(result, c)
+		}
+	}
+	return result
+}
+
+// checkExpiry logs expired and soon-to-expire certificates
+func (m *tlsMaterial) checkExpiry(now time.Time) {
+	for _, c := range m.expiring(now) {
+		attrs := []any{
+			"type", c.kind,
+			"subject", c.subject,
+			"serial", c.serial,
+			"path", c.path,
+			"not_after", c.notAfter,
+		}
+		if !now.Before(c.notAfter) {
+			slog.Error("TLS certificate expired", attrs...)
+			continue
+		}
+		slog.Warn("TLS certificate expires soon",
+			append(attrs, "days_left", int(c.notAfter.Sub(now).Hours()/24))...)
+	}
+}
+
+// getCertificate implements tls.Config.GetCertificate
+func (m *tlsMaterial) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.cert, nil
+}
+
+// getConfigForClient implements tls.Config.GetConfigForClient, so client
+// certificates are verified against the current CA pool
+func (m *tlsMaterial) getConfigForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.current, nil
+}
+
+// readCABundle parses every certificate of a PEM bundle
+func readCABundle(path string) ([]*x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA certificate: %w", err)
+	}
+
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse CA certificate %s: %w", path, err)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("failed to parse CA certificate %s: no certificates found", path)
+	}
+	return certs, nil
+}
+
+func newTLSCertInfo(kind, path string, cert *x509.Certificate) tlsCertInfo {
+	return tlsCertInfo{
+		kind:     kind,
+		path:     path,
+		subject:  cert.Subject.CommonName,
+		serial:   fmt.Sprintf("%X", cert.SerialNumber),
+		notAfter: cert.NotAfter,
+	}
+}
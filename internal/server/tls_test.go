@@ -0,0 +1,220 @@
+package server
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/titaev-lv/hsm-service/internal/config"
+)
+
+// newTestCertPEM returns a self-signed certificate (usable as CA) and its key
+func newTestCertPEM(t *testing.T, cn string, serial int64, validFor time.Duration) (certPEM, keyPEM []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(validFor),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+// writeTestKeyPair writes a self-signed server certificate that is also the CA
+func writeTestKeyPair(t *testing.T, paths config.TLSConfig, cn string) {
+	t.Helper()
+	certPEM, keyPEM := newTestCertPEM(t, cn, time.Now().UnixNano(), 365*24*time.Hour)
+	writeTestFile(t, paths.KeyPath, keyPEM)
+	writeTestFile(t, paths.CertPath, certPEM)
+	writeTestFile(t, paths.CAPath, certPEM)
+}
+
+func writeTestFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	if err := config.WriteFileAtomic(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testTLSPaths(t *testing.T) config.TLSConfig {
+	dir := t.TempDir()
+	return config.TLSConfig{
+		CertPath: filepath.Join(dir, "server.crt"),
+		KeyPath:  filepath.Join(dir, "server.key"),
+		CAPath:   filepath.Join(dir, "ca.crt"),
+	}
+}
+
+func servedCN(t *testing.T, m *tlsMaterial) string {
+	t.Helper()
+	cert, err := m.getCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leaf.Subject.CommonName
+}
+
+func TestTLSMaterial_Reload(t *testing.T) {
+	paths := testTLSPaths(t)
+	writeTestKeyPair(t, paths, "old.example.com")
+
+	m, err := newTLSMaterial(paths, &tls.Config{MinVersion: tls.VersionTLS13}, nil)
+	if err != nil {
+		t.Fatalf("newTLSMaterial failed: %v", err)
+	}
+	if cn := servedCN(t, m); cn != "old.example.com" {
+		t.Fatalf("CN = %s, want old.example.com", cn)
+	}
+
+	writeTestKeyPair(t, paths, "new.example.com")
+	if err := m.Reload(); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	if cn := servedCN(t, m); cn != "new.example.com" {
+		t.Errorf("CN after reload = %s, want new.example.com", cn)
+	}
+	cfg, _ := m.getConfigForClient(nil)
+	if cfg.MinVersion != tls.VersionTLS13 || len(cfg.Certificates) != 1 {
+		t.Error("per-client config must keep base settings and carry the certificate")
+	}
+
+	// A broken file must not replace working material
+	writeTestFile(t, paths.CAPath, []byte("not a certificate"))
+	if err := m.Reload(); err == nil {
+		t.Error("Reload should fail on invalid CA bundle")
+	}
+	if cn := servedCN(t, m); cn != "new.example.com" {
+		t.Errorf("CN after failed reload = %s, want new.example.com", cn)
+	}
+}
+
+func TestTLSMaterial_MultipleCABundles(t *testing.T) {
+	paths := testTLSPaths(t)
+	writeTestKeyPair(t, paths, "hsm-service.local")
+
+	// New CA bundle during CA rotation: two certificates in one file
+	oldCA, _ := newTestCertPEM(t, "Old Root CA", 1001, 24*time.Hour)
+	newCA, _ := newTestCertPEM(t, "New Root CA", 1002, 3*365*24*time.Hour)
+	bundle := filepath.Join(filepath.Dir(paths.CAPath), "ca-rotation.crt")
+	writeTestFile(t, bundle, append(oldCA, newCA...))
+	paths.CAPaths = []string{bundle, paths.CAPath}
+
+	m, err := newTLSMaterial(paths, &tls.Config{}, nil)
+	if err != nil {
+		t.Fatalf("newTLSMaterial failed: %v", err)
+	}
+
+	cfg, _ := m.getConfigForClient(nil)
+	for _, caPEM := range [][]byte{oldCA, newCA} {
+		block, _ := pem.Decode(caPEM)
+		caCert, _ := x509.ParseCertificate(block.Bytes)
+		if _, err := caCert.Verify(x509.VerifyOptions{Roots: cfg.ClientCAs}); err != nil {
+			t.Errorf("%s is not trusted: %v", caCert.Subject.CommonName, err)
+		}
+	}
+
+	newCAExpiry := testutil.ToFloat64(TLSCertificateExpiry.WithLabelValues("ca", "New Root CA", "3EA"))
+	if newCAExpiry == 0 {
+		t.Error("expiry gauge not set for New Root CA")
+	}
+
+	// Default threshold is 30 days: only the old CA is reported
+	expiring := m.expiring(time.Now())
+	if len(expiring) != 1 || expiring[0].subject != "Old Root CA" {
+		t.Errorf("expiring = %+v, want only Old Root CA", expiring)
+	}
+	m.paths.ExpiryWarningDays = 2000
+	if got := len(m.expiring(time.Now())); got != 4 {
+		t.Errorf("expiring with 2000 day threshold = %d, want all 4 certificates", got)
+	}
+}
+
+func TestTLSMaterial_AutoReload(t *testing.T) {
+	paths := testTLSPaths(t)
+	writeTestKeyPair(t, paths, "old.example.com")
+
+	m, err := newTLSMaterial(paths, &tls.Config{}, nil)
+	if err != nil {
+		t.Fatalf("newTLSMaterial failed: %v", err)
+	}
+	m.StartAutoReload()
+	defer m.StopAutoReload()
+
+	// issue-server-cert.sh style renewal: key first, then certificate
+	writeTestKeyPair(t, paths, "renewed.example.com")
+
+	deadline := time.Now().Add(3 * time.Second)
+	for servedCN(t, m) != "renewed.example.com" {
+		if time.Now().After(deadline) {
+			t.Fatal("renewed certificate was not picked up")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestTLSMaterial_Signer(t *testing.T) {
+	paths := testTLSPaths(t)
+	writeTestKeyPair(t, paths, "hsm-service.local")
+	keyPEM, err := os.ReadFile(paths.KeyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(keyPEM)
+	key, err := x509.ParseECPrivateKey(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// key_label: the key file is not read, the signer stands in for the HSM
+	paths.KeyPath = ""
+	paths.KeyLabel = "tls-hsm-service"
+	signer := crypto.Signer(key)
+	m, err := newTLSMaterial(paths, &tls.Config{}, func() (crypto.Signer, error) { return signer, nil })
+	if err != nil {
+		t.Fatalf("newTLSMaterial failed: %v", err)
+	}
+	cert, _ := m.getCertificate(nil)
+	if cert.PrivateKey != signer {
+		t.Error("certificate must use the HSM signer as private key")
+	}
+
+	// Certificate renewed for a different key: keep serving the old pair
+	other, _ := newTestCertPEM(t, "other.example.com", 42, time.Hour)
+	writeTestFile(t, paths.CertPath, other)
+	if err := m.Reload(); err == nil {
+		t.Error("Reload should fail when the certificate does not match the HSM key")
+	}
+	if cn := servedCN(t, m); cn != "hsm-service.local" {
+		t.Errorf("CN after failed reload = %s, want hsm-service.local", cn)
+	}
+}
@@ -0,0 +1,165 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/titaev-lv/hsm-service/internal/config"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/credentials"
+)
+
+// tracer creates the spans of the HTTP layer (a no-op until InitTracing)
+var tracer = otel.Tracer("github.com/titaev-lv/hsm-service/internal/server")
+
+// InitTracing installs the global tracer provider exporting over OTLP and
+// the W3C trace context propagator. The returned function flushes pending
+// spans and stops the exporter. With tracing disabled it only installs the
+// propagator, so trace context still reaches the audit log.
+func InitTracing(ctx context.Context, cfg *config.TracingConfig) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	if !cfg.Enabled {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := newTraceExporter(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		attribute.String("service.name", cfg.GetServiceName()),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("failed to build trace resource: %w", err)
+	}
+
+	sampler := sdktrace.TraceIDRatioBased(cfg.GetSampleRatio())
+	if cfg.GetParentBased() {
+		sampler = sdktrace.ParentBased(sampler)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sampler),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// newTraceExporter creates the OTLP/gRPC or OTLP/HTTP exporter; the
+// connection is established lazily, an unreachable collector does not
+// prevent startup
+func newTraceExporter(ctx context.Context, cfg *config.TracingConfig) (*otlptrace.Exporter, error) {
+	var tlsConfig *tls.Config
+	if !cfg.Insecure {
+		tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+		if cfg.CAPath != "" {
+			pem, err := os.ReadFile(cfg.CAPath)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read CA: %w", err)
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificates in %s", cfg.CAPath)
+			}
+			tlsConfig.RootCAs = pool
+		}
+	}
+
+	if cfg.GetProtocol() == config.TracingProtocolHTTP {
+		opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(cfg.GetEndpoint())}
+		if tlsConfig == nil {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		} else {
+			opts = append(opts, otlptracehttp.WithTLSClientConfig(tlsConfig))
+		}
+		if len(cfg.Headers) > 0 {
+			opts = append(opts, otlptracehttp.WithHeaders(cfg.Headers))
+		}
+		return otlptracehttp.New(ctx, opts...)
+	}
+
+	opts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(cfg.GetEndpoint())}
+	if tlsConfig == nil {
+		opts = append(opts, otlptracegrpc.WithInsecure())
+	} else {
+		opts = append(opts, otlptracegrpc.WithTLSCredentials(credentials.NewTLS(tlsConfig)))
+	}
+	if len(cfg.Headers) > 0 {
+		opts = append(opts, otlptracegrpc.WithHeaders(cfg.Headers))
+	}
+	return otlptracegrpc.New(ctx, opts...)
+}
+
+// TracingMiddleware starts the server span of each request, continuing the
+// caller's trace from the traceparent header. It runs first, so the span
+// covers the rate limiter and the audit middleware too (the TLS handshake
+// happens before the request and is not part of it).
+func TracingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, r.Method+" "+spanRoute(r.URL.Path),
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", r.Method),
+				attribute.String("url.path", r.URL.Path),
+				attribute.String("client.address", r.RemoteAddr),
+			),
+		)
+		defer span.End()
+
+		if r.TLS != nil {
+			span.SetAttributes(attribute.Bool("tls.resumed", r.TLS.DidResume))
+			if len(r.TLS.PeerCertificates) > 0 {
+				span.SetAttributes(attribute.String("client.cn", r.TLS.PeerCertificates[0].Subject.CommonName))
+			}
+		}
+
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r.WithContext(ctx))
+
+		span.SetAttributes(attribute.Int("http.response.status_code", sw.status))
+		if sw.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(sw.status))
+		}
+	})
+}
+
+// spanRoute returns the path for span names: registered endpoints only, so
+// arbitrary paths of 404 requests do not create new span names
+func spanRoute(path string) string {
+	switch path {
+	case "/encrypt", "/decrypt", "/health", "/keys", "/metrics", "/pki/sign-csr", "/audit/events", "/admin/log-level":
+		return path
+	}
+	return "unknown"
+}
+
+// startSpan starts an internal span of a request phase
+func startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// endSpan ends span, recording err (nil = success)
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
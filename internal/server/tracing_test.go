@@ -0,0 +1,123 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/titaev-lv/hsm-service/internal/config"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+var (
+	testTracerOnce     sync.Once
+	testTracerProvider *sdktrace.TracerProvider
+)
+
+// recordSpans installs an always-sampling tracer provider (once: the global
+// provider delegates only once) and records the spans ended during the test
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	testTracerOnce.Do(func() {
+		testTracerProvider = sdktrace.NewTracerProvider(sdktrace.WithSampler(sdktrace.AlwaysSample()))
+		otel.SetTracerProvider(testTracerProvider)
+		otel.SetTextMapPropagator(propagation.TraceContext{})
+	})
+	recorder := tracetest.NewSpanRecorder()
+	testTracerProvider.RegisterSpanProcessor(recorder)
+	t.Cleanup(func() { testTracerProvider.UnregisterSpanProcessor(recorder) })
+	return recorder
+}
+
+func TestTracingMiddleware_EncryptSpans(t *testing.T) {
+	recorder := recordSpans(t)
+	logs := captureLog(t)
+
+	revokedFile := filepath.Join(t.TempDir(), "revoked.yaml")
+	os.WriteFile(revokedFile, []byte("revoked: []"), 0644)
+	aclChecker, _ := NewACLChecker(&config.ACLConfig{
+		RevokedFile: revokedFile,
+		Mappings:    map[string][]string{"Trading": {"exchange-key"}},
+	})
+	handler := TracingMiddleware(AuditLogMiddleware(EncryptHandler(createMockKeyManager(), aclChecker)))
+
+	body, _ := json.Marshal(EncryptRequest{Context: "exchange-key", Plaintext: base64.StdEncoding.EncodeToString([]byte("data"))})
+	req := createRequestWithCert("POST", "/encrypt", body, "trading-service-1", "Trading")
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, s := range recorder.Ended() {
+		spans[s.Name()] = s
+		if s.SpanContext().TraceID().String() != traceID {
+			t.Errorf("span %s not in the caller's trace: %s", s.Name(), s.SpanContext().TraceID())
+		}
+	}
+	for _, name := range []string{"POST /encrypt", "EncryptHandler", "json.decode", "ACLChecker.CheckAccess", "json.encode"} {
+		if _, ok := spans[name]; !ok {
+			t.Errorf("missing span %q (got %v)", name, recorder.Ended())
+		}
+	}
+	if root := spans["POST /encrypt"]; root != nil && root.Parent().SpanID().String() != "00f067aa0ba902b7" {
+		t.Errorf("server span parent = %s, want the traceparent span", root.Parent().SpanID())
+	}
+	if acl := spans["ACLChecker.CheckAccess"]; acl != nil && acl.Parent().SpanID() != spans["EncryptHandler"].SpanContext().SpanID() {
+		t.Error("ACL span is not a child of the handler span")
+	}
+
+	events := auditEvents(t, logs)
+	if len(events) != 1 || events[0]["trace_id"] != traceID {
+		t.Errorf("audit event without trace_id: %v", events)
+	}
+}
+
+func TestTracingMiddleware_ACLDeniedSpan(t *testing.T) {
+	recorder := recordSpans(t)
+	captureLog(t)
+
+	revokedFile := filepath.Join(t.TempDir(), "revoked.yaml")
+	os.WriteFile(revokedFile, []byte("revoked: []"), 0644)
+	aclChecker, _ := NewACLChecker(&config.ACLConfig{RevokedFile: revokedFile})
+	handler := TracingMiddleware(EncryptHandler(createMockKeyManager(), aclChecker))
+
+	body, _ := json.Marshal(EncryptRequest{Context: "exchange-key", Plaintext: "ZGF0YQ=="})
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, createRequestWithCert("POST", "/encrypt", body, "unknown-service", "Unknown"))
+
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("Expected status 403, got %d", w.Code)
+	}
+	for _, s := range recorder.Ended() {
+		if s.Name() == "ACLChecker.CheckAccess" {
+			if s.Status().Code != codes.Error {
+				t.Errorf("ACL span status = %v, want Error", s.Status())
+			}
+			return
+		}
+	}
+	t.Error("no ACL span recorded")
+}
+
+func TestSpanRoute(t *testing.T) {
+	if got := spanRoute("/encrypt"); got != "/encrypt" {
+		t.Errorf("spanRoute(/encrypt) = %s", got)
+	}
+	if got := spanRoute("/random/path/12345"); got != "unknown" {
+		t.Errorf("spanRoute(unregistered) = %s, want unknown", got)
+	}
+}
@@ -2,55 +2,51 @@ package main
 
 import (
 	"context"
-	"io"
 	"log"
-	"log/slog"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
+	"github.com/titaev-lv/hsm-service/internal/audit"
 	"github.com/titaev-lv/hsm-service/internal/config"
 	"github.com/titaev-lv/hsm-service/internal/hsm"
+	"github.com/titaev-lv/hsm-service/internal/pki"
 	"github.com/titaev-lv/hsm-service/internal/server"
-	"gopkg.in/natefinch/lumberjack.v2"
 )
 
 func main() {
-	// 0. Setup log rotation (A09:2021 security requirement)
-	logWriter := &lumberjack.Logger{
-		Filename:   "/var/log/hsm-service/hsm-service.log",
-		MaxSize:    100, // MB
-		MaxBackups: 10,  // Keep 10 old log files
-		MaxAge:     30,  // Keep logs for 30 days
-		Compress:   true,
-	}
-
-	// Use both file and stdout for logs
-	multiWriter := io.MultiWriter(os.Stdout, logWriter)
-	logger := slog.New(slog.NewJSONHandler(multiWriter, &slog.HandlerOptions{
-		Level: slog.LevelInfo,
-	}))
-	slog.SetDefault(logger)
-
-	// Also configure standard log package for compatibility
-	log.SetOutput(multiWriter)
-
-	// 1. Load configuration
+	// 1. Load configuration (until logging is set up, errors go to stderr)
 	configPath := getConfigPath()
 	cfg, err := config.LoadConfig(configPath)
 	if err != nil {
 		log.Fatalf("Failed to load config from %s: %v", configPath, err)
 	}
 
-	// 2. Load metadata
-	metadataPath := cfg.HSM.MetadataFile
-	if metadataPath == "" {
-		metadataPath = "metadata.yaml" // Default fallback
+	// 1a. Setup logging from logging.* (HSM_LOG_LEVEL, HSM_LOG_FORMAT):
+	// level, format, destinations and log rotation (A09:2021 security requirement)
+	if err := server.InitLogger(&cfg.Logging); err != nil {
+		log.Fatalf("Failed to initialize logging: %v", err)
 	}
-	metadata, err := config.LoadMetadata(metadataPath)
+	log.Printf("✓ Logging: level %s, format %s, outputs %v", server.LogLevel(), cfg.Logging.GetFormat(), cfg.Logging.GetOutputs())
+
+	// 1b. OpenTelemetry tracing (tracing.*): spans exported over OTLP
+	shutdownTracing, err := server.InitTracing(context.Background(), &cfg.Tracing)
+	if err != nil {
+		log.Fatalf("Failed to initialize tracing: %v", err)
+	}
+	if cfg.Tracing.Enabled {
+		log.Printf("✓ Tracing: OTLP/%s to %s (sample ratio %g)", cfg.Tracing.GetProtocol(), cfg.Tracing.GetEndpoint(), cfg.Tracing.GetSampleRatio())
+	}
+
+	// 2. Load metadata (hsm.metadata_store: metadata.yaml by default)
+	metadataStore, err := config.OpenMetadataStore(&cfg.HSM)
+	if err != nil {
+		log.Fatalf("Failed to open metadata store: %v", err)
+	}
+	metadata, _, err := metadataStore.Load()
 	if err != nil {
-		log.Fatalf("Failed to load metadata: %v", err)
+		log.Fatalf("Failed to load metadata from %s: %v", metadataStore, err)
 	}
 
 	// 3. Get HSM PIN from environment variable
@@ -67,21 +63,65 @@ func main() {
 	// Note: Close HSM context manually in shutdown handler to avoid panic
 
 	// 4a. Create KeyManager with hot reload capability
-	keyManager, err := hsm.NewKeyManager(hsmCtx.GetContext(), cfg, metadata)
+	keyManager, err := hsm.NewKeyManager(hsmCtx.GetContext(), cfg, metadataStore)
 	if err != nil {
 		log.Fatalf("Failed to create key manager: %v", err)
 	}
 
-	// 4b. Start auto-reload for metadata.yaml (30 seconds interval)
+	// 4b. Open the tamper-evident audit log (PCI DSS 10.5): hash-chained
+	// records, checkpoints signed with the HSM audit key
+	var auditSink *audit.Sink
+	var auditIndex *audit.Index
+	if cfg.Audit.Enabled {
+		auditMAC, err := hsm.NewHMAC(hsmCtx.GetContext(), cfg.Audit.GetKeyLabel())
+		if err != nil {
+			log.Fatalf("Failed to load audit checkpoint key (run 'hsm-admin audit init-key'): %v", err)
+		}
+		auditSink, err = audit.OpenSink(&cfg.Audit, auditMAC)
+		if err != nil {
+			log.Fatalf("Failed to open audit log %s: %v", cfg.Audit.GetFile(), err)
+		}
+		// Query index (hsm-admin audit query, /audit/events): catch up with
+		// records written while the service was down, then fed by the sink
+		auditIndex = audit.NewIndex(cfg.Audit.GetIndexFile())
+		if n, err := auditIndex.Sync(cfg.Audit.GetFile()); err != nil {
+			log.Printf("⚠️  Warning: audit index sync failed (queries may miss records): %v", err)
+		} else if n > 0 {
+			log.Printf("✓ Audit index: %d records indexed from %s", n, cfg.Audit.GetFile())
+		}
+		auditSink.SetIndex(auditIndex)
+		auditIndex.Start(time.Second)
+		if err := auditSink.StartCheckpoints(cfg.Audit.GetCheckpointInterval()); err != nil {
+			log.Fatalf("Failed to write audit checkpoint: %v", err)
+		}
+		audit.SetSink(auditSink)
+		log.Printf("✓ Audit log: %s (signed checkpoints every %s)", cfg.Audit.GetFile(), cfg.Audit.GetCheckpointInterval())
+	}
+
+	// 4c. SIEM outputs (logging.audit_outputs): audit events forwarded over
+	// syslog, buffered while a collector is down
+	var auditOutputs []*audit.Output
+	for i := range cfg.Logging.AuditOutputs {
+		out, err := audit.NewOutput(&cfg.Logging.AuditOutputs[i])
+		if err != nil {
+			log.Fatalf("Failed to create audit output: %v", err)
+		}
+		auditOutputs = append(auditOutputs, out)
+		log.Printf("✓ Audit output %s: %s over %s to %s", out.Name(), cfg.Logging.AuditOutputs[i].Format,
+			cfg.Logging.AuditOutputs[i].GetNetwork(), cfg.Logging.AuditOutputs[i].Address)
+	}
+	audit.SetOutputs(auditOutputs)
+
+	// 4d. Start auto-reload from the metadata store (30 seconds interval)
 	keyManager.StartAutoReload(30 * time.Second)
 	log.Println("✓ Started metadata hot reload (30s interval)")
 
-	// 4c. Auto-cleanup old key versions (PCI DSS compliance)
+	// 4e. Auto-cleanup old key versions (PCI DSS compliance)
 	if err := performAutoCleanup(&cfg.HSM, metadata); err != nil {
 		log.Printf("⚠️  Warning: auto-cleanup failed: %v", err)
 	}
 
-	// 4d. Check for keys needing rotation
+	// 4f. Check for keys needing rotation
 	keysNeedingRotation := keyManager.GetKeysNeedingRotation()
 	if len(keysNeedingRotation) > 0 {
 		log.Printf("⚠️  WARNING: The following keys need rotation:")
@@ -93,6 +133,14 @@ func main() {
 		log.Printf("⚠️  Run 'hsm-admin rotate <label>' to rotate keys")
 	}
 
+	// 4g. Start automatic rotation scheduler (opt-in)
+	var rotationScheduler *hsm.RotationScheduler
+	if cfg.HSM.AutoRotation.Enabled {
+		rotationScheduler = hsm.NewRotationScheduler(keyManager, &cfg.HSM.AutoRotation)
+		rotationScheduler.Start()
+		log.Printf("✓ Started key rotation scheduler (%s interval)", cfg.HSM.AutoRotation.GetCheckInterval())
+	}
+
 	// 5. Initialize ACL checker
 	aclChecker, err := server.NewACLChecker(&cfg.ACL)
 	if err != nil {
@@ -105,15 +153,35 @@ func main() {
 		cfg.RateLimit.Burst,
 	)
 
+	// 5a. Load the mini-CA (pki.enabled): CA key pair on the token,
+	// OUs checked against the current acl.mappings
+	var ca *pki.CA
+	if cfg.PKI.Enabled {
+		caSigner, err := keyManager.Signer(cfg.PKI.GetCAKeyLabel())
+		if err != nil {
+			log.Fatalf("Failed to load PKI CA key: %v", err)
+		}
+		registry := pki.NewRegistry(cfg.PKI.IssuedFilePath(&cfg.ACL))
+		ca, err = pki.NewCA(&cfg.PKI, cfg.PKI.CACertFilePath(&cfg.ACL), caSigner, registry, aclChecker.Mappings, aclChecker.IsRevoked, cfg.Server.GetAdminOUs())
+		if err != nil {
+			log.Fatalf("Failed to load PKI CA: %v", err)
+		}
+		log.Printf("✓ Enabled /pki/sign-csr (CA %s, expires %s)",
+			ca.Certificate().Subject.CommonName, ca.Certificate().NotAfter.Format("2006-01-02"))
+	}
+
 	// 6. Create server with all components
-	srv, err := server.NewServer(&cfg.Server, keyManager, aclChecker, rateLimiter)
+	srv, err := server.NewServer(&cfg.Server, keyManager, aclChecker, rateLimiter, ca, auditIndex)
 	if err != nil {
 		log.Fatalf("Failed to create server: %v", err)
 	}
+	log.Printf("✓ Admin API (/admin/*, /audit/events, /pki/sign-csr) restricted to OUs %v", cfg.Server.GetAdminOUs())
 
 	// 7. Setup graceful shutdown
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	hupChan := make(chan os.Signal, 1)
+	signal.Notify(hupChan, syscall.SIGHUP)
 
 	// 8. Start server in goroutine
 	errChan := make(chan error, 1)
@@ -124,52 +192,114 @@ func main() {
 		}
 	}()
 
-	// 9. Wait for shutdown signal or error
-	select {
-	case err := <-errChan:
-		log.Fatalf("Server error: %v", err)
-	case sig := <-sigChan:
-		log.Printf("Received signal %v, shutting down gracefully...", sig)
-
-		// Create shutdown context with timeout
-		shutdownCtx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
-		defer cancel()
-
-		// 1. Stop metadata auto-reload
-		log.Println("Stopping metadata auto-reload...")
-		if err := keyManager.StopAutoReload(shutdownCtx); err != nil {
-			log.Printf("Warning: metadata auto-reload stop timeout: %v", err)
-		}
-
-		// 2. Stop ACL auto-reload
-		log.Println("Stopping ACL auto-reload...")
-		if err := aclChecker.StopAutoReload(shutdownCtx); err != nil {
-			log.Printf("Warning: ACL auto-reload stop timeout: %v", err)
-		}
-
-		// 3. Stop HTTP server
-		log.Println("Stopping HTTP server...")
-		if err := srv.Shutdown(); err != nil {
-			log.Printf("Error during shutdown: %v", err)
-		}
-
-		// 4. Close KeyManager (which closes HSM context)
-		log.Println("Closing KeyManager...")
-		func() {
-			defer func() {
-				if r := recover(); r != nil {
-					log.Printf("Recovered from panic during KeyManager cleanup: %v", r)
-				}
-			}()
-			if err := keyManager.Close(); err != nil {
-				log.Printf("Error closing KeyManager: %v", err)
+	// 9. Wait for shutdown signal or error (SIGHUP reloads and keeps running)
+	var sig os.Signal
+	for sig == nil {
+		select {
+		case err := <-errChan:
+			log.Fatalf("Server error: %v", err)
+		case <-hupChan:
+			reloadComponents(configPath, keyManager, aclChecker, srv)
+		case sig = <-sigChan:
+		}
+	}
+	log.Printf("Received signal %v, shutting down gracefully...", sig)
+
+	// Create shutdown context with timeout
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	// 1. Stop rotation scheduler and metadata auto-reload
+	if rotationScheduler != nil {
+		log.Println("Stopping key rotation scheduler...")
+		if err := rotationScheduler.Stop(shutdownCtx); err != nil {
+			log.Printf("Warning: rotation scheduler stop timeout: %v", err)
+		}
+	}
+	log.Println("Stopping metadata auto-reload...")
+	if err := keyManager.StopAutoReload(shutdownCtx); err != nil {
+		log.Printf("Warning: metadata auto-reload stop timeout: %v", err)
+	}
+
+	// 2. Stop ACL auto-reload
+	log.Println("Stopping ACL auto-reload...")
+	if err := aclChecker.StopAutoReload(shutdownCtx); err != nil {
+		log.Printf("Warning: ACL auto-reload stop timeout: %v", err)
+	}
+
+	// 3. Stop HTTP server
+	log.Println("Stopping HTTP server...")
+	if err := srv.Shutdown(); err != nil {
+		log.Printf("Error during shutdown: %v", err)
+	}
+
+	// 4. Close the audit log with a final signed checkpoint (needs the HSM)
+	if auditSink != nil {
+		log.Println("Closing audit log...")
+		audit.SetSink(nil)
+		if err := auditSink.Close(); err != nil {
+			log.Printf("Error closing audit log: %v", err)
+		}
+		if err := auditIndex.Close(); err != nil {
+			log.Printf("Error flushing audit index: %v", err)
+		}
+	}
+	if len(auditOutputs) > 0 {
+		log.Println("Flushing audit outputs...")
+		audit.SetOutputs(nil)
+		for _, out := range auditOutputs {
+			out.Close()
+		}
+	}
+
+	// 5. Close KeyManager (which closes HSM context)
+	log.Println("Closing KeyManager...")
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				log.Printf("Recovered from panic during KeyManager cleanup: %v", r)
 			}
 		}()
+		if err := keyManager.Close(); err != nil {
+			log.Printf("Error closing KeyManager: %v", err)
+		}
+	}()
+
+	// 6. Flush pending spans
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		log.Printf("Error flushing traces: %v", err)
 	}
 
 	log.Println("HSM service stopped")
 }
 
+// reloadComponents re-reads metadata, the revoked list, ACL mappings and TLS
+// files on SIGHUP (kill -HUP). Each component is reloaded independently;
+// a failure keeps its previous state.
+func reloadComponents(configPath string, keyManager *hsm.KeyManager, aclChecker *server.ACLChecker, srv *server.Server) {
+	log.Println("Received SIGHUP, reloading...")
+
+	report := func(component string, err error) {
+		if err != nil {
+			log.Printf("✗ Reload %s failed: %v", component, err)
+			return
+		}
+		log.Printf("✓ Reloaded %s", component)
+	}
+
+	report("metadata", keyManager.ReloadMetadata())
+	report("revoked list", aclChecker.ForceReload())
+
+	// Only ACL mappings are taken from the new config; other settings need a restart
+	cfg, err := config.LoadConfig(configPath)
+	if err == nil {
+		aclChecker.UpdateMappings(cfg.ACL.Mappings)
+	}
+	report("ACL mappings", err)
+
+	report("TLS certificates", srv.ReloadTLS())
+}
+
 // performAutoCleanup performs automatic cleanup of old key versions on startup
 func performAutoCleanup(hsmCfg *config.HSMConfig, metadata *config.Metadata) error {
 	maxVersions := hsmCfg.MaxVersions
@@ -183,6 +313,10 @@ func performAutoCleanup(hsmCfg *config.HSMConfig, metadata *config.Metadata) err
 
 	log.Printf("🧹 Auto-cleanup: max_versions=%d, cleanup_after_days=%d", maxVersions, cleanupAfterDays)
 
+	// Versions past retention are disabled by the metadata reload loop (hourly)
+	policy := hsmCfg.RetentionPolicy(config.KeyMetadata{})
+	log.Printf("🧹 Retention: overlap_days=%d, retention_days=%d (0 = never disable)", policy.OverlapDays, policy.RetentionDays)
+
 	// For auto-cleanup, we only check version count limits, not age
 	// Age-based cleanup is done manually via hsm-admin for safety
 	deleted := 0
@@ -125,9 +125,10 @@ except:
 if 'revoked' not in data:
     data['revoked'] = []
 
-# Check if already revoked
+# Check if already revoked (by serial; entries without serial revoke the whole CN)
 for cert in data['revoked']:
-    if cert.get('cn') == '$CN':
+    listed = str(cert.get('serial', 'unknown'))
+    if ('$SERIAL' != 'unknown' and listed == '$SERIAL') or ('$SERIAL' == 'unknown' and cert.get('cn') == '$CN' and listed == 'unknown'):
         print("  ${YELLOW}Warning: Certificate already in revoked list${NC}")
         exit(0)
 
@@ -334,11 +334,11 @@ See logs: $LOG_FILE" "warning"
                 CLEANUP_SUDO="sudo"
             fi
             
-            log "Executing: $CLEANUP_SUDO $HSM_ADMIN_CMD cleanup-old-versions --force"
+            log "Executing: $CLEANUP_SUDO $HSM_ADMIN_CMD cleanup-old-versions --yes"
             export HSM_PIN
             
             # Run cleanup with explicit HSM_PIN export
-            CLEANUP_OUTPUT=$($CLEANUP_SUDO bash -c "export HSM_PIN='$HSM_PIN'; $HSM_ADMIN_CMD cleanup-old-versions --force" 2>&1)
+            CLEANUP_OUTPUT=$($CLEANUP_SUDO bash -c "export HSM_PIN='$HSM_PIN'; $HSM_ADMIN_CMD cleanup-old-versions --yes" 2>&1)
             CLEANUP_EXIT_CODE=$?
             
             if [ $CLEANUP_EXIT_CODE -eq 0 ]; then
@@ -881,8 +881,8 @@ print_success "Versions backdated for cleanup testing"
 
 print_test "Test 10.5: Execute cleanup (delete excess versions)"
 echo ""
-echo "=== Executing cleanup with --force ==="
-docker exec -e HSM_PIN=1234 hsm-service /app/hsm-admin cleanup-old-versions --force > /tmp/cleanup.log 2>&1
+echo "=== Executing cleanup with --yes --ignore-usage ==="
+docker exec -e HSM_PIN=1234 hsm-service /app/hsm-admin cleanup-old-versions --yes --ignore-usage > /tmp/cleanup.log 2>&1
 CLEANUP_EXIT_CODE=$?
 
 echo "Cleanup output:"